@@ -0,0 +1,326 @@
+// Command secretmessage-cli sends and reads secrets through the Secret Message REST API
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/neufeldtech/secretmessage-go/pkg/secretclient"
+)
+
+const usage = `usage: secretmessage-cli [-config FILE] <command>
+
+Commands:
+  send [-to #channel|C123|U123] [-expires 1h|7d] [-plain] < FILE
+                       create a secret from stdin and print its link, or its ID when it's only posted to Slack
+  read [-o FILE] LINK  print the secret behind a web link, which can then no longer be read. Files
+                       are saved under their own name unless -o names another path, or - for stdout
+  revoke ID|LINK       revoke an unread secret
+  list [-limit N]      list the secrets created with your token
+
+The server and token are read from the config file, a JSON object like
+{"url": "https://secretmessage.example.com", "token": "smk_..."}, and are overridden by the
+SECRETMESSAGE_URL and SECRETMESSAGE_TOKEN environment variables.`
+
+// config is where the server is and how to authenticate to it
+type config struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command line and returns the process exit code
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("secretmessage-cli", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprintln(stderr, usage) }
+	configPath := fs.String("config", defaultConfigPath(), "path of the config file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	cmd, cmdArgs := fs.Arg(0), fs.Args()[1:]
+	if cmd == "read" {
+		// Links carry their server and need no token
+		return read(ctx, secretclient.NewClient("", ""), cmdArgs, stdout, stderr)
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "error reading config: %v\n", err)
+		return 1
+	}
+	if cfg.URL == "" || cfg.Token == "" {
+		fmt.Fprintf(stderr, "no server URL or token, set them in %s or with SECRETMESSAGE_URL and SECRETMESSAGE_TOKEN\n", *configPath)
+		return 1
+	}
+	client := secretclient.NewClient(cfg.URL, cfg.Token)
+
+	switch cmd {
+	case "send":
+		return send(ctx, client, cmdArgs, stdin, stdout, stderr)
+	case "revoke":
+		return revoke(ctx, client, cmdArgs, stdout, stderr)
+	case "list":
+		return list(ctx, client, cmdArgs, stdout, stderr)
+	default:
+		fs.Usage()
+		return 2
+	}
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "secretmessage-cli.json"
+	}
+	return filepath.Join(dir, "secretmessage", "cli.json")
+}
+
+// loadConfig reads the config file, which may not exist, and applies the environment on top of it
+func loadConfig(path string) (config, error) {
+	var cfg config
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return cfg, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if v := os.Getenv("SECRETMESSAGE_URL"); v != "" {
+		cfg.URL = v
+	}
+	if v := os.Getenv("SECRETMESSAGE_TOKEN"); v != "" {
+		cfg.Token = v
+	}
+	return cfg, nil
+}
+
+func send(ctx context.Context, client *secretclient.Client, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	to := fs.String("to", "", "post the secret to a channel (#name or ID) or to a user's DM (user ID)")
+	expires := fs.String("expires", "", "how long the secret can be read for, like 30m, 12h or 7d")
+	plain := fs.Bool("plain", false, "let the server encrypt the secret even when it supports client encryption")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var req secretclient.CreateRequest
+	if *to != "" {
+		req.ChannelID, req.UserID = destination(*to)
+	}
+	if *expires != "" {
+		d, err := parseExpiry(*expires)
+		if err != nil {
+			fmt.Fprintf(stderr, "-expires: %v\n", err)
+			return 2
+		}
+		expiresAt := time.Now().Add(d)
+		req.ExpiresAt = &expiresAt
+	}
+
+	b, err := io.ReadAll(stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "error reading secret: %v\n", err)
+		return 1
+	}
+	// Files and echo end with a newline that isn't part of the secret
+	text := strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r")
+	if text == "" {
+		fmt.Fprintln(stderr, "the secret on stdin is empty")
+		return 1
+	}
+
+	encrypt := false
+	if !*plain {
+		info, err := client.Info(ctx)
+		if err != nil && !secretclient.IsStatus(err, http.StatusNotFound) {
+			fmt.Fprintf(stderr, "error contacting server: %v\n", err)
+			return 1
+		}
+		encrypt = info.ClientEncryption
+	}
+
+	var sealed secretclient.Sealed
+	if encrypt {
+		sealed, err = secretclient.Seal([]byte(text))
+		if err != nil {
+			fmt.Fprintf(stderr, "error encrypting secret: %v\n", err)
+			return 1
+		}
+		req.Ciphertext, req.KeyHash = sealed.Ciphertext, sealed.KeyHash
+		if *to != "" {
+			// The link posted to Slack has to carry the key
+			req.Key = sealed.Key
+		}
+	} else {
+		if *to == "" {
+			fmt.Fprintln(stderr, "the server doesn't support client encryption, so -to is required")
+			return 2
+		}
+		req.Text = text
+	}
+
+	secret, err := client.CreateSecret(ctx, req)
+	if err != nil {
+		fmt.Fprintf(stderr, "error creating secret: %v\n", err)
+		return 1
+	}
+	if link := secret.Link(sealed.Key); encrypt && link != "" {
+		fmt.Fprintln(stdout, link)
+	} else {
+		fmt.Fprintln(stdout, secret.ID)
+	}
+	return 0
+}
+
+// destination maps -to onto the API's channel_id or user_id. User IDs go to the user's DM, anything
+// else, including #channel-name, is taken as a channel.
+func destination(to string) (string, string) {
+	to = strings.TrimPrefix(to, "@")
+	if strings.HasPrefix(to, "U") || strings.HasPrefix(to, "W") {
+		return "", to
+	}
+	return to, ""
+}
+
+// parseExpiry parses a Go duration, or a whole number of days like 7d
+func parseExpiry(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+func read(ctx context.Context, client *secretclient.Client, args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("read", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("o", "", "where to save a file secret, - for stdout. Defaults to the file's name in the current directory.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: secretmessage-cli read [-o FILE] LINK")
+		return 2
+	}
+	revealed, err := client.Reveal(ctx, fs.Arg(0))
+	var apiErr *secretclient.Error
+	switch {
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusGone:
+		fmt.Fprintf(stderr, "the secret can no longer be read, it was %s\n", apiErr.Status)
+		return 1
+	case errors.As(err, &apiErr) && apiErr.Status == secretclient.StatusNotFound:
+		fmt.Fprintln(stderr, "no secret found, check that the link was copied in full")
+		return 1
+	case err != nil:
+		fmt.Fprintf(stderr, "error reading secret: %v\n", err)
+		return 1
+	case revealed.File == nil:
+		fmt.Fprintln(stdout, revealed.Text)
+		return 0
+	}
+
+	if *output == "-" {
+		stdout.Write(revealed.File)
+		return 0
+	}
+	path := *output
+	if path == "" {
+		path = filepath.Base(revealed.FileName)
+	}
+	// The file can't be downloaded again, so never lose it to an existing file
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		fmt.Fprintf(stderr, "error saving file: %v, writing it to stdout instead\n", err)
+		stdout.Write(revealed.File)
+		return 1
+	}
+	if _, err := f.Write(revealed.File); err != nil {
+		f.Close()
+		fmt.Fprintf(stderr, "error saving file: %v\n", err)
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintf(stderr, "error saving file: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stderr, "saved %s\n", path)
+	return 0
+}
+
+func revoke(ctx context.Context, client *secretclient.Client, args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, "usage: secretmessage-cli revoke ID|LINK")
+		return 2
+	}
+	id := args[0]
+	if strings.Contains(id, "/s/") {
+		if _, linkID, _, err := secretclient.ParseLink(id); err == nil {
+			id = linkID
+		} else {
+			id = id[strings.LastIndex(id, "/s/")+len("/s/"):]
+		}
+	}
+	secret, err := client.RevokeSecret(ctx, id)
+	if err != nil {
+		fmt.Fprintf(stderr, "error revoking secret: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "revoked %s\n", secret.ID)
+	return 0
+}
+
+func list(ctx context.Context, client *secretclient.Client, args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	limit := fs.Int("limit", 0, "list at most this many secrets")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	secrets, err := client.ListSecrets(ctx, *limit)
+	if err != nil {
+		fmt.Fprintf(stderr, "error listing secrets: %v\n", err)
+		return 1
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tCHANNEL\tCREATED\tEXPIRES")
+	for _, s := range secrets {
+		channel := s.ChannelID
+		if channel == "" {
+			channel = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.ID, s.Status, channel,
+			s.CreatedAt.Local().Format(time.DateTime), s.ExpiresAt.Local().Format(time.DateTime))
+	}
+	tw.Flush()
+	return 0
+}
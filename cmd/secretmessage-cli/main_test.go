@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretblob"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretclient"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const teamID = "T1234ABCD"
+
+// newServer runs the real router against an in-memory database and points the CLI at it
+func newServer(t *testing.T, clientEncryption bool) *gorm.DB {
+	gdb, err := gorm.Open(sqlite.Open(fmt.Sprintf("file::memory:?cache=shared&dbname=%s", t.Name())), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, gdb.AutoMigrate(secretmessage.Team{}, secretmessage.Secret{}, secretmessage.TeamSettings{}, secretmessage.AuditEvent{}, secretmessage.APIToken{}))
+	require.NoError(t, gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: "xoxb-1234"}).Error)
+	token, _, err := secretmessage.CreateAPIToken(context.Background(), gdb, teamID, "", "laptop", secretmessage.APIScopes)
+	require.NoError(t, err)
+
+	// The server's AppURL is its own address, which is only known once it's listening
+	var router http.Handler
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r)
+	}))
+	store, err := secretblob.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	cfg := secretmessage.Config{BlobStore: store}
+	if clientEncryption {
+		cfg.AppURL = srv.URL
+	}
+	ctl := secretmessage.NewController(cfg, gdb, nil)
+	router = ctl.ConfigureRoutes()
+	t.Cleanup(func() {
+		srv.Close()
+		// Background jobs use the database, they must finish before it's closed
+		assert.NoError(t, ctl.Close(context.Background()))
+		db, _ := gdb.DB()
+		db.Close()
+	})
+
+	t.Setenv("SECRETMESSAGE_URL", srv.URL)
+	t.Setenv("SECRETMESSAGE_TOKEN", token)
+	return gdb
+}
+
+// mockSlack answers chat.postMessage and lets every other request through to the test server
+func mockSlack(t *testing.T) *[]string {
+	var posted []string
+	httpmock.Activate()
+	t.Cleanup(httpmock.DeactivateAndReset)
+	httpmock.RegisterNoResponder(httpmock.InitialTransport.RoundTrip)
+	httpmock.RegisterResponder("POST", "https://slack.com/api/chat.postMessage", func(req *http.Request) (*http.Response, error) {
+		req.ParseForm()
+		posted = append(posted, req.PostForm.Get("channel")+" "+req.PostForm.Get("attachments"))
+		return httpmock.NewStringResponse(200, `{"ok": true, "channel": "C0001", "ts": "1700000000.000100"}`), nil
+	})
+	return &posted
+}
+
+func runCLI(t *testing.T, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-config", filepath.Join(t.TempDir(), "missing.json")}, args...)
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, strings.TrimSpace(stdout.String()), stderr.String()
+}
+
+func TestSendAndReadEncryptedLink(t *testing.T) {
+	gdb := newServer(t, true)
+
+	code, link, stderr := runCLI(t, "hunter2\n", "send", "-expires", "1h")
+	require.Equal(t, 0, code, stderr)
+	_, id, key, err := secretclient.ParseLink(link)
+	require.NoError(t, err)
+
+	var s secretmessage.Secret
+	require.NoError(t, gdb.Where("id = ?", id).Take(&s).Error)
+	assert.True(t, s.ClientEncrypted)
+	assert.NotContains(t, s.Value, "hunter2")
+	assert.NotContains(t, s.Value, key)
+
+	code, text, stderr := runCLI(t, "", "read", link)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, "hunter2", text)
+
+	code, _, stderr = runCLI(t, "", "read", link)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "it was read")
+
+	code, out, stderr := runCLI(t, "", "list")
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, out, id)
+	assert.Contains(t, out, secretmessage.SecretRetiredRead)
+}
+
+func TestSendEncryptedLinkToChannel(t *testing.T) {
+	newServer(t, true)
+	posted := mockSlack(t)
+
+	code, link, stderr := runCLI(t, "hunter2", "send", "-to", "C0001")
+	require.Equal(t, 0, code, stderr)
+	require.Len(t, *posted, 1)
+	assert.Contains(t, (*posted)[0], "C0001")
+	assert.Contains(t, (*posted)[0], link)
+
+	code, text, stderr := runCLI(t, "", "read", link)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, "hunter2", text)
+}
+
+func TestSendPlainWithoutClientEncryption(t *testing.T) {
+	gdb := newServer(t, false)
+	posted := mockSlack(t)
+
+	code, _, stderr := runCLI(t, "hunter2", "send")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "-to is required")
+
+	code, id, stderr := runCLI(t, "hunter2", "send", "-to", "U0001")
+	require.Equal(t, 0, code, stderr)
+	require.Len(t, *posted, 1)
+
+	var s secretmessage.Secret
+	require.NoError(t, gdb.Where("id = ?", id).Take(&s).Error)
+	assert.False(t, s.ClientEncrypted)
+	assert.NotContains(t, s.Value, "hunter2")
+}
+
+func TestRevoke(t *testing.T) {
+	newServer(t, true)
+
+	code, link, stderr := runCLI(t, "hunter2", "send")
+	require.Equal(t, 0, code, stderr)
+	_, id, _, err := secretclient.ParseLink(link)
+	require.NoError(t, err)
+
+	code, out, stderr := runCLI(t, "", "revoke", link)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, "revoked "+id, out)
+
+	code, _, stderr = runCLI(t, "", "read", link)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "it was "+secretmessage.SecretRetiredRevoked)
+
+	code, _, _ = runCLI(t, "", "revoke", id)
+	assert.Equal(t, 1, code)
+}
+
+func TestConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cli.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"url": "https://file.example.com", "token": "smk_file"}`), 0o600))
+
+	t.Setenv("SECRETMESSAGE_URL", "")
+	t.Setenv("SECRETMESSAGE_TOKEN", "")
+	cfg, err := loadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, config{URL: "https://file.example.com", Token: "smk_file"}, cfg)
+
+	t.Setenv("SECRETMESSAGE_TOKEN", "smk_env")
+	cfg, err = loadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "smk_env", cfg.Token)
+
+	cfg, err = loadConfig(filepath.Join(t.TempDir(), "missing.json"))
+	require.NoError(t, err)
+	assert.Equal(t, "smk_env", cfg.Token)
+}
+
+func TestReadFile(t *testing.T) {
+	newServer(t, true)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "kubeconfig")
+	require.NoError(t, err)
+	fw.Write([]byte("token: hunter2\n"))
+	require.NoError(t, mw.Close())
+	req, err := http.NewRequest(http.MethodPost, os.Getenv("SECRETMESSAGE_URL")+"/api/v1/files", &body)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("SECRETMESSAGE_TOKEN"))
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var link struct {
+		Link string `json:"link"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&link))
+
+	path := filepath.Join(t.TempDir(), "saved")
+	code, _, stderr := runCLI(t, "", "read", "-o", path, link.Link)
+	require.Equal(t, 0, code, stderr)
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "token: hunter2\n", string(saved))
+
+	code, _, _ = runCLI(t, "", "read", "-o", "-", link.Link)
+	assert.Equal(t, 1, code)
+}
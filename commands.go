@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const commandUsage = `usage: secretmessage [command]
+
+With no command the server is started. Commands:
+  audit verify [-team T123]   verify the audit log hash chains and report any breaks
+  audit export -team T123 [-format jsonl|csv] [-since RFC3339] [-until RFC3339] [-limit N] [-cursor C]
+                              write a team's audit events to stdout
+  api-token create -team T123 -name NAME [-enterprise E123] [-scopes secrets:write,secrets:read,secrets:revoke]
+                              issue a REST API token for a team and print it once
+  api-token list -team T123   list a team's API tokens
+  api-token revoke -team T123 -id N
+                              revoke one of a team's API tokens`
+
+// runCommand runs an administrative command instead of the server and returns the process exit code
+func runCommand(logger *zap.Logger, args []string) int {
+	switch {
+	case len(args) >= 2 && args[0] == "audit" && args[1] == "verify":
+		return auditVerify(logger, args[2:])
+	case len(args) >= 2 && args[0] == "audit" && args[1] == "export":
+		return auditExport(logger, args[2:])
+	case len(args) >= 2 && args[0] == "api-token" && args[1] == "create":
+		return apiTokenCreate(logger, args[2:])
+	case len(args) >= 2 && args[0] == "api-token" && args[1] == "list":
+		return apiTokenList(logger, args[2:])
+	case len(args) >= 2 && args[0] == "api-token" && args[1] == "revoke":
+		return apiTokenRevoke(logger, args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return 2
+	}
+}
+
+// openCommandDatabase connects to DATABASE_URL, the configuration every command needs
+func openCommandDatabase() (*gorm.DB, error) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		return nil, fmt.Errorf("DATABASE_URL is not set")
+	}
+	return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+}
+
+func auditVerify(logger *zap.Logger, args []string) int {
+	fs := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	teamID := fs.String("team", "", "only verify the chain of this team")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	db, err := openCommandDatabase()
+	if err != nil {
+		logger.Error("error connecting to database", zap.Error(err))
+		return 1
+	}
+	auditKey, err := secretmessage.ParseAuditKey(os.Getenv("AUDIT_KEY"))
+	if err != nil {
+		logger.Error("error parsing AUDIT_KEY", zap.Error(err))
+		return 1
+	}
+	breaks, checked, err := secretmessage.VerifyAuditChain(context.Background(), db, auditKey, *teamID)
+	for _, b := range breaks {
+		fmt.Println(b)
+	}
+	if err != nil {
+		logger.Error("error verifying audit chain", zap.Error(err))
+		return 1
+	}
+	fmt.Printf("checked %d audit events, found %d breaks\n", checked, len(breaks))
+	if len(breaks) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func auditExport(logger *zap.Logger, args []string) int {
+	fs := flag.NewFlagSet("audit export", flag.ContinueOnError)
+	teamID := fs.String("team", "", "team whose events to export")
+	format := fs.String("format", secretmessage.AuditExportJSONLines, "jsonl or csv")
+	since := fs.String("since", "", "only events at or after this RFC 3339 time")
+	until := fs.String("until", "", "only events before this RFC 3339 time")
+	limit := fs.Int("limit", 0, "export at most this many events, 0 for all of them")
+	cursor := fs.String("cursor", "", "continue from the cursor printed by a previous limited export")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *teamID == "" {
+		fmt.Fprintln(os.Stderr, "-team is required")
+		return 2
+	}
+	q := secretmessage.AuditExportQuery{TeamID: *teamID, Format: *format, Limit: *limit, Cursor: *cursor}
+	for name, value := range map[string]string{"since": *since, "until": *until} {
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-%s must be an RFC 3339 timestamp\n", name)
+			return 2
+		}
+		if name == "since" {
+			q.Since = t
+		} else {
+			q.Until = t
+		}
+	}
+
+	db, err := openCommandDatabase()
+	if err != nil {
+		logger.Error("error connecting to database", zap.Error(err))
+		return 1
+	}
+	ctx := context.Background()
+	next, err := secretmessage.NextAuditExportCursor(ctx, db, q)
+	if err != nil {
+		logger.Error("error paginating audit export", zap.Error(err))
+		return 1
+	}
+	written, err := secretmessage.ExportAuditEvents(ctx, db, os.Stdout, q)
+	if err != nil {
+		logger.Error("error exporting audit events", zap.Error(err), zap.Int("written", written))
+		return 1
+	}
+	if next != "" {
+		fmt.Fprintf(os.Stderr, "more events follow, continue with -cursor %s\n", next)
+	}
+	return 0
+}
+
+func apiTokenCreate(logger *zap.Logger, args []string) int {
+	fs := flag.NewFlagSet("api-token create", flag.ContinueOnError)
+	teamID := fs.String("team", "", "team the token acts for")
+	enterpriseID := fs.String("enterprise", "", "Enterprise Grid organization of the team, if it uses an org-wide install")
+	name := fs.String("name", "", "name of the token, shown as the sender of its secrets")
+	scopes := fs.String("scopes", strings.Join(secretmessage.APIScopes, ","), "comma separated scopes to grant")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *teamID == "" || *name == "" {
+		fmt.Fprintln(os.Stderr, "-team and -name are required")
+		return 2
+	}
+
+	db, err := openCommandDatabase()
+	if err != nil {
+		logger.Error("error connecting to database", zap.Error(err))
+		return 1
+	}
+	token, apiToken, err := secretmessage.CreateAPIToken(context.Background(), db, *teamID, *enterpriseID, *name, strings.Split(*scopes, ","))
+	if err != nil {
+		logger.Error("error creating API token", zap.Error(err))
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "created API token %d for team %s, it won't be shown again:\n", apiToken.ID, apiToken.TeamID)
+	fmt.Println(token)
+	return 0
+}
+
+func apiTokenList(logger *zap.Logger, args []string) int {
+	fs := flag.NewFlagSet("api-token list", flag.ContinueOnError)
+	teamID := fs.String("team", "", "team whose tokens to list")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *teamID == "" {
+		fmt.Fprintln(os.Stderr, "-team is required")
+		return 2
+	}
+
+	db, err := openCommandDatabase()
+	if err != nil {
+		logger.Error("error connecting to database", zap.Error(err))
+		return 1
+	}
+	tokens, err := secretmessage.ListAPITokens(context.Background(), db, *teamID)
+	if err != nil {
+		logger.Error("error listing API tokens", zap.Error(err))
+		return 1
+	}
+	for _, t := range tokens {
+		lastUsed := "never"
+		if !t.LastUsedAt.IsZero() {
+			lastUsed = t.LastUsedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%d\t%s\t%s\tcreated %s\tlast used %s\n", t.ID, t.Name, strings.Join(t.Scopes, ","), t.CreatedAt.Format(time.RFC3339), lastUsed)
+	}
+	return 0
+}
+
+func apiTokenRevoke(logger *zap.Logger, args []string) int {
+	fs := flag.NewFlagSet("api-token revoke", flag.ContinueOnError)
+	teamID := fs.String("team", "", "team the token belongs to")
+	id := fs.Uint("id", 0, "ID of the token, as printed by api-token list")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *teamID == "" || *id == 0 {
+		fmt.Fprintln(os.Stderr, "-team and -id are required")
+		return 2
+	}
+
+	db, err := openCommandDatabase()
+	if err != nil {
+		logger.Error("error connecting to database", zap.Error(err))
+		return 1
+	}
+	revoked, err := secretmessage.RevokeAPIToken(context.Background(), db, *teamID, *id)
+	if err != nil {
+		logger.Error("error revoking API token", zap.Error(err))
+		return 1
+	}
+	if !revoked {
+		fmt.Fprintf(os.Stderr, "team %s has no API token %d\n", *teamID, *id)
+		return 1
+	}
+	fmt.Printf("revoked API token %d\n", *id)
+	return 0
+}
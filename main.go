@@ -2,13 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"os/signal"
+	"syscall"
 
 	"strconv"
+	"time"
 
 	"os"
 
 	"github.com/gin-gonic/gin"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretblob"
 	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage"
 
 	"go.uber.org/zap"
@@ -19,21 +25,27 @@ import (
 )
 
 var (
-	defaultPort                 int64 = 8080
-	slackSigningSecretConfigKey       = "slackSigningSecret"
-	slackClientIDConfigKey            = "slackClientID"
-	slackClientSecretConfigKey        = "slackClientSecret"
-	slackCallbackURLConfigKey         = "slackCallbackURL"
-	appURLConfigKey                   = "appURL"
-	databaseURL                       = "databaseURL"
+	defaultPort                   int64 = 8080
+	slackSigningSecretConfigKey         = "slackSigningSecret"
+	slackClientIDConfigKey              = "slackClientID"
+	slackClientSecretConfigKey          = "slackClientSecret"
+	slackCallbackURLConfigKey           = "slackCallbackURL"
+	appURLConfigKey                     = "appURL"
+	databaseURL                         = "databaseURL"
+	tokenEncryptionKeysConfigKey        = "tokenEncryptionKeys"
+	tokenEncryptionKeyIDConfigKey       = "tokenEncryptionKeyID"
+	auditKeyConfigKey                   = "auditKey"
 
 	configMap = map[string]string{
-		slackSigningSecretConfigKey: os.Getenv("SLACK_SIGNING_SECRET"),
-		slackClientIDConfigKey:      os.Getenv("SLACK_CLIENT_ID"),
-		slackClientSecretConfigKey:  os.Getenv("SLACK_CLIENT_SECRET"),
-		slackCallbackURLConfigKey:   os.Getenv("SLACK_CALLBACK_URL"),
-		appURLConfigKey:             os.Getenv("APP_URL"),
-		databaseURL:                 os.Getenv("DATABASE_URL"),
+		slackSigningSecretConfigKey:   os.Getenv("SLACK_SIGNING_SECRET"),
+		slackClientIDConfigKey:        os.Getenv("SLACK_CLIENT_ID"),
+		slackClientSecretConfigKey:    os.Getenv("SLACK_CLIENT_SECRET"),
+		slackCallbackURLConfigKey:     os.Getenv("SLACK_CALLBACK_URL"),
+		appURLConfigKey:               os.Getenv("APP_URL"),
+		databaseURL:                   os.Getenv("DATABASE_URL"),
+		tokenEncryptionKeysConfigKey:  os.Getenv("TOKEN_ENCRYPTION_KEYS"),
+		tokenEncryptionKeyIDConfigKey: os.Getenv("TOKEN_ENCRYPTION_KEY_ID"),
+		auditKeyConfigKey:             os.Getenv("AUDIT_KEY"),
 	}
 )
 
@@ -62,6 +74,12 @@ func main() {
 		logger = zap.Must(zap.NewProduction())
 	}
 
+	if len(os.Args) > 1 {
+		code := runCommand(logger, os.Args[1:])
+		logger.Sync()
+		os.Exit(code)
+	}
+
 	tp, err := secretmessage.InitTracer(secretmessage.ServiceName)
 	if err != nil {
 		logger.Fatal(err.Error())
@@ -79,17 +97,36 @@ func main() {
 		}
 	}
 
+	auditKey, err := secretmessage.ParseAuditKey(configMap[auditKeyConfigKey])
+	if err != nil {
+		logger.Fatal("error parsing audit key", zap.Error(err))
+	}
+
+	tokenKeys, err := secretmessage.ParseTokenEncryptionKeys(configMap[tokenEncryptionKeysConfigKey])
+	if err != nil {
+		logger.Fatal("error parsing token encryption keys", zap.Error(err))
+	}
+	tokenCipher, err := secretmessage.NewTokenCipher(configMap[tokenEncryptionKeyIDConfigKey], tokenKeys)
+	if err != nil {
+		logger.Fatal("error initializing token cipher", zap.Error(err))
+	}
+
 	conf := secretmessage.Config{
 		Port:          resolvePort(),
 		SlackToken:    "",
 		SigningSecret: configMap[slackSigningSecretConfigKey],
 		AppURL:        configMap[appURLConfigKey],
 		DatabaseURL:   configMap[databaseURL],
+		TokenCipher:   tokenCipher,
+		AuditKey:      auditKey,
+		AdminAPIToken: os.Getenv("ADMIN_API_TOKEN"),
+		// Only for receivers on the same network as a self-hosted install
+		AllowPrivateWebhooks: os.Getenv("ALLOW_PRIVATE_WEBHOOKS") == "true",
 		OauthConfig: &oauth2.Config{
 			ClientID:     configMap[slackClientIDConfigKey],
 			ClientSecret: configMap[slackClientSecretConfigKey],
 			RedirectURL:  configMap[slackCallbackURLConfigKey],
-			Scopes:       []string{"chat:write", "commands", "workflow.steps:execute"},
+			Scopes:       []string{"chat:write", "commands", "users:read", "workflow.steps:execute", "files:read", "im:history"},
 			Endpoint: oauth2.Endpoint{
 				AuthURL:  "https://slack.com/oauth/v2/authorize",
 				TokenURL: "https://slack.com/api/oauth.v2.access",
@@ -97,6 +134,15 @@ func main() {
 		},
 	}
 
+	// Sharing files needs somewhere to keep them, and stays disabled without it
+	if blobDir := os.Getenv("BLOB_DIR"); blobDir != "" {
+		store, err := secretblob.NewLocalStore(blobDir)
+		if err != nil {
+			logger.Fatal("error initializing blob store", zap.Error(err))
+		}
+		conf.BlobStore = store
+	}
+
 	db, err := gorm.Open(postgres.Open(conf.DatabaseURL), &gorm.Config{})
 	if err != nil {
 		logger.Fatal("error connecting to database", zap.Error(err))
@@ -107,6 +153,16 @@ func main() {
 
 	db.AutoMigrate(secretmessage.Secret{})
 	db.AutoMigrate(secretmessage.Team{})
+	db.AutoMigrate(secretmessage.TeamSettings{})
+	db.AutoMigrate(secretmessage.AuditEvent{})
+	db.AutoMigrate(secretmessage.APIToken{})
+	db.AutoMigrate(secretmessage.SecretShare{})
+
+	migrated, err := secretmessage.EncryptTeamTokens(context.Background(), db, tokenCipher)
+	if err != nil {
+		logger.Fatal("error encrypting team tokens", zap.Error(err))
+	}
+	logger.Info("encrypted team tokens", zap.Int("count", migrated), zap.String("keyID", tokenCipher.ActiveKeyID()))
 
 	controller := secretmessage.NewController(
 		conf,
@@ -115,8 +171,31 @@ func main() {
 	)
 
 	go controller.StayAwake()
+	go controller.RetireExpiredSecretsEvery(time.Hour)
 	r := controller.ConfigureRoutes()
 	logger.Sugar().Infof("Booted and listening on port %v", conf.Port)
 
-	r.Run(fmt.Sprintf("0.0.0.0:%v", conf.Port))
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("0.0.0.0:%v", conf.Port),
+		Handler: r,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("error serving http", zap.Error(err))
+		}
+	}()
+
+	// On shutdown, stop taking requests, then finish the work that was already acknowledged to Slack
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	<-stop.Done()
+	logger.Info("Shutting down")
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelShutdown()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("error shutting down http server", zap.Error(err))
+	}
+	if err := controller.Close(ctx); err != nil {
+		logger.Error("error finishing background work", zap.Error(err))
+	}
 }
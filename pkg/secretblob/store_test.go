@@ -0,0 +1,61 @@
+package secretblob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	n, err := store.Put(ctx, "abc123", strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, n)
+
+	rc, err := store.Open(ctx, "abc123")
+	require.NoError(t, err)
+	b, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	require.NoError(t, store.Delete(ctx, "abc123"))
+	_, err = store.Open(ctx, "abc123")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, store.Delete(ctx, "abc123"), "deleting twice")
+}
+
+func TestLocalStore_RejectsPathsOutsideTheStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	for _, key := range []string{"", "../escape", "a/b", "."} {
+		_, err := store.Put(context.Background(), key, strings.NewReader("x"))
+		assert.Error(t, err, key)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("upload interrupted") }
+
+func TestLocalStore_FailedPutLeavesNothing(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStore(dir)
+	require.NoError(t, err)
+
+	_, err = store.Put(context.Background(), "abc123", io.MultiReader(strings.NewReader("partial"), failingReader{}))
+	assert.Error(t, err)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
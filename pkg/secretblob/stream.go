@@ -0,0 +1,181 @@
+package secretblob
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Blobs are encrypted in segments, so files of any size are encrypted and decrypted without holding
+// them in memory. Each segment is sealed with AES-256-GCM under a nonce made of a random prefix, the
+// segment's number and a flag marking the last segment, which makes reordered, dropped or truncated
+// segments fail to decrypt.
+const (
+	streamVersion = 1
+	// SegmentSize is how much plain text each sealed segment holds, the last one may hold less
+	SegmentSize = 64 * 1024
+
+	noncePrefixSize = 7
+	headerSize      = 1 + noncePrefixSize
+	tagSize         = 16
+)
+
+// ErrInvalidStream is returned when a blob isn't a stream sealed by NewEncryptWriter with the same key
+var ErrInvalidStream = errors.New("invalid or tampered encrypted stream")
+
+func newStreamAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(prefix []byte, segment uint32, last bool) []byte {
+	nonce := make([]byte, 0, noncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, segment)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	segment uint32
+	buf     []byte
+	closed  bool
+}
+
+// NewEncryptWriter returns a writer that encrypts what is written to it with a 32 byte key and writes
+// the result to w. Close must be called to seal the last segment, it doesn't close w.
+func NewEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newStreamAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	header[0] = streamVersion
+	if _, err := rand.Read(header[1:]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		prefix: header[1:],
+		buf:    make([]byte, 0, SegmentSize),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full segment is only sealed once more data arrives, since the last one is sealed differently
+		if len(e.buf) == SegmentSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):SegmentSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	sealed := e.aead.Seal(nil, segmentNonce(e.prefix, e.segment, last), e.buf, nil)
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.segment++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	segment uint32
+	sealed  []byte
+	plain   []byte
+	done    bool
+}
+
+// NewDecryptReader returns a reader of the plain text of a stream written by NewEncryptWriter. Every
+// segment is authenticated before it is returned, and a stream cut short ends with ErrInvalidStream.
+func NewDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newStreamAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil || header[0] != streamVersion {
+		return nil, ErrInvalidStream
+	}
+	return &decryptReader{
+		r:      bufio.NewReaderSize(r, SegmentSize+tagSize),
+		aead:   aead,
+		prefix: header[1:],
+		sealed: make([]byte, SegmentSize+tagSize),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		d.done = true
+	case err != nil:
+		return err
+	default:
+		// A full segment is the last one if nothing follows it
+		if _, peekErr := d.r.Peek(1); errors.Is(peekErr, io.EOF) {
+			d.done = true
+		}
+	}
+	plain, err := d.aead.Open(d.sealed[:0], segmentNonce(d.prefix, d.segment, d.done), d.sealed[:n], nil)
+	if err != nil {
+		return ErrInvalidStream
+	}
+	d.segment++
+	d.plain = plain
+	return nil
+}
@@ -0,0 +1,79 @@
+package secretblob
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(t *testing.T) []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+func seal(t *testing.T, key []byte, plain []byte) []byte {
+	var sealed bytes.Buffer
+	w, err := NewEncryptWriter(&sealed, key)
+	require.NoError(t, err)
+	// Write in odd sized pieces so segment boundaries don't line up with writes
+	for len(plain) > 0 {
+		n := min(len(plain), 10007)
+		_, err := w.Write(plain[:n])
+		require.NoError(t, err)
+		plain = plain[n:]
+	}
+	require.NoError(t, w.Close())
+	return sealed.Bytes()
+}
+
+func open(key []byte, sealed []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(sealed), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStream_RoundTrip(t *testing.T) {
+	key := testKey(t)
+	for _, size := range []int{0, 1, SegmentSize - 1, SegmentSize, SegmentSize + 1, 3*SegmentSize + 17} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		sealed := seal(t, key, plain)
+		segments := max(1, (size+SegmentSize-1)/SegmentSize)
+		assert.Equal(t, headerSize+size+segments*tagSize, len(sealed), "size %d", size)
+
+		got, err := open(key, sealed)
+		require.NoError(t, err, "size %d", size)
+		assert.True(t, bytes.Equal(plain, got), "size %d", size)
+	}
+}
+
+func TestStream_DetectsTampering(t *testing.T) {
+	key := testKey(t)
+	plain := make([]byte, 2*SegmentSize+100)
+	sealed := seal(t, key, plain)
+
+	_, err := open(testKey(t), sealed)
+	assert.ErrorIs(t, err, ErrInvalidStream, "wrong key")
+
+	flipped := bytes.Clone(sealed)
+	flipped[headerSize+SegmentSize+5] ^= 1
+	_, err = open(key, flipped)
+	assert.ErrorIs(t, err, ErrInvalidStream, "flipped bit")
+
+	// Dropping whole segments off the end must not pass for a shorter file
+	truncated := sealed[:headerSize+2*(SegmentSize+tagSize)]
+	_, err = open(key, truncated)
+	assert.ErrorIs(t, err, ErrInvalidStream, "truncated at a segment boundary")
+
+	_, err = open(key, sealed[:headerSize-1])
+	assert.ErrorIs(t, err, ErrInvalidStream, "truncated header")
+}
@@ -0,0 +1,246 @@
+// Package secretclient talks to the Secret Message REST API
+package secretclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusNotFound is the status of secrets and links that don't exist or whose key is wrong
+const StatusNotFound = "not_found"
+
+// Client calls the REST API of one Secret Message server with an API token
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+func NewClient(baseURL string, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+func (cl *Client) WithHTTPClient(hc *http.Client) *Client {
+	cl.httpClient = hc
+	return cl
+}
+
+// Error is a response from the server other than a success. Status is the "status" field of the
+// response body when it has one.
+type Error struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *Error) Error() string {
+	if e.Status == "" {
+		return fmt.Sprintf("server responded %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("server responded %d: %s", e.StatusCode, e.Status)
+}
+
+// IsStatus reports whether err is an Error with the given HTTP status code
+func IsStatus(err error, code int) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == code
+}
+
+// Info describes the token the client uses and what the server supports
+type Info struct {
+	TeamID    string   `json:"team_id"`
+	TokenName string   `json:"token_name"`
+	Scopes    []string `json:"scopes"`
+	// ClientEncryption is whether the server accepts secrets encrypted by the client
+	ClientEncryption bool `json:"client_encryption"`
+}
+
+// CreateRequest is a secret to create. Set either Text, or Ciphertext and KeyHash from Seal.
+type CreateRequest struct {
+	Text       string     `json:"text,omitempty"`
+	Ciphertext string     `json:"ciphertext,omitempty"`
+	KeyHash    string     `json:"key_hash,omitempty"`
+	Key        string     `json:"key,omitempty"`
+	ChannelID  string     `json:"channel_id,omitempty"`
+	UserID     string     `json:"user_id,omitempty"`
+	Recipients []string   `json:"recipients,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// Secret describes a secret without its content
+type Secret struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status"`
+	ChannelID  string    `json:"channel_id"`
+	Recipients []string  `json:"recipients"`
+	ReadBy     string    `json:"read_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	RetiredAt  time.Time `json:"retired_at"`
+	URL        string    `json:"url"`
+}
+
+// Link returns the secret's web link with its key, or "" when the secret has no link
+func (s Secret) Link(key string) string {
+	if s.URL == "" {
+		return ""
+	}
+	return s.URL + "#" + key
+}
+
+func (cl *Client) Info(ctx context.Context) (Info, error) {
+	var res Info
+	err := cl.do(ctx, http.MethodGet, "/api/v1/info", nil, &res)
+	return res, err
+}
+
+func (cl *Client) CreateSecret(ctx context.Context, req CreateRequest) (Secret, error) {
+	var res Secret
+	err := cl.do(ctx, http.MethodPost, "/api/v1/secrets", req, &res)
+	return res, err
+}
+
+func (cl *Client) GetSecret(ctx context.Context, id string) (Secret, error) {
+	var res Secret
+	err := cl.do(ctx, http.MethodGet, "/api/v1/secrets/"+url.PathEscape(id), nil, &res)
+	return res, err
+}
+
+func (cl *Client) RevokeSecret(ctx context.Context, id string) (Secret, error) {
+	var res Secret
+	err := cl.do(ctx, http.MethodDelete, "/api/v1/secrets/"+url.PathEscape(id), nil, &res)
+	return res, err
+}
+
+// ListSecrets returns up to limit of the secrets created with the client's token, newest first.
+// A limit of 0 uses the server's default.
+func (cl *Client) ListSecrets(ctx context.Context, limit int) ([]Secret, error) {
+	path := "/api/v1/secrets"
+	if limit > 0 {
+		path += "?limit=" + strconv.Itoa(limit)
+	}
+	var res struct {
+		Secrets []Secret `json:"secrets"`
+	}
+	err := cl.do(ctx, http.MethodGet, path, nil, &res)
+	return res.Secrets, err
+}
+
+// Revealed is the content of a secret read through its web link
+type Revealed struct {
+	Text string
+	// File holds the contents of a file secret, named FileName
+	File     []byte
+	FileName string
+}
+
+// revealResponse is what the reveal endpoint answers with, JSON for text and the file itself for files
+type revealResponse struct {
+	Text       string `json:"text"`
+	Ciphertext string `json:"ciphertext"`
+
+	file     []byte
+	fileName string
+}
+
+// Reveal reads the secret behind a web link, which consumes it. Reading needs no token, so the link
+// may point to any server.
+func (cl *Client) Reveal(ctx context.Context, link string) (Revealed, error) {
+	pageURL, _, key, err := ParseLink(link)
+	if err != nil {
+		return Revealed{}, err
+	}
+	var res revealResponse
+	// Client encrypted secrets are claimed with the key's hash, others with the key itself. A wrong
+	// claim doesn't consume the secret, so try one and then the other.
+	err = cl.send(ctx, http.MethodPost, pageURL, map[string]string{"key": KeyHash(key)}, &res)
+	if IsStatus(err, http.StatusNotFound) {
+		err = cl.send(ctx, http.MethodPost, pageURL, map[string]string{"key": key}, &res)
+	}
+	switch {
+	case err != nil:
+		return Revealed{}, err
+	case res.file != nil:
+		return Revealed{File: res.file, FileName: res.fileName}, nil
+	case res.Ciphertext == "":
+		return Revealed{Text: res.Text}, nil
+	}
+	plaintext, err := Open(key, res.Ciphertext)
+	if err != nil {
+		return Revealed{}, fmt.Errorf("error decrypting secret: %w", err)
+	}
+	return Revealed{Text: string(plaintext)}, nil
+}
+
+func (cl *Client) do(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	return cl.send(ctx, method, cl.baseURL+path, body, out)
+}
+
+func (cl *Client) send(ctx context.Context, method string, target string, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if cl.token != "" && strings.HasPrefix(target, cl.baseURL+"/api/") {
+		req.Header.Set("Authorization", "Bearer "+cl.token)
+	}
+
+	resp, err := cl.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		var status struct {
+			Status string `json:"status"`
+		}
+		if json.Unmarshal(respBody, &status) == nil {
+			apiErr.Status = status.Status
+		}
+		return apiErr
+	}
+	if reveal, ok := out.(*revealResponse); ok && resp.Header.Get("Content-Type") == "application/octet-stream" {
+		reveal.file = respBody
+		reveal.fileName = "secret"
+		if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+			reveal.fileName = params["filename"]
+		}
+		return nil
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
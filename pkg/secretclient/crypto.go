@@ -0,0 +1,104 @@
+package secretclient
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// keySize is the length of the AES-256 keys secrets are encrypted with before they're sent
+const keySize = 32
+
+// Sealed is a secret encrypted on the client, the same way the server's /new page does it in the browser
+type Sealed struct {
+	// Key decrypts the secret. It belongs in the link's fragment and is never sent to the server,
+	// unless the link is posted to Slack.
+	Key string
+	// KeyHash is the hex SHA-256 of Key, which the server stores in its place
+	KeyHash string
+	// Ciphertext is the unpadded base64url of the AES-GCM nonce followed by the sealed secret
+	Ciphertext string
+}
+
+// KeyHash returns the hex SHA-256 of a key as it appears in a link
+func KeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Seal encrypts plaintext with a new random key
+func Seal(plaintext []byte) (Sealed, error) {
+	keyBytes := make([]byte, keySize)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return Sealed{}, err
+	}
+	gcm, err := newGCM(keyBytes)
+	if err != nil {
+		return Sealed{}, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return Sealed{}, err
+	}
+	key := base64.RawURLEncoding.EncodeToString(keyBytes)
+	return Sealed{
+		Key:        key,
+		KeyHash:    KeyHash(key),
+		Ciphertext: base64.RawURLEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)),
+	}, nil
+}
+
+// Open decrypts a ciphertext made by Seal
+func Open(key string, ciphertext string) ([]byte, error) {
+	keyBytes, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil || len(keyBytes) != keySize {
+		return nil, errors.New("invalid key")
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %w", err)
+	}
+	gcm, err := newGCM(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ParseLink splits a web link like https://host/s/<id>#<key> into the page's URL, without the
+// fragment, the secret's ID and its key
+func ParseLink(link string) (string, string, string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", "", "", err
+	}
+	id, ok := strings.CutPrefix(u.Path, "/s/")
+	if !ok || id == "" || u.Host == "" {
+		return "", "", "", fmt.Errorf("%q is not a Secret Message link", link)
+	}
+	if u.Fragment == "" {
+		return "", "", "", fmt.Errorf("the link has no key after #, it may have been cut off")
+	}
+	key := u.Fragment
+	u.Fragment = ""
+	return u.String(), id, key, nil
+}
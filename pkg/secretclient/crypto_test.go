@@ -0,0 +1,37 @@
+package secretclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(t *testing.T) {
+	sealed, err := Seal([]byte("hunter2"))
+	require.NoError(t, err)
+	assert.Equal(t, KeyHash(sealed.Key), sealed.KeyHash)
+	assert.NotContains(t, sealed.Ciphertext, "hunter2")
+
+	plaintext, err := Open(sealed.Key, sealed.Ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", string(plaintext))
+
+	other, err := Seal([]byte("hunter2"))
+	require.NoError(t, err)
+	_, err = Open(other.Key, sealed.Ciphertext)
+	assert.Error(t, err)
+}
+
+func TestParseLink(t *testing.T) {
+	page, id, key, err := ParseLink("https://secretmessage.example.com/s/abc123#the-key")
+	require.NoError(t, err)
+	assert.Equal(t, "https://secretmessage.example.com/s/abc123", page)
+	assert.Equal(t, "abc123", id)
+	assert.Equal(t, "the-key", key)
+
+	_, _, _, err = ParseLink("https://secretmessage.example.com/s/abc123")
+	assert.Error(t, err)
+	_, _, _, err = ParseLink("https://example.com/other#key")
+	assert.Error(t, err)
+}
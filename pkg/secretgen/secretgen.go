@@ -0,0 +1,143 @@
+// Package secretgen generates random passwords and passphrases to send as secrets
+package secretgen
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"io"
+	"math/big"
+	"strings"
+)
+
+// Passwords and passphrases are drawn uniformly with crypto/rand. A password holds at least one
+// character of every charset it is made of, so it passes the usual "one of each" rules, and is then
+// shuffled so those characters aren't always first.
+
+const (
+	// DefaultPasswordLength is the length of a password when none is asked for
+	DefaultPasswordLength = 24
+	// MinPasswordLength is the shortest password that can be generated
+	MinPasswordLength = 8
+	// MaxPasswordLength is the longest password that can be generated
+	MaxPasswordLength = 128
+	// DefaultPassphraseWords is the number of words in a passphrase when none is asked for
+	DefaultPassphraseWords = 6
+	// MinPassphraseWords is the fewest words a passphrase can have. Each word drawn from the 1958 word
+	// list adds about 10.9 bits of entropy, so 6 words make about 65 bits
+	MinPassphraseWords = 6
+	// MaxPassphraseWords is the most words a passphrase can have
+	MaxPassphraseWords = 20
+	// PassphraseSeparator joins the words of a passphrase
+	PassphraseSeparator = "-"
+)
+
+// Charset is a set of the character classes a password is made of
+type Charset uint8
+
+const (
+	Lower Charset = 1 << iota
+	Upper
+	Digits
+	Symbols
+
+	// AllCharsets is every character class, what passwords are made of unless asked otherwise
+	AllCharsets = Lower | Upper | Digits | Symbols
+)
+
+// charsetCharacters are the characters of each class. Symbols leave out quotes, backslashes and
+// the characters chat apps and shells treat specially, so passwords survive being pasted.
+var charsetCharacters = []struct {
+	charset    Charset
+	characters string
+}{
+	{Lower, "abcdefghijklmnopqrstuvwxyz"},
+	{Upper, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"},
+	{Digits, "0123456789"},
+	{Symbols, "!#%()*+,-./:;=?@[]^_{}~"},
+}
+
+var (
+	// ErrInvalidLength is returned for a password length or passphrase word count out of range
+	ErrInvalidLength = errors.New("invalid length")
+	// ErrNoCharset is returned when asked for a password made of no characters
+	ErrNoCharset = errors.New("no charset")
+)
+
+//go:embed wordlist.txt
+var wordlistText string
+
+// wordlist is the words passphrases are made of, common English words of 3 to 8 letters
+var wordlist = strings.Fields(wordlistText)
+
+// Password returns a random password of length characters from charsets
+func Password(length int, charsets Charset) (string, error) {
+	return passwordWithReader(rand.Reader, length, charsets)
+}
+
+func passwordWithReader(rr io.Reader, length int, charsets Charset) (string, error) {
+	if length < MinPasswordLength || length > MaxPasswordLength {
+		return "", ErrInvalidLength
+	}
+	var all string
+	var password []byte
+	for _, c := range charsetCharacters {
+		if charsets&c.charset == 0 {
+			continue
+		}
+		all += c.characters
+		n, err := randomIndex(rr, len(c.characters))
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c.characters[n])
+	}
+	if all == "" {
+		return "", ErrNoCharset
+	}
+	for len(password) < length {
+		n, err := randomIndex(rr, len(all))
+		if err != nil {
+			return "", err
+		}
+		password = append(password, all[n])
+	}
+	// Fisher-Yates shuffle
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomIndex(rr, i+1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
+
+// Passphrase returns words random words from the embedded wordlist joined by PassphraseSeparator
+func Passphrase(words int) (string, error) {
+	return passphraseWithReader(rand.Reader, words)
+}
+
+func passphraseWithReader(rr io.Reader, words int) (string, error) {
+	if words < MinPassphraseWords || words > MaxPassphraseWords {
+		return "", ErrInvalidLength
+	}
+	chosen := make([]string, words)
+	for i := range chosen {
+		n, err := randomIndex(rr, len(wordlist))
+		if err != nil {
+			return "", err
+		}
+		chosen[i] = wordlist[n]
+	}
+	return strings.Join(chosen, PassphraseSeparator), nil
+}
+
+// randomIndex returns a uniformly random index below n
+func randomIndex(rr io.Reader, n int) (int, error) {
+	i, err := rand.Int(rr, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
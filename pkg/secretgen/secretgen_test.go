@@ -0,0 +1,77 @@
+package secretgen
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPassword(t *testing.T) {
+	password, err := Password(DefaultPasswordLength, AllCharsets)
+	require.NoError(t, err)
+	assert.Len(t, password, DefaultPasswordLength)
+	assert.Regexp(t, `[a-z]`, password)
+	assert.Regexp(t, `[A-Z]`, password)
+	assert.Regexp(t, `[0-9]`, password)
+	assert.Regexp(t, `[^a-zA-Z0-9]`, password)
+
+	other, err := Password(DefaultPasswordLength, AllCharsets)
+	require.NoError(t, err)
+	assert.NotEqual(t, password, other)
+}
+
+func TestPassword_Charsets(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		password, err := Password(MinPasswordLength, Lower|Digits)
+		require.NoError(t, err)
+		assert.Regexp(t, `^[a-z0-9]{8}$`, password)
+		// Every charset is used even in the shortest passwords
+		assert.Regexp(t, `[a-z]`, password)
+		assert.Regexp(t, `[0-9]`, password)
+	}
+}
+
+func TestPassword_Invalid(t *testing.T) {
+	_, err := Password(MinPasswordLength-1, AllCharsets)
+	assert.Equal(t, ErrInvalidLength, err)
+	_, err = Password(MaxPasswordLength+1, AllCharsets)
+	assert.Equal(t, ErrInvalidLength, err)
+	_, err = Password(DefaultPasswordLength, 0)
+	assert.Equal(t, ErrNoCharset, err)
+}
+
+func TestPassword_ReaderError(t *testing.T) {
+	_, err := passwordWithReader(bytes.NewReader(nil), DefaultPasswordLength, AllCharsets)
+	assert.Error(t, err)
+}
+
+func TestPassphrase(t *testing.T) {
+	passphrase, err := Passphrase(DefaultPassphraseWords)
+	require.NoError(t, err)
+	words := strings.Split(passphrase, PassphraseSeparator)
+	assert.Len(t, words, DefaultPassphraseWords)
+	for _, w := range words {
+		assert.Contains(t, wordlist, w)
+	}
+
+	_, err = Passphrase(MinPassphraseWords - 1)
+	assert.Equal(t, ErrInvalidLength, err)
+	_, err = Passphrase(MaxPassphraseWords + 1)
+	assert.Equal(t, ErrInvalidLength, err)
+}
+
+func TestWordlist(t *testing.T) {
+	// Over 10 bits per word, so even the shortest passphrase is over 60 bits
+	assert.GreaterOrEqual(t, len(wordlist), 1024)
+	seen := map[string]bool{}
+	word := regexp.MustCompile(`^[a-z]{3,8}$`)
+	for _, w := range wordlist {
+		assert.Regexp(t, word, w)
+		assert.False(t, seen[w], "%s is in the wordlist twice", w)
+		seen[w] = true
+	}
+}
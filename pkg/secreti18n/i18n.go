@@ -0,0 +1,83 @@
+// Package secreti18n translates the text Secret Message shows in Slack. Each locale's messages are a
+// JSON catalogue in locales/, mapping a message key to a fmt format string.
+package secreti18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultLocale is used for users and workspaces whose locale isn't translated, and for keys missing
+// from a catalogue
+const DefaultLocale = "en"
+
+//go:embed locales/*.json
+var catalogueFiles embed.FS
+
+// catalogues holds each locale's messages by key
+var catalogues = mustLoadCatalogues()
+
+func mustLoadCatalogues() map[string]map[string]string {
+	files, err := catalogueFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	loaded := make(map[string]map[string]string, len(files))
+	for _, f := range files {
+		b, err := catalogueFiles.ReadFile(path.Join("locales", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(b, &messages); err != nil {
+			panic(fmt.Sprintf("catalogue %s: %v", f.Name(), err))
+		}
+		loaded[strings.TrimSuffix(f.Name(), ".json")] = messages
+	}
+	if _, ok := loaded[DefaultLocale]; !ok {
+		panic("no catalogue for the default locale")
+	}
+	return loaded
+}
+
+// Locales returns the translated locales, sorted
+func Locales() []string {
+	locales := make([]string, 0, len(catalogues))
+	for locale := range catalogues {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Supported returns the translated locale for a locale like Slack's fr-FR, or "" if its language
+// isn't translated
+func Supported(locale string) string {
+	language, _, _ := strings.Cut(strings.ToLower(locale), "-")
+	language, _, _ = strings.Cut(language, "_")
+	if _, ok := catalogues[language]; ok {
+		return language
+	}
+	return ""
+}
+
+// T returns the message for key in locale, with its placeholders filled in from args. Locales that
+// aren't translated, and keys a catalogue misses, fall back to DefaultLocale. Unknown keys are
+// returned as is, so they stand out rather than showing nothing.
+func T(locale string, key string, args ...interface{}) string {
+	format, ok := catalogues[Supported(locale)][key]
+	if !ok {
+		format, ok = catalogues[DefaultLocale][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,51 @@
+package secreti18n
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// placeholders matches fmt verbs, %% is a literal percent sign
+var placeholders = regexp.MustCompile(`%(\[\d+\])?[-+# 0]*\d*(\.\d+)?[a-zA-Z%]`)
+
+func TestEveryCatalogueHasEveryKey(t *testing.T) {
+	for _, locale := range Locales() {
+		for key := range catalogues[DefaultLocale] {
+			assert.Contains(t, catalogues[locale], key, "%s is missing %s", locale, key)
+		}
+		for key := range catalogues[locale] {
+			assert.Contains(t, catalogues[DefaultLocale], key, "%s has %s, which %s doesn't", locale, key, DefaultLocale)
+		}
+	}
+}
+
+func TestTranslationsKeepPlaceholders(t *testing.T) {
+	for _, locale := range Locales() {
+		for key, message := range catalogues[locale] {
+			assert.ElementsMatch(t, placeholders.FindAllString(catalogues[DefaultLocale][key], -1), placeholders.FindAllString(message, -1),
+				"%s %s has different placeholders than %s", locale, key, DefaultLocale)
+		}
+	}
+}
+
+func TestLocales(t *testing.T) {
+	assert.Equal(t, []string{"de", "en", "fr"}, Locales())
+}
+
+func TestSupported(t *testing.T) {
+	assert.Equal(t, "fr", Supported("fr-FR"))
+	assert.Equal(t, "de", Supported("de_AT"))
+	assert.Equal(t, "en", Supported("EN-us"))
+	assert.Equal(t, "", Supported("ja-JP"))
+	assert.Equal(t, "", Supported(""))
+}
+
+func TestT(t *testing.T) {
+	assert.Equal(t, "Secret sent", T("en-US", "sent_modal.title"))
+	assert.Equal(t, "Secret envoyé", T("fr-FR", "sent_modal.title"))
+	assert.Equal(t, "Secret sent", T("ja-JP", "sent_modal.title"), "untranslated locales fall back to English")
+	assert.Equal(t, "Höchstens 500 Zeichen", T("de-DE", "create_modal.secret_hint", "500"))
+	assert.Equal(t, "no.such.key", T("fr-FR", "no.such.key"))
+}
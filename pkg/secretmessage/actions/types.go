@@ -2,3 +2,14 @@ package actions
 
 const ReadMessage string = "send_secret"
 const DeleteMessage string = "delete_secret"
+
+// Modal callback IDs
+const CreateSecretModal string = "create_secret"
+const TeamSettingsModal string = "team_settings"
+const SplitSecretModal string = "split_secret"
+const SubmitShareModal string = "submit_share"
+
+// Block action IDs
+const RevokeSecret string = "revoke_secret"
+const SubmitShare string = "submit_share"
+const ChooseTemplate string = "choose_template"
@@ -0,0 +1,99 @@
+package secretmessage
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// APIScopeSecretsWrite allows creating and delivering secrets
+	APIScopeSecretsWrite = "secrets:write"
+	// APIScopeSecretsRead allows looking up whether a secret was read
+	APIScopeSecretsRead = "secrets:read"
+	// APIScopeSecretsRevoke allows revoking unread secrets
+	APIScopeSecretsRevoke = "secrets:revoke"
+
+	apiTokenPrefix = "smk_"
+	// apiTokenLastUsedPrecision is how stale a token's last use may be before it is updated, so a busy
+	// token doesn't write to the database on every request
+	apiTokenLastUsedPrecision = time.Minute
+)
+
+// APIScopes are every scope an API token can be granted
+var APIScopes = []string{APIScopeSecretsWrite, APIScopeSecretsRead, APIScopeSecretsRevoke}
+
+// HasScope reports whether the token was granted scope
+func (t APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// actorID identifies the token as the actor of audit events, since no Slack user is involved
+func (t APIToken) actorID() string {
+	return fmt.Sprintf("api_token:%d", t.ID)
+}
+
+// CreateAPIToken issues a token for the team with the given scopes. The returned token can't be
+// recovered later, only its hash is stored.
+func CreateAPIToken(ctx context.Context, db *gorm.DB, teamID string, enterpriseID string, name string, scopes []string) (string, APIToken, error) {
+	if teamID == "" || name == "" {
+		return "", APIToken{}, fmt.Errorf("an API token needs a team and a name")
+	}
+	if len(scopes) == 0 {
+		return "", APIToken{}, fmt.Errorf("an API token needs at least one scope, from %s", strings.Join(APIScopes, ", "))
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(APIScopes, scope) {
+			return "", APIToken{}, fmt.Errorf("unknown API scope %q, expected one of %s", scope, strings.Join(APIScopes, ", "))
+		}
+	}
+
+	token := apiTokenPrefix + rand.Text()
+	apiToken := APIToken{
+		TeamID:       teamID,
+		EnterpriseID: enterpriseID,
+		Name:         name,
+		TokenHash:    hash(token),
+		Scopes:       scopes,
+	}
+	if err := db.WithContext(ctx).Create(&apiToken).Error; err != nil {
+		return "", APIToken{}, err
+	}
+	return token, apiToken, nil
+}
+
+// findAPIToken looks up an unrevoked token and notes that it was used, at most once a minute
+func findAPIToken(ctx context.Context, db *gorm.DB, token string) (APIToken, error) {
+	var apiToken APIToken
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return apiToken, gorm.ErrRecordNotFound
+	}
+	if err := db.WithContext(ctx).Where("token_hash = ?", hash(token)).First(&apiToken).Error; err != nil {
+		return apiToken, err
+	}
+	now := time.Now()
+	if now.Sub(apiToken.LastUsedAt) < apiTokenLastUsedPrecision {
+		return apiToken, nil
+	}
+	apiToken.LastUsedAt = now
+	err := db.WithContext(ctx).Model(&apiToken).UpdateColumn("last_used_at", apiToken.LastUsedAt).Error
+	return apiToken, err
+}
+
+// ListAPITokens returns the team's unrevoked tokens, oldest first
+func ListAPITokens(ctx context.Context, db *gorm.DB, teamID string) ([]APIToken, error) {
+	var tokens []APIToken
+	err := db.WithContext(ctx).Where("team_id = ?", teamID).Order("id").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeAPIToken deletes one of the team's tokens, reporting whether it existed
+func RevokeAPIToken(ctx context.Context, db *gorm.DB, teamID string, id uint) (bool, error) {
+	res := db.WithContext(ctx).Where("team_id = ?", teamID).Delete(&APIToken{}, id)
+	return res.RowsAffected > 0, res.Error
+}
@@ -0,0 +1,79 @@
+package secretmessage
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newAPITokenTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname="+t.Name()), &gorm.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		d, _ := db.DB()
+		d.Close()
+	})
+	require.NoError(t, db.AutoMigrate(APIToken{}))
+	return db
+}
+
+func TestCreateAPIToken_StoresOnlyTheHash(t *testing.T) {
+	db := newAPITokenTestDB(t)
+	token, created, err := CreateAPIToken(context.Background(), db, "T1", "", "ci", []string{APIScopeSecretsWrite})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, apiTokenPrefix))
+
+	var stored APIToken
+	require.NoError(t, db.First(&stored, created.ID).Error)
+	assert.Equal(t, hash(token), stored.TokenHash)
+	assert.NotContains(t, stored.TokenHash, token)
+	assert.Equal(t, []string{APIScopeSecretsWrite}, stored.Scopes)
+
+	found, err := findAPIToken(context.Background(), db, token)
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, found.ID)
+	assert.False(t, found.LastUsedAt.IsZero())
+}
+
+func TestFindAPIToken_UpdatesLastUsedAtOncePerMinute(t *testing.T) {
+	db := newAPITokenTestDB(t)
+	token, created, err := CreateAPIToken(context.Background(), db, "T1", "", "ci", []string{APIScopeSecretsWrite})
+	require.NoError(t, err)
+
+	recent := time.Now().Add(-10 * time.Second).Truncate(time.Second)
+	require.NoError(t, db.Model(&created).UpdateColumn("last_used_at", recent).Error)
+	found, err := findAPIToken(context.Background(), db, token)
+	require.NoError(t, err)
+	assert.True(t, found.LastUsedAt.Equal(recent))
+
+	stale := time.Now().Add(-2 * time.Minute)
+	require.NoError(t, db.Model(&created).UpdateColumn("last_used_at", stale).Error)
+	found, err = findAPIToken(context.Background(), db, token)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), found.LastUsedAt, 5*time.Second)
+	var stored APIToken
+	require.NoError(t, db.First(&stored, created.ID).Error)
+	assert.WithinDuration(t, time.Now(), stored.LastUsedAt, 5*time.Second)
+}
+
+func TestCreateAPIToken_RejectsUnknownScopes(t *testing.T) {
+	db := newAPITokenTestDB(t)
+	_, _, err := CreateAPIToken(context.Background(), db, "T1", "", "ci", []string{"secrets:everything"})
+	assert.ErrorContains(t, err, "secrets:everything")
+	_, _, err = CreateAPIToken(context.Background(), db, "T1", "", "ci", nil)
+	assert.Error(t, err)
+}
+
+func TestFindAPIToken_Unknown(t *testing.T) {
+	db := newAPITokenTestDB(t)
+	_, err := findAPIToken(context.Background(), db, "smk_missing")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = findAPIToken(context.Background(), db, "not-a-token")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
@@ -0,0 +1,193 @@
+package secretmessage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// auditAppendAttempts bounds how often appending retries after losing a race for the next Seq
+	auditAppendAttempts = 5
+	// auditKeyedHashPrefix marks hashes keyed with the audit key, from events written once one was set
+	auditKeyedHashPrefix = "hmac:"
+)
+
+var errAuditSeqTaken = errors.New("audit event sequence number already taken")
+
+// minAuditKeyLength is the shortest audit key accepted, 32 bytes like the HMAC's output
+const minAuditKeyLength = 32
+
+// ParseAuditKey checks an audit key, any string of at least 32 characters, e.g. from
+// openssl rand -hex 32
+func ParseAuditKey(s string) ([]byte, error) {
+	if len(s) < minAuditKeyLength {
+		return nil, fmt.Errorf("audit key must be at least %d characters", minAuditKeyLength)
+	}
+	return []byte(s), nil
+}
+
+// AuditChainBreak is a point where a team's audit chain doesn't verify
+type AuditChainBreak struct {
+	TeamID string
+	Seq    int64
+	Reason string
+}
+
+func (b AuditChainBreak) String() string {
+	return fmt.Sprintf("team %s seq %d: %s", b.TeamID, b.Seq, b.Reason)
+}
+
+// computeHash hashes every field of the event except its own Hash, chaining it to the previous event.
+// The hash is an HMAC keyed with key, so that events can't be forged by someone who can only write to
+// the database. Without a key it is a plain SHA-256, as events were hashed before keys were required.
+func (e AuditEvent) computeHash(key []byte) string {
+	fields := []string{
+		e.TeamID,
+		strconv.FormatInt(e.Seq, 10),
+		strconv.FormatInt(e.OccurredAt.UnixMicro(), 10),
+		e.Action,
+		e.SecretID,
+		e.ActorID,
+		e.ChannelID,
+		e.PrevHash,
+	}
+	for i, f := range fields {
+		// Length-prefix fields so that moving a separator between fields changes the hash
+		fields[i] = strconv.Itoa(len(f)) + ":" + f
+	}
+	if len(key) == 0 {
+		sum := sha256.Sum256([]byte(strings.Join(fields, "|")))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join(fields, "|")))
+	return auditKeyedHashPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (e AuditEvent) keyed() bool {
+	return strings.HasPrefix(e.Hash, auditKeyedHashPrefix)
+}
+
+// AppendAuditEvent adds an event to the end of its team's chain, hashed with key
+func AppendAuditEvent(ctx context.Context, db *gorm.DB, key []byte, e AuditEvent) (AuditEvent, error) {
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Now()
+	}
+	// Postgres keeps microseconds, so hash what will be read back
+	e.OccurredAt = e.OccurredAt.UTC().Truncate(time.Microsecond)
+
+	var err error
+	for attempt := 0; attempt < auditAppendAttempts; attempt++ {
+		err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var last AuditEvent
+			lastErr := tx.Where("team_id = ?", e.TeamID).Order("seq DESC").Take(&last).Error
+			if lastErr != nil && !errors.Is(lastErr, gorm.ErrRecordNotFound) {
+				return lastErr
+			}
+			e.ID = 0
+			e.Seq = last.Seq + 1
+			e.PrevHash = last.Hash
+			e.Hash = e.computeHash(key)
+			// A concurrent append that took this Seq makes the insert a no-op, and we retry
+			res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&e)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return errAuditSeqTaken
+			}
+			return nil
+		})
+		if !errors.Is(err, errAuditSeqTaken) {
+			return e, err
+		}
+	}
+	return e, err
+}
+
+// VerifyAuditChain walks the audit chain of one team, or of every team when teamID is empty, and
+// reports each event that was edited, deleted or inserted out of order. It returns how many events
+// were checked. Events deleted from the very end of a chain leave no trace and can't be detected.
+// Events hashed before an audit key was set are only checked for consistency, as anyone could have
+// recomputed their hashes, but no unkeyed event may follow a keyed one.
+func VerifyAuditChain(ctx context.Context, db *gorm.DB, key []byte, teamID string) ([]AuditChainBreak, int, error) {
+	teamIDs := []string{teamID}
+	if teamID == "" {
+		teamIDs = nil
+		if err := db.WithContext(ctx).Model(&AuditEvent{}).Distinct("team_id").Order("team_id").Pluck("team_id", &teamIDs).Error; err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var breaks []AuditChainBreak
+	var checked int
+	for _, id := range teamIDs {
+		prev := AuditEvent{TeamID: id}
+		var batch []AuditEvent
+		err := db.WithContext(ctx).
+			Where("team_id = ?", id).
+			Order("seq").
+			FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+				for _, e := range batch {
+					breaks = append(breaks, verifyAuditLink(key, prev, e)...)
+					prev = e
+					checked++
+				}
+				return nil
+			}).Error
+		if err != nil {
+			return breaks, checked, err
+		}
+	}
+	return breaks, checked, nil
+}
+
+func verifyAuditLink(key []byte, prev AuditEvent, e AuditEvent) []AuditChainBreak {
+	var breaks []AuditChainBreak
+	if prev.keyed() && !e.keyed() {
+		breaks = append(breaks, AuditChainBreak{e.TeamID, e.Seq, "event is not keyed but follows a keyed one"})
+	}
+	if !e.keyed() {
+		key = nil
+	} else if len(key) == 0 {
+		return append(breaks, AuditChainBreak{e.TeamID, e.Seq, "event is keyed but no audit key is set"})
+	}
+	if e.Seq != prev.Seq+1 {
+		breaks = append(breaks, AuditChainBreak{e.TeamID, e.Seq, fmt.Sprintf("expected seq %d", prev.Seq+1)})
+	}
+	if e.PrevHash != prev.Hash {
+		breaks = append(breaks, AuditChainBreak{e.TeamID, e.Seq, "previous hash does not match the preceding event"})
+	}
+	if !hmac.Equal([]byte(e.Hash), []byte(e.computeHash(key))) {
+		breaks = append(breaks, AuditChainBreak{e.TeamID, e.Seq, "hash does not match the event's contents"})
+	}
+	return breaks
+}
+
+// recordSecretEvent appends a lifecycle event for a secret to the audit log and sends it to the team's
+// webhook. Failures are logged rather than returned, so the Slack interaction that triggered the event
+// still completes.
+func (ctl *PublicController) recordSecretEvent(ctx context.Context, action string, teamID string, secretID string, actorID string, channelID string) {
+	event, err := AppendAuditEvent(ctx, ctl.db, ctl.config.AuditKey, AuditEvent{
+		TeamID:    teamID,
+		Action:    action,
+		SecretID:  secretID,
+		ActorID:   actorID,
+		ChannelID: channelID,
+	})
+	if err != nil {
+		ctl.logger.Error("error writing audit event", zap.Error(err), zap.String("action", action), zap.String("teamID", teamID))
+	}
+	ctl.sendWebhook(ctx, event)
+}
@@ -0,0 +1,221 @@
+package secretmessage
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AuditExportJSONLines = "jsonl"
+	AuditExportCSV       = "csv"
+
+	// auditExportBatchSize is how many events are held in memory at a time while streaming an export
+	auditExportBatchSize = 500
+)
+
+// AuditExportQuery selects one page of a team's audit events. Since is inclusive and Until exclusive,
+// zero times leave the range open. A Limit of zero exports everything after Cursor.
+type AuditExportQuery struct {
+	TeamID string
+	Since  time.Time
+	Until  time.Time
+	Cursor string
+	Limit  int
+	Format string
+}
+
+// auditExportRecord is the exported form of an AuditEvent, shared by both formats
+type auditExportRecord struct {
+	TeamID     string    `json:"team_id"`
+	Seq        int64     `json:"seq"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Action     string    `json:"action"`
+	SecretID   string    `json:"secret_id"`
+	ActorID    string    `json:"actor_id"`
+	ChannelID  string    `json:"channel_id"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+var auditExportCSVHeader = []string{"team_id", "seq", "occurred_at", "action", "secret_id", "actor_id", "channel_id", "prev_hash", "hash"}
+
+func (r auditExportRecord) csvRow() []string {
+	return []string{r.TeamID, strconv.FormatInt(r.Seq, 10), r.OccurredAt.Format(time.RFC3339Nano), r.Action, r.SecretID, r.ActorID, r.ChannelID, r.PrevHash, r.Hash}
+}
+
+// auditEventWriter encodes exported events onto a stream
+type auditEventWriter interface {
+	Write(auditExportRecord) error
+	// Flush pushes buffered events to the client, so long exports stream instead of arriving at the end
+	Flush() error
+}
+
+type jsonLinesAuditWriter struct {
+	w   io.Writer
+	enc *json.Encoder
+}
+
+func (j *jsonLinesAuditWriter) Write(r auditExportRecord) error {
+	return j.enc.Encode(r)
+}
+
+func (j *jsonLinesAuditWriter) Flush() error {
+	flushHTTP(j.w)
+	return nil
+}
+
+type csvAuditWriter struct {
+	w           io.Writer
+	csv         *csv.Writer
+	wroteHeader bool
+}
+
+func (c *csvAuditWriter) Write(r auditExportRecord) error {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		if err := c.csv.Write(auditExportCSVHeader); err != nil {
+			return err
+		}
+	}
+	return c.csv.Write(r.csvRow())
+}
+
+func (c *csvAuditWriter) Flush() error {
+	if !c.wroteHeader {
+		// An empty export is still a valid CSV file with a header
+		c.wroteHeader = true
+		if err := c.csv.Write(auditExportCSVHeader); err != nil {
+			return err
+		}
+	}
+	c.csv.Flush()
+	flushHTTP(c.w)
+	return c.csv.Error()
+}
+
+func flushHTTP(w io.Writer) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func newAuditEventWriter(w io.Writer, format string) (auditEventWriter, error) {
+	switch format {
+	case AuditExportJSONLines, "":
+		return &jsonLinesAuditWriter{w: w, enc: json.NewEncoder(w)}, nil
+	case AuditExportCSV:
+		return &csvAuditWriter{w: w, csv: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown audit export format %q, expected %s or %s", format, AuditExportJSONLines, AuditExportCSV)
+	}
+}
+
+// AuditExportContentType is the Content-Type of an export in the given format
+func AuditExportContentType(format string) string {
+	if format == AuditExportCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// scope restricts db to the query's team and time range, after its cursor
+func (q AuditExportQuery) scope(db *gorm.DB, afterSeq int64) *gorm.DB {
+	db = db.Model(&AuditEvent{}).Where("team_id = ? AND seq > ?", q.TeamID, afterSeq)
+	if !q.Since.IsZero() {
+		db = db.Where("occurred_at >= ?", q.Since)
+	}
+	if !q.Until.IsZero() {
+		db = db.Where("occurred_at < ?", q.Until)
+	}
+	return db
+}
+
+// parseAuditCursor reads a cursor returned by a previous page. Cursors are opaque to clients.
+func parseAuditCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return seq, nil
+}
+
+// NextAuditExportCursor returns the cursor of the page after q, or "" if q is the last page. It is
+// computed before exporting so that it can be sent as a header ahead of the streamed body.
+func NextAuditExportCursor(ctx context.Context, db *gorm.DB, q AuditExportQuery) (string, error) {
+	afterSeq, err := parseAuditCursor(q.Cursor)
+	if err != nil || q.Limit <= 0 {
+		return "", err
+	}
+	var seqs []int64
+	err = q.scope(db.WithContext(ctx), afterSeq).
+		Order("seq").
+		Offset(q.Limit-1).
+		Limit(2).
+		Pluck("seq", &seqs).Error
+	if err != nil || len(seqs) < 2 {
+		return "", err
+	}
+	return strconv.FormatInt(seqs[0], 10), nil
+}
+
+// ExportAuditEvents streams one page of a team's audit events to w, in Seq order, holding at most
+// one batch in memory. It returns how many events were written.
+func ExportAuditEvents(ctx context.Context, db *gorm.DB, w io.Writer, q AuditExportQuery) (int, error) {
+	afterSeq, err := parseAuditCursor(q.Cursor)
+	if err != nil {
+		return 0, err
+	}
+	aw, err := newAuditEventWriter(w, q.Format)
+	if err != nil {
+		return 0, err
+	}
+
+	var written int
+	for q.Limit <= 0 || written < q.Limit {
+		batchSize := auditExportBatchSize
+		if q.Limit > 0 && q.Limit-written < batchSize {
+			batchSize = q.Limit - written
+		}
+		var batch []AuditEvent
+		err := q.scope(db.WithContext(ctx), afterSeq).Order("seq").Limit(batchSize).Find(&batch).Error
+		if err != nil {
+			return written, err
+		}
+		for _, e := range batch {
+			err := aw.Write(auditExportRecord{
+				TeamID:     e.TeamID,
+				Seq:        e.Seq,
+				OccurredAt: e.OccurredAt.UTC(),
+				Action:     e.Action,
+				SecretID:   e.SecretID,
+				ActorID:    e.ActorID,
+				ChannelID:  e.ChannelID,
+				PrevHash:   e.PrevHash,
+				Hash:       e.Hash,
+			})
+			if err != nil {
+				return written, err
+			}
+			afterSeq = e.Seq
+			written++
+		}
+		if err := aw.Flush(); err != nil {
+			return written, err
+		}
+		if len(batch) < batchSize {
+			break
+		}
+	}
+	return written, nil
+}
@@ -1,6 +1,7 @@
 package secretmessage
 
 import (
+	"github.com/neufeldtech/secretmessage-go/pkg/secretblob"
 	"golang.org/x/oauth2"
 )
 
@@ -12,4 +13,17 @@ type Config struct {
 	AppURL                  string
 	OauthConfig             *oauth2.Config
 	DatabaseURL             string
+	// TokenCipher encrypts Slack tokens, webhook signing secrets and submitted shares in the database.
+	// Without one they are stored in plain text, which is only meant for development and tests.
+	TokenCipher *TokenCipher
+	// AuditKey keys the audit log's hash chain. It is kept out of the database, so that someone who can
+	// write to it can't rewrite events and their hashes.
+	AuditKey []byte
+	// AdminAPIToken authenticates operator endpoints such as the audit export. They are disabled when empty.
+	AdminAPIToken string
+	// AllowPrivateWebhooks lets webhooks be delivered to loopback and private network addresses. Admins
+	// of any workspace set webhook URLs, so only receivers on the public internet are allowed by default.
+	AllowPrivateWebhooks bool
+	// BlobStore holds the encrypted contents of file secrets. Sharing files is disabled when nil.
+	BlobStore secretblob.Store
 }
@@ -1,29 +1,44 @@
 package secretmessage
 
 import (
+	"context"
 	"net/http"
 	"os"
 	"time"
 
 	ginzap "github.com/gin-contrib/zap"
 	"github.com/gin-gonic/gin"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretqueue"
 	"github.com/neufeldtech/secretmessage-go/pkg/secretslack"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretwebhook"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+// jobWorkers is how many acknowledged requests are processed in the background concurrently
+const jobWorkers = 8
+
 type PublicController struct {
-	db           *gorm.DB
-	config       Config
-	logger       *zap.Logger
-	slackService *secretslack.SlackService
+	db            *gorm.DB
+	config        Config
+	logger        *zap.Logger
+	slackService  *secretslack.SlackService
+	eventHandlers map[string]EventHandler
+	webhooks      *secretwebhook.Dispatcher
+	jobs          *secretqueue.Queue
+	locales       *localeCache
+
+	tokenRefreshLocks teamLocks
 }
 
 func NewController(config Config, db *gorm.DB, logger *zap.Logger) *PublicController {
 	if logger == nil {
 		logger = zap.Must(zap.NewProduction())
 	}
+	if config.TokenCipher == nil {
+		logger.Warn("Token encryption is disabled. Slack tokens, webhook secrets and split secret shares will be stored in plain text. This is not recommended.")
+	}
 
 	slackService := secretslack.NewSlackService().
 		WithHTTPClient(
@@ -32,14 +47,45 @@ func NewController(config Config, db *gorm.DB, logger *zap.Logger) *PublicContro
 		).
 		WithLogger(logger)
 
+	webhookClient := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: secretwebhook.NewPublicTransport(nil),
+	}
+	if config.AllowPrivateWebhooks {
+		logger.Warn("Webhooks can be delivered to private network addresses.")
+		webhookClient.Transport = nil
+	}
+	webhooks := secretwebhook.NewDispatcher().
+		WithHTTPClient(webhookClient).
+		WithLogger(logger).
+		Start(webhookWorkers)
+
+	jobs := secretqueue.NewQueue().
+		WithLogger(logger).
+		Start(jobWorkers)
+
 	return &PublicController{
-		db:           db,
-		config:       config,
-		logger:       logger,
-		slackService: slackService,
+		db:            db,
+		config:        config,
+		logger:        logger,
+		slackService:  slackService,
+		eventHandlers: defaultEventHandlers(),
+		webhooks:      webhooks,
+		jobs:          jobs,
+		locales:       newLocaleCache(),
 	}
 }
 
+// Close waits for background work, like slash commands that were already acknowledged and queued
+// webhook deliveries, to finish, or for ctx to be done
+func (ctl *PublicController) Close(ctx context.Context) error {
+	// Jobs record events that queue webhooks, so they finish first
+	if err := ctl.jobs.Close(ctx); err != nil {
+		return err
+	}
+	return ctl.webhooks.Close(ctx)
+}
+
 func (ctl *PublicController) ConfigureRoutes() *gin.Engine {
 
 	r := gin.New()
@@ -65,15 +111,41 @@ func (ctl *PublicController) ConfigureRoutes() *gin.Engine {
 				zap.Int64("MaxLifetimeClosed", stats.MaxLifetimeClosed),
 			)
 		}
+		// Cache stats change on every request, they are only worth logging while debugging the cache
+		clientStats := ctl.slackService.ClientCacheStats()
+		ctl.logger.Debug("Slack Client Cache Stats",
+			zap.Uint64("Hits", clientStats.Hits),
+			zap.Uint64("Misses", clientStats.Misses),
+			zap.Uint64("Evictions", clientStats.Evictions),
+			zap.Int("Size", clientStats.Size),
+		)
 	})
 	r.GET("/health", ctl.HandleHealth)
 
 	r.GET("/auth/slack", ctl.HandleOauthBegin)
 	r.GET("/auth/slack/callback", ctl.HandleOauthCallback)
 
+	// One-time web links, the key is in the URL fragment so it never shows up in request logs
+	r.GET("/s/:id", ctl.HandleRevealPage)
+	r.POST("/s/:id", ctl.HandleReveal)
+	r.GET("/new", ctl.HandleNewSecretPage)
+
 	// Signature validation required
 	r.POST("/slash", ctl.ValidateSignature(), ctl.HandleSlash)
 	r.POST("/interactive", ctl.ValidateSignature(), ctl.HandleInteractive)
+	r.POST("/events", ctl.ValidateSignature(), ctl.HandleEvents)
+
+	// Admin token required
+	r.GET("/admin/audit/export", ctl.RequireAdminToken(), ctl.HandleAuditExport)
+
+	// API token required
+	api := r.Group("/api/v1")
+	api.GET("/info", ctl.RequireAPIToken(""), ctl.HandleAPIInfo)
+	api.GET("/secrets", ctl.RequireAPIToken(APIScopeSecretsRead), ctl.HandleAPIListSecrets)
+	api.POST("/secrets", ctl.RequireAPIToken(APIScopeSecretsWrite), ctl.HandleAPICreateSecret)
+	api.GET("/secrets/:id", ctl.RequireAPIToken(APIScopeSecretsRead), ctl.HandleAPIGetSecret)
+	api.DELETE("/secrets/:id", ctl.RequireAPIToken(APIScopeSecretsRevoke), ctl.HandleAPIRevokeSecret)
+	api.POST("/files", ctl.RequireAPIToken(APIScopeSecretsWrite), ctl.HandleAPICreateFile)
 
 	return r
 }
@@ -3,6 +3,7 @@ package secretmessage
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hkdf"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
@@ -23,6 +24,12 @@ func deriveCryptoKey(key string) []byte {
 	hasher.Write([]byte(key))
 	return hasher.Sum(nil)
 }
+
+// deriveFileKey derives the AES-256 key a file secret's contents are encrypted with from its secret key
+func deriveFileKey(key string) ([]byte, error) {
+	return hkdf.Key(sha256.New, []byte(key), nil, "secretmessage file", 32)
+}
+
 func decrypt(input string, passphrase string) (string, error) {
 	var result string
 	if input == "" {
@@ -68,6 +75,10 @@ func encryptWithReader(rr io.Reader, input string, passphrase string) (string, e
 	if input == "" {
 		return result, fmt.Errorf("cannot encrypt empty string")
 	}
+	// Secrets are checked against their team's limit before they get here, this is the hard stop
+	if len(input) > utf8.UTFMax*MaxSecretLengthLimit {
+		return result, fmt.Errorf("cannot encrypt more than %d bytes", utf8.UTFMax*MaxSecretLengthLimit)
+	}
 	if passphrase == "" {
 		return result, fmt.Errorf("cannot encrypt with empty passphrase")
 	}
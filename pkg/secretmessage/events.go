@@ -0,0 +1,87 @@
+package secretmessage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slack-go/slack/slackevents"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// EventAppUninstalled forgets the team's token and purges its secrets once the app is removed from a workspace
+func EventAppUninstalled(ctl *PublicController, c *gin.Context, e slackevents.EventsAPIEvent) error {
+	ctl.logger.Info("app uninstalled", zap.String("teamID", e.TeamID))
+	return revokeTeamInstallation(ctl, c.Request.Context(), e.TeamID, e.EnterpriseID)
+}
+
+// EventTokensRevoked behaves like EventAppUninstalled when the team's bot token is revoked.
+// Revoked user tokens are ignored since the app never stores them.
+func EventTokensRevoked(ctl *PublicController, c *gin.Context, e slackevents.EventsAPIEvent) error {
+	revoked, ok := e.InnerEvent.Data.(*slackevents.TokensRevokedEvent)
+	if !ok || len(revoked.Tokens.Bot) == 0 {
+		return nil
+	}
+	ctl.logger.Info("bot token revoked", zap.String("teamID", e.TeamID))
+	return revokeTeamInstallation(ctl, c.Request.Context(), e.TeamID, e.EnterpriseID)
+}
+
+// revokeTeamInstallation clears the stored tokens of the installation serving a workspace and deletes
+// every secret sent from that workspace, since nobody there can read them anymore, along with its API
+// tokens, submitted shares and stored files. An org-wide install keeps serving the org's other
+// workspaces, so its tokens are only cleared by events about the org itself.
+//
+// Secrets stored before secrets recorded their team have an empty team_id and can't be told apart,
+// so they aren't purged here. They are retired by the expiry sweep like any other secret. Events about
+// an org without a workspace have an empty teamID too, so they only clear the org install's tokens.
+func revokeTeamInstallation(ctl *PublicController, ctx context.Context, teamID string, enterpriseID string) error {
+	team, err := ctl.findInstallation(ctx, teamID, enterpriseID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	ownInstallation := err == nil && (!team.IsEnterpriseInstall || teamID == "" || teamID == team.ID)
+	// Files of retired secrets are already deleted, the rest go with the secrets
+	var fileIDs []string
+	if teamID != "" {
+		err = ctl.db.WithContext(ctx).Model(&Secret{}).Where("team_id = ? AND file = ?", teamID, true).Pluck("id", &fileIDs).Error
+		if err != nil {
+			return err
+		}
+	}
+	err = ctl.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if ownInstallation {
+			err := tx.Model(&Team{}).
+				Where("id = ?", team.ID).
+				Updates(map[string]interface{}{
+					"access_token":     "",
+					"refresh_token":    "",
+					"token_expires_at": time.Time{},
+				}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if teamID == "" {
+			return nil
+		}
+		if err := tx.Where("team_id = ?", teamID).Delete(&APIToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("team_id = ?", teamID).Delete(&SecretShare{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("team_id = ?", teamID).Delete(&Secret{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range fileIDs {
+		ctl.deleteFile(ctx, id)
+	}
+	if ownInstallation {
+		ctl.slackService.InvalidateTeam(team.ID)
+	}
+	return nil
+}
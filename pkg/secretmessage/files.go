@@ -0,0 +1,243 @@
+package secretmessage
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretblob"
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.uber.org/zap"
+)
+
+// maxFileNameLength bounds the stored name of a file secret, in bytes
+const maxFileNameLength = 255
+
+var (
+	errFilesDisabled = newUserError("files_disabled")
+	errFileTooLarge  = errors.New("file is larger than the workspace allows")
+	errFileEmpty     = errors.New("file is empty")
+)
+
+// filesEnabled reports whether file secrets can be stored, and served as web links
+func (ctl *PublicController) filesEnabled() bool {
+	return ctl.config.BlobStore != nil && ctl.config.AppURL != ""
+}
+
+// fileName cleans up the name a file was uploaded with, so it's safe to offer back as a download
+func fileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == '\\' {
+			return -1
+		}
+		return r
+	}, path.Base(strings.ReplaceAll(name, "\\", "/")))
+	for len(name) > maxFileNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "secret"
+	}
+	return name
+}
+
+// putFile encrypts a file into the blob store under a new key, failing with errFileTooLarge once it
+// grows past maxSize. It returns the key, the ID the blob is stored under and the file's size.
+func (ctl *PublicController) putFile(ctx context.Context, r io.Reader, maxSize int64) (string, string, int64, error) {
+	key := rand.Text()
+	id := hash(key)
+	fileKey, err := deriveFileKey(key)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	var size int64
+	go func() {
+		defer close(done)
+		w, err := secretblob.NewEncryptWriter(pw, fileKey)
+		if err == nil {
+			size, err = io.Copy(w, io.LimitReader(r, maxSize+1))
+		}
+		switch {
+		case err != nil:
+		case size > maxSize:
+			err = errFileTooLarge
+		case size == 0:
+			err = errFileEmpty
+		default:
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	_, err = ctl.config.BlobStore.Put(ctx, id, pr)
+	// Unblock the encryption if the store gave up before reading everything
+	pr.CloseWithError(err)
+	<-done
+	if err != nil {
+		return "", "", 0, err
+	}
+	return key, id, size, nil
+}
+
+// saveFile stores the secret of a file that putFile encrypted, deleting the file if that fails. The
+// file's name is encrypted with the same key as its contents.
+func (ctl *PublicController) saveFile(ctx context.Context, key string, id string, name string, size int64, teamID string, settings TeamSettings, options ...SecretOption) (*Secret, error) {
+	nameEncrypted, err := encrypt(fileName(name), key)
+	if err != nil {
+		ctl.deleteFile(ctx, id)
+		return nil, err
+	}
+	sec, err := ctl.saveSecret(ctx, id, nameEncrypted, teamID, settings, append(options, WithWebLink(true), WithFile(size))...)
+	if err != nil {
+		ctl.deleteFile(ctx, id)
+		return nil, err
+	}
+	return sec, nil
+}
+
+// openFile decrypts a file secret's name and returns a reader of its decrypted contents, which the
+// caller must close
+func (ctl *PublicController) openFile(ctx context.Context, secret Secret, key string) (string, io.Reader, io.Closer, error) {
+	if ctl.config.BlobStore == nil {
+		return "", nil, nil, errFilesDisabled
+	}
+	name, err := decrypt(secret.Value, key)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("decrypting file name: %w", err)
+	}
+	fileKey, err := deriveFileKey(key)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	blob, err := ctl.config.BlobStore.Open(ctx, secret.ID)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	r, err := secretblob.NewDecryptReader(blob, fileKey)
+	if err != nil {
+		blob.Close()
+		return "", nil, nil, err
+	}
+	return name, r, blob, nil
+}
+
+// deleteFile removes a file secret's contents from the blob store once it is retired. It's a no-op
+// for secrets that aren't files.
+func (ctl *PublicController) deleteFile(ctx context.Context, id string) {
+	if ctl.config.BlobStore == nil {
+		return
+	}
+	if err := ctl.config.BlobStore.Delete(ctx, id); err != nil {
+		ctl.logger.Error("error deleting file blob", zap.Error(err), zap.String("secretID", id))
+	}
+}
+
+// EventMessage turns files shared with the app in its DM into one-time download links, and replies in
+// the DM with a link for the sender to pass on
+func EventMessage(ctl *PublicController, c *gin.Context, e slackevents.EventsAPIEvent) error {
+	msg, ok := e.InnerEvent.Data.(*slackevents.MessageEvent)
+	if !ok || msg.ChannelType != "im" || msg.SubType != "file_share" || msg.BotID != "" || msg.Message == nil {
+		return nil
+	}
+	// Slack retries events it didn't see acknowledged in time, and a retry would share the files twice
+	if c.GetHeader("X-Slack-Retry-Num") != "" {
+		return nil
+	}
+	// Storing a file can outlast the 3 seconds Slack waits for a response, so it's done in the background
+	return ctl.jobs.Enqueue(c.Request.Context(), func(ctx context.Context) {
+		if err := shareMessageFiles(ctl, ctx, e, msg); err != nil {
+			ctl.logger.Error("error sharing files", zap.Error(err), zap.String("teamID", e.TeamID))
+		}
+	})
+}
+
+// shareMessageFiles shares the files in a message sent to the app, replying to it with their links
+func shareMessageFiles(ctl *PublicController, ctx context.Context, e slackevents.EventsAPIEvent, msg *slackevents.MessageEvent) error {
+	team, err := ctl.findInstallation(ctx, e.TeamID, e.EnterpriseID)
+	if err != nil {
+		return err
+	}
+	api, err := ctl.slackClientForTeam(ctx, team)
+	if err != nil {
+		return err
+	}
+	settings, err := ctl.teamSettings(ctx, e.TeamID)
+	if err != nil {
+		return err
+	}
+
+	locale := ctl.userLocale(ctx, e.TeamID, e.EnterpriseID, msg.User)
+
+	for _, f := range msg.Message.Files {
+		reply := shareSlackFile(ctl, ctx, api, e.TeamID, msg.User, f, settings, locale)
+		_, _, err := api.PostMessageContext(ctx, msg.Channel,
+			slack.MsgOptionText(reply.Fallback, false),
+			slack.MsgOptionAttachments(reply))
+		if err != nil {
+			ctl.logger.Error("error replying to shared file", zap.Error(err), zap.String("teamID", e.TeamID))
+		}
+	}
+	return nil
+}
+
+// shareSlackFile downloads a file shared with the app into the blob store, and returns the reply
+// holding its link or explaining why it couldn't be shared, in locale
+func shareSlackFile(ctl *PublicController, ctx context.Context, api *slack.Client, teamID string, userID string, f slack.File, settings TeamSettings, locale string) slack.Attachment {
+	var err error
+	switch {
+	case !ctl.filesEnabled():
+		err = errFilesDisabled
+	case settings.RequireRecipients:
+		err = newUserError("file_recipients_required")
+	case int64(f.Size) > settings.MaxFileSize():
+		err = errFileTooLarge
+	}
+
+	var key, id string
+	var size int64
+	if err == nil {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(api.GetFileContext(ctx, f.URLPrivateDownload, pw))
+		}()
+		key, id, size, err = ctl.putFile(ctx, pr, settings.MaxFileSize())
+		pr.Close()
+	}
+	var sec *Secret
+	if err == nil {
+		sec, err = ctl.saveFile(ctx, key, id, f.Name, size, teamID, settings, WithSenderID(userID), WithReadReceipt(settings.ReadReceiptDefault))
+	}
+
+	var ue userError
+	switch {
+	case errors.Is(err, errFileTooLarge):
+		ue = newUserError("file_too_large", settings.MaxFileSize()>>20)
+	case errors.Is(err, errFileEmpty):
+		ue = newUserError("file_empty", fileName(f.Name))
+	case errors.As(err, &ue):
+	case err != nil:
+		ctl.logger.Error("error sharing file", zap.Error(err), zap.String("teamID", teamID))
+		ue = newUserError("file_failed", fileName(f.Name))
+	default:
+		return slack.Attachment{
+			Title:    secreti18n.T(locale, "file_link.title"),
+			Fallback: secreti18n.T(locale, "file_link.title"),
+			Text:     secreti18n.T(locale, "file_link.text", ctl.webLinkURL(sec.ID)+"#"+key, fileName(f.Name)),
+			Color:    "#6D5692",
+			Footer:   secreti18n.T(locale, "web_link.expires", fmt.Sprintf("<!date^%d^{date_pretty}|%s>", sec.ExpiresAt.Unix(), sec.ExpiresAt.Format("2006-01-02 15:04 MST"))),
+		}
+	}
+	return slack.Attachment{Title: ue.title(locale), Fallback: ue.title(locale), Text: ue.text(locale), Color: "#FF0000"}
+}
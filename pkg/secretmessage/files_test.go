@@ -0,0 +1,27 @@
+package secretmessage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileName(t *testing.T) {
+	assert.Equal(t, "kubeconfig", fileName("kubeconfig"))
+	assert.Equal(t, "id_rsa", fileName("../../.ssh/id_rsa"))
+	assert.Equal(t, "prod.pem", fileName(`C:\keys\prod.pem`))
+	assert.Equal(t, "evilname.pem", fileName("evil\"name\r\n.pem"))
+	assert.Equal(t, "secret", fileName(""))
+	assert.Equal(t, "secret", fileName("/"))
+
+	long := fileName(strings.Repeat("é", 200))
+	assert.LessOrEqual(t, len(long), maxFileNameLength)
+	assert.Equal(t, strings.Repeat("é", maxFileNameLength/2), long)
+}
+
+func TestFormatFileSize(t *testing.T) {
+	assert.Equal(t, "512 bytes", formatFileSize(512))
+	assert.Equal(t, "12 KB", formatFileSize(12*1024+100))
+	assert.Equal(t, "1.5 MB", formatFileSize(3<<19))
+}
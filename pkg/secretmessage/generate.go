@@ -0,0 +1,245 @@
+package secretmessage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/neufeldtech/secretmessage-go/pkg/secretgen"
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+)
+
+const (
+	// generatePassword and generatePassphrase are what the create modal generates when one of them is
+	// chosen in its template select
+	generatePassword   = "password"
+	generatePassphrase = "passphrase"
+	// generateOptionPrefix starts the template select's options for generating the secret, followed by
+	// what to generate
+	generateOptionPrefix = "generate:"
+)
+
+// generateCharsets are the charsets people can pick for a password, in the order the modal offers them,
+// by the name /secret generate takes
+var generateCharsets = []struct {
+	name    string
+	charset secretgen.Charset
+}{
+	{"lower", secretgen.Lower},
+	{"upper", secretgen.Upper},
+	{"digits", secretgen.Digits},
+	{"symbols", secretgen.Symbols},
+}
+
+// generateRequest is what to generate, a password of length characters from charsets or a passphrase
+// of length words
+type generateRequest struct {
+	passphrase bool
+	length     int
+	charsets   secretgen.Charset
+}
+
+// parseGenerateCommand reads the arguments of /secret generate [length] [lower] [upper] [digits] [symbols]
+// or /secret generate passphrase [words]. Passwords are made of every charset unless some are named.
+func parseGenerateCommand(args string) (generateRequest, error) {
+	var g generateRequest
+	lengthGiven := false
+	for _, field := range strings.Fields(strings.ToLower(args)) {
+		if n, err := strconv.Atoi(field); err == nil && !lengthGiven {
+			g.length, lengthGiven = n, true
+			continue
+		}
+		if field == generatePassphrase && !g.passphrase {
+			g.passphrase = true
+			continue
+		}
+		charset, ok := generateCharset(field)
+		if !ok {
+			return generateRequest{}, newUserError("generate_usage")
+		}
+		g.charsets |= charset
+	}
+	switch {
+	case g.passphrase && g.charsets != 0:
+		return generateRequest{}, newUserError("generate_usage")
+	case !lengthGiven && g.passphrase:
+		g.length = secretgen.DefaultPassphraseWords
+	case !lengthGiven:
+		g.length = secretgen.DefaultPasswordLength
+	}
+	if !g.passphrase && g.charsets == 0 {
+		g.charsets = secretgen.AllCharsets
+	}
+	return g, g.validate()
+}
+
+func generateCharset(name string) (secretgen.Charset, bool) {
+	for _, c := range generateCharsets {
+		if c.name == name {
+			return c.charset, true
+		}
+	}
+	return 0, false
+}
+
+// validate returns the userError explaining why the request's length can't be generated, or nil
+func (g generateRequest) validate() error {
+	switch {
+	case g.passphrase && (g.length < secretgen.MinPassphraseWords || g.length > secretgen.MaxPassphraseWords):
+		return newUserError("generate_words", secretgen.MinPassphraseWords, secretgen.MaxPassphraseWords)
+	case !g.passphrase && (g.length < secretgen.MinPasswordLength || g.length > secretgen.MaxPasswordLength):
+		return newUserError("generate_length", secretgen.MinPasswordLength, secretgen.MaxPasswordLength)
+	}
+	return nil
+}
+
+func (g generateRequest) generate() (string, error) {
+	if g.passphrase {
+		return secretgen.Passphrase(g.length)
+	}
+	return secretgen.Password(g.length, g.charsets)
+}
+
+// sendGeneratedSecret sends a password or passphrase generated for /secret generate as a secret, and
+// replies with a copy only the sender sees, so they can use it too
+func sendGeneratedSecret(ctl *PublicController, ctx context.Context, s slack.SlashCommand, args string) error {
+	g, err := parseGenerateCommand(args)
+	if err != nil {
+		return err
+	}
+	settings, err := ctl.teamSettings(ctx, s.TeamID)
+	if err != nil {
+		return err
+	}
+	// Commands can't name recipients, the form can
+	if settings.RequireRecipients {
+		return newUserError("recipients_required")
+	}
+	value, err := g.generate()
+	if err != nil {
+		ctl.logger.Error("error generating secret", zap.Error(err), zap.String("teamID", s.TeamID))
+		return err
+	}
+	if err := PrepareAndSendSecretEnvelope(ctl, ctx, value, s.TeamID, settings, s.ResponseURL, WithSenderID(s.UserID), WithChannelID(s.ChannelID), WithReadReceipt(settings.ReadReceiptDefault)); err != nil {
+		return err
+	}
+
+	locale := ctl.userLocale(ctx, s.TeamID, s.EnterpriseID, s.UserID)
+	title := generatedCopyTitle(g.passphrase, locale)
+	response := slack.Message{
+		Msg: slack.Msg{
+			ResponseType: slack.ResponseTypeEphemeral,
+			Attachments: []slack.Attachment{{
+				Title:      title,
+				Fallback:   title,
+				Text:       generatedCopyText(value),
+				Color:      "#6D5692",
+				Footer:     secreti18n.T(locale, "generate.copy_footer"),
+				MarkdownIn: []string{"text"},
+			}},
+		},
+	}
+	if err := ctl.slackService.SendResponseUrlMessage(ctx, s.ResponseURL, response); err != nil {
+		// The secret was already sent, the sender can still read it like anyone else
+		ctl.logger.Error("error sending generated secret copy to slack", zap.Error(err), zap.String("teamID", s.TeamID))
+	}
+	return nil
+}
+
+func generatedCopyTitle(passphrase bool, locale string) string {
+	if passphrase {
+		return secreti18n.T(locale, "generate.copy_passphrase")
+	}
+	return secreti18n.T(locale, "generate.copy_password")
+}
+
+// generatedCopyText shows a generated secret as code, so Slack doesn't format any of its characters
+func generatedCopyText(value string) string {
+	return "```" + escapeMrkdwn(value) + "```"
+}
+
+// generatedCopyBlock is the sender's copy of a secret generated with the create modal, shown once in
+// the modal confirming it was sent
+func generatedCopyBlock(value string, passphrase bool, locale string) slack.Block {
+	text := "*" + generatedCopyTitle(passphrase, locale) + "*\n" + generatedCopyText(value) + "\n" + secreti18n.T(locale, "generate.copy_footer")
+	return slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", text, false, false), nil, nil)
+}
+
+// generateInputs are the create modal's inputs for generating a password or passphrase, in place of
+// the secret's text
+func generateInputs(passphrase bool, locale string) []slack.Block {
+	if passphrase {
+		words := slack.NewNumberInputBlockElement(nil, "generate_length_input", false).
+			WithInitialValue(strconv.Itoa(secretgen.DefaultPassphraseWords)).
+			WithMinValue(strconv.Itoa(secretgen.MinPassphraseWords)).
+			WithMaxValue(strconv.Itoa(secretgen.MaxPassphraseWords))
+		return []slack.Block{slack.NewInputBlock(
+			"generate_length_input",
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.generate_words_label"), false, false),
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.generate_words_hint", secretgen.MinPassphraseWords, secretgen.MaxPassphraseWords), false, false),
+			words,
+		)}
+	}
+
+	length := slack.NewNumberInputBlockElement(nil, "generate_length_input", false).
+		WithInitialValue(strconv.Itoa(secretgen.DefaultPasswordLength)).
+		WithMinValue(strconv.Itoa(secretgen.MinPasswordLength)).
+		WithMaxValue(strconv.Itoa(secretgen.MaxPasswordLength))
+	options := make([]*slack.OptionBlockObject, len(generateCharsets))
+	for n, c := range generateCharsets {
+		options[n] = slack.NewOptionBlockObject(c.name, slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.generate_charset_"+c.name), false, false), nil)
+	}
+	charsets := slack.NewCheckboxGroupsBlockElement("generate_charsets_input", options...)
+	charsets.InitialOptions = options
+	return []slack.Block{
+		slack.NewInputBlock(
+			"generate_length_input",
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.generate_length_label"), false, false),
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.generate_length_hint", secretgen.MinPasswordLength, secretgen.MaxPasswordLength), false, false),
+			length,
+		),
+		slack.NewInputBlock(
+			"generate_charsets_input",
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.generate_charsets_label"), false, false),
+			nil,
+			charsets,
+		),
+	}
+}
+
+// parseGenerateFields generates the secret asked for with the create modal's generate inputs, with an
+// error for each input that can't be used
+func parseGenerateFields(state *slack.ViewState, passphrase bool, settings TeamSettings, locale string) (string, map[string]string) {
+	validationErrors := map[string]string{}
+	g := generateRequest{passphrase: passphrase}
+	g.length, _ = strconv.Atoi(state.Values["generate_length_input"]["generate_length_input"].Value)
+	var ue userError
+	if errors.As(g.validate(), &ue) {
+		validationErrors["generate_length_input"] = ue.text(locale)
+	}
+	if !passphrase {
+		for _, option := range state.Values["generate_charsets_input"]["generate_charsets_input"].SelectedOptions {
+			charset, _ := generateCharset(option.Value)
+			g.charsets |= charset
+		}
+		if g.charsets == 0 {
+			validationErrors["generate_charsets_input"] = secreti18n.T(locale, "validation.generate_charsets")
+		}
+	}
+	if len(validationErrors) > 0 {
+		return "", validationErrors
+	}
+
+	value, err := g.generate()
+	if err != nil {
+		validationErrors["generate_length_input"] = viewSubmissionFailed(locale)
+		return "", validationErrors
+	}
+	if errors.As(validateSecretText(value, settings), &ue) {
+		validationErrors["generate_length_input"] = ue.text(locale)
+	}
+	return value, validationErrors
+}
@@ -0,0 +1,79 @@
+package secretmessage
+
+import (
+	"testing"
+
+	"github.com/neufeldtech/secretmessage-go/pkg/secretgen"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGenerateCommand(t *testing.T) {
+	cases := map[string]generateRequest{
+		"":                 {length: secretgen.DefaultPasswordLength, charsets: secretgen.AllCharsets},
+		" 32 ":             {length: 32, charsets: secretgen.AllCharsets},
+		"16 lower DIGITS":  {length: 16, charsets: secretgen.Lower | secretgen.Digits},
+		"symbols upper 40": {length: 40, charsets: secretgen.Symbols | secretgen.Upper},
+		"passphrase":       {passphrase: true, length: secretgen.DefaultPassphraseWords},
+		"passphrase 8":     {passphrase: true, length: 8},
+		"10 passphrase":    {passphrase: true, length: 10},
+	}
+	for args, want := range cases {
+		g, err := parseGenerateCommand(args)
+		assert.NoError(t, err, args)
+		assert.Equal(t, want, g, args)
+	}
+}
+
+func TestParseGenerateCommand_Errors(t *testing.T) {
+	cases := map[string]string{
+		"emoji":                 "Use /secret generate",
+		"32 32":                 "Use /secret generate",
+		"passphrase digits":     "Use /secret generate",
+		"passphrase passphrase": "Use /secret generate",
+		"0":                     "Passwords can be 8 to 128 characters long",
+		"129":                   "Passwords can be 8 to 128 characters long",
+		"passphrase 5":          "Passphrases can have 6 to 20 words",
+	}
+	for args, want := range cases {
+		_, err := parseGenerateCommand(args)
+		var ue userError
+		if assert.ErrorAs(t, err, &ue, args) {
+			assert.Contains(t, ue.text("en"), want, args)
+		}
+	}
+}
+
+func generateState(length string, charsets ...string) *slack.ViewState {
+	selected := make([]slack.OptionBlockObject, len(charsets))
+	for n, c := range charsets {
+		selected[n] = slack.OptionBlockObject{Value: c}
+	}
+	return &slack.ViewState{
+		Values: map[string]map[string]slack.BlockAction{
+			"generate_length_input":   {"generate_length_input": {Value: length}},
+			"generate_charsets_input": {"generate_charsets_input": {SelectedOptions: selected}},
+		},
+	}
+}
+
+func TestParseGenerateFields(t *testing.T) {
+	settings := DefaultTeamSettings("T1")
+	text, errs := parseGenerateFields(generateState("12", "lower"), false, settings, "en")
+	assert.Empty(t, errs)
+	assert.Regexp(t, `^[a-z]{12}$`, text)
+
+	text, errs = parseGenerateFields(generateState("6"), true, settings, "en")
+	assert.Empty(t, errs)
+	assert.Regexp(t, `^[a-z]+(-[a-z]+){5}$`, text)
+
+	_, errs = parseGenerateFields(generateState("", "lower"), false, settings, "en")
+	assert.Equal(t, map[string]string{"generate_length_input": "Passwords can be 8 to 128 characters long"}, errs)
+
+	_, errs = parseGenerateFields(generateState("21"), true, settings, "fr")
+	assert.Equal(t, map[string]string{"generate_length_input": "Les phrases de passe ont de 6 à 20 mots"}, errs)
+}
+
+func TestGeneratedCopyText(t *testing.T) {
+	assert.Equal(t, "```a&lt;b&gt;c&amp;d```", generatedCopyText("a<b>c&d"))
+}
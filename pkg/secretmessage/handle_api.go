@@ -0,0 +1,364 @@
+package secretmessage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAPIListLimit = 50
+	maxAPIListLimit     = 200
+)
+
+// apiCreateSecretRequest is the body of POST /api/v1/secrets. A secret sent as Text is delivered to
+// exactly one of ChannelID or UserID, the latter in the user's DM with the app.
+//
+// A secret can instead be encrypted by the client, which sends only the Ciphertext and KeyHash, the
+// hex SHA-256 of the key. It becomes a one-time web link that the reader's browser decrypts. Posting
+// the link to Slack needs the Key too, which goes into the message and is never stored.
+type apiCreateSecretRequest struct {
+	Text       string     `json:"text"`
+	Ciphertext string     `json:"ciphertext"`
+	KeyHash    string     `json:"key_hash"`
+	Key        string     `json:"key"`
+	ChannelID  string     `json:"channel_id"`
+	UserID     string     `json:"user_id"`
+	Recipients []string   `json:"recipients"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// apiSecret describes a secret in API responses. It never holds the secret's content.
+type apiSecret struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status"`
+	ChannelID  string    `json:"channel_id,omitempty"`
+	Recipients []string  `json:"recipients,omitempty"`
+	ReadBy     string    `json:"read_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	RetiredAt  time.Time `json:"retired_at,omitzero"`
+	// URL is the web link of a client encrypted secret or a file, without the key fragment
+	URL string `json:"url,omitempty"`
+	// FileSize is the size in bytes of a file secret
+	FileSize int64 `json:"file_size,omitempty"`
+	// Link is the web link of a file with its key, only returned when the file is uploaded
+	Link string `json:"link,omitempty"`
+}
+
+func (ctl *PublicController) newAPISecret(s Secret) apiSecret {
+	res := apiSecret{
+		ID:         s.ID,
+		Status:     s.Status(),
+		ChannelID:  s.ChannelID,
+		Recipients: s.Recipients,
+		ReadBy:     s.ReadBy,
+		CreatedAt:  s.CreatedAt,
+		ExpiresAt:  s.ExpiresAt,
+		RetiredAt:  s.DeletedAt.Time,
+	}
+	if s.ClientEncrypted || s.File {
+		res.URL = ctl.webLinkURL(s.ID)
+	}
+	res.FileSize = s.FileSize
+	return res
+}
+
+// destination is the conversation the secret is posted to, empty if it isn't posted to Slack
+func (r apiCreateSecretRequest) destination() string {
+	if r.ChannelID != "" {
+		return r.ChannelID
+	}
+	return r.UserID
+}
+
+// validate checks the request against the team's settings, the same rules the secret form applies
+func (r apiCreateSecretRequest) validate(settings TeamSettings) error {
+	switch {
+	case (r.Text == "") == (r.Ciphertext == ""):
+		return fmt.Errorf("exactly one of text or ciphertext is required")
+	case r.ChannelID != "" && r.UserID != "":
+		return fmt.Errorf("only one of channel_id or user_id may be set")
+	case r.ExpiresAt != nil && r.ExpiresAt.Before(time.Now()):
+		return fmt.Errorf("expires_at must be in the future")
+	case r.Ciphertext != "":
+		return r.validateClientEncrypted(settings)
+	}
+	if err := validateSecretText(r.Text, settings); err != nil {
+		return err
+	}
+	switch {
+	case r.destination() == "":
+		return fmt.Errorf("exactly one of channel_id or user_id is required")
+	case settings.RequireRecipients && len(r.Recipients) == 0:
+		return fmt.Errorf("recipients are required in this workspace")
+	}
+	return nil
+}
+
+// validateClientEncrypted checks a secret encrypted by the client. Its length can only be bounded by
+// the longest ciphertext a secret of the workspace's maximum length encrypts to.
+func (r apiCreateSecretRequest) validateClientEncrypted(settings TeamSettings) error {
+	ciphertext, err := base64.RawURLEncoding.DecodeString(r.Ciphertext)
+	switch {
+	case err != nil || len(ciphertext) < clientCiphertextOverhead:
+		return fmt.Errorf("ciphertext must be the unpadded base64url of the AES-GCM nonce and sealed secret")
+	case len(ciphertext) > clientCiphertextOverhead+utf8.UTFMax*settings.MaxSecretLength:
+		return fmt.Errorf("ciphertext is longer than a secret of %d characters in this workspace", settings.MaxSecretLength)
+	case !isKeyHash(r.KeyHash):
+		return fmt.Errorf("key_hash must be the hex SHA-256 of the key")
+	case len(r.Recipients) > 0 || settings.RequireRecipients:
+		return fmt.Errorf("anyone with the link can read a client encrypted secret, so it can't be limited to recipients")
+	case r.destination() != "" && hash(r.Key) != r.KeyHash:
+		return fmt.Errorf("key is required to post the link to Slack, and must match key_hash")
+	}
+	return nil
+}
+
+// HandleAPICreateSecret stores a secret and posts its envelope to a channel or user, as the app
+func (ctl *PublicController) HandleAPICreateSecret(c *gin.Context) {
+	hc := c.Request.Context()
+	token := c.MustGet(apiTokenContextKey).(APIToken)
+	logger := ctl.logger.With(zap.String("teamID", token.TeamID), zap.Uint("apiTokenID", token.ID))
+
+	var req apiCreateSecretRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "Request body must be a JSON object"})
+		return
+	}
+	settings, err := ctl.teamSettings(hc, token.TeamID)
+	if err != nil {
+		logger.Error("error getting team settings", zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+	if err := req.validate(settings); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": err.Error()})
+		return
+	}
+	if req.Ciphertext != "" && ctl.config.AppURL == "" {
+		c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"status": "Web links are unavailable, this installation has no public URL"})
+		return
+	}
+
+	destination := req.destination()
+	var api *slack.Client
+	if destination != "" {
+		if api = ctl.apiSlackClient(c, token, logger); api == nil {
+			return
+		}
+	}
+
+	options := []SecretOption{WithSenderID(token.actorID()), WithChannelID(destination), WithRecipients(req.Recipients)}
+	if req.ExpiresAt != nil {
+		options = append(options, WithExpiryDate(*req.ExpiresAt))
+	}
+	var sec *Secret
+	var envelope slack.Message
+	if req.Ciphertext != "" {
+		options = append(options, WithWebLink(true), WithClientEncrypted(true))
+		sec, err = ctl.saveSecret(hc, hash(req.KeyHash), req.Ciphertext, token.TeamID, settings, options...)
+		if err == nil {
+			envelope = webLinkEnvelope(sec, ctl.webLinkURL(sec.ID)+"#"+req.Key, token.Name, settings.Language())
+		}
+	} else {
+		var secretID string
+		secretID, sec, err = ctl.storeSecret(hc, req.Text, token.TeamID, settings, options...)
+		if err == nil {
+			envelope = secretEnvelope(sec, secretID, token.Name, settings.Language())
+		}
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+
+	if api != nil && !ctl.postAPISecret(c, api, token, destination, sec, envelope, logger) {
+		return
+	}
+
+	logger.Info("secret created through the API", zap.String("channelID", destination), zap.Bool("clientEncrypted", sec.ClientEncrypted))
+	c.JSON(http.StatusCreated, ctl.newAPISecret(*sec))
+}
+
+// apiSlackClient returns a client for the token's workspace, or responds with an error and returns nil
+func (ctl *PublicController) apiSlackClient(c *gin.Context, token APIToken, logger *zap.Logger) *slack.Client {
+	hc := c.Request.Context()
+	team, err := ctl.findInstallation(hc, token.TeamID, token.EnterpriseID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && team.AccessToken == "") {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"status": "Secret Message is not installed in this workspace"})
+		return nil
+	}
+	if err != nil {
+		logger.Error("error getting team", zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return nil
+	}
+	api, err := ctl.slackClientForTeam(hc, team)
+	if err != nil {
+		logger.Error("error building slack client", zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return nil
+	}
+	return api
+}
+
+// postAPISecret posts a secret's envelope to its destination. When that fails, the secret is revoked
+// and an error response is sent, and it returns false.
+func (ctl *PublicController) postAPISecret(c *gin.Context, api *slack.Client, token APIToken, destination string, sec *Secret, envelope slack.Message, logger *zap.Logger) bool {
+	hc := c.Request.Context()
+	channelID, _, err := api.PostMessageContext(hc, destination,
+		slack.MsgOptionText(envelope.Attachments[0].Fallback, false),
+		slack.MsgOptionAttachments(envelope.Attachments...))
+	if err == nil && strings.HasPrefix(destination, "#") && channelID != "" {
+		// Slack resolved a channel name, keep the ID so the secret still points at the channel if it's renamed
+		sec.ChannelID = channelID
+		if err := ctl.db.WithContext(hc).Model(sec).Update("channel_id", channelID).Error; err != nil {
+			logger.Error("error storing resolved channel", zap.Error(err), zap.String("channelID", channelID))
+		}
+	}
+	if err != nil {
+		// Nobody can reach the secret without its envelope, so don't leave it behind
+		logger.Error("error sending secret to slack", zap.Error(err), zap.String("channelID", destination))
+		if _, retireErr := retireSecret(ctl.db.WithContext(hc), sec.ID, SecretRetiredRevoked, ""); retireErr != nil {
+			logger.Error("error revoking undelivered secret", zap.Error(retireErr))
+		}
+		ctl.recordSecretEvent(hc, AuditSecretRevoked, token.TeamID, sec.ID, token.actorID(), destination)
+		ctl.deleteFile(hc, sec.ID)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"status": "Error delivering secret to Slack: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// apiInfo tells clients who their token acts for and what the server supports
+type apiInfo struct {
+	TeamID    string   `json:"team_id"`
+	TokenName string   `json:"token_name"`
+	Scopes    []string `json:"scopes"`
+	// ClientEncryption is whether secrets can be encrypted by the client, which needs web links
+	ClientEncryption bool `json:"client_encryption"`
+}
+
+// HandleAPIInfo describes the token and the server's capabilities
+func (ctl *PublicController) HandleAPIInfo(c *gin.Context) {
+	token := c.MustGet(apiTokenContextKey).(APIToken)
+	c.JSON(http.StatusOK, apiInfo{
+		TeamID:           token.TeamID,
+		TokenName:        token.Name,
+		Scopes:           token.Scopes,
+		ClientEncryption: ctl.config.AppURL != "",
+	})
+}
+
+// HandleAPIListSecrets lists the secrets created with the token, newest first
+func (ctl *PublicController) HandleAPIListSecrets(c *gin.Context) {
+	token := c.MustGet(apiTokenContextKey).(APIToken)
+	limit := defaultAPIListLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAPIListLimit {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": fmt.Sprintf("limit must be between 1 and %d", maxAPIListLimit)})
+			return
+		}
+		limit = n
+	}
+
+	var secrets []Secret
+	err := ctl.db.WithContext(c.Request.Context()).
+		Unscoped().
+		Where("team_id = ? AND sender_id = ?", token.TeamID, token.actorID()).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&secrets).Error
+	if err != nil {
+		ctl.logger.Error("error listing secrets", zap.Error(err), zap.String("teamID", token.TeamID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+	res := make([]apiSecret, len(secrets))
+	for i, s := range secrets {
+		res[i] = ctl.newAPISecret(s)
+	}
+	c.JSON(http.StatusOK, gin.H{"secrets": res})
+}
+
+// apiSecretByID loads a secret the token sent, including retired ones, from the :id path parameter.
+// Secrets sent by anyone else in the team aren't found, as for the list.
+func (ctl *PublicController) apiSecretByID(c *gin.Context, token APIToken) (Secret, error) {
+	var secret Secret
+	err := ctl.db.WithContext(c.Request.Context()).
+		Unscoped().
+		Where("id = ? AND team_id = ? AND sender_id = ?", c.Param("id"), token.TeamID, token.actorID()).
+		Take(&secret).Error
+	return secret, err
+}
+
+// HandleAPIGetSecret reports whether a secret was read, revoked or expired
+func (ctl *PublicController) HandleAPIGetSecret(c *gin.Context) {
+	token := c.MustGet(apiTokenContextKey).(APIToken)
+	secret, err := ctl.apiSecretByID(c, token)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"status": "Secret not found"})
+		return
+	}
+	if err != nil {
+		ctl.logger.Error("error getting secret", zap.Error(err), zap.String("teamID", token.TeamID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+	c.JSON(http.StatusOK, ctl.newAPISecret(secret))
+}
+
+// HandleAPIRevokeSecret revokes an unread secret, so its envelope can no longer be opened
+func (ctl *PublicController) HandleAPIRevokeSecret(c *gin.Context) {
+	hc := c.Request.Context()
+	token := c.MustGet(apiTokenContextKey).(APIToken)
+	secret, err := ctl.apiSecretByID(c, token)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"status": "Secret not found"})
+		return
+	}
+	if err != nil {
+		ctl.logger.Error("error getting secret", zap.Error(err), zap.String("teamID", token.TeamID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+
+	if secret.Status() != SecretStatusUnread {
+		c.AbortWithStatusJSON(http.StatusConflict, ctl.newAPISecret(secret))
+		return
+	}
+
+	retired, err := retireSecret(ctl.db.WithContext(hc).Where("team_id = ? AND sender_id = ?", token.TeamID, token.actorID()), secret.ID, SecretRetiredRevoked, "")
+	if err != nil {
+		ctl.logger.Error("error revoking secret", zap.Error(err), zap.String("teamID", token.TeamID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+	if !retired {
+		// Read or revoked a moment ago
+		ctl.db.WithContext(hc).Unscoped().Where("id = ?", secret.ID).Take(&secret)
+		c.AbortWithStatusJSON(http.StatusConflict, ctl.newAPISecret(secret))
+		return
+	}
+	ctl.recordSecretEvent(hc, AuditSecretRevoked, token.TeamID, secret.ID, token.actorID(), secret.ChannelID)
+	ctl.deleteFile(hc, secret.ID)
+	if secret.Threshold > 0 {
+		ctl.deleteShares(hc, secret.ID)
+	}
+
+	secret.RetiredReason = SecretRetiredRevoked
+	secret.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	c.JSON(http.StatusOK, ctl.newAPISecret(secret))
+}
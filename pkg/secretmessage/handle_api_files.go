@@ -0,0 +1,150 @@
+package secretmessage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+)
+
+// maxAPIFileFieldLength bounds the form fields sent alongside an uploaded file, in bytes
+const maxAPIFileFieldLength = 1024
+
+// HandleAPICreateFile encrypts an uploaded file into the blob store and returns a one-time download
+// link for it, optionally posting the link to a channel or user like HandleAPICreateSecret. The body is
+// multipart/form-data with the file in a part named file and optional channel_id, user_id and
+// expires_at fields. The file is encrypted as it is read, so it never touches the disk unencrypted.
+func (ctl *PublicController) HandleAPICreateFile(c *gin.Context) {
+	hc := c.Request.Context()
+	token := c.MustGet(apiTokenContextKey).(APIToken)
+	logger := ctl.logger.With(zap.String("teamID", token.TeamID), zap.Uint("apiTokenID", token.ID))
+
+	if !ctl.filesEnabled() {
+		c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"status": "Files are unavailable, this installation has no blob store or public URL"})
+		return
+	}
+	settings, err := ctl.teamSettings(hc, token.TeamID)
+	if err != nil {
+		logger.Error("error getting team settings", zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+	if settings.RequireRecipients {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "anyone with the link can download a file, so it can't be limited to the recipients this workspace requires"})
+		return
+	}
+	tooLarge := fmt.Sprintf("files are limited to %d MB in this workspace", settings.MaxFileSize()>>20)
+
+	// Leave room for the form fields around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, settings.MaxFileSize()+1<<20)
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "Request body must be multipart/form-data"})
+		return
+	}
+
+	var key, id, name string
+	var size int64
+	var sec *Secret
+	defer func() {
+		// Don't keep a stored file whose request failed
+		if id != "" && sec == nil {
+			ctl.deleteFile(hc, id)
+		}
+	}()
+	fields := map[string]string{}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"status": tooLarge})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "Error reading the multipart body"})
+			return
+		}
+
+		switch part.FormName() {
+		case "file":
+			if id != "" {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "only one file may be uploaded at a time"})
+				return
+			}
+			name = part.FileName()
+			key, id, size, err = ctl.putFile(hc, part, settings.MaxFileSize())
+			switch {
+			case errors.Is(err, errFileTooLarge) || errors.As(err, &maxBytesErr):
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"status": tooLarge})
+				return
+			case errors.Is(err, errFileEmpty):
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "file is empty"})
+				return
+			case err != nil:
+				logger.Error("error storing file", zap.Error(err))
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+				return
+			}
+		case "channel_id", "user_id", "expires_at":
+			b, err := io.ReadAll(io.LimitReader(part, maxAPIFileFieldLength+1))
+			if err != nil || len(b) > maxAPIFileFieldLength {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": fmt.Sprintf("%s is invalid", part.FormName())})
+				return
+			}
+			fields[part.FormName()] = string(b)
+		}
+		part.Close()
+	}
+
+	options := []SecretOption{WithSenderID(token.actorID())}
+	destination := fields["channel_id"]
+	switch {
+	case id == "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "file is required"})
+		return
+	case fields["channel_id"] != "" && fields["user_id"] != "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "only one of channel_id or user_id may be set"})
+		return
+	case destination == "":
+		destination = fields["user_id"]
+	}
+	if v := fields["expires_at"]; v != "" {
+		expiresAt, err := time.Parse(time.RFC3339, v)
+		if err != nil || expiresAt.Before(time.Now()) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "expires_at must be an RFC 3339 time in the future"})
+			return
+		}
+		options = append(options, WithExpiryDate(expiresAt))
+	}
+
+	var api *slack.Client
+	if destination != "" {
+		if api = ctl.apiSlackClient(c, token, logger); api == nil {
+			return
+		}
+		options = append(options, WithChannelID(destination))
+	}
+
+	sec, err = ctl.saveFile(hc, key, id, name, size, token.TeamID, settings, options...)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+	link := ctl.webLinkURL(sec.ID) + "#" + key
+	if api != nil && !ctl.postAPISecret(c, api, token, destination, sec, webLinkEnvelope(sec, link, token.Name, ctl.teamLocale(hc, token.TeamID)), logger) {
+		return
+	}
+
+	logger.Info("file created through the API", zap.String("channelID", destination), zap.Int64("size", size))
+	res := ctl.newAPISecret(*sec)
+	res.Link = link
+	c.JSON(http.StatusCreated, res)
+}
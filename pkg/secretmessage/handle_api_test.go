@@ -0,0 +1,319 @@
+package secretmessage_test
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jarcoal/httpmock"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage/actions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var _ = Describe("/api/v1/secrets", func() {
+	teamID := "T1234ABCD"
+	var gdb *gorm.DB
+	var err error
+	var ctl *secretmessage.PublicController
+	var router *gin.Engine
+	var serverResponse *httptest.ResponseRecorder
+	var token string
+	var tokenSenderID string
+	var posted []map[string]interface{}
+	var postMessageResponse string
+
+	BeforeEach(func() {
+		posted = nil
+		postMessageResponse = `{"ok": true, "channel": "C0001", "ts": "1700000000.000100"}`
+		httpmock.Activate()
+		httpmock.RegisterResponder("POST", "https://slack.com/api/chat.postMessage", func(req *http.Request) (*http.Response, error) {
+			req.ParseForm()
+			var attachments []map[string]interface{}
+			json.Unmarshal([]byte(req.PostForm.Get("attachments")), &attachments)
+			posted = append(posted, map[string]interface{}{"channel": req.PostForm.Get("channel"), "attachments": attachments})
+			return httpmock.NewStringResponse(200, postMessageResponse), nil
+		})
+
+		gdb, err = gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname=handle_api"), &gorm.Config{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		gdb.AutoMigrate(secretmessage.Team{})
+		gdb.AutoMigrate(secretmessage.Secret{})
+		gdb.AutoMigrate(secretmessage.TeamSettings{})
+		gdb.AutoMigrate(secretmessage.AuditEvent{})
+		gdb.AutoMigrate(secretmessage.APIToken{})
+		gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: "xoxb-1234"})
+		var apiToken secretmessage.APIToken
+		token, apiToken, err = secretmessage.CreateAPIToken(context.Background(), gdb, teamID, "", "deploy-bot", secretmessage.APIScopes)
+		Expect(err).To(BeNil())
+		tokenSenderID = fmt.Sprintf("api_token:%d", apiToken.ID)
+
+		ctl = secretmessage.NewController(secretmessage.Config{AppURL: "https://secretmessage.example.com"}, gdb, nil)
+		router = ctl.ConfigureRoutes()
+	})
+	AfterEach(func() {
+		httpmock.DeactivateAndReset()
+		db, _ := gdb.DB()
+		db.Close()
+	})
+
+	request := func(method string, path string, body string) {
+		headers := map[string]string{"Authorization": "Bearer " + token, "Content-Type": "application/json"}
+		serverResponse = doHttpRequest(router, strings.NewReader(body), headers, method, path)
+	}
+	responseBody := func() map[string]interface{} {
+		var body map[string]interface{}
+		Expect(json.Unmarshal(serverResponse.Body.Bytes(), &body)).To(Succeed())
+		return body
+	}
+
+	Describe("POST", func() {
+		It("should store the secret and post its envelope to the channel", func() {
+			request("POST", "/api/v1/secrets", `{"text": "hunter2", "channel_id": "C0001", "recipients": ["U0001"]}`)
+			Expect(serverResponse.Code).To(Equal(http.StatusCreated))
+			body := responseBody()
+			Expect(body["status"]).To(Equal(secretmessage.SecretStatusUnread))
+			Expect(body["channel_id"]).To(Equal("C0001"))
+			Expect(body).NotTo(HaveKey("text"))
+
+			Expect(posted).To(HaveLen(1))
+			Expect(posted[0]["channel"]).To(Equal("C0001"))
+			attachment := posted[0]["attachments"].([]map[string]interface{})[0]
+			Expect(attachment["text"]).To(HavePrefix("*deploy-bot sent a secret message*"))
+			Expect(attachment["callback_id"]).To(HavePrefix(actions.ReadMessage + ":"))
+
+			var s secretmessage.Secret
+			Expect(gdb.Where("id = ?", body["id"]).Take(&s).Error).To(BeNil())
+			Expect(s.TeamID).To(Equal(teamID))
+			Expect(s.Value).NotTo(ContainSubstring("hunter2"))
+			Expect(s.Recipients).To(Equal([]string{"U0001"}))
+
+			var events []secretmessage.AuditEvent
+			gdb.Where("team_id = ?", teamID).Find(&events)
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Action).To(Equal(secretmessage.AuditSecretCreated))
+			Expect(events[0].ActorID).To(HavePrefix("api_token:"))
+		})
+
+		It("should reject requests without a destination", func() {
+			request("POST", "/api/v1/secrets", `{"text": "hunter2"}`)
+			Expect(serverResponse.Code).To(Equal(http.StatusBadRequest))
+			Expect(responseBody()["status"]).To(ContainSubstring("channel_id"))
+			Expect(posted).To(BeEmpty())
+		})
+
+		It("should apply the workspace's settings", func() {
+			gdb.Create(&secretmessage.TeamSettings{TeamID: teamID, MaxSecretLength: 3, DefaultExpiryDays: 1, MaxExpiryDays: 1})
+			request("POST", "/api/v1/secrets", `{"text": "hunter2", "user_id": "U0001"}`)
+			Expect(serverResponse.Code).To(Equal(http.StatusBadRequest))
+			Expect(responseBody()["status"]).To(ContainSubstring("limited to 3 characters"))
+		})
+
+		It("should revoke the secret when Slack rejects the message", func() {
+			postMessageResponse = `{"ok": false, "error": "not_in_channel"}`
+			request("POST", "/api/v1/secrets", `{"text": "hunter2", "channel_id": "C0001"}`)
+			Expect(serverResponse.Code).To(Equal(http.StatusBadGateway))
+			Expect(responseBody()["status"]).To(ContainSubstring("not_in_channel"))
+
+			var s secretmessage.Secret
+			Expect(gdb.Unscoped().Take(&s).Error).To(BeNil())
+			Expect(s.RetiredReason).To(Equal(secretmessage.SecretRetiredRevoked))
+			Expect(s.Value).To(BeEmpty())
+		})
+	})
+
+	Describe("POST with a client encrypted secret", func() {
+		var key string
+		var keyHash string
+		var ciphertext string
+
+		// Encrypts like the /new page does in the browser
+		BeforeEach(func() {
+			keyBytes := make([]byte, 32)
+			rand.Read(keyBytes)
+			key = base64.RawURLEncoding.EncodeToString(keyBytes)
+			sum := sha256.Sum256([]byte(key))
+			keyHash = hex.EncodeToString(sum[:])
+
+			block, _ := aes.NewCipher(keyBytes)
+			gcm, _ := cipher.NewGCM(block)
+			nonce := make([]byte, gcm.NonceSize())
+			rand.Read(nonce)
+			ciphertext = base64.RawURLEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte("hunter2"), nil))
+		})
+
+		It("should store only the ciphertext and hand it back once for the browser to decrypt", func() {
+			request("POST", "/api/v1/secrets", fmt.Sprintf(`{"ciphertext": %q, "key_hash": %q}`, ciphertext, keyHash))
+			Expect(serverResponse.Code).To(Equal(http.StatusCreated))
+			body := responseBody()
+			Expect(body["url"]).To(Equal("https://secretmessage.example.com/s/" + body["id"].(string)))
+			Expect(posted).To(BeEmpty())
+
+			var s secretmessage.Secret
+			Expect(gdb.Where("id = ?", body["id"]).Take(&s).Error).To(BeNil())
+			Expect(s.ClientEncrypted).To(BeTrue())
+			Expect(s.WebLink).To(BeTrue())
+			Expect(s.Value).To(Equal(ciphertext))
+
+			page := doHttpRequest(router, nil, nil, "GET", "/s/"+s.ID)
+			Expect(page.Body.String()).To(MatchRegexp(`clientEncrypted =\s*true`))
+
+			reveal := func() *httptest.ResponseRecorder {
+				return doHttpRequest(router, strings.NewReader(fmt.Sprintf(`{"key": %q}`, keyHash)), map[string]string{"Content-Type": "application/json"}, "POST", "/s/"+s.ID)
+			}
+			res := reveal()
+			Expect(res.Code).To(Equal(http.StatusOK))
+			var revealed map[string]string
+			Expect(json.Unmarshal(res.Body.Bytes(), &revealed)).To(Succeed())
+			Expect(revealed).NotTo(HaveKey("text"))
+			Expect(revealed["ciphertext"]).To(Equal(ciphertext))
+			Expect(reveal().Code).To(Equal(http.StatusGone))
+		})
+
+		It("should post the link with its key to Slack when asked to", func() {
+			request("POST", "/api/v1/secrets", fmt.Sprintf(`{"ciphertext": %q, "key_hash": %q, "key": %q, "channel_id": "C0001"}`, ciphertext, keyHash, key))
+			Expect(serverResponse.Code).To(Equal(http.StatusCreated))
+			Expect(posted).To(HaveLen(1))
+			attachment := posted[0]["attachments"].([]map[string]interface{})[0]
+			button := attachment["actions"].([]interface{})[0].(map[string]interface{})
+			Expect(button["url"]).To(Equal(responseBody()["url"].(string) + "#" + key))
+		})
+
+		It("should reject a key that doesn't match its hash", func() {
+			request("POST", "/api/v1/secrets", fmt.Sprintf(`{"ciphertext": %q, "key_hash": %q, "key": "wrong", "channel_id": "C0001"}`, ciphertext, keyHash))
+			Expect(serverResponse.Code).To(Equal(http.StatusBadRequest))
+			Expect(posted).To(BeEmpty())
+		})
+
+		It("should reject recipients it can't enforce", func() {
+			request("POST", "/api/v1/secrets", fmt.Sprintf(`{"ciphertext": %q, "key_hash": %q, "recipients": ["U0001"]}`, ciphertext, keyHash))
+			Expect(serverResponse.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should serve the page that encrypts in the browser", func() {
+			res := doHttpRequest(router, nil, nil, "GET", "/new")
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Header().Get("Content-Security-Policy")).To(ContainSubstring("connect-src 'self'"))
+			Expect(res.Body.String()).To(ContainSubstring("crypto.subtle.encrypt"))
+		})
+	})
+
+	Describe("GET and DELETE", func() {
+		BeforeEach(func() {
+			gdb.Create(&secretmessage.Secret{ID: "abc", Value: "encrypted", TeamID: teamID, SenderID: tokenSenderID, ChannelID: "C0001", ExpiresAt: time.Now().Add(time.Hour)})
+			gdb.Create(&secretmessage.Secret{ID: "other-team", Value: "encrypted", TeamID: "T9999ZZZZ", SenderID: tokenSenderID, ExpiresAt: time.Now().Add(time.Hour)})
+		})
+
+		It("should report the status of a secret", func() {
+			request("GET", "/api/v1/secrets/abc", "")
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+			Expect(responseBody()["status"]).To(Equal(secretmessage.SecretStatusUnread))
+		})
+
+		It("should not find another team's secret", func() {
+			request("GET", "/api/v1/secrets/other-team", "")
+			Expect(serverResponse.Code).To(Equal(http.StatusNotFound))
+			request("DELETE", "/api/v1/secrets/other-team", "")
+			Expect(serverResponse.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should not find secrets sent by someone else in the team", func() {
+			_, other, err := secretmessage.CreateAPIToken(context.Background(), gdb, teamID, "", "other-bot", secretmessage.APIScopes)
+			Expect(err).To(BeNil())
+			gdb.Create(&secretmessage.Secret{ID: "from-slack", Value: "encrypted", TeamID: teamID, SenderID: "U0001", ExpiresAt: time.Now().Add(time.Hour)})
+			gdb.Create(&secretmessage.Secret{ID: "from-token", Value: "encrypted", TeamID: teamID, SenderID: fmt.Sprintf("api_token:%d", other.ID), ExpiresAt: time.Now().Add(time.Hour)})
+
+			for _, id := range []string{"from-slack", "from-token"} {
+				request("GET", "/api/v1/secrets/"+id, "")
+				Expect(serverResponse.Code).To(Equal(http.StatusNotFound))
+				request("DELETE", "/api/v1/secrets/"+id, "")
+				Expect(serverResponse.Code).To(Equal(http.StatusNotFound))
+
+				var secret secretmessage.Secret
+				Expect(gdb.Where("id = ?", id).Take(&secret).Error).To(BeNil())
+				Expect(secret.Status()).To(Equal(secretmessage.SecretStatusUnread))
+			}
+		})
+
+		It("should revoke an unread secret once", func() {
+			request("DELETE", "/api/v1/secrets/abc", "")
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+			Expect(responseBody()["status"]).To(Equal(secretmessage.SecretRetiredRevoked))
+
+			request("GET", "/api/v1/secrets/abc", "")
+			Expect(responseBody()["status"]).To(Equal(secretmessage.SecretRetiredRevoked))
+			Expect(responseBody()).To(HaveKey("retired_at"))
+
+			request("DELETE", "/api/v1/secrets/abc", "")
+			Expect(serverResponse.Code).To(Equal(http.StatusConflict))
+		})
+	})
+
+	Describe("GET /api/v1/secrets and /api/v1/info", func() {
+		It("should list only the token's own secrets", func() {
+			gdb.Create(&secretmessage.Secret{ID: "mine", Value: "encrypted", TeamID: teamID, SenderID: tokenSenderID, ExpiresAt: time.Now().Add(time.Hour)})
+			gdb.Create(&secretmessage.Secret{ID: "slack", Value: "encrypted", TeamID: teamID, SenderID: "U0001", ExpiresAt: time.Now().Add(time.Hour)})
+
+			request("GET", "/api/v1/secrets", "")
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+			secrets := responseBody()["secrets"].([]interface{})
+			Expect(secrets).To(HaveLen(1))
+			Expect(secrets[0].(map[string]interface{})["id"]).To(Equal("mine"))
+
+			request("GET", "/api/v1/secrets?limit=0", "")
+			Expect(serverResponse.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should describe the token to any scope", func() {
+			token, _, err = secretmessage.CreateAPIToken(context.Background(), gdb, teamID, "", "reader", []string{secretmessage.APIScopeSecretsRead})
+			Expect(err).To(BeNil())
+			request("GET", "/api/v1/info", "")
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+			body := responseBody()
+			Expect(body["token_name"]).To(Equal("reader"))
+			Expect(body["client_encryption"]).To(BeTrue())
+		})
+	})
+
+	Describe("authentication", func() {
+		It("should reject unknown tokens", func() {
+			token = "smk_nope"
+			request("GET", "/api/v1/secrets/abc", "")
+			Expect(serverResponse.Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("should reject tokens without the scope", func() {
+			token, _, err = secretmessage.CreateAPIToken(context.Background(), gdb, teamID, "", "reader", []string{secretmessage.APIScopeSecretsRead})
+			Expect(err).To(BeNil())
+			request("POST", "/api/v1/secrets", `{"text": "hunter2", "channel_id": "C0001"}`)
+			Expect(serverResponse.Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("should reject revoked tokens", func() {
+			tokens, err := secretmessage.ListAPITokens(context.Background(), gdb, teamID)
+			Expect(err).To(BeNil())
+			revoked, err := secretmessage.RevokeAPIToken(context.Background(), gdb, teamID, tokens[0].ID)
+			Expect(err).To(BeNil())
+			Expect(revoked).To(BeTrue())
+			request("GET", "/api/v1/secrets/abc", "")
+			Expect(serverResponse.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
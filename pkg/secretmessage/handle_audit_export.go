@@ -0,0 +1,83 @@
+package secretmessage
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultAuditExportPageSize = 1000
+	maxAuditExportPageSize     = 10000
+)
+
+// parseAuditExportQuery reads the team, since, until, format, cursor and limit query parameters
+func parseAuditExportQuery(c *gin.Context) (AuditExportQuery, error) {
+	q := AuditExportQuery{
+		TeamID: c.Query("team_id"),
+		Cursor: c.Query("cursor"),
+		Format: c.DefaultQuery("format", AuditExportJSONLines),
+		Limit:  defaultAuditExportPageSize,
+	}
+	if q.TeamID == "" {
+		return q, fmt.Errorf("team_id is required")
+	}
+	if _, err := newAuditEventWriter(nil, q.Format); err != nil {
+		return q, err
+	}
+	if _, err := parseAuditCursor(q.Cursor); err != nil {
+		return q, err
+	}
+	for param, target := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+			}
+			*target = t
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxAuditExportPageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxAuditExportPageSize)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// HandleAuditExport streams a page of a team's audit events as JSON Lines or CSV. When more events
+// follow, the X-Next-Cursor header holds the cursor to pass to the next request.
+func (ctl *PublicController) HandleAuditExport(c *gin.Context) {
+	hc := c.Request.Context()
+	q, err := parseAuditExportQuery(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": err.Error()})
+		return
+	}
+
+	next, err := NextAuditExportCursor(hc, ctl.db, q)
+	if err != nil {
+		ctl.logger.Error("error paginating audit export", zap.Error(err), zap.String("teamID", q.TeamID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error exporting audit events"})
+		return
+	}
+	if next != "" {
+		c.Header("X-Next-Cursor", next)
+	}
+	c.Header("Content-Type", AuditExportContentType(q.Format))
+	c.Status(http.StatusOK)
+
+	written, err := ExportAuditEvents(hc, ctl.db, c.Writer, q)
+	if err != nil {
+		// The status is already sent, so the client sees a truncated export
+		ctl.logger.Error("error streaming audit export", zap.Error(err), zap.String("teamID", q.TeamID), zap.Int("written", written))
+		return
+	}
+	ctl.logger.Info("exported audit events", zap.String("teamID", q.TeamID), zap.Int("count", written), zap.String("format", q.Format))
+}
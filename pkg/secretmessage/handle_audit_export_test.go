@@ -0,0 +1,163 @@
+package secretmessage_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var _ = Describe("/admin/audit/export", func() {
+	teamID := "T1234ABCD"
+	adminToken := "s3cret-admin-token"
+	var gdb *gorm.DB
+	var err error
+	var ctl *secretmessage.PublicController
+	var router *gin.Engine
+	var serverResponse *httptest.ResponseRecorder
+	var path string
+	var headers map[string]string
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	BeforeEach(func() {
+		gdb, err = gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname=handle_audit_export"), &gorm.Config{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		gdb.AutoMigrate(secretmessage.AuditEvent{})
+		for i := 0; i < 5; i++ {
+			_, err := secretmessage.AppendAuditEvent(context.Background(), gdb, nil, secretmessage.AuditEvent{
+				TeamID:     teamID,
+				OccurredAt: base.Add(time.Duration(i) * time.Hour),
+				Action:     secretmessage.AuditSecretCreated,
+				SecretID:   "s",
+				ActorID:    "U1",
+			})
+			Expect(err).To(BeNil())
+		}
+		_, err = secretmessage.AppendAuditEvent(context.Background(), gdb, nil, secretmessage.AuditEvent{TeamID: "T9999ZZZZ", Action: secretmessage.AuditSecretRead})
+		Expect(err).To(BeNil())
+
+		ctl = secretmessage.NewController(
+			secretmessage.Config{AdminAPIToken: adminToken},
+			gdb,
+			nil,
+		)
+		headers = map[string]string{"Authorization": "Bearer " + adminToken}
+	})
+	JustBeforeEach(func() {
+		router = ctl.ConfigureRoutes()
+		serverResponse = doHttpRequest(router, nil, headers, "GET", path)
+	})
+	AfterEach(func() {
+		db, _ := gdb.DB()
+		db.Close()
+	})
+
+	jsonLines := func() []map[string]interface{} {
+		var lines []map[string]interface{}
+		scanner := bufio.NewScanner(serverResponse.Body)
+		for scanner.Scan() {
+			var line map[string]interface{}
+			Expect(json.Unmarshal(scanner.Bytes(), &line)).To(Succeed())
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	Context("without the admin token", func() {
+		BeforeEach(func() {
+			path = "/admin/audit/export?team_id=" + teamID
+			headers = map[string]string{"Authorization": "Bearer wrong"}
+		})
+		It("should respond with 401", func() {
+			Expect(serverResponse.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("when no admin token is configured", func() {
+		BeforeEach(func() {
+			path = "/admin/audit/export?team_id=" + teamID
+			ctl = secretmessage.NewController(secretmessage.Config{}, gdb, nil)
+			headers = map[string]string{"Authorization": "Bearer "}
+		})
+		It("should respond with 404", func() {
+			Expect(serverResponse.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("as JSON Lines", func() {
+		BeforeEach(func() {
+			path = "/admin/audit/export?team_id=" + teamID
+		})
+		It("should stream every event of the team in order", func() {
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+			Expect(serverResponse.Header().Get("Content-Type")).To(Equal("application/x-ndjson"))
+			Expect(serverResponse.Header().Get("X-Next-Cursor")).To(BeEmpty())
+			lines := jsonLines()
+			Expect(lines).To(HaveLen(5))
+			Expect(lines[0]["seq"]).To(BeEquivalentTo(1))
+			Expect(lines[0]["team_id"]).To(Equal(teamID))
+			Expect(lines[0]["action"]).To(Equal(secretmessage.AuditSecretCreated))
+			Expect(lines[4]["seq"]).To(BeEquivalentTo(5))
+		})
+	})
+
+	Context("as CSV within a time range", func() {
+		BeforeEach(func() {
+			path = "/admin/audit/export?format=csv&team_id=" + teamID + "&since=2026-01-01T01:00:00Z&until=2026-01-01T03:00:00Z"
+		})
+		It("should write a header and the events in range", func() {
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+			rows, err := csv.NewReader(serverResponse.Body).ReadAll()
+			Expect(err).To(BeNil())
+			Expect(rows).To(HaveLen(3))
+			Expect(rows[0][0]).To(Equal("team_id"))
+			Expect(rows[1][1]).To(Equal("2"))
+			Expect(rows[2][1]).To(Equal("3"))
+		})
+	})
+
+	Context("with a page size", func() {
+		BeforeEach(func() {
+			path = "/admin/audit/export?limit=2&team_id=" + teamID
+		})
+		It("should return the first page and a cursor for the next", func() {
+			Expect(jsonLines()).To(HaveLen(2))
+			cursor := serverResponse.Header().Get("X-Next-Cursor")
+			Expect(cursor).NotTo(BeEmpty())
+
+			var seqs []float64
+			for cursor != "" {
+				serverResponse = doHttpRequest(router, nil, headers, "GET", "/admin/audit/export?limit=2&team_id="+teamID+"&cursor="+cursor)
+				cursor = serverResponse.Header().Get("X-Next-Cursor")
+				for _, line := range jsonLines() {
+					seqs = append(seqs, line["seq"].(float64))
+				}
+			}
+			Expect(seqs).To(Equal([]float64{3, 4, 5}))
+		})
+	})
+
+	Context("with invalid parameters", func() {
+		BeforeEach(func() {
+			path = "/admin/audit/export?format=xml&team_id=" + teamID
+		})
+		It("should respond with 400", func() {
+			Expect(serverResponse.Code).To(Equal(http.StatusBadRequest))
+			Expect(strings.Contains(serverResponse.Body.String(), "xml")).To(BeTrue())
+		})
+	})
+})
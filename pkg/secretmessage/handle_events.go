@@ -0,0 +1,72 @@
+package secretmessage
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slack-go/slack/slackevents"
+	"go.uber.org/zap"
+)
+
+// EventHandler processes a single Events API callback event. Returning an error makes
+// Slack retry the delivery, so handlers must be idempotent.
+type EventHandler func(ctl *PublicController, c *gin.Context, e slackevents.EventsAPIEvent) error
+
+// RegisterEventHandler routes Events API callbacks of the given inner event type to h,
+// replacing any handler already registered for that type
+func (ctl *PublicController) RegisterEventHandler(eventType string, h EventHandler) {
+	ctl.eventHandlers[eventType] = h
+}
+
+func defaultEventHandlers() map[string]EventHandler {
+	return map[string]EventHandler{
+		string(slackevents.AppUninstalled): EventAppUninstalled,
+		string(slackevents.TokensRevoked):  EventTokensRevoked,
+		string(slackevents.AppHomeOpened):  EventAppHomeOpened,
+		string(slackevents.Message):        EventMessage,
+	}
+}
+
+func (ctl *PublicController) HandleEvents(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		ctl.logger.Error("error reading events request body", zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "Bad Request"})
+		return
+	}
+
+	// Requests are authenticated by ValidateSignature, so the deprecated verification token is not checked
+	e, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
+	if err != nil {
+		ctl.logger.Error("error parsing event payload", zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "Bad Request"})
+		return
+	}
+
+	switch e.Type {
+	case slackevents.URLVerification:
+		verification, ok := e.Data.(*slackevents.EventsAPIURLVerificationEvent)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"status": "Bad Request"})
+			return
+		}
+		c.Data(http.StatusOK, gin.MIMEPlain, []byte(verification.Challenge))
+	case slackevents.CallbackEvent:
+		handler, ok := ctl.eventHandlers[e.InnerEvent.Type]
+		if !ok {
+			ctl.logger.Warn("no handler registered for event", zap.String("type", e.InnerEvent.Type), zap.String("teamID", e.TeamID))
+			c.Data(http.StatusOK, gin.MIMEPlain, nil)
+			return
+		}
+		if err := handler(ctl, c, e); err != nil {
+			ctl.logger.Error("error handling event", zap.Error(err), zap.String("type", e.InnerEvent.Type), zap.String("teamID", e.TeamID))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error handling event"})
+			return
+		}
+		c.Data(http.StatusOK, gin.MIMEPlain, nil)
+	default:
+		c.Data(http.StatusOK, gin.MIMEPlain, nil)
+	}
+}
@@ -0,0 +1,248 @@
+package secretmessage_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack/slackevents"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func eventCallbackPayload(teamID string, innerEvent string) string {
+	return fmt.Sprintf(`{
+		"token": "deprecated",
+		"team_id": %q,
+		"api_app_id": "A0001",
+		"type": "event_callback",
+		"event_id": "Ev0001",
+		"event_time": 1700000000,
+		"event": %s
+	}`, teamID, innerEvent)
+}
+
+func signedHeaders(signingSecret string, body string) map[string]string {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+	return map[string]string{
+		"Content-Type":              "application/json",
+		"X-Slack-Request-Timestamp": ts,
+		"X-Slack-Signature":         "v0=" + hex.EncodeToString(mac.Sum(nil)),
+	}
+}
+
+var _ = Describe("/events", func() {
+	teamID := "T1234ABCD"
+	otherTeamID := "T9999ZZZZ"
+	signingSecret := "shhh"
+	var gdb *gorm.DB
+	var err error
+	var ctl *secretmessage.PublicController
+	var router *gin.Engine
+	var serverResponse *httptest.ResponseRecorder
+	var requestBody string
+	var requestHeaders map[string]string
+
+	BeforeEach(func() {
+		gdb, err = gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname=handle_events"), &gorm.Config{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		gdb.AutoMigrate(secretmessage.Team{})
+		gdb.AutoMigrate(secretmessage.Secret{})
+		gdb.AutoMigrate(secretmessage.TeamSettings{})
+		gdb.AutoMigrate(secretmessage.AuditEvent{})
+		gdb.AutoMigrate(secretmessage.APIToken{})
+		gdb.AutoMigrate(secretmessage.SecretShare{})
+		ctl = secretmessage.NewController(
+			secretmessage.Config{SigningSecret: signingSecret},
+			gdb,
+			nil,
+		)
+		requestHeaders = nil
+
+		Expect(gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: "xoxb-1234"}).Error).To(BeNil())
+		Expect(gdb.Create(&secretmessage.Team{ID: otherTeamID, AccessToken: "xoxb-9999"}).Error).To(BeNil())
+		Expect(gdb.Create(&secretmessage.Secret{ID: "s1", Value: "abc", TeamID: teamID, ExpiresAt: time.Now().Add(time.Hour)}).Error).To(BeNil())
+		Expect(gdb.Create(&secretmessage.Secret{ID: "s2", Value: "def", TeamID: otherTeamID, ExpiresAt: time.Now().Add(time.Hour)}).Error).To(BeNil())
+	})
+	JustBeforeEach(func() {
+		if requestHeaders == nil {
+			requestHeaders = signedHeaders(signingSecret, requestBody)
+		}
+		router = ctl.ConfigureRoutes()
+		serverResponse = doHttpRequest(router, strings.NewReader(requestBody), requestHeaders, "POST", "/events")
+	})
+	AfterEach(func() {
+		db, _ := gdb.DB()
+		db.Close()
+	})
+
+	Context("on url_verification", func() {
+		BeforeEach(func() {
+			requestBody = `{"token": "deprecated", "challenge": "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P", "type": "url_verification"}`
+		})
+		It("should echo the challenge", func() {
+			b, _ := ioutil.ReadAll(serverResponse.Body)
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+			Expect(string(b)).To(Equal("3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"))
+		})
+	})
+
+	Context("on invalid signature", func() {
+		BeforeEach(func() {
+			requestBody = eventCallbackPayload(teamID, `{"type": "app_uninstalled"}`)
+			requestHeaders = signedHeaders("not-the-signing-secret", requestBody)
+		})
+		It("should respond with 403 and leave the team untouched", func() {
+			Expect(serverResponse.Code).To(Equal(http.StatusForbidden))
+			var team secretmessage.Team
+			gdb.Where("id = ?", teamID).First(&team)
+			Expect(team.AccessToken).To(Equal("xoxb-1234"))
+		})
+	})
+
+	Context("on app_uninstalled", func() {
+		BeforeEach(func() {
+			requestBody = eventCallbackPayload(teamID, `{"type": "app_uninstalled"}`)
+		})
+		It("should respond with 200", func() {
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+		})
+		It("should clear the team's access token", func() {
+			var team secretmessage.Team
+			gdb.Where("id = ?", teamID).First(&team)
+			Expect(team.AccessToken).To(BeEmpty())
+		})
+		It("should purge the team's secrets only", func() {
+			var count int64
+			gdb.Unscoped().Model(&secretmessage.Secret{}).Where("team_id = ?", teamID).Count(&count)
+			Expect(count).To(BeZero())
+			gdb.Model(&secretmessage.Secret{}).Where("team_id = ?", otherTeamID).Count(&count)
+			Expect(count).To(BeEquivalentTo(1))
+		})
+	})
+
+	Context("on tokens_revoked with a bot token", func() {
+		BeforeEach(func() {
+			requestBody = eventCallbackPayload(teamID, `{"type": "tokens_revoked", "tokens": {"oauth": [], "bot": ["U0BOT"]}, "event_ts": "1700000000.000100"}`)
+		})
+		It("should clear the team's access token and purge its secrets", func() {
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+			var team secretmessage.Team
+			gdb.Where("id = ?", teamID).First(&team)
+			Expect(team.AccessToken).To(BeEmpty())
+			var count int64
+			gdb.Model(&secretmessage.Secret{}).Where("team_id = ?", teamID).Count(&count)
+			Expect(count).To(BeZero())
+		})
+	})
+
+	Context("on tokens_revoked with only user tokens", func() {
+		BeforeEach(func() {
+			requestBody = eventCallbackPayload(teamID, `{"type": "tokens_revoked", "tokens": {"oauth": ["U0001"], "bot": []}, "event_ts": "1700000000.000100"}`)
+		})
+		It("should keep the team's access token", func() {
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+			var team secretmessage.Team
+			gdb.Where("id = ?", teamID).First(&team)
+			Expect(team.AccessToken).To(Equal("xoxb-1234"))
+		})
+	})
+
+	Context("under an org-wide install", func() {
+		enterpriseID := "E1234ABCD"
+		orgTeamID := "T5555EEEE"
+		BeforeEach(func() {
+			Expect(gdb.Create(&secretmessage.Team{ID: enterpriseID, EnterpriseID: enterpriseID, IsEnterpriseInstall: true, InstallType: secretmessage.InstallTypeEnterprise, AccessToken: "xoxb-org"}).Error).To(BeNil())
+			Expect(gdb.Create(&secretmessage.Secret{ID: "s3", Value: "ghi", TeamID: orgTeamID, ExpiresAt: time.Now().Add(time.Hour)}).Error).To(BeNil())
+		})
+		orgEventPayload := func(teamID string) string {
+			return fmt.Sprintf(`{
+				"token": "deprecated",
+				"team_id": %q,
+				"enterprise_id": %q,
+				"api_app_id": "A0001",
+				"type": "event_callback",
+				"event_id": "Ev0002",
+				"event_time": 1700000000,
+				"event": {"type": "app_uninstalled"}
+			}`, teamID, enterpriseID)
+		}
+
+		Context("on app_uninstalled from one of its workspaces", func() {
+			BeforeEach(func() {
+				requestBody = orgEventPayload(orgTeamID)
+			})
+			It("should keep the org install's token and purge the workspace's secrets", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				var org secretmessage.Team
+				gdb.Where("id = ?", enterpriseID).First(&org)
+				Expect(org.AccessToken).To(Equal("xoxb-org"))
+				var count int64
+				gdb.Unscoped().Model(&secretmessage.Secret{}).Where("team_id = ?", orgTeamID).Count(&count)
+				Expect(count).To(BeZero())
+				gdb.Model(&secretmessage.Secret{}).Where("team_id = ?", teamID).Count(&count)
+				Expect(count).To(BeEquivalentTo(1))
+			})
+		})
+
+		Context("on app_uninstalled from the org", func() {
+			BeforeEach(func() {
+				requestBody = orgEventPayload(enterpriseID)
+			})
+			It("should clear the org install's token", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				var org secretmessage.Team
+				gdb.Where("id = ?", enterpriseID).First(&org)
+				Expect(org.AccessToken).To(BeEmpty())
+			})
+		})
+
+		Context("on app_uninstalled from the org without a workspace", func() {
+			BeforeEach(func() {
+				Expect(gdb.Create(&secretmessage.Secret{ID: "legacy", Value: "jkl", ExpiresAt: time.Now().Add(time.Hour)}).Error).To(BeNil())
+				requestBody = orgEventPayload("")
+			})
+			It("should clear the org install's token and keep secrets from before secrets recorded their team", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				var org secretmessage.Team
+				gdb.Where("id = ?", enterpriseID).First(&org)
+				Expect(org.AccessToken).To(BeEmpty())
+				var count int64
+				gdb.Model(&secretmessage.Secret{}).Where("id = ?", "legacy").Count(&count)
+				Expect(count).To(BeEquivalentTo(1))
+			})
+		})
+	})
+
+	Context("on a custom registered event type", func() {
+		var handled string
+		BeforeEach(func() {
+			handled = ""
+			ctl.RegisterEventHandler("team_rename", func(ctl *secretmessage.PublicController, c *gin.Context, e slackevents.EventsAPIEvent) error {
+				handled = e.TeamID
+				return nil
+			})
+			requestBody = eventCallbackPayload(teamID, `{"type": "team_rename", "name": "new name"}`)
+		})
+		It("should dispatch to the registered handler", func() {
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+			Expect(handled).To(Equal(teamID))
+		})
+	})
+})
@@ -0,0 +1,160 @@
+package secretmessage_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jarcoal/httpmock"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage/actions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var _ = Describe("App Home", func() {
+	teamID := "T1234ABCD"
+	userID := "U1234ABCD"
+	var gdb *gorm.DB
+	var err error
+	var ctl *secretmessage.PublicController
+	var router *gin.Engine
+	var serverResponse *httptest.ResponseRecorder
+	var admin bool
+	var published []string
+
+	BeforeEach(func() {
+		admin = false
+		published = nil
+		httpmock.Activate()
+		httpmock.RegisterResponder("POST", "https://slack.com/api/users.info", func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"ok":   true,
+				"user": map[string]interface{}{"id": userID, "is_admin": admin},
+			})
+		})
+		httpmock.RegisterResponder("POST", "https://slack.com/api/views.publish", func(req *http.Request) (*http.Response, error) {
+			var body slack.PublishViewContextRequest
+			json.NewDecoder(req.Body).Decode(&body)
+			Expect(body.UserID).To(Equal(userID))
+			b, _ := json.Marshal(body.View)
+			published = append(published, string(b))
+			return httpmock.NewStringResponse(200, `{"ok": true}`), nil
+		})
+
+		gdb, err = gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname=handle_home"), &gorm.Config{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		gdb.AutoMigrate(secretmessage.Team{})
+		gdb.AutoMigrate(secretmessage.Secret{})
+		gdb.AutoMigrate(secretmessage.TeamSettings{})
+		gdb.AutoMigrate(secretmessage.AuditEvent{})
+		gdb.AutoMigrate(secretmessage.APIToken{})
+		gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: "xoxb-1234"})
+		gdb.Create(&secretmessage.Secret{ID: "mine", Value: "abc", TeamID: teamID, SenderID: userID, ChannelID: "C0001", ExpiresAt: time.Now().Add(time.Hour)})
+		gdb.Create(&secretmessage.Secret{ID: "theirs", Value: "def", TeamID: teamID, SenderID: "U9999", ChannelID: "C0002", ExpiresAt: time.Now().Add(time.Hour)})
+		gdb.Create(&secretmessage.Secret{ID: "read", TeamID: teamID, SenderID: userID, ChannelID: "C0003", ExpiresAt: time.Now().Add(time.Hour),
+			RetiredReason: secretmessage.SecretRetiredRead, ReadBy: "U5555", Model: gorm.Model{DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}})
+		ctl = secretmessage.NewController(
+			secretmessage.Config{SkipSignatureValidation: true},
+			gdb,
+			nil,
+		)
+		router = ctl.ConfigureRoutes()
+	})
+	AfterEach(func() {
+		httpmock.DeactivateAndReset()
+		db, _ := gdb.DB()
+		db.Close()
+	})
+
+	Describe("on app_home_opened", func() {
+		JustBeforeEach(func() {
+			body := eventCallbackPayload(teamID, fmt.Sprintf(`{"type": "app_home_opened", "user": %q, "channel": "D0001", "tab": "home", "event_ts": "1700000000.000100"}`, userID))
+			serverResponse = doHttpRequest(router, strings.NewReader(body), map[string]string{"Content-Type": "application/json"}, "POST", "/events")
+		})
+
+		Context("as a regular member", func() {
+			It("should publish the user's unread secrets and activity", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				Expect(published).To(HaveLen(1))
+				Expect(published[0]).To(ContainSubstring(`"type":"home"`))
+				Expect(published[0]).To(ContainSubstring(`\u003c#C0001\u003e`))
+				Expect(published[0]).To(ContainSubstring(actions.RevokeSecret))
+				Expect(published[0]).To(ContainSubstring(`Read by \u003c@U5555\u003e`))
+				Expect(published[0]).NotTo(ContainSubstring("C0002"))
+				Expect(published[0]).NotTo(ContainSubstring("Workspace"))
+			})
+		})
+
+		Context("as a workspace admin", func() {
+			BeforeEach(func() {
+				admin = true
+			})
+			It("should include workspace counts", func() {
+				Expect(published).To(HaveLen(1))
+				Expect(published[0]).To(ContainSubstring("Workspace"))
+				Expect(published[0]).To(ContainSubstring(`*Unread secrets*\n2`))
+				Expect(published[0]).To(ContainSubstring(`*Read in the last 30 days*\n1`))
+			})
+		})
+	})
+
+	Describe("on revoke button", func() {
+		var secretID string
+		JustBeforeEach(func() {
+			interactionPayload := slack.InteractionCallback{
+				Type: slack.InteractionTypeBlockActions,
+				Team: slack.Team{ID: teamID},
+				User: slack.User{ID: userID},
+				ActionCallback: slack.ActionCallbacks{
+					BlockActions: []*slack.BlockAction{{ActionID: actions.RevokeSecret, Value: secretID}},
+				},
+			}
+			interactionBytes, _ := json.Marshal(interactionPayload)
+			requestBody := url.Values{"payload": []string{string(interactionBytes)}}
+			serverResponse = doHttpRequest(router, strings.NewReader(requestBody.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "POST", "/interactive")
+		})
+
+		Context("on the user's own secret", func() {
+			BeforeEach(func() {
+				secretID = "mine"
+			})
+			It("should revoke the secret and refresh the Home tab", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				var s secretmessage.Secret
+				Expect(gdb.Where("id = ?", "mine").Take(&s).RowsAffected).To(BeEquivalentTo(0))
+				Expect(gdb.Unscoped().Where("id = ?", "mine").Take(&s).Error).To(BeNil())
+				Expect(s.RetiredReason).To(Equal(secretmessage.SecretRetiredRevoked))
+				Expect(s.Value).To(BeEmpty())
+				Expect(published).To(HaveLen(1))
+				var e secretmessage.AuditEvent
+				Expect(gdb.Take(&e).Error).To(BeNil())
+				Expect(e.Action).To(Equal(secretmessage.AuditSecretRevoked))
+				Expect(e.ActorID).To(Equal(userID))
+			})
+		})
+
+		Context("on someone else's secret", func() {
+			BeforeEach(func() {
+				secretID = "theirs"
+			})
+			It("should leave the secret alone", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				var s secretmessage.Secret
+				Expect(gdb.Where("id = ?", "theirs").Take(&s).Error).To(BeNil())
+				Expect(s.Value).To(Equal("def"))
+			})
+		})
+	})
+})
@@ -27,6 +27,8 @@ func (ctl *PublicController) HandleInteractive(c *gin.Context) {
 	switch i.Type {
 	case slack.InteractionTypeViewSubmission:
 		CallbackViewSubmission(ctl, c, i)
+	case slack.InteractionTypeBlockActions:
+		CallbackBlockActions(ctl, c, i)
 	default:
 		callbackType := strings.Split(i.CallbackID, ":")[0]
 		switch callbackType {
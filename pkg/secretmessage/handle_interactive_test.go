@@ -1,6 +1,7 @@
 package secretmessage_test
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -51,6 +52,9 @@ var _ = Describe("/interactive", func() {
 			}
 			gdb.AutoMigrate(secretmessage.Team{})
 			gdb.AutoMigrate(secretmessage.Secret{})
+			gdb.AutoMigrate(secretmessage.TeamSettings{})
+			gdb.AutoMigrate(secretmessage.AuditEvent{})
+			gdb.AutoMigrate(secretmessage.APIToken{})
 			ctl = secretmessage.NewController(
 				secretmessage.Config{SkipSignatureValidation: true},
 				gdb,
@@ -83,6 +87,18 @@ var _ = Describe("/interactive", func() {
 				tx := gdb.Take(&s)
 				Expect(tx.RowsAffected).To(BeEquivalentTo(0))
 			})
+			It("should keep the scrubbed secret for the sender's activity", func() {
+				var s secretmessage.Secret
+				Expect(gdb.Unscoped().Take(&s).Error).To(BeNil())
+				Expect(s.Value).To(BeEmpty())
+				Expect(s.RetiredReason).To(Equal(secretmessage.SecretRetiredRead))
+			})
+			It("should record the read in the audit log", func() {
+				var e secretmessage.AuditEvent
+				Expect(gdb.Take(&e).Error).To(BeNil())
+				Expect(e.Action).To(Equal(secretmessage.AuditSecretRead))
+				Expect(e.SecretID).To(Equal(secretIDHashed))
+			})
 		})
 		Context("on secret not found in DB", func() {
 			BeforeEach(func() {
@@ -109,6 +125,11 @@ var _ = Describe("/interactive", func() {
 				})
 				Expect(tx.RowsAffected).To(BeEquivalentTo(1))
 			})
+			It("should record the expiry in the audit log", func() {
+				var e secretmessage.AuditEvent
+				Expect(gdb.Take(&e).Error).To(BeNil())
+				Expect(e.Action).To(Equal(secretmessage.AuditSecretExpired))
+			})
 			It("should return error message for expired secret", func() {
 				var msg slack.Message
 				b, _ := ioutil.ReadAll(serverResponse.Body)
@@ -155,6 +176,9 @@ var _ = Describe("/interactive", func() {
 			}
 			gdb.AutoMigrate(secretmessage.Team{})
 			gdb.AutoMigrate(secretmessage.Secret{})
+			gdb.AutoMigrate(secretmessage.TeamSettings{})
+			gdb.AutoMigrate(secretmessage.AuditEvent{})
+			gdb.AutoMigrate(secretmessage.APIToken{})
 			ctl = secretmessage.NewController(
 				secretmessage.Config{SkipSignatureValidation: true},
 				gdb,
@@ -179,6 +203,25 @@ var _ = Describe("/interactive", func() {
 				Expect(serverResponse.Code).To(Equal(http.StatusOK))
 				Expect(msg.DeleteOriginal).To(BeTrue())
 			})
+			It("should not record a dismissal of a secret it doesn't know", func() {
+				var count int64
+				gdb.Model(&secretmessage.AuditEvent{}).Count(&count)
+				Expect(count).To(BeZero())
+			})
+		})
+
+		Context("on a secret that was read", func() {
+			BeforeEach(func() {
+				tx := gdb.Create(&secretmessage.Secret{ID: secretIDHashed, TeamID: "T1234ABCD", ChannelID: "C1234ABCD", ExpiresAt: time.Now().Add(time.Hour)})
+				Expect(tx.Error).To(BeNil())
+			})
+			It("should record that the secret was dismissed", func() {
+				var e secretmessage.AuditEvent
+				Expect(gdb.Take(&e).Error).To(BeNil())
+				Expect(e.Action).To(Equal(secretmessage.AuditSecretDismissed))
+				Expect(e.SecretID).To(Equal(secretIDHashed))
+				Expect(e.TeamID).To(Equal("T1234ABCD"))
+			})
 		})
 	})
 
@@ -226,6 +269,9 @@ var _ = Describe("/interactive", func() {
 			}
 			gdb.AutoMigrate(secretmessage.Team{})
 			gdb.AutoMigrate(secretmessage.Secret{})
+			gdb.AutoMigrate(secretmessage.TeamSettings{})
+			gdb.AutoMigrate(secretmessage.AuditEvent{})
+			gdb.AutoMigrate(secretmessage.APIToken{})
 			ctl = secretmessage.NewController(
 				secretmessage.Config{SkipSignatureValidation: true},
 				gdb,
@@ -254,4 +300,488 @@ var _ = Describe("/interactive", func() {
 			})
 		})
 	})
+
+	Describe("Modal Submit with channel metadata", func() {
+		responseURL := "https://hooks.slack.com/actions/T00000000/1234567890/abcdefghijklmnopqrstuvwxyz"
+		teamID := "T1234ABCD"
+		channelID := "C0CHANNEL"
+		var openedAt time.Time
+		var posted []url.Values
+		var responseURLCalls int
+
+		BeforeEach(func() {
+			openedAt = time.Now()
+			posted = nil
+			responseURLCalls = 0
+			httpmock.Activate()
+			httpmock.RegisterResponder("POST", responseURL, func(req *http.Request) (*http.Response, error) {
+				responseURLCalls++
+				return httpmock.NewStringResponse(200, `ok`), nil
+			})
+			httpmock.RegisterResponder("POST", "https://slack.com/api/chat.postMessage", func(req *http.Request) (*http.Response, error) {
+				req.ParseForm()
+				posted = append(posted, req.PostForm)
+				return httpmock.NewStringResponse(200, `{"ok": true, "channel": "C0CHANNEL", "ts": "1700000000.000100"}`), nil
+			})
+
+			var err error
+			gdb, err = gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname=handle_interactive_metadata"), &gorm.Config{})
+			if err != nil {
+				log.Fatal(err)
+			}
+			gdb.AutoMigrate(secretmessage.Team{})
+			gdb.AutoMigrate(secretmessage.Secret{})
+			gdb.AutoMigrate(secretmessage.TeamSettings{})
+			gdb.AutoMigrate(secretmessage.AuditEvent{})
+			gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: "xoxb-1234"})
+			ctl = secretmessage.NewController(
+				secretmessage.Config{SkipSignatureValidation: true},
+				gdb,
+				nil,
+			)
+		})
+		JustBeforeEach(func() {
+			metadata, _ := json.Marshal(map[string]interface{}{"response_url": responseURL, "channel_id": channelID, "opened_at": openedAt.Unix()})
+			interactionPayload := slack.InteractionCallback{
+				Type: slack.InteractionTypeViewSubmission,
+				Team: slack.Team{ID: teamID},
+				User: slack.User{ID: "U1234ABCD", Name: "alice"},
+				View: slack.View{
+					PrivateMetadata: string(metadata),
+					CallbackID:      actions.CreateSecretModal,
+					State: &slack.ViewState{
+						Values: map[string]map[string]slack.BlockAction{
+							"secret_text_input": {"secret_text_input": {Value: "example secret text"}},
+							"expiry_date_input": {"expiry_date_input": {SelectedDate: time.Now().AddDate(0, 0, 1).Format("2006-01-02")}},
+						},
+					},
+				},
+			}
+			interactionBytes, _ := json.Marshal(interactionPayload)
+			requestBody := url.Values{"payload": []string{string(interactionBytes)}}
+			router = ctl.ConfigureRoutes()
+			serverResponse = doHttpRequest(router, strings.NewReader(requestBody.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "POST", "/interactive")
+			// Wait for the secret to be delivered in the background
+			Expect(ctl.Close(context.Background())).To(Succeed())
+		})
+		AfterEach(func() {
+			httpmock.DeactivateAndReset()
+			db, _ := gdb.DB()
+			db.Close()
+		})
+
+		Context("with a fresh response URL", func() {
+			It("should send the envelope to the response URL and record the channel", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				Expect(responseURLCalls).To(Equal(1))
+				Expect(posted).To(BeEmpty())
+				var s secretmessage.Secret
+				Expect(gdb.Take(&s).Error).To(BeNil())
+				Expect(s.ChannelID).To(Equal(channelID))
+			})
+		})
+
+		Context("with a response URL older than 30 minutes", func() {
+			BeforeEach(func() {
+				openedAt = time.Now().Add(-31 * time.Minute)
+			})
+			It("should post the envelope to the channel with the bot token", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				Expect(responseURLCalls).To(Equal(0))
+				Expect(posted).To(HaveLen(1))
+				Expect(posted[0].Get("channel")).To(Equal(channelID))
+				Expect(posted[0].Get("attachments")).To(ContainSubstring(actions.ReadMessage))
+			})
+		})
+
+		Context("with a response URL that was used up", func() {
+			BeforeEach(func() {
+				httpmock.RegisterResponder("POST", responseURL, func(req *http.Request) (*http.Response, error) {
+					responseURLCalls++
+					return httpmock.NewStringResponse(404, `used_url`), nil
+				})
+			})
+			It("should fall back to posting the envelope to the channel", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				Expect(responseURLCalls).To(Equal(1))
+				Expect(posted).To(HaveLen(1))
+				Expect(posted[0].Get("channel")).To(Equal(channelID))
+			})
+		})
+	})
+
+	Describe("Modal Submit validation", func() {
+		responseURL := "https://hooks.slack.com/actions/T00000000/1234567890/abcdefghijklmnopqrstuvwxyz"
+		teamID := "T1234ABCD"
+		channelID := "C0CHANNEL"
+		var values map[string]map[string]slack.BlockAction
+		var res slack.ViewSubmissionResponse
+		var submitterLocale string
+		var extraMetadata map[string]interface{}
+
+		BeforeEach(func() {
+			submitterLocale = "en-US"
+			extraMetadata = nil
+			values = map[string]map[string]slack.BlockAction{
+				"secret_text_input": {"secret_text_input": {Value: "example secret text"}},
+				"expiry_date_input": {"expiry_date_input": {SelectedDate: time.Now().AddDate(0, 0, 1).Format("2006-01-02")}},
+			}
+			httpmock.Activate()
+			httpmock.RegisterResponder("POST", responseURL, httpmock.NewStringResponder(200, `ok`))
+			httpmock.RegisterResponder("POST", "https://slack.com/api/users.info", func(req *http.Request) (*http.Response, error) {
+				req.ParseForm()
+				id := req.PostForm.Get("user")
+				locale := ""
+				if id == "U1234ABCD" {
+					locale = submitterLocale
+				}
+				return httpmock.NewJsonResponse(200, map[string]interface{}{
+					"ok":   true,
+					"user": map[string]interface{}{"id": id, "name": strings.ToLower(id), "is_bot": id == "B0BOT", "deleted": id == "U0GONE", "locale": locale},
+				})
+			})
+
+			var err error
+			gdb, err = gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname=handle_interactive_validation"), &gorm.Config{})
+			if err != nil {
+				log.Fatal(err)
+			}
+			gdb.AutoMigrate(secretmessage.Team{})
+			gdb.AutoMigrate(secretmessage.Secret{})
+			gdb.AutoMigrate(secretmessage.TeamSettings{})
+			gdb.AutoMigrate(secretmessage.AuditEvent{})
+			gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: "xoxb-1234"})
+			ctl = secretmessage.NewController(
+				secretmessage.Config{SkipSignatureValidation: true},
+				gdb,
+				nil,
+			)
+		})
+		JustBeforeEach(func() {
+			meta := map[string]interface{}{"response_url": responseURL, "channel_id": channelID, "opened_at": time.Now().Unix()}
+			for k, v := range extraMetadata {
+				meta[k] = v
+			}
+			metadata, _ := json.Marshal(meta)
+			interactionPayload := slack.InteractionCallback{
+				Type: slack.InteractionTypeViewSubmission,
+				Team: slack.Team{ID: teamID},
+				User: slack.User{ID: "U1234ABCD", Name: "alice"},
+				View: slack.View{
+					ID:              "V0MODAL",
+					PrivateMetadata: string(metadata),
+					CallbackID:      actions.CreateSecretModal,
+					State:           &slack.ViewState{Values: values},
+				},
+			}
+			interactionBytes, _ := json.Marshal(interactionPayload)
+			requestBody := url.Values{"payload": []string{string(interactionBytes)}}
+			router = ctl.ConfigureRoutes()
+			serverResponse = doHttpRequest(router, strings.NewReader(requestBody.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "POST", "/interactive")
+			res = slack.ViewSubmissionResponse{}
+			json.Unmarshal(serverResponse.Body.Bytes(), &res)
+			// Wait for the secret to be delivered in the background
+			Expect(ctl.Close(context.Background())).To(Succeed())
+		})
+		AfterEach(func() {
+			httpmock.DeactivateAndReset()
+			db, _ := gdb.DB()
+			db.Close()
+		})
+
+		expectRejected := func(blockID string, message string) {
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+			Expect(res.ResponseAction).To(Equal(slack.RAErrors))
+			Expect(res.Errors).To(HaveKeyWithValue(blockID, MatchRegexp(message)))
+			var count int64
+			gdb.Model(&secretmessage.Secret{}).Count(&count)
+			Expect(count).To(BeZero())
+		}
+
+		It("should confirm a valid secret was sent by updating the modal", func() {
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+			Expect(res.ResponseAction).To(Equal(slack.RAUpdate))
+			Expect(res.View.Title.Text).To(Equal("Secret sent"))
+			Expect(res.View.Blocks.BlockSet[0].(*slack.SectionBlock).Text.Text).To(ContainSubstring("<#" + channelID + ">"))
+		})
+
+		Context("with an empty secret", func() {
+			BeforeEach(func() {
+				values["secret_text_input"] = map[string]slack.BlockAction{"secret_text_input": {Value: "   "}}
+			})
+			It("should show an error under the secret", func() {
+				expectRejected("secret_text_input", "Enter the secret")
+			})
+		})
+
+		Context("with a template", func() {
+			BeforeEach(func() {
+				extraMetadata = map[string]interface{}{"template": "VPN access", "template_fields": []string{"User", "Password"}}
+				delete(values, "secret_text_input")
+				values["template_field_0"] = map[string]slack.BlockAction{"template_field_0": {Value: "alice"}}
+				values["template_field_1"] = map[string]slack.BlockAction{"template_field_1": {Value: "hunter2"}}
+			})
+			It("should store the labelled fields together", func() {
+				Expect(res.ResponseAction).To(Equal(slack.RAUpdate))
+				var s secretmessage.Secret
+				Expect(gdb.Take(&s).Error).To(BeNil())
+				Expect(s.Template).To(Equal("VPN access"))
+				Expect(s.Value).NotTo(ContainSubstring("hunter2"))
+			})
+			Context("with an empty field", func() {
+				BeforeEach(func() {
+					values["template_field_1"] = map[string]slack.BlockAction{"template_field_1": {Value: ""}}
+				})
+				It("should show an error under the field", func() {
+					expectRejected("template_field_1", "Enter the secret")
+				})
+			})
+		})
+
+		Context("generating a password", func() {
+			BeforeEach(func() {
+				extraMetadata = map[string]interface{}{"generate": "password"}
+				delete(values, "secret_text_input")
+				values["generate_length_input"] = map[string]slack.BlockAction{"generate_length_input": {Value: "20"}}
+				values["generate_charsets_input"] = map[string]slack.BlockAction{"generate_charsets_input": {SelectedOptions: []slack.OptionBlockObject{{Value: "upper"}, {Value: "digits"}}}}
+			})
+			It("should send it and show the sender a copy", func() {
+				Expect(res.ResponseAction).To(Equal(slack.RAUpdate))
+				Expect(res.View.Blocks.BlockSet).To(HaveLen(2))
+				text := res.View.Blocks.BlockSet[1].(*slack.SectionBlock).Text.Text
+				Expect(text).To(HavePrefix("*Your generated password*"))
+				Expect(text).To(MatchRegexp("```[A-Z0-9]{20}```"))
+				var count int64
+				gdb.Model(&secretmessage.Secret{}).Count(&count)
+				Expect(count).To(BeEquivalentTo(1))
+			})
+			Context("without any characters", func() {
+				BeforeEach(func() {
+					values["generate_charsets_input"] = map[string]slack.BlockAction{"generate_charsets_input": {}}
+				})
+				It("should show an error under the characters", func() {
+					expectRejected("generate_charsets_input", "at least one kind")
+				})
+			})
+			Context("longer than the workspace allows", func() {
+				BeforeEach(func() {
+					settings := secretmessage.DefaultTeamSettings(teamID)
+					settings.MaxSecretLength = 10
+					gdb.Create(&settings)
+				})
+				It("should show an error under the length", func() {
+					expectRejected("generate_length_input", "limited to 10 characters")
+				})
+			})
+		})
+
+		Context("from a user whose Slack is in French", func() {
+			BeforeEach(func() {
+				submitterLocale = "fr-FR"
+			})
+			It("should confirm the secret was sent in French", func() {
+				Expect(res.ResponseAction).To(Equal(slack.RAUpdate))
+				Expect(res.View.Title.Text).To(Equal("Secret envoyé"))
+			})
+			Context("with an empty secret", func() {
+				BeforeEach(func() {
+					values["secret_text_input"] = map[string]slack.BlockAction{"secret_text_input": {Value: "   "}}
+				})
+				It("should show the error in French", func() {
+					expectRejected("secret_text_input", "Saisissez le secret")
+				})
+			})
+		})
+
+		Context("from a user whose Slack language isn't translated", func() {
+			BeforeEach(func() {
+				submitterLocale = "ja-JP"
+				settings := secretmessage.DefaultTeamSettings(teamID)
+				settings.Locale = "de"
+				gdb.Create(&settings)
+			})
+			It("should answer in the workspace's language", func() {
+				Expect(res.ResponseAction).To(Equal(slack.RAUpdate))
+				Expect(res.View.Title.Text).To(Equal("Geheimnis gesendet"))
+			})
+		})
+
+		Context("with a secret longer than the workspace allows", func() {
+			BeforeEach(func() {
+				settings := secretmessage.DefaultTeamSettings(teamID)
+				settings.MaxSecretLength = 5
+				gdb.Create(&settings)
+			})
+			It("should show an error under the secret", func() {
+				expectRejected("secret_text_input", "limited to 5 characters")
+			})
+		})
+
+		Context("with an unparsable expiry date", func() {
+			BeforeEach(func() {
+				values["expiry_date_input"] = map[string]slack.BlockAction{"expiry_date_input": {SelectedDate: "next tuesday"}}
+			})
+			It("should show an error under the expiry date", func() {
+				expectRejected("expiry_date_input", "Choose a date")
+			})
+		})
+
+		Context("with an expiry date further out than the workspace allows", func() {
+			BeforeEach(func() {
+				values["expiry_date_input"] = map[string]slack.BlockAction{"expiry_date_input": {SelectedDate: time.Now().AddDate(0, 0, secretmessage.DefaultMaxExpiryDays+5).Format("2006-01-02")}}
+			})
+			It("should show an error under the expiry date", func() {
+				expectRejected("expiry_date_input", "at most 30 days")
+			})
+		})
+
+		Context("with an expiry date in the past", func() {
+			BeforeEach(func() {
+				values["expiry_date_input"] = map[string]slack.BlockAction{"expiry_date_input": {SelectedDate: time.Now().AddDate(0, 0, -3).Format("2006-01-02")}}
+			})
+			It("should show an error under the expiry date", func() {
+				expectRejected("expiry_date_input", "in the past")
+			})
+		})
+
+		Context("with an app as a recipient", func() {
+			BeforeEach(func() {
+				values["recipients_input"] = map[string]slack.BlockAction{"recipients_input": {SelectedUsers: []string{"U0FRIEND", "B0BOT"}}}
+			})
+			It("should show an error under the recipients", func() {
+				expectRejected("recipients_input", "b0bot is an app")
+			})
+		})
+
+		Context("with a deactivated recipient", func() {
+			BeforeEach(func() {
+				values["recipients_input"] = map[string]slack.BlockAction{"recipients_input": {SelectedUsers: []string{"U0GONE"}}}
+			})
+			It("should show an error under the recipients", func() {
+				expectRejected("recipients_input", "u0gone is deactivated")
+			})
+		})
+
+		Context("when the secret can't be delivered", func() {
+			var updated []string
+			BeforeEach(func() {
+				updated = nil
+				httpmock.RegisterResponder("POST", responseURL, httpmock.NewStringResponder(404, `used_url`))
+				httpmock.RegisterResponder("POST", "https://slack.com/api/chat.postMessage", httpmock.NewStringResponder(200, `{"ok": false, "error": "channel_not_found"}`))
+				httpmock.RegisterResponder("POST", "https://slack.com/api/views.update", func(req *http.Request) (*http.Response, error) {
+					body, _ := ioutil.ReadAll(req.Body)
+					updated = append(updated, string(body))
+					return httpmock.NewStringResponse(200, `{"ok": true}`), nil
+				})
+			})
+			It("should revoke the secret and replace the confirmation with an error", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				Expect(res.ResponseAction).To(Equal(slack.RAUpdate))
+				var s secretmessage.Secret
+				Expect(gdb.Unscoped().Take(&s).Error).To(BeNil())
+				Expect(s.RetiredReason).To(Equal(secretmessage.SecretRetiredRevoked))
+				Expect(updated).To(HaveLen(1))
+				Expect(updated[0]).To(ContainSubstring(`"view_id":"V0MODAL"`))
+				Expect(updated[0]).To(ContainSubstring("couldn't be sent"))
+			})
+		})
+
+		Context("with several recipients", func() {
+			BeforeEach(func() {
+				values["recipients_input"] = map[string]slack.BlockAction{"recipients_input": {SelectedUsers: []string{"U0FRIEND", "U0OTHER", "U0GONE"}}}
+			})
+			It("should look them all up", func() {
+				expectRejected("recipients_input", "u0gone is deactivated")
+				// The submitter, then every recipient
+				Expect(httpmock.GetCallCountInfo()["POST https://slack.com/api/users.info"]).To(Equal(4))
+			})
+		})
+	})
+
+	Describe("Choose template", func() {
+		teamID := "T1234ABCD"
+		var updated []string
+		var chosen string
+
+		BeforeEach(func() {
+			updated = nil
+			chosen = "template:VPN access"
+			httpmock.Activate()
+			httpmock.RegisterResponder("POST", "https://slack.com/api/users.info", httpmock.NewStringResponder(200, `{"ok": true, "user": {"id": "U1234ABCD"}}`))
+			httpmock.RegisterResponder("POST", "https://slack.com/api/views.update", func(req *http.Request) (*http.Response, error) {
+				body, _ := ioutil.ReadAll(req.Body)
+				updated = append(updated, string(body))
+				return httpmock.NewStringResponse(200, `{"ok": true}`), nil
+			})
+
+			var err error
+			gdb, err = gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname=handle_interactive_template"), &gorm.Config{})
+			if err != nil {
+				log.Fatal(err)
+			}
+			gdb.AutoMigrate(secretmessage.Team{})
+			gdb.AutoMigrate(secretmessage.TeamSettings{})
+			gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: "xoxb-1234"})
+			settings := secretmessage.DefaultTeamSettings(teamID)
+			settings.Templates = secretmessage.SecretTemplates{{Name: "VPN access", Fields: []string{"User", "Password", "OTP seed"}}}
+			gdb.Create(&settings)
+			ctl = secretmessage.NewController(
+				secretmessage.Config{SkipSignatureValidation: true},
+				gdb,
+				nil,
+			)
+		})
+		JustBeforeEach(func() {
+			interactionPayload := slack.InteractionCallback{
+				Type: slack.InteractionTypeBlockActions,
+				Team: slack.Team{ID: teamID},
+				User: slack.User{ID: "U1234ABCD"},
+				View: slack.View{ID: "V0MODAL", Hash: "1234.abcd", CallbackID: actions.CreateSecretModal, PrivateMetadata: `{"channel_id":"C0CHANNEL"}`},
+				ActionCallback: slack.ActionCallbacks{
+					BlockActions: []*slack.BlockAction{{ActionID: actions.ChooseTemplate, SelectedOption: slack.OptionBlockObject{Value: chosen}}},
+				},
+			}
+			interactionBytes, _ := json.Marshal(interactionPayload)
+			requestBody := url.Values{"payload": []string{string(interactionBytes)}}
+			router = ctl.ConfigureRoutes()
+			serverResponse = doHttpRequest(router, strings.NewReader(requestBody.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "POST", "/interactive")
+		})
+		AfterEach(func() {
+			httpmock.DeactivateAndReset()
+			db, _ := gdb.DB()
+			db.Close()
+		})
+
+		It("should update the modal with an input for each field", func() {
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+			Expect(updated).To(HaveLen(1))
+			Expect(updated[0]).To(ContainSubstring("V0MODAL"))
+			Expect(updated[0]).To(ContainSubstring("template_field_2"))
+			Expect(updated[0]).To(ContainSubstring("OTP seed"))
+			Expect(updated[0]).NotTo(ContainSubstring("secret_text_input"))
+		})
+
+		Context("choosing to generate a password", func() {
+			BeforeEach(func() {
+				chosen = "generate:password"
+			})
+			It("should update the modal with the length and characters to generate", func() {
+				Expect(updated).To(HaveLen(1))
+				Expect(updated[0]).To(ContainSubstring("generate_length_input"))
+				Expect(updated[0]).To(ContainSubstring("generate_charsets_input"))
+				Expect(updated[0]).NotTo(ContainSubstring("secret_text_input"))
+			})
+		})
+
+		Context("choosing no template", func() {
+			BeforeEach(func() {
+				chosen = "none"
+			})
+			It("should update the modal with the secret text input", func() {
+				Expect(updated).To(HaveLen(1))
+				Expect(updated[0]).To(ContainSubstring("secret_text_input"))
+				Expect(updated[0]).NotTo(ContainSubstring("template_field_0"))
+			})
+		})
+	})
 })
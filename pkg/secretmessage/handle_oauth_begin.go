@@ -9,7 +9,7 @@ import (
 
 func (ctl *PublicController) HandleOauthBegin(c *gin.Context) {
 	state := rand.Text()
-	url := ctl.config.OauthConfig.AuthCodeURL(state, oauth2.AccessTypeOnline)
+	url := ctl.config.OauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline)
 
 	c.SetCookie("state", state, 0, "", "", false, true)
 	c.Redirect(302, url)
@@ -2,11 +2,12 @@ package secretmessage
 
 import (
 	"database/sql"
-	"encoding/json"
+	"errors"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
 )
 
 func (ctl *PublicController) HandleOauthCallback(c *gin.Context) {
@@ -30,34 +31,31 @@ func (ctl *PublicController) HandleOauthCallback(c *gin.Context) {
 		c.Redirect(302, "https://secretmessage.xyz/error")
 		return
 	}
-	r := token.Extra("raw")
-	b, _ := json.Marshal(r)
-	ctl.logger.Sugar().Infof("%+v", string(b))
-
-	teamMap, ok := token.Extra("team").(map[string]interface{})
-	if !ok {
-		ctl.logger.Error("error unmarshalling team from token", zap.Any("token", token))
+	installation, err := parseInstallation(token)
+	if err != nil {
+		ctl.logger.Error("error parsing installation from token", zap.Error(err))
 		c.Redirect(302, "https://secretmessage.xyz/error")
 		return
 	}
+	teamID := installation.ID
 
-	teamID, ok := teamMap["id"].(string)
-	if !ok || teamID == "" {
-		ctl.logger.Error("error unmarshalling teamID from token", zap.Any("token", token))
+	scope, ok := token.Extra("scope").(string)
+	if !ok || scope == "" {
+		ctl.logger.Error("error unmarshalling scope from token", zap.String("teamID", teamID))
 		c.Redirect(302, "https://secretmessage.xyz/error")
 		return
 	}
 
-	teamName, ok := teamMap["name"].(string)
-	if !ok || teamName == "" {
-		ctl.logger.Error("error unmarshalling teamName from token", zap.Any("token", token))
+	accessToken, err := ctl.sealAtRest(token.AccessToken)
+	if err != nil {
+		ctl.logger.Error("error encrypting access token", zap.Error(err), zap.String("teamID", teamID))
 		c.Redirect(302, "https://secretmessage.xyz/error")
 		return
 	}
-
-	scope, ok := token.Extra("scope").(string)
-	if !ok || scope == "" {
-		ctl.logger.Error("error unmarshalling scope from token", zap.Any("token", token))
+	// Refresh token and expiry are only present when token rotation is enabled for the app
+	refreshToken, err := ctl.sealAtRest(token.RefreshToken)
+	if err != nil {
+		ctl.logger.Error("error encrypting refresh token", zap.Error(err), zap.String("teamID", teamID))
 		c.Redirect(302, "https://secretmessage.xyz/error")
 		return
 	}
@@ -67,7 +65,17 @@ func (ctl *PublicController) HandleOauthCallback(c *gin.Context) {
 		WithContext(hc).
 		Where(&team, Team{ID: teamID}).
 		Attrs(Team{Paid: sql.NullBool{Bool: false, Valid: true}}).
-		Assign(Team{AccessToken: token.AccessToken, Scope: scope, Name: teamName}).
+		Assign(map[string]interface{}{
+			"access_token":          accessToken,
+			"refresh_token":         refreshToken,
+			"token_expires_at":      token.Expiry,
+			"scope":                 scope,
+			"name":                  installation.Name,
+			"enterprise_id":         installation.EnterpriseID,
+			"enterprise_name":       installation.EnterpriseName,
+			"install_type":          installation.InstallType,
+			"is_enterprise_install": installation.IsEnterpriseInstall,
+		}).
 		FirstOrCreate(&team).Error
 
 	if updateTeamErr != nil {
@@ -76,5 +84,43 @@ func (ctl *PublicController) HandleOauthCallback(c *gin.Context) {
 		return
 	}
 
+	ctl.logger.Info("app installed", zap.String("teamID", teamID), zap.String("enterpriseID", installation.EnterpriseID), zap.String("installType", installation.InstallType))
 	c.Redirect(302, "https://secretmessage.xyz/success")
 }
+
+// parseInstallation reads the workspace or Enterprise Grid organization the app was installed into
+// from an oauth.v2.access response. Org-wide installs have no team, and are keyed by enterprise ID instead.
+func parseInstallation(token *oauth2.Token) (Team, error) {
+	var installation Team
+	enterpriseMap, _ := token.Extra("enterprise").(map[string]interface{})
+	if enterpriseMap != nil {
+		installation.EnterpriseID, _ = enterpriseMap["id"].(string)
+		installation.EnterpriseName, _ = enterpriseMap["name"].(string)
+	}
+
+	if isEnterpriseInstall, _ := token.Extra("is_enterprise_install").(bool); isEnterpriseInstall {
+		if installation.EnterpriseID == "" || installation.EnterpriseName == "" {
+			return installation, errors.New("enterprise install without enterprise id or name")
+		}
+		installation.ID = installation.EnterpriseID
+		installation.Name = installation.EnterpriseName
+		installation.InstallType = InstallTypeEnterprise
+		installation.IsEnterpriseInstall = true
+		return installation, nil
+	}
+
+	teamMap, ok := token.Extra("team").(map[string]interface{})
+	if !ok {
+		return installation, errors.New("missing team in token response")
+	}
+	installation.ID, _ = teamMap["id"].(string)
+	if installation.ID == "" {
+		return installation, errors.New("missing team id in token response")
+	}
+	installation.Name, _ = teamMap["name"].(string)
+	if installation.Name == "" {
+		return installation, errors.New("missing team name in token response")
+	}
+	installation.InstallType = InstallTypeTeam
+	return installation, nil
+}
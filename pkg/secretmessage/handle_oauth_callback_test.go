@@ -1,6 +1,7 @@
 package secretmessage_test
 
 import (
+	"bytes"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -28,7 +29,10 @@ var _ = Describe("/auth/slack/callback", func() {
 	var teamName = "foobar"
 	var accessToken = "xoxb-foobar"
 	var scopes = "scope1,scope2,scope3"
+	var tokenCipher *secretmessage.TokenCipher
 	BeforeEach(func() {
+		tokenCipher, err = secretmessage.NewTokenCipher("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+		Expect(err).To(BeNil())
 		httpmock.Activate()
 		gdb, err = gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname=handle_oauth_callback"), &gorm.Config{})
 		if err != nil {
@@ -36,9 +40,13 @@ var _ = Describe("/auth/slack/callback", func() {
 		}
 		gdb.AutoMigrate(secretmessage.Team{})
 		gdb.AutoMigrate(secretmessage.Secret{})
+		gdb.AutoMigrate(secretmessage.TeamSettings{})
+		gdb.AutoMigrate(secretmessage.AuditEvent{})
+		gdb.AutoMigrate(secretmessage.APIToken{})
 		ctl = secretmessage.NewController(
 			secretmessage.Config{
 				SkipSignatureValidation: true,
+				TokenCipher:             tokenCipher,
 				OauthConfig: &oauth2.Config{
 					ClientID:     "myclientID",
 					ClientSecret: "myClientSecret",
@@ -94,6 +102,99 @@ var _ = Describe("/auth/slack/callback", func() {
 				Expect(serverResponse.Code).To(Equal(http.StatusFound))
 				Expect(serverResponse.Result().Header.Get("Location")).To(MatchRegexp(`/success`))
 			})
+			It("stores the access token encrypted", func() {
+				var team secretmessage.Team
+				tx := gdb.First(&team, "id = ?", teamID)
+				Expect(tx.Error).To(BeNil())
+				Expect(team.AccessToken).NotTo(ContainSubstring(accessToken))
+				Expect(secretmessage.IsEncryptedToken(team.AccessToken)).To(BeTrue())
+				decrypted, err := tokenCipher.Decrypt(team.AccessToken)
+				Expect(err).To(BeNil())
+				Expect(decrypted).To(Equal(accessToken))
+			})
+		})
+		Context("when token rotation is enabled", func() {
+			BeforeEach(func() {
+				httpmock.RegisterResponder("POST", "https://testingslack.com/api/oauth.v2.access", httpmock.NewJsonResponderOrPanic(200, OauthToken{
+					AccessToken:  accessToken,
+					RefreshToken: "xoxe-1-refresh",
+					ExpiresIn:    43200,
+					Scope:        scopes,
+					Team: struct {
+						Name string `json:"name"`
+						ID   string `json:"id"`
+					}{
+						Name: teamName,
+						ID:   teamID,
+					},
+				}))
+			})
+			It("stores the encrypted refresh token and expiry", func() {
+				var team secretmessage.Team
+				tx := gdb.First(&team, "id = ?", teamID)
+				Expect(tx.Error).To(BeNil())
+				Expect(secretmessage.IsEncryptedToken(team.RefreshToken)).To(BeTrue())
+				decrypted, err := tokenCipher.Decrypt(team.RefreshToken)
+				Expect(err).To(BeNil())
+				Expect(decrypted).To(Equal("xoxe-1-refresh"))
+				Expect(team.TokenExpiresAt).To(BeTemporally("~", time.Now().Add(12*time.Hour), time.Minute))
+			})
+		})
+		Context("when installed org-wide into an Enterprise Grid organization", func() {
+			BeforeEach(func() {
+				httpmock.RegisterResponder("POST", "https://testingslack.com/api/oauth.v2.access", httpmock.NewJsonResponderOrPanic(200, OauthToken{
+					AccessToken: accessToken,
+					Scope:       scopes,
+					Enterprise: &struct {
+						Name string `json:"name"`
+						ID   string `json:"id"`
+					}{
+						Name: "Globular Construct Inc",
+						ID:   "E0000001",
+					},
+					IsEnterpriseInstall: true,
+				}))
+			})
+			It("stores an enterprise installation keyed by enterprise ID", func() {
+				var team secretmessage.Team
+				tx := gdb.First(&team, "id = ?", "E0000001")
+				Expect(tx.Error).To(BeNil())
+				Expect(team.EnterpriseID).To(Equal("E0000001"))
+				Expect(team.Name).To(Equal("Globular Construct Inc"))
+				Expect(team.InstallType).To(Equal(secretmessage.InstallTypeEnterprise))
+				Expect(team.IsEnterpriseInstall).To(BeTrue())
+				Expect(serverResponse.Result().Header.Get("Location")).To(MatchRegexp(`/success`))
+			})
+		})
+		Context("when installed into a workspace of an Enterprise Grid organization", func() {
+			BeforeEach(func() {
+				httpmock.RegisterResponder("POST", "https://testingslack.com/api/oauth.v2.access", httpmock.NewJsonResponderOrPanic(200, OauthToken{
+					AccessToken: accessToken,
+					Scope:       scopes,
+					Team: struct {
+						Name string `json:"name"`
+						ID   string `json:"id"`
+					}{
+						Name: teamName,
+						ID:   teamID,
+					},
+					Enterprise: &struct {
+						Name string `json:"name"`
+						ID   string `json:"id"`
+					}{
+						Name: "Globular Construct Inc",
+						ID:   "E0000001",
+					},
+				}))
+			})
+			It("stores a workspace installation that records the enterprise", func() {
+				var team secretmessage.Team
+				tx := gdb.First(&team, "id = ?", teamID)
+				Expect(tx.Error).To(BeNil())
+				Expect(team.EnterpriseID).To(Equal("E0000001"))
+				Expect(team.InstallType).To(Equal(secretmessage.InstallTypeTeam))
+				Expect(team.IsEnterpriseInstall).To(BeFalse())
+			})
 		})
 		Context("when team already exists in db", func() {
 			createTime := time.Time{}
@@ -0,0 +1,249 @@
+package secretmessage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// webLinkActorID is the audit actor of secrets read through a web link, whose readers aren't Slack users
+const webLinkActorID = "web_link"
+
+// revealNotFound is the reveal page state of a link that doesn't match a web link secret
+const revealNotFound = "not_found"
+
+// clientCiphertextOverhead is what AES-GCM adds to a secret encrypted in the browser: the 12 byte
+// nonce in front of the sealed secret and the 16 byte tag after it
+const clientCiphertextOverhead = 12 + 16
+
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	revealTemplate    = template.Must(template.ParseFS(templateFS, "templates/reveal.html", "templates/style.html"))
+	newSecretTemplate = template.Must(template.ParseFS(templateFS, "templates/new.html", "templates/style.html"))
+)
+
+type revealPage struct {
+	State     string
+	ExpiresAt time.Time
+	Nonce     string
+	// ClientEncrypted pages decrypt the secret in the browser instead of receiving it in plain text
+	ClientEncrypted bool
+	// File pages download the secret instead of showing it
+	File     bool
+	FileSize string
+}
+
+type revealRequest struct {
+	Key string `json:"key"`
+}
+
+// webLinkURL is the address of a web link secret's page, without the key fragment
+func (ctl *PublicController) webLinkURL(id string) string {
+	return fmt.Sprintf("%s/s/%s", strings.TrimSuffix(ctl.config.AppURL, "/"), id)
+}
+
+// isKeyHash reports whether s looks like the hex SHA-256 that stands in for a client encrypted secret's key
+func isKeyHash(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == sha256.Size*2
+}
+
+// setRevealHeaders keeps reveal pages and responses out of caches, search engines and referrers
+func setRevealHeaders(c *gin.Context, nonce string) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Robots-Tag", "noindex, nofollow")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", fmt.Sprintf("default-src 'none'; script-src 'nonce-%s'; style-src 'unsafe-inline'; connect-src 'self'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'", nonce))
+}
+
+// webLinkSecret loads a web link secret by its ID, including retired ones
+func (ctl *PublicController) webLinkSecret(c *gin.Context) (Secret, error) {
+	var secret Secret
+	err := ctl.db.WithContext(c.Request.Context()).
+		Unscoped().
+		Where("id = ? AND web_link = ?", c.Param("id"), true).
+		Take(&secret).Error
+	return secret, err
+}
+
+// HandleNewSecretPage serves the page that encrypts a secret in the browser and creates a web link for
+// it through the API, so its plain text never reaches the server
+func (ctl *PublicController) HandleNewSecretPage(c *gin.Context) {
+	nonce := rand.Text()
+	setRevealHeaders(c, nonce)
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := newSecretTemplate.Execute(c.Writer, gin.H{"Nonce": nonce}); err != nil {
+		ctl.logger.Error("error rendering new secret page", zap.Error(err))
+	}
+}
+
+// HandleRevealPage serves the page behind a one-time web link. Loading it doesn't consume the secret,
+// so link previews can't burn it, the reader has to click to reveal it.
+func (ctl *PublicController) HandleRevealPage(c *gin.Context) {
+	page := revealPage{State: revealNotFound, Nonce: rand.Text()}
+	secret, err := ctl.webLinkSecret(c)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		ctl.logger.Error("error getting web link secret", zap.Error(err))
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		page.State = secret.Status()
+		page.ExpiresAt = secret.ExpiresAt
+		page.ClientEncrypted = secret.ClientEncrypted
+		page.File = secret.File
+		page.FileSize = formatFileSize(secret.FileSize)
+	}
+
+	setRevealHeaders(c, page.Nonce)
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := revealTemplate.Execute(c.Writer, page); err != nil {
+		ctl.logger.Error("error rendering reveal page", zap.Error(err))
+	}
+}
+
+// HandleReveal decrypts a web link secret with the key from the link's fragment, which the page posts
+// in the body, and retires it so the link only works once. Client encrypted secrets are returned as
+// ciphertext for the page to decrypt, their pages post the hash of the key instead of the key itself.
+func (ctl *PublicController) HandleReveal(c *gin.Context) {
+	hc := c.Request.Context()
+	setRevealHeaders(c, rand.Text())
+
+	var req revealRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Key == "" || hash(req.Key) != c.Param("id") {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"status": revealNotFound})
+		return
+	}
+	secret, err := ctl.webLinkSecret(c)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"status": revealNotFound})
+		return
+	}
+	if err != nil {
+		ctl.logger.Error("error getting web link secret", zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error"})
+		return
+	}
+
+	switch secret.Status() {
+	case SecretStatusUnread:
+	case SecretRetiredExpired:
+		if retired, _ := retireSecret(ctl.db.WithContext(hc), secret.ID, SecretRetiredExpired, ""); retired {
+			ctl.recordSecretEvent(hc, AuditSecretExpired, secret.TeamID, secret.ID, "", secret.ChannelID)
+			ctl.deleteFile(hc, secret.ID)
+		}
+		fallthrough
+	default:
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{"status": secret.Status()})
+		return
+	}
+
+	if secret.File {
+		ctl.revealFile(c, secret, req.Key)
+		return
+	}
+
+	response := gin.H{"status": SecretRetiredRead}
+	if secret.ClientEncrypted {
+		response["ciphertext"] = secret.Value
+	} else {
+		secretDecrypted, err := decrypt(secret.Value, req.Key)
+		if err != nil {
+			ctl.logger.Error("error decrypting secret", zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error"})
+			return
+		}
+		response["text"] = secretDecrypted
+	}
+	// Retire the secret before showing it, so it can only be read once even if revealed twice concurrently
+	retired, err := retireSecret(ctl.db.WithContext(hc), secret.ID, SecretRetiredRead, "")
+	if err != nil {
+		ctl.logger.Error("error retiring secret after retrieval", zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error"})
+		return
+	}
+	if !retired {
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{"status": SecretRetiredRead})
+		return
+	}
+	ctl.recordSecretEvent(hc, AuditSecretRead, secret.TeamID, secret.ID, webLinkActorID, secret.ChannelID)
+
+	c.JSON(http.StatusOK, response)
+
+	if secret.ReadReceipt && secret.SenderID != "" {
+		sendReadReceipt(ctl, hc, secret.TeamID, "", secret, "")
+	}
+}
+
+// revealFile streams a file secret's decrypted contents as a download and deletes it. The secret is
+// retired before the download starts, so a download that is interrupted can't be retried.
+func (ctl *PublicController) revealFile(c *gin.Context, secret Secret, key string) {
+	hc := c.Request.Context()
+	name, r, blob, err := ctl.openFile(hc, secret, key)
+	if err != nil {
+		ctl.logger.Error("error opening file secret", zap.Error(err), zap.String("teamID", secret.TeamID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error"})
+		return
+	}
+
+	retired, err := retireSecret(ctl.db.WithContext(hc), secret.ID, SecretRetiredRead, "")
+	if err != nil || !retired {
+		blob.Close()
+	}
+	if err != nil {
+		ctl.logger.Error("error retiring secret after retrieval", zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "error"})
+		return
+	}
+	if !retired {
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{"status": SecretRetiredRead})
+		return
+	}
+	ctl.recordSecretEvent(hc, AuditSecretRead, secret.TeamID, secret.ID, webLinkActorID, secret.ChannelID)
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	c.Header("Content-Length", strconv.FormatInt(secret.FileSize, 10))
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, r); err != nil {
+		// The headers are sent, so the reader only sees the download stop short
+		ctl.logger.Error("error sending file secret", zap.Error(err), zap.String("teamID", secret.TeamID))
+	}
+	blob.Close()
+	ctl.deleteFile(hc, secret.ID)
+
+	if secret.ReadReceipt && secret.SenderID != "" {
+		sendReadReceipt(ctl, hc, secret.TeamID, "", secret, "")
+	}
+}
+
+// formatFileSize formats a size in bytes for people, like 12 KB
+func formatFileSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%d KB", size>>10)
+	default:
+		return fmt.Sprintf("%d bytes", size)
+	}
+}
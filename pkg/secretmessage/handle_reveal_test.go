@@ -0,0 +1,158 @@
+package secretmessage_test
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jarcoal/httpmock"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var _ = Describe("One-time web links", func() {
+	responseURL := "https://fake-webhooks.fakeslack.com/response_url_1"
+	teamID := "T1234ABCD"
+	appURL := "https://secretmessage.example.com"
+	var gdb *gorm.DB
+	var err error
+	var ctl *secretmessage.PublicController
+	var router *gin.Engine
+	var sent []slack.Message
+	var link *url.URL
+
+	BeforeEach(func() {
+		sent = nil
+		link = nil
+		httpmock.Activate()
+		httpmock.RegisterResponder("POST", responseURL, func(req *http.Request) (*http.Response, error) {
+			var msg slack.Message
+			json.NewDecoder(req.Body).Decode(&msg)
+			sent = append(sent, msg)
+			return httpmock.NewStringResponse(200, "ok"), nil
+		})
+		gdb, err = gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname=handle_reveal"), &gorm.Config{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		gdb.AutoMigrate(secretmessage.Team{})
+		gdb.AutoMigrate(secretmessage.Secret{})
+		gdb.AutoMigrate(secretmessage.TeamSettings{})
+		gdb.AutoMigrate(secretmessage.AuditEvent{})
+		gdb.AutoMigrate(secretmessage.APIToken{})
+		gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: "xoxb-1234"})
+		ctl = secretmessage.NewController(
+			secretmessage.Config{SkipSignatureValidation: true, AppURL: appURL},
+			gdb,
+			nil,
+		)
+		router = ctl.ConfigureRoutes()
+
+		requestBody := url.Values{
+			"command":      []string{"/secret"},
+			"channel_id":   []string{"C1234ABCD"},
+			"text":         []string{"link hunter2"},
+			"team_id":      []string{teamID},
+			"user_id":      []string{"U1234ABCD"},
+			"user_name":    []string{"imafish"},
+			"response_url": []string{responseURL},
+		}
+		res := doHttpRequest(router, strings.NewReader(requestBody.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "POST", "/slash")
+		// Wait for the acknowledged command to be processed
+		Expect(ctl.Close(context.Background())).To(Succeed())
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(sent).To(HaveLen(1))
+		raw := regexp.MustCompile(`https://\S+`).FindString(sent[0].Attachments[0].Text)
+		link, err = url.Parse(raw)
+		Expect(err).To(BeNil())
+	})
+	AfterEach(func() {
+		httpmock.DeactivateAndReset()
+		db, _ := gdb.DB()
+		db.Close()
+	})
+
+	reveal := func(key string) (int, map[string]string) {
+		body, _ := json.Marshal(map[string]string{"key": key})
+		res := doHttpRequest(router, strings.NewReader(string(body)), map[string]string{"Content-Type": "application/json"}, "POST", link.Path)
+		var out map[string]string
+		json.Unmarshal(res.Body.Bytes(), &out)
+		return res.Code, out
+	}
+
+	It("should reply only to the sender with a link carrying the key in its fragment", func() {
+		Expect(sent[0].ResponseType).To(Equal(slack.ResponseTypeEphemeral))
+		Expect(link.Host).To(Equal("secretmessage.example.com"))
+		Expect(link.Path).To(MatchRegexp(`^/s/[a-f0-9]{64}$`))
+		Expect(link.Fragment).NotTo(BeEmpty())
+
+		var s secretmessage.Secret
+		Expect(gdb.Take(&s).Error).To(BeNil())
+		Expect(s.WebLink).To(BeTrue())
+		Expect(s.Value).NotTo(ContainSubstring("hunter2"))
+	})
+
+	It("should serve a page that doesn't consume the secret", func() {
+		res := doHttpRequest(router, nil, nil, "GET", link.Path)
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Header().Get("Cache-Control")).To(Equal("no-store"))
+		Expect(res.Body.String()).To(ContainSubstring("Reveal secret"))
+		Expect(res.Body.String()).NotTo(ContainSubstring("hunter2"))
+
+		var count int64
+		gdb.Model(&secretmessage.Secret{}).Count(&count)
+		Expect(count).To(BeEquivalentTo(1))
+	})
+
+	It("should reveal the secret exactly once", func() {
+		code, body := reveal(link.Fragment)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body["text"]).To(Equal("hunter2"))
+
+		code, body = reveal(link.Fragment)
+		Expect(code).To(Equal(http.StatusGone))
+		Expect(body["status"]).To(Equal(secretmessage.SecretRetiredRead))
+		Expect(body).NotTo(HaveKey("text"))
+
+		page := doHttpRequest(router, nil, nil, "GET", link.Path)
+		Expect(page.Body.String()).To(ContainSubstring("Already read"))
+		Expect(page.Body.String()).NotTo(ContainSubstring("Reveal secret"))
+
+		var e secretmessage.AuditEvent
+		Expect(gdb.Where("action = ?", secretmessage.AuditSecretRead).Take(&e).Error).To(BeNil())
+		Expect(e.ActorID).To(Equal("web_link"))
+	})
+
+	It("should not reveal the secret with the wrong key", func() {
+		code, body := reveal("not-the-key")
+		Expect(code).To(Equal(http.StatusNotFound))
+		Expect(body["status"]).To(Equal("not_found"))
+	})
+
+	It("should show expired secrets as expired", func() {
+		gdb.Model(&secretmessage.Secret{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Minute))
+
+		page := doHttpRequest(router, nil, nil, "GET", link.Path)
+		Expect(page.Body.String()).To(ContainSubstring("Secret expired"))
+
+		code, body := reveal(link.Fragment)
+		Expect(code).To(Equal(http.StatusGone))
+		Expect(body["status"]).To(Equal(secretmessage.SecretRetiredExpired))
+	})
+
+	It("should not reveal secrets sent as Slack messages", func() {
+		gdb.Model(&secretmessage.Secret{}).Where("1 = 1").Update("web_link", false)
+		code, _ := reveal(link.Fragment)
+		Expect(code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,388 @@
+package secretmessage_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jarcoal/httpmock"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage/actions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var _ = Describe("team settings", func() {
+	teamID := "T1234ABCD"
+	userID := "U1234ABCD"
+	responseURL := "https://fake-webhooks.fakeslack.com/response_url_1"
+	var gdb *gorm.DB
+	var err error
+	var ctl *secretmessage.PublicController
+	var router *gin.Engine
+	var serverResponse *httptest.ResponseRecorder
+
+	usersInfoResponder := func(admin bool) httpmock.Responder {
+		return func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"ok":   true,
+				"user": map[string]interface{}{"id": userID, "is_admin": admin},
+			})
+		}
+	}
+
+	BeforeEach(func() {
+		httpmock.Activate()
+		gdb, err = gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname=handle_settings"), &gorm.Config{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		gdb.AutoMigrate(secretmessage.Team{})
+		gdb.AutoMigrate(secretmessage.Secret{})
+		gdb.AutoMigrate(secretmessage.TeamSettings{})
+		gdb.AutoMigrate(secretmessage.AuditEvent{})
+		gdb.AutoMigrate(secretmessage.APIToken{})
+		gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: "xoxb-1234"})
+		ctl = secretmessage.NewController(
+			secretmessage.Config{SkipSignatureValidation: true},
+			gdb,
+			nil,
+		)
+		router = ctl.ConfigureRoutes()
+	})
+	AfterEach(func() {
+		httpmock.DeactivateAndReset()
+		db, _ := gdb.DB()
+		db.Close()
+	})
+
+	Describe("/secret settings", func() {
+		JustBeforeEach(func() {
+			requestBody := url.Values{
+				"command":      []string{"/secret"},
+				"text":         []string{"settings"},
+				"team_id":      []string{teamID},
+				"user_id":      []string{userID},
+				"user_name":    []string{"imafish"},
+				"response_url": []string{responseURL},
+				"trigger_id":   []string{"0000000000.1111111111.222222222222aaaaaaaaaaaaaa"},
+			}
+			serverResponse = doHttpRequest(router, strings.NewReader(requestBody.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "POST", "/slash")
+			// Wait for the acknowledged command to be processed
+			Expect(ctl.Close(context.Background())).To(Succeed())
+		})
+
+		Context("as a workspace admin", func() {
+			var view slack.ModalViewRequest
+			BeforeEach(func() {
+				httpmock.RegisterResponder("POST", "https://slack.com/api/users.info", usersInfoResponder(true))
+				httpmock.RegisterResponder("POST", "https://slack.com/api/views.open", func(req *http.Request) (*http.Response, error) {
+					var body struct {
+						View slack.ModalViewRequest `json:"view"`
+					}
+					json.NewDecoder(req.Body).Decode(&body)
+					view = body.View
+					return httpmock.NewStringResponse(200, `{"ok": true}`), nil
+				})
+			})
+			It("should open the settings modal", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				Expect(httpmock.GetCallCountInfo()["POST https://slack.com/api/views.open"]).To(Equal(1))
+				Expect(view.CallbackID).To(Equal(actions.TeamSettingsModal))
+			})
+			Context("with a webhook", func() {
+				BeforeEach(func() {
+					settings := secretmessage.DefaultTeamSettings(teamID)
+					settings.WebhookURL = "https://siem.example.com/hook"
+					settings.WebhookSecret = "whsec_ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+					gdb.Create(&settings)
+				})
+				It("should only show the end of the signing secret", func() {
+					b, _ := json.Marshal(view)
+					Expect(string(b)).NotTo(ContainSubstring("whsec_ABCDEFGHIJKLMNOPQRSTUVWXYZ"))
+					Expect(string(b)).To(ContainSubstring("Deliveries are signed with whsec_••••WXYZ"))
+					Expect(string(b)).To(ContainSubstring("webhook_rotate_input"))
+				})
+			})
+		})
+
+		Context("as a regular member", func() {
+			var sent []slack.Message
+			BeforeEach(func() {
+				sent = nil
+				httpmock.RegisterResponder("POST", "https://slack.com/api/users.info", usersInfoResponder(false))
+				httpmock.RegisterResponder("POST", responseURL, recordMessages(&sent))
+			})
+			It("should refuse with an ephemeral message", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				Expect(sent).To(HaveLen(1))
+				Expect(sent[0].ResponseType).To(Equal(slack.ResponseTypeEphemeral))
+				Expect(sent[0].Attachments[0].Text).To(MatchRegexp(`Only workspace admins`))
+				Expect(httpmock.GetCallCountInfo()["POST https://slack.com/api/views.open"]).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("settings modal submission", func() {
+		var maxLength string
+		var webhookURL string
+		var templates string
+		var rotate []slack.OptionBlockObject
+		BeforeEach(func() {
+			webhookURL = ""
+			templates = ""
+			rotate = nil
+		})
+		JustBeforeEach(func() {
+			interactionPayload := slack.InteractionCallback{
+				Type: slack.InteractionTypeViewSubmission,
+				Team: slack.Team{ID: teamID},
+				User: slack.User{ID: userID},
+				View: slack.View{
+					CallbackID: actions.TeamSettingsModal,
+					State: &slack.ViewState{
+						Values: map[string]map[string]slack.BlockAction{
+							"default_expiry_days_input": {"default_expiry_days_input": {Value: "3"}},
+							"max_expiry_days_input":     {"max_expiry_days_input": {Value: "14"}},
+							"max_secret_length_input":   {"max_secret_length_input": {Value: maxLength}},
+							"max_file_size_mb_input":    {"max_file_size_mb_input": {Value: "10"}},
+							"options_input":             {"options_input": {}},
+							"webhook_url_input":         {"webhook_url_input": {Value: webhookURL}},
+							"webhook_rotate_input":      {"webhook_rotate_input": {SelectedOptions: rotate}},
+							"templates_input":           {"templates_input": {Value: templates}},
+						},
+					},
+				},
+			}
+			interactionBytes, _ := json.Marshal(interactionPayload)
+			requestBody := url.Values{"payload": []string{string(interactionBytes)}}
+			serverResponse = doHttpRequest(router, strings.NewReader(requestBody.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "POST", "/interactive")
+		})
+
+		Context("with valid values from an admin", func() {
+			BeforeEach(func() {
+				maxLength = "500"
+				httpmock.RegisterResponder("POST", "https://slack.com/api/users.info", usersInfoResponder(true))
+			})
+			It("should store the settings", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				var settings secretmessage.TeamSettings
+				Expect(gdb.Where("team_id = ?", teamID).First(&settings).Error).To(BeNil())
+				Expect(settings.DefaultExpiryDays).To(Equal(3))
+				Expect(settings.MaxExpiryDays).To(Equal(14))
+				Expect(settings.MaxSecretLength).To(Equal(500))
+				Expect(settings.MaxFileSizeMB).To(Equal(10))
+				Expect(settings.AllowInlineSecret).To(BeFalse())
+			})
+		})
+
+		Context("with an invalid value", func() {
+			BeforeEach(func() {
+				maxLength = "999999"
+				httpmock.RegisterResponder("POST", "https://slack.com/api/users.info", usersInfoResponder(true))
+			})
+			It("should return a view submission error for the field", func() {
+				var res slack.ViewSubmissionResponse
+				b, _ := ioutil.ReadAll(serverResponse.Body)
+				json.Unmarshal(b, &res)
+				Expect(res.ResponseAction).To(Equal(slack.RAErrors))
+				Expect(res.Errors).To(HaveKey("max_secret_length_input"))
+				var count int64
+				gdb.Model(&secretmessage.TeamSettings{}).Count(&count)
+				Expect(count).To(BeZero())
+			})
+		})
+
+		Context("with templates", func() {
+			BeforeEach(func() {
+				maxLength = "500"
+				templates = "VPN access: User, Password, OTP seed\n\nDatabase: Host, Password"
+				httpmock.RegisterResponder("POST", "https://slack.com/api/users.info", usersInfoResponder(true))
+			})
+			It("should store the templates", func() {
+				Expect(serverResponse.Code).To(Equal(http.StatusOK))
+				var settings secretmessage.TeamSettings
+				Expect(gdb.Where("team_id = ?", teamID).First(&settings).Error).To(BeNil())
+				Expect(settings.Templates).To(Equal(secretmessage.SecretTemplates{
+					{Name: "VPN access", Fields: []string{"User", "Password", "OTP seed"}},
+					{Name: "Database", Fields: []string{"Host", "Password"}},
+				}))
+			})
+			Context("replacing existing ones", func() {
+				BeforeEach(func() {
+					existing := secretmessage.DefaultTeamSettings(teamID)
+					existing.Templates = secretmessage.SecretTemplates{{Name: "Old", Fields: []string{"Secret"}}}
+					Expect(gdb.Create(&existing).Error).To(BeNil())
+				})
+				It("should store the new templates", func() {
+					var settings secretmessage.TeamSettings
+					Expect(gdb.Where("team_id = ?", teamID).First(&settings).Error).To(BeNil())
+					Expect(settings.Templates).To(HaveLen(2))
+					Expect(settings.Templates[0].Name).To(Equal("VPN access"))
+				})
+			})
+		})
+
+		Context("with a template without fields", func() {
+			BeforeEach(func() {
+				maxLength = "500"
+				templates = "VPN access"
+				httpmock.RegisterResponder("POST", "https://slack.com/api/users.info", usersInfoResponder(true))
+			})
+			It("should return a view submission error for the templates", func() {
+				var res slack.ViewSubmissionResponse
+				b, _ := ioutil.ReadAll(serverResponse.Body)
+				json.Unmarshal(b, &res)
+				Expect(res.ResponseAction).To(Equal(slack.RAErrors))
+				Expect(res.Errors).To(HaveKey("templates_input"))
+			})
+		})
+
+		Context("with a new webhook URL", func() {
+			BeforeEach(func() {
+				maxLength = "500"
+				webhookURL = "https://siem.example.com/hook"
+				httpmock.RegisterResponder("POST", "https://slack.com/api/users.info", usersInfoResponder(true))
+			})
+			It("should generate a signing secret and show it once", func() {
+				var settings secretmessage.TeamSettings
+				Expect(gdb.Where("team_id = ?", teamID).First(&settings).Error).To(BeNil())
+				Expect(settings.WebhookURL).To(Equal(webhookURL))
+				Expect(settings.WebhookSecret).To(HavePrefix("whsec_"))
+
+				var res slack.ViewSubmissionResponse
+				b, _ := ioutil.ReadAll(serverResponse.Body)
+				json.Unmarshal(b, &res)
+				Expect(res.ResponseAction).To(Equal(slack.RAUpdate))
+				Expect(string(b)).To(ContainSubstring(settings.WebhookSecret))
+			})
+			Context("that already has a signing secret", func() {
+				BeforeEach(func() {
+					existing := secretmessage.DefaultTeamSettings(teamID)
+					existing.WebhookURL = webhookURL
+					existing.WebhookSecret = "whsec_old"
+					Expect(gdb.Create(&existing).Error).To(BeNil())
+				})
+				It("should keep the secret without showing it", func() {
+					var settings secretmessage.TeamSettings
+					Expect(gdb.Where("team_id = ?", teamID).First(&settings).Error).To(BeNil())
+					Expect(settings.WebhookSecret).To(Equal("whsec_old"))
+					b, _ := ioutil.ReadAll(serverResponse.Body)
+					Expect(string(b)).NotTo(ContainSubstring("whsec_"))
+				})
+				Context("and a rotation", func() {
+					BeforeEach(func() {
+						rotate = []slack.OptionBlockObject{{Value: "rotate_webhook_secret"}}
+					})
+					It("should make a new secret and show it once", func() {
+						var settings secretmessage.TeamSettings
+						Expect(gdb.Where("team_id = ?", teamID).First(&settings).Error).To(BeNil())
+						Expect(settings.WebhookSecret).To(HavePrefix("whsec_"))
+						Expect(settings.WebhookSecret).NotTo(Equal("whsec_old"))
+						b, _ := ioutil.ReadAll(serverResponse.Body)
+						Expect(string(b)).To(ContainSubstring(settings.WebhookSecret))
+					})
+				})
+			})
+		})
+
+		Context("with a plain http webhook URL", func() {
+			BeforeEach(func() {
+				maxLength = "500"
+				webhookURL = "http://10.0.0.1/hook"
+				httpmock.RegisterResponder("POST", "https://slack.com/api/users.info", usersInfoResponder(true))
+			})
+			It("should return a view submission error for the field", func() {
+				var res slack.ViewSubmissionResponse
+				b, _ := ioutil.ReadAll(serverResponse.Body)
+				json.Unmarshal(b, &res)
+				Expect(res.ResponseAction).To(Equal(slack.RAErrors))
+				Expect(res.Errors).To(HaveKey("webhook_url_input"))
+			})
+		})
+
+		Context("from a regular member", func() {
+			BeforeEach(func() {
+				maxLength = "500"
+				httpmock.RegisterResponder("POST", "https://slack.com/api/users.info", usersInfoResponder(false))
+			})
+			It("should not store the settings", func() {
+				var res slack.ViewSubmissionResponse
+				b, _ := ioutil.ReadAll(serverResponse.Body)
+				json.Unmarshal(b, &res)
+				Expect(res.ResponseAction).To(Equal(slack.RAErrors))
+				var count int64
+				gdb.Model(&secretmessage.TeamSettings{}).Count(&count)
+				Expect(count).To(BeZero())
+			})
+		})
+	})
+
+	Describe("inline secrets disabled", func() {
+		var sent []slack.Message
+		BeforeEach(func() {
+			sent = nil
+			httpmock.RegisterResponder("POST", responseURL, recordMessages(&sent))
+			settings := secretmessage.DefaultTeamSettings(teamID)
+			settings.AllowInlineSecret = false
+			gdb.Create(&settings)
+			requestBody := url.Values{
+				"command":      []string{"/secret"},
+				"text":         []string{"this is my secret"},
+				"team_id":      []string{teamID},
+				"user_id":      []string{userID},
+				"user_name":    []string{"imafish"},
+				"response_url": []string{responseURL},
+			}
+			serverResponse = doHttpRequest(router, strings.NewReader(requestBody.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "POST", "/slash")
+			// Wait for the acknowledged command to be processed
+			Expect(ctl.Close(context.Background())).To(Succeed())
+		})
+		It("should refuse the inline secret", func() {
+			Expect(sent).To(HaveLen(1))
+			Expect(sent[0].Attachments[0].Title).To(MatchRegexp(`Inline secrets are disabled`))
+			var count int64
+			gdb.Model(&secretmessage.Secret{}).Count(&count)
+			Expect(count).To(BeZero())
+		})
+	})
+
+	Describe("reading a secret sent to specific people", func() {
+		secretID := "monkey"
+		secretIDHashed := "000c285457fc971f862a79b786476c78812c8897063c6fa9c045f579a3b2d63f"
+		encryptedPayload := "30303030303030303030303029c9922a9be75ba2e6be5afd32d19387baea51fa577c0c51dc9809a54adb9085490f109237d15a3262a585"
+
+		BeforeEach(func() {
+			gdb.Create(&secretmessage.Secret{ID: secretIDHashed, Value: encryptedPayload, ExpiresAt: time.Now().Add(time.Hour), Recipients: []string{"U9999"}})
+			interactionPayload := slack.InteractionCallback{
+				CallbackID: actions.ReadMessage + ":" + secretID,
+				Team:       slack.Team{ID: teamID},
+				User:       slack.User{ID: userID},
+			}
+			interactionBytes, _ := json.Marshal(interactionPayload)
+			requestBody := url.Values{"payload": []string{string(interactionBytes)}}
+			serverResponse = doHttpRequest(router, strings.NewReader(requestBody.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "POST", "/interactive")
+		})
+		It("should refuse someone who isn't a recipient", func() {
+			var msg slack.Message
+			b, _ := ioutil.ReadAll(serverResponse.Body)
+			json.Unmarshal(b, &msg)
+			Expect(msg.Attachments[0].Text).To(MatchRegexp(`can only be read by them`))
+			Expect(msg.DeleteOriginal).To(BeFalse())
+		})
+		It("should keep the secret", func() {
+			var s secretmessage.Secret
+			Expect(gdb.Take(&s).RowsAffected).To(BeEquivalentTo(1))
+		})
+	})
+})
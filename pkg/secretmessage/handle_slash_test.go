@@ -1,6 +1,7 @@
 package secretmessage_test
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"log"
@@ -54,6 +55,9 @@ var _ = Describe("/secret", func() {
 		}
 		gdb.AutoMigrate(secretmessage.Team{})
 		gdb.AutoMigrate(secretmessage.Secret{})
+		gdb.AutoMigrate(secretmessage.TeamSettings{})
+		gdb.AutoMigrate(secretmessage.AuditEvent{})
+		gdb.AutoMigrate(secretmessage.APIToken{})
 		ctl = secretmessage.NewController(
 			secretmessage.Config{SkipSignatureValidation: true},
 			gdb,
@@ -63,6 +67,8 @@ var _ = Describe("/secret", func() {
 	JustBeforeEach(func() {
 		router = ctl.ConfigureRoutes()
 		serverResponse = doHttpRequest(router, strings.NewReader(requestBody.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "POST", "/slash")
+		// Wait for the acknowledged command to be processed
+		Expect(ctl.Close(context.Background())).To(Succeed())
 	})
 
 	AfterEach(func() {
@@ -72,8 +78,10 @@ var _ = Describe("/secret", func() {
 	})
 
 	Context("on happy path with team and access token present in DB", func() {
+		var sent []slack.Message
 		BeforeEach(func() {
-			httpmock.RegisterResponder("POST", responseURL, httpmock.NewStringResponder(200, `ok`))
+			sent = nil
+			httpmock.RegisterResponder("POST", responseURL, recordMessages(&sent))
 			tx := gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: accessToken})
 			Expect(tx.Error).To(BeNil())
 			var s secretmessage.Secret
@@ -90,12 +98,25 @@ var _ = Describe("/secret", func() {
 		It("should POST to Slack at responseURL exactly once", func() {
 			Expect(httpmock.GetTotalCallCount()).To(Equal(1))
 		})
+		It("should attribute the secret to the sender by mention rather than username", func() {
+			Expect(sent).To(HaveLen(1))
+			Expect(sent[0].Attachments[0].Text).To(HavePrefix("*<@U1234ABCD> sent a secret message*"))
+			Expect(sent[0].Attachments[0].Fallback).NotTo(ContainSubstring("imafish"))
+		})
 		It("should store the message", func() {
 			var s secretmessage.Secret
 			gdb.Take(&s)
 			Expect(s.ID).To(MatchRegexp(`^[a-f0-9]{64}$`))
 			Expect(s.Value).To(MatchRegexp(`^[a-f0-9]{1,}$`))
 		})
+		It("should record who created the secret in the audit log", func() {
+			var e secretmessage.AuditEvent
+			Expect(gdb.Take(&e).Error).To(BeNil())
+			Expect(e.Action).To(Equal(secretmessage.AuditSecretCreated))
+			Expect(e.TeamID).To(Equal(teamID))
+			Expect(e.ActorID).To(Equal("U1234ABCD"))
+			Expect(e.ChannelID).To(Equal("C1234ABCD"))
+		})
 	})
 
 	Context("on happy path with team not in DB", func() {
@@ -116,17 +137,49 @@ var _ = Describe("/secret", func() {
 		})
 	})
 
+	Context("on happy path with only an org-wide Enterprise Grid install in DB", func() {
+		BeforeEach(func() {
+			httpmock.RegisterResponder("POST", responseURL, httpmock.NewStringResponder(200, `ok`))
+			tx := gdb.Create(&secretmessage.Team{ID: "E0001", EnterpriseID: "E0001", IsEnterpriseInstall: true, InstallType: secretmessage.InstallTypeEnterprise, AccessToken: "xoxb-org"})
+			Expect(tx.Error).To(BeNil())
+		})
+		It("should respond with 200", func() {
+			Expect(serverResponse.Code).To(Equal(http.StatusOK))
+		})
+		It("should not ask for a reinstall", func() {
+			Expect(httpmock.GetTotalCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("on empty text given with only an org-wide Enterprise Grid install in DB", func() {
+		var authorization string
+		BeforeEach(func() {
+			requestBody.Set("text", "")
+			gdb.Create(&secretmessage.Team{ID: "E0001", EnterpriseID: "E0001", IsEnterpriseInstall: true, InstallType: secretmessage.InstallTypeEnterprise, AccessToken: "xoxb-org"})
+			httpmock.RegisterResponder("POST", "https://slack.com/api/views.open", func(req *http.Request) (*http.Response, error) {
+				authorization = req.Header.Get("Authorization")
+				return httpmock.NewStringResponse(200, `{"ok": true}`), nil
+			})
+		})
+		It("should open the modal with the enterprise token", func() {
+			Expect(httpmock.GetTotalCallCount()).To(Equal(1))
+			Expect(authorization).To(Equal("Bearer xoxb-org"))
+		})
+	})
+
 	Context("on db error storing secret", func() {
+		var sent []slack.Message
 		BeforeEach(func() {
+			sent = nil
+			httpmock.RegisterResponder("POST", responseURL, recordMessages(&sent))
 			// Close the DB early to force an error
 			db, _ := gdb.DB()
 			db.Close()
 		})
-		It("should return a useful error message", func() {
-			var msg slack.Message
-			b, _ := ioutil.ReadAll(serverResponse.Body)
-			json.Unmarshal(b, &msg)
-			Expect(msg.Attachments[0].Text).To(MatchRegexp(`An error occurred`))
+		It("should send a useful error message to the response URL", func() {
+			Expect(sent).To(HaveLen(1))
+			Expect(sent[0].ResponseType).To(Equal(slack.ResponseTypeEphemeral))
+			Expect(sent[0].Attachments[0].Text).To(MatchRegexp(`An error occurred`))
 		})
 		It("should respond with 200", func() {
 			Expect(serverResponse.Code).To(Equal(http.StatusOK))
@@ -161,18 +214,132 @@ var _ = Describe("/secret", func() {
 			Expect(serverResponse.Code).To(Equal(http.StatusOK))
 		})
 	})
+
+	Context("on empty text given by a user whose language isn't known yet", func() {
+		var calls []string
+		BeforeEach(func() {
+			calls = nil
+			requestBody.Set("text", "")
+			gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: accessToken})
+			httpmock.RegisterResponder("POST", "https://slack.com/api/views.open", func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, "views.open")
+				return httpmock.NewStringResponse(200, `{"ok": true}`), nil
+			})
+			httpmock.RegisterResponder("POST", "https://slack.com/api/users.info", func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, "users.info")
+				return httpmock.NewStringResponse(200, `{"ok": true, "user": {"id": "U1234ABCD", "locale": "fr-FR"}}`), nil
+			})
+		})
+		It("should open the modal in the team's language without waiting to look the user up", func() {
+			Expect(calls).To(Equal([]string{"views.open"}))
+		})
+	})
+
 	Context("on error sending responseURL POST msg to slack", func() {
+		var lastResponseURLBody string
 		BeforeEach(func() {
-			httpmock.RegisterResponder("POST", responseURL, httpmock.NewStringResponder(503, `ok`))
+			httpmock.RegisterResponder("POST", responseURL, func(req *http.Request) (*http.Response, error) {
+				b, _ := ioutil.ReadAll(req.Body)
+				lastResponseURLBody = string(b)
+				return httpmock.NewStringResponse(503, `ok`), nil
+			})
 		})
-		It("should return a useful error message", func() {
+		It("should try to send a useful error message to the response URL", func() {
 			var msg slack.Message
-			b, _ := ioutil.ReadAll(serverResponse.Body)
-			json.Unmarshal(b, &msg)
+			json.Unmarshal([]byte(lastResponseURLBody), &msg)
 			Expect(msg.Attachments[0].Text).To(MatchRegexp(`An error occurred`))
 		})
 		It("should respond with 200", func() {
 			Expect(serverResponse.Code).To(Equal(http.StatusOK))
 		})
 	})
+
+	Context("on text that only starts with a subcommand's name", func() {
+		var sent []slack.Message
+		BeforeEach(func() {
+			sent = nil
+			requestBody.Set("text", "split the bill: 4111 1111 1111 1111")
+			httpmock.RegisterResponder("POST", responseURL, recordMessages(&sent))
+			gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: accessToken})
+		})
+		It("should send the text as a secret", func() {
+			Expect(sent).To(HaveLen(1))
+			Expect(sent[0].ResponseType).To(Equal(slack.ResponseTypeInChannel))
+			var count int64
+			gdb.Model(&secretmessage.Secret{}).Count(&count)
+			Expect(count).To(BeEquivalentTo(1))
+		})
+	})
+
+	Context("on generate", func() {
+		var sent []slack.Message
+		BeforeEach(func() {
+			sent = nil
+			requestBody.Set("text", "generate 32 lower digits")
+			httpmock.RegisterResponder("POST", responseURL, recordMessages(&sent))
+			gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: accessToken})
+		})
+		It("should send the generated password as a secret and a copy only the sender sees", func() {
+			Expect(sent).To(HaveLen(2))
+			Expect(sent[0].ResponseType).To(Equal(slack.ResponseTypeInChannel))
+			Expect(sent[0].Attachments[0].Text).To(HavePrefix("*<@U1234ABCD> sent a secret message*"))
+			Expect(sent[1].ResponseType).To(Equal(slack.ResponseTypeEphemeral))
+			Expect(sent[1].Attachments[0].Title).To(Equal("Your generated password"))
+			Expect(sent[1].Attachments[0].Text).To(MatchRegexp("^```[a-z0-9]{32}```$"))
+			var count int64
+			gdb.Model(&secretmessage.Secret{}).Count(&count)
+			Expect(count).To(BeEquivalentTo(1))
+		})
+
+		Context("a passphrase", func() {
+			BeforeEach(func() {
+				requestBody.Set("text", "generate passphrase 7")
+			})
+			It("should send a copy of the passphrase", func() {
+				Expect(sent).To(HaveLen(2))
+				Expect(sent[1].Attachments[0].Title).To(Equal("Your generated passphrase"))
+				Expect(sent[1].Attachments[0].Text).To(MatchRegexp("^```[a-z]+(-[a-z]+){6}```$"))
+			})
+		})
+
+		Context("with an unknown option", func() {
+			BeforeEach(func() {
+				requestBody.Set("text", "generate 32 emoji")
+			})
+			It("should explain how to use the command and send nothing", func() {
+				Expect(sent).To(HaveLen(1))
+				Expect(sent[0].ResponseType).To(Equal(slack.ResponseTypeEphemeral))
+				Expect(sent[0].Attachments[0].Text).To(HavePrefix("Use /secret generate"))
+				var count int64
+				gdb.Model(&secretmessage.Secret{}).Count(&count)
+				Expect(count).To(BeZero())
+			})
+		})
+
+		Context("with text that was meant as an inline secret", func() {
+			BeforeEach(func() {
+				requestBody.Set("text", "generate the report with key 8f3kd92")
+			})
+			It("should not send the text, and point the sender to the form", func() {
+				Expect(sent).To(HaveLen(1))
+				Expect(sent[0].ResponseType).To(Equal(slack.ResponseTypeEphemeral))
+				Expect(sent[0].Attachments[0].Text).To(ContainSubstring("run /secret on its own and type it in the form"))
+				var count int64
+				gdb.Model(&secretmessage.Secret{}).Count(&count)
+				Expect(count).To(BeZero())
+			})
+		})
+
+		Context("in a workspace that requires recipients", func() {
+			BeforeEach(func() {
+				settings := secretmessage.DefaultTeamSettings(teamID)
+				settings.RequireRecipients = true
+				gdb.Create(&settings)
+			})
+			It("should point the sender to the form", func() {
+				Expect(sent).To(HaveLen(1))
+				Expect(sent[0].Attachments[0].Text).To(ContainSubstring("Run /secret on its own"))
+			})
+		})
+	})
 })
@@ -0,0 +1,258 @@
+package secretmessage_test
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jarcoal/httpmock"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage/actions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var _ = Describe("split secrets", func() {
+	teamID := "T1234ABCD"
+	senderID := "U0SENDER"
+	holders := []string{"U0000001", "U0000002", "U0000003"}
+	sharePattern := regexp.MustCompile(`smshare-[A-Za-z0-9_-]+`)
+	var gdb *gorm.DB
+	var err error
+	var ctl *secretmessage.PublicController
+	var router *gin.Engine
+	var posted []url.Values
+	var failDMTo string
+
+	BeforeEach(func() {
+		posted = nil
+		failDMTo = ""
+		httpmock.Activate()
+		httpmock.RegisterResponder("POST", "https://slack.com/api/chat.postMessage", func(req *http.Request) (*http.Response, error) {
+			req.ParseForm()
+			if req.PostForm.Get("channel") == failDMTo {
+				return httpmock.NewStringResponse(200, `{"ok": false, "error": "user_not_found"}`), nil
+			}
+			posted = append(posted, req.PostForm)
+			return httpmock.NewStringResponse(200, `{"ok": true, "channel": "D0001", "ts": "1700000000.000100"}`), nil
+		})
+
+		gdb, err = gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname=handle_split"), &gorm.Config{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		gdb.AutoMigrate(secretmessage.Team{})
+		gdb.AutoMigrate(secretmessage.Secret{})
+		gdb.AutoMigrate(secretmessage.TeamSettings{})
+		gdb.AutoMigrate(secretmessage.AuditEvent{})
+		gdb.AutoMigrate(secretmessage.SecretShare{})
+		gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: "xoxb-1234"})
+		ctl = secretmessage.NewController(
+			secretmessage.Config{SkipSignatureValidation: true},
+			gdb,
+			nil,
+		)
+		router = ctl.ConfigureRoutes()
+	})
+	AfterEach(func() {
+		httpmock.DeactivateAndReset()
+		db, _ := gdb.DB()
+		db.Close()
+	})
+
+	interact := func(i slack.InteractionCallback) *httptest.ResponseRecorder {
+		i.Team = slack.Team{ID: teamID}
+		interactionBytes, _ := json.Marshal(i)
+		requestBody := url.Values{"payload": []string{string(interactionBytes)}}
+		return doHttpRequest(router, strings.NewReader(requestBody.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "POST", "/interactive")
+	}
+	split := func(text string, recipients []string, threshold string) *httptest.ResponseRecorder {
+		return interact(slack.InteractionCallback{
+			Type: slack.InteractionTypeViewSubmission,
+			User: slack.User{ID: senderID},
+			View: slack.View{
+				CallbackID: actions.SplitSecretModal,
+				State: &slack.ViewState{Values: map[string]map[string]slack.BlockAction{
+					"secret_text_input": {"secret_text_input": {Value: text}},
+					"recipients_input":  {"recipients_input": {SelectedUsers: recipients}},
+					"threshold_input":   {"threshold_input": {Value: threshold}},
+					"expiry_date_input": {"expiry_date_input": {SelectedDate: time.Now().AddDate(0, 0, 1).Format("2006-01-02")}},
+				}},
+			},
+		})
+	}
+	submit := func(userID string, secretID string, share string) *httptest.ResponseRecorder {
+		return interact(slack.InteractionCallback{
+			Type: slack.InteractionTypeViewSubmission,
+			User: slack.User{ID: userID},
+			View: slack.View{
+				CallbackID:      actions.SubmitShareModal,
+				PrivateMetadata: secretID,
+				State: &slack.ViewState{Values: map[string]map[string]slack.BlockAction{
+					"share_input": {"share_input": {Value: share}},
+				}},
+			},
+		})
+	}
+
+	Describe("splitting a secret", func() {
+		It("should DM each share holder their own share and only keep hashes of the shares", func() {
+			res := split("root password hunter2", holders, "2")
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(ContainSubstring(`"response_action":"update"`))
+
+			Expect(posted).To(HaveLen(3))
+			shares := map[string]bool{}
+			for n, p := range posted {
+				Expect(p.Get("channel")).To(Equal(holders[n]))
+				Expect(p.Get("blocks")).To(ContainSubstring(actions.SubmitShare))
+				share := sharePattern.FindString(p.Get("blocks"))
+				Expect(share).NotTo(BeEmpty())
+				shares[share] = true
+			}
+			Expect(shares).To(HaveLen(3))
+
+			var s secretmessage.Secret
+			Expect(gdb.Take(&s).Error).To(BeNil())
+			Expect(s.Threshold).To(Equal(2))
+			Expect(s.Recipients).To(Equal(holders))
+			Expect(s.SenderID).To(Equal(senderID))
+			Expect(s.ShareHashes).To(HaveLen(3))
+			for share := range shares {
+				Expect(s.Value).NotTo(ContainSubstring(share))
+				Expect(s.ShareHashes).NotTo(ContainElement(share))
+			}
+		})
+
+		It("should refuse a threshold above the number of share holders", func() {
+			res := split("hunter2", holders, "4")
+			Expect(res.Body.String()).To(ContainSubstring(`"response_action":"errors"`))
+			Expect(res.Body.String()).To(ContainSubstring("threshold_input"))
+			Expect(posted).To(BeEmpty())
+		})
+
+		It("should refuse a single share holder", func() {
+			res := split("hunter2", holders[:1], "2")
+			Expect(res.Body.String()).To(ContainSubstring("recipients_input"))
+			Expect(posted).To(BeEmpty())
+		})
+
+		It("should discard the secret when a share can't be delivered", func() {
+			failDMTo = holders[2]
+			res := split("hunter2", holders, "2")
+			Expect(res.Body.String()).To(ContainSubstring(`"response_action":"errors"`))
+			var s secretmessage.Secret
+			Expect(gdb.Unscoped().Take(&s).Error).To(BeNil())
+			Expect(s.RetiredReason).To(Equal(secretmessage.SecretRetiredRevoked))
+			Expect(s.Value).To(BeEmpty())
+		})
+	})
+
+	Describe("submitting shares", func() {
+		var secretID string
+		var shares []string
+
+		BeforeEach(func() {
+			res := split("root password hunter2", holders, "2")
+			Expect(res.Code).To(Equal(http.StatusOK))
+			shares = nil
+			for _, p := range posted {
+				shares = append(shares, sharePattern.FindString(p.Get("blocks")))
+			}
+			var s secretmessage.Secret
+			Expect(gdb.Take(&s).Error).To(BeNil())
+			secretID = s.ID
+			posted = nil
+		})
+
+		It("should reveal the secret once enough shares are submitted", func() {
+			res := submit(holders[0], secretID, shares[0])
+			Expect(res.Body.String()).To(ContainSubstring("1 of 2 shares"))
+			Expect(res.Body.String()).NotTo(ContainSubstring("hunter2"))
+			var stored secretmessage.SecretShare
+			Expect(gdb.Take(&stored).Error).To(BeNil())
+			Expect(stored.UserID).To(Equal(holders[0]))
+
+			res = submit(holders[2], secretID, " "+shares[2]+"\n")
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(ContainSubstring("root password hunter2"))
+
+			var s secretmessage.Secret
+			Expect(gdb.Unscoped().Take(&s).Error).To(BeNil())
+			Expect(s.RetiredReason).To(Equal(secretmessage.SecretRetiredRead))
+			Expect(s.ReadBy).To(Equal(holders[2]))
+			Expect(s.Value).To(BeEmpty())
+			var left int64
+			gdb.Model(&secretmessage.SecretShare{}).Count(&left)
+			Expect(left).To(BeEquivalentTo(0))
+
+			Expect(posted).To(HaveLen(1))
+			Expect(posted[0].Get("channel")).To(Equal(senderID))
+
+			var actions []string
+			gdb.Model(&secretmessage.AuditEvent{}).Order("seq").Pluck("action", &actions)
+			Expect(actions).To(Equal([]string{
+				secretmessage.AuditSecretCreated,
+				secretmessage.AuditShareSubmitted,
+				secretmessage.AuditShareSubmitted,
+				secretmessage.AuditSecretRead,
+			}))
+
+			res = submit(holders[1], secretID, shares[1])
+			Expect(res.Body.String()).To(ContainSubstring("already revealed"))
+		})
+
+		It("should refuse someone else's share", func() {
+			res := submit(holders[0], secretID, shares[1])
+			Expect(res.Body.String()).To(ContainSubstring(`"response_action":"errors"`))
+			Expect(res.Body.String()).To(ContainSubstring("isn't your share"))
+		})
+
+		It("should refuse people the secret wasn't split with", func() {
+			res := submit("U0OUTSIDER", secretID, shares[0])
+			Expect(res.Body.String()).To(ContainSubstring("wasn't split with you"))
+		})
+
+		It("should only count each share holder once", func() {
+			submit(holders[0], secretID, shares[0])
+			res := submit(holders[0], secretID, shares[0])
+			Expect(res.Body.String()).To(ContainSubstring("already submitted"))
+			var s secretmessage.Secret
+			Expect(gdb.Take(&s).Error).To(BeNil())
+		})
+
+		It("should refuse shares after the secret expired, and delete the submitted ones", func() {
+			submit(holders[0], secretID, shares[0])
+			gdb.Model(&secretmessage.Secret{}).Where("id = ?", secretID).Update("expires_at", time.Now().Add(-time.Minute))
+			res := submit(holders[1], secretID, shares[1])
+			Expect(res.Body.String()).To(ContainSubstring("has expired"))
+			var s secretmessage.Secret
+			Expect(gdb.Unscoped().Take(&s).Error).To(BeNil())
+			Expect(s.RetiredReason).To(Equal(secretmessage.SecretRetiredExpired))
+			var left int64
+			gdb.Model(&secretmessage.SecretShare{}).Count(&left)
+			Expect(left).To(BeEquivalentTo(0))
+		})
+
+		It("should delete the submitted shares when the expiry sweep retires the secret", func() {
+			submit(holders[0], secretID, shares[0])
+			gdb.Model(&secretmessage.Secret{}).Where("id = ?", secretID).Update("expires_at", time.Now().Add(-time.Minute))
+			retired, err := ctl.RetireExpiredSecrets(context.Background())
+			Expect(err).To(BeNil())
+			Expect(retired).To(Equal(1))
+			var left int64
+			gdb.Model(&secretmessage.SecretShare{}).Count(&left)
+			Expect(left).To(BeEquivalentTo(0))
+		})
+	})
+})
@@ -0,0 +1,138 @@
+package secretmessage_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretwebhook"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var _ = Describe("webhooks", func() {
+	teamID := "T1234ABCD"
+	responseURL := "https://fake-webhooks.fakeslack.com/response_url_1"
+	webhookSecret := "whsec_test"
+	var gdb *gorm.DB
+	var err error
+	var ctl *secretmessage.PublicController
+	var receiver *httptest.Server
+	var mux sync.Mutex
+	var received []secretmessage.WebhookEvent
+	var signaturesValid []bool
+
+	BeforeEach(func() {
+		received = nil
+		signaturesValid = nil
+		receiver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var e secretmessage.WebhookEvent
+			json.Unmarshal(body, &e)
+			mux.Lock()
+			defer mux.Unlock()
+			received = append(received, e)
+			signaturesValid = append(signaturesValid, secretwebhook.Verify(webhookSecret, r.Header.Get(secretwebhook.TimestampHeader), body, r.Header.Get(secretwebhook.SignatureHeader)))
+		}))
+
+		httpmock.Activate()
+		// Let webhook deliveries through to the httptest receiver
+		httpmock.RegisterNoResponder(httpmock.InitialTransport.RoundTrip)
+		httpmock.RegisterResponder("POST", responseURL, httpmock.NewStringResponder(200, `ok`))
+
+		gdb, err = gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname=handle_webhooks"), &gorm.Config{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		gdb.AutoMigrate(secretmessage.Team{})
+		gdb.AutoMigrate(secretmessage.Secret{})
+		gdb.AutoMigrate(secretmessage.TeamSettings{})
+		gdb.AutoMigrate(secretmessage.AuditEvent{})
+		gdb.AutoMigrate(secretmessage.APIToken{})
+		gdb.Create(&secretmessage.Team{ID: teamID, AccessToken: "xoxb-1234"})
+		settings := secretmessage.DefaultTeamSettings(teamID)
+		settings.WebhookURL = receiver.URL
+		settings.WebhookSecret = webhookSecret
+		gdb.Create(&settings)
+		ctl = secretmessage.NewController(
+			// The receiver listens on loopback
+			secretmessage.Config{SkipSignatureValidation: true, AllowPrivateWebhooks: true},
+			gdb,
+			nil,
+		)
+	})
+	AfterEach(func() {
+		// Let queued commands and deliveries finish before the mocks they use go away
+		Expect(ctl.Close(context.Background())).To(Succeed())
+		httpmock.DeactivateAndReset()
+		receiver.Close()
+		db, _ := gdb.DB()
+		db.Close()
+	})
+
+	It("should POST a signed secret.created event without the secret's content", func() {
+		requestBody := url.Values{
+			"command":      []string{"/secret"},
+			"text":         []string{"this is my secret"},
+			"team_id":      []string{teamID},
+			"user_id":      []string{"U1234ABCD"},
+			"user_name":    []string{"imafish"},
+			"channel_id":   []string{"C1234ABCD"},
+			"response_url": []string{responseURL},
+		}
+		serverResponse := doHttpRequest(ctl.ConfigureRoutes(), strings.NewReader(requestBody.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "POST", "/slash")
+		Expect(serverResponse.Code).To(Equal(http.StatusOK))
+
+		Eventually(func() int {
+			mux.Lock()
+			defer mux.Unlock()
+			return len(received)
+		}).Should(Equal(1))
+		mux.Lock()
+		defer mux.Unlock()
+		Expect(signaturesValid).To(Equal([]bool{true}))
+		Expect(received[0].Type).To(Equal("secret.created"))
+		Expect(received[0].TeamID).To(Equal(teamID))
+		Expect(received[0].ActorID).To(Equal("U1234ABCD"))
+		Expect(received[0].ChannelID).To(Equal("C1234ABCD"))
+		Expect(received[0].SecretID).To(MatchRegexp(`^[a-f0-9]{64}$`))
+	})
+
+	It("should POST secret.expired for secrets that expire unread", func() {
+		gdb.Create(secretmessage.NewSecret("expired", "hunter2", secretmessage.WithTeamID(teamID), secretmessage.WithExpiryDate(time.Now().Add(-time.Minute))))
+		gdb.Create(secretmessage.NewSecret("unread", "hunter2", secretmessage.WithTeamID(teamID), secretmessage.WithExpiryDate(time.Now().Add(time.Hour))))
+		gdb.Create(secretmessage.NewSecret("default", "hunter2", secretmessage.WithTeamID(teamID)))
+
+		retired, err := ctl.RetireExpiredSecrets(context.Background())
+		Expect(err).To(BeNil())
+		Expect(retired).To(Equal(1))
+		var secret secretmessage.Secret
+		Expect(gdb.Unscoped().Where("id = ?", "expired").Take(&secret).Error).To(BeNil())
+		Expect(secret.Value).To(BeEmpty())
+		Expect(secret.Status()).To(Equal(secretmessage.SecretRetiredExpired))
+		var unread int64
+		gdb.Model(&secretmessage.Secret{}).Count(&unread)
+		Expect(unread).To(Equal(int64(2)))
+
+		Eventually(func() int {
+			mux.Lock()
+			defer mux.Unlock()
+			return len(received)
+		}).Should(Equal(1))
+		mux.Lock()
+		defer mux.Unlock()
+		Expect(received[0].Type).To(Equal("secret.expired"))
+		Expect(received[0].SecretID).To(Equal("expired"))
+	})
+})
@@ -2,10 +2,14 @@ package secretmessage_test
 
 import (
 	"database/sql/driver"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/slack-go/slack"
 )
 
 func doHttpRequest(r http.Handler, body io.Reader, headers map[string]string, method, path string) *httptest.ResponseRecorder {
@@ -18,6 +22,16 @@ func doHttpRequest(r http.Handler, body io.Reader, headers map[string]string, me
 	return w
 }
 
+// recordMessages responds to messages POSTed to a response URL, keeping them in sent
+func recordMessages(sent *[]slack.Message) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		var msg slack.Message
+		json.NewDecoder(req.Body).Decode(&msg)
+		*sent = append(*sent, msg)
+		return httpmock.NewStringResponse(200, `ok`), nil
+	}
+}
+
 // SQLMock Helpers
 type AnyTime struct{}
 
@@ -50,10 +64,17 @@ func (a AnySecretValue) Match(v driver.Value) bool {
 }
 
 type OauthToken struct {
-	AccessToken string `json:"access_token"`
-	Scope       string `json:"scope"`
-	Team        struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+	Scope        string `json:"scope"`
+	Team         struct {
 		Name string `json:"name"`
 		ID   string `json:"id"`
 	} `json:"team"`
+	Enterprise *struct {
+		Name string `json:"name"`
+		ID   string `json:"id"`
+	} `json:"enterprise,omitempty"`
+	IsEnterpriseInstall bool `json:"is_enterprise_install"`
 }
@@ -0,0 +1,245 @@
+package secretmessage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage/actions"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	homeUnreadLimit   = 20
+	homeActivityLimit = 10
+	homeStatsDays     = 30
+)
+
+// workspaceStats are the workspace-level counts shown to admins on the App Home tab
+type workspaceStats struct {
+	Unread       int64
+	SentRecently int64
+	ReadRecently int64
+}
+
+// EventAppHomeOpened publishes a fresh Home tab whenever a user opens it
+func EventAppHomeOpened(ctl *PublicController, c *gin.Context, e slackevents.EventsAPIEvent) error {
+	opened, ok := e.InnerEvent.Data.(*slackevents.AppHomeOpenedEvent)
+	if !ok || opened.Tab != "home" {
+		return nil
+	}
+	return publishHome(ctl, c.Request.Context(), e.TeamID, e.EnterpriseID, opened.User)
+}
+
+// CallbackRevokeSecret retires an unread secret from its sender's Home tab and refreshes the tab
+func CallbackRevokeSecret(ctl *PublicController, c *gin.Context, i slack.InteractionCallback, action *slack.BlockAction) {
+	hc := c.Request.Context()
+	// Only the sender may revoke, so the secret is looked up by its sender as well as its ID
+	revoked, err := retireSecret(
+		ctl.db.WithContext(hc).Where("team_id = ? AND sender_id = ?", i.Team.ID, i.User.ID),
+		action.Value,
+		SecretRetiredRevoked,
+		"",
+	)
+	if err != nil {
+		ctl.logger.Error("error revoking secret", zap.Error(err), zap.String("teamID", i.Team.ID), zap.String("userID", i.User.ID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+	if revoked {
+		ctl.recordSecretEvent(hc, AuditSecretRevoked, i.Team.ID, action.Value, i.User.ID, "")
+		ctl.deleteFile(hc, action.Value)
+		ctl.deleteShares(hc, action.Value)
+	} else {
+		ctl.logger.Info("secret to revoke was already retired", zap.String("teamID", i.Team.ID), zap.String("userID", i.User.ID))
+	}
+	if err := publishHome(ctl, hc, i.Team.ID, i.Enterprise.ID, i.User.ID); err != nil {
+		ctl.logger.Error("error refreshing app home after revoke", zap.Error(err), zap.String("teamID", i.Team.ID), zap.String("userID", i.User.ID))
+	}
+	c.Data(http.StatusOK, gin.MIMEPlain, nil)
+}
+
+// publishHome renders and publishes a user's Home tab. Workspace stats are only shown to admins.
+func publishHome(ctl *PublicController, ctx context.Context, teamID string, enterpriseID string, userID string) error {
+	team, err := ctl.findInstallation(ctx, teamID, enterpriseID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && team.AccessToken == "") {
+		ctl.logger.Warn("no installation to publish app home with", zap.String("teamID", teamID))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	api, err := ctl.slackClientForTeam(ctx, team)
+	if err != nil {
+		return err
+	}
+	admin, err := isWorkspaceAdmin(ctx, api, userID)
+	if err != nil {
+		ctl.logger.Warn("error checking admin for app home, hiding workspace stats", zap.Error(err), zap.String("teamID", teamID), zap.String("userID", userID))
+	}
+
+	var unread []Secret
+	err = ctl.db.WithContext(ctx).
+		Where("team_id = ? AND sender_id = ? AND expires_at > ?", teamID, userID, time.Now()).
+		Order("created_at DESC").
+		Limit(homeUnreadLimit).
+		Find(&unread).Error
+	if err != nil {
+		return err
+	}
+	var activity []Secret
+	err = ctl.db.WithContext(ctx).
+		Unscoped().
+		Where("team_id = ? AND sender_id = ? AND deleted_at IS NOT NULL", teamID, userID).
+		Order("deleted_at DESC").
+		Limit(homeActivityLimit).
+		Find(&activity).Error
+	if err != nil {
+		return err
+	}
+	var stats *workspaceStats
+	if admin {
+		stats, err = ctl.workspaceStats(ctx, teamID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = api.PublishViewContext(ctx, slack.PublishViewContextRequest{
+		UserID: userID,
+		View:   homeView(unread, activity, stats, ctl.userLocale(ctx, teamID, enterpriseID, userID)),
+	})
+	return err
+}
+
+func (ctl *PublicController) workspaceStats(ctx context.Context, teamID string) (*workspaceStats, error) {
+	var stats workspaceStats
+	since := time.Now().AddDate(0, 0, -homeStatsDays)
+	err := ctl.db.WithContext(ctx).Model(&Secret{}).
+		Where("team_id = ? AND expires_at > ?", teamID, time.Now()).
+		Count(&stats.Unread).Error
+	if err != nil {
+		return nil, err
+	}
+	err = ctl.db.WithContext(ctx).Model(&Secret{}).Unscoped().
+		Where("team_id = ? AND created_at > ?", teamID, since).
+		Count(&stats.SentRecently).Error
+	if err != nil {
+		return nil, err
+	}
+	err = ctl.db.WithContext(ctx).Model(&Secret{}).Unscoped().
+		Where("team_id = ? AND retired_reason = ? AND deleted_at > ?", teamID, SecretRetiredRead, since).
+		Count(&stats.ReadRecently).Error
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func homeView(unread []Secret, activity []Secret, stats *workspaceStats, locale string) slack.HomeTabViewRequest {
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "home.unread_header"), false, false)),
+	}
+	if len(unread) == 0 {
+		blocks = append(blocks, slack.NewSectionBlock(
+			slack.NewTextBlockObject("mrkdwn", secreti18n.T(locale, "home.no_unread"), false, false), nil, nil))
+	}
+	for _, s := range unread {
+		revoke := slack.NewButtonBlockElement(actions.RevokeSecret, s.ID, slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "home.revoke_button"), false, false)).
+			WithStyle(slack.StyleDanger).
+			WithConfirm(slack.NewConfirmationBlockObject(
+				slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "home.revoke_confirm_title"), false, false),
+				slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "home.revoke_confirm_text"), false, false),
+				slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "home.revoke_button"), false, false),
+				slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "modal.cancel"), false, false),
+			))
+		text := secreti18n.T(locale, "home.unread_item", secretDestination(s, locale), slackDate(s.CreatedAt), slackDate(s.ExpiresAt))
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", text, false, false), nil, slack.NewAccessory(revoke)))
+	}
+
+	blocks = append(blocks,
+		slack.NewDividerBlock(),
+		slack.NewHeaderBlock(slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "home.activity_header"), false, false)),
+	)
+	if len(activity) == 0 {
+		blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", secreti18n.T(locale, "home.no_activity"), false, false)))
+	}
+	for _, s := range activity {
+		blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", activityText(s, locale), false, false)))
+	}
+
+	if stats != nil {
+		blocks = append(blocks,
+			slack.NewDividerBlock(),
+			slack.NewHeaderBlock(slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "home.workspace_header"), false, false)),
+			slack.NewSectionBlock(nil, []*slack.TextBlockObject{
+				slack.NewTextBlockObject("mrkdwn", secreti18n.T(locale, "home.stats_unread", stats.Unread), false, false),
+				slack.NewTextBlockObject("mrkdwn", secreti18n.T(locale, "home.stats_sent", homeStatsDays, stats.SentRecently), false, false),
+				slack.NewTextBlockObject("mrkdwn", secreti18n.T(locale, "home.stats_read", homeStatsDays, stats.ReadRecently), false, false),
+			}, nil),
+		)
+	}
+
+	return slack.HomeTabViewRequest{
+		Type:   slack.VTHomeTab,
+		Blocks: slack.Blocks{BlockSet: blocks},
+	}
+}
+
+// secretDestination describes where a secret was sent and who may read it, in locale
+func secretDestination(s Secret, locale string) string {
+	destination := secreti18n.T(locale, "home.destination_unknown")
+	switch {
+	case s.Threshold > 0:
+		return secreti18n.T(locale, "home.destination_split", userMentions(s.Recipients), s.Threshold)
+	case s.File:
+		return secreti18n.T(locale, "home.destination_file", formatFileSize(s.FileSize))
+	case s.WebLink:
+		return secreti18n.T(locale, "home.destination_web_link")
+	case s.ChannelID != "":
+		destination = fmt.Sprintf("<#%s>", s.ChannelID)
+	}
+	if len(s.Recipients) > 0 {
+		destination = secreti18n.T(locale, "home.destination_for", destination, userMentions(s.Recipients))
+	}
+	return destination
+}
+
+// userMentions mentions each of the users, separated by commas
+func userMentions(userIDs []string) string {
+	mentions := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		mentions[i] = fmt.Sprintf("<@%s>", id)
+	}
+	return strings.Join(mentions, ", ")
+}
+
+func activityText(s Secret, locale string) string {
+	destination := secretDestination(s, locale)
+	switch s.RetiredReason {
+	case SecretRetiredRead:
+		if s.ReadBy == "" {
+			return secreti18n.T(locale, "home.activity_opened", slackDate(s.DeletedAt.Time), destination)
+		}
+		return secreti18n.T(locale, "home.activity_read", s.ReadBy, slackDate(s.DeletedAt.Time), destination)
+	case SecretRetiredRevoked:
+		return secreti18n.T(locale, "home.activity_revoked", slackDate(s.DeletedAt.Time), destination)
+	case SecretRetiredExpired:
+		return secreti18n.T(locale, "home.activity_expired", destination)
+	default:
+		return secreti18n.T(locale, "home.activity_removed", slackDate(s.DeletedAt.Time), destination)
+	}
+}
+
+// slackDate formats t so Slack renders it in the reader's own timezone
+func slackDate(t time.Time) string {
+	return fmt.Sprintf("<!date^%d^{date_short_pretty} at {time}|%s>", t.Unix(), t.UTC().Format("2006-01-02 15:04 UTC"))
+}
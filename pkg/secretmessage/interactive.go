@@ -1,14 +1,17 @@
 package secretmessage
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
 	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage/actions"
 	"github.com/slack-go/slack"
 	"go.uber.org/zap"
@@ -21,34 +24,44 @@ func CallbackReadSecret(ctl *PublicController, c *gin.Context, i slack.Interacti
 	// Fetch secret
 	var secret Secret
 	getSecretErr := ctl.db.WithContext(hc).Where("id = ?", hash(secretID)).First(&secret).Error
-	var errTitle string
-	var errMsg string
+	locale := ctl.userLocale(hc, i.Team.ID, i.Enterprise.ID, i.User.ID)
+	var errUser userError
 	var errCallback string
 	var deleteOriginal bool
 	switch {
 	case !secret.ExpiresAt.IsZero() && secret.ExpiresAt.Before(time.Now()):
 		getSecretErr = errors.New("Secret expired")
-		errTitle = ":hourglass: Secret expired"
-		errMsg = "This Secret has expired"
+		errUser = newUserError("secret_expired")
 		errCallback = "secret_expired"
 		deleteOriginal = true
-		ctl.db.WithContext(hc).Unscoped().Where("id = ?", hash(secretID)).Delete(Secret{})
+		if retired, _ := retireSecret(ctl.db.WithContext(hc), hash(secretID), SecretRetiredExpired, ""); retired {
+			ctl.recordSecretEvent(hc, AuditSecretExpired, secretTeamID(secret, i), secret.ID, "", secret.ChannelID)
+		}
 	case getSecretErr == gorm.ErrRecordNotFound:
-		errTitle = ":question: Secret not found"
-		errMsg = "This Secret has already been retrieved or has expired"
+		errUser = newUserError("secret_not_found")
 		errCallback = "secret_not_found"
 		deleteOriginal = true
 	case getSecretErr != nil:
-		errTitle = ":x: Sorry, an error occurred"
-		errMsg = "An error occurred attempting to retrieve secret"
+		errUser = newUserError("secret_read_failed")
 		errCallback = "secret_get_error"
 		deleteOriginal = false
 	}
+	if getSecretErr == nil && !secret.CanBeReadBy(i.User.ID) {
+		ctl.logger.Info("secret read attempted by non-recipient", zap.String("userID", i.User.ID))
+		notRecipient := newUserError("not_recipient")
+		res, code := ctl.slackService.NewSlackErrorResponse(
+			notRecipient.title(locale),
+			notRecipient.text(locale),
+			false,
+			"secret_not_recipient")
+		c.Data(code, gin.MIMEJSON, res)
+		return
+	}
 	if getSecretErr != nil {
 		ctl.logger.Error("error retrieving secret from store", zap.Error(getSecretErr), zap.String("secretID", secretID))
 		res, code := ctl.slackService.NewSlackErrorResponse(
-			errTitle,
-			errMsg,
+			errUser.title(locale),
+			errUser.text(locale),
 			deleteOriginal,
 			errCallback)
 		c.Data(code, gin.MIMEJSON, res)
@@ -59,44 +72,53 @@ func CallbackReadSecret(ctl *PublicController, c *gin.Context, i slack.Interacti
 	var secretDecrypted string
 	var decryptionErr error
 	secretDecrypted, decryptionErr = decrypt(secret.Value, secretID)
+	readFailed := newUserError("secret_read_failed")
 	if decryptionErr != nil {
 		ctl.logger.Error("error decrypting secret", zap.Error(decryptionErr), zap.String("secretID", secretID))
 		res, code := ctl.slackService.NewSlackErrorResponse(
-			":x: Sorry, an error occurred",
-			"An error occurred attempting to retrieve secret",
+			readFailed.title(locale),
+			readFailed.text(locale),
 			false,
 			"decrypt_error")
 		c.Data(code, gin.MIMEJSON, res)
 		return
 	}
 
-	response := slack.Message{
-		Msg: slack.Msg{
-			DeleteOriginal: true,
-			ResponseType:   slack.ResponseTypeEphemeral,
-			Attachments: []slack.Attachment{{
-				Title:      "Secret message",
-				Fallback:   "Secret message",
-				Text:       secretDecrypted,
-				CallbackID: fmt.Sprintf("%s:%v", actions.DeleteMessage, secretID),
-				Color:      "#6D5692",
-				Footer:     "The above message is only visible to you and will disappear when your Slack client reloads. To remove it immediately, press the delete button",
-				Actions: []slack.AttachmentAction{{
-					Name:  "removeMessage",
-					Text:  ":x: Delete message",
-					Type:  "button",
-					Style: "danger",
-					Value: "removeMessage",
-				}},
-			}},
-		},
+	// Retire the secret before showing it, so it can only be read once even if clicked twice concurrently
+	retired, retireErr := retireSecret(ctl.db.WithContext(hc), hash(secretID), SecretRetiredRead, i.User.ID)
+	if retireErr != nil {
+		ctl.logger.Error("error retiring secret after retrieval", zap.Error(retireErr), zap.String("secretID", secretID))
+		res, code := ctl.slackService.NewSlackErrorResponse(
+			readFailed.title(locale),
+			readFailed.text(locale),
+			false,
+			"secret_retire_error")
+		c.Data(code, gin.MIMEJSON, res)
+		return
 	}
-	responseBytes, err := json.Marshal(response)
+	if !retired {
+		notFound := newUserError("secret_not_found")
+		res, code := ctl.slackService.NewSlackErrorResponse(
+			notFound.title(locale),
+			notFound.text(locale),
+			true,
+			"secret_not_found")
+		c.Data(code, gin.MIMEJSON, res)
+		return
+	}
+
+	ctl.recordSecretEvent(hc, AuditSecretRead, secretTeamID(secret, i), secret.ID, i.User.ID, secret.ChannelID)
+
+	revealed := revealedSecretMessage(secretDecrypted, secretID, locale)
+	if secret.Template != "" {
+		revealed = revealedTemplateMessage(secretDecrypted, secret.Template, secretID, locale)
+	}
+	responseBytes, err := json.Marshal(revealed)
 	if err != nil {
 		ctl.logger.Error("error marshalling response", zap.Error(err), zap.String("secretID", secretID))
 		res, code := ctl.slackService.NewSlackErrorResponse(
-			":x: Sorry, an error occurred",
-			"An error occurred attempting to retrieve secret",
+			readFailed.title(locale),
+			readFailed.text(locale),
 			false,
 			"json_marshal_error")
 		c.Data(code, gin.MIMEJSON, res)
@@ -104,8 +126,121 @@ func CallbackReadSecret(ctl *PublicController, c *gin.Context, i slack.Interacti
 	}
 	c.Data(http.StatusOK, gin.MIMEJSON, responseBytes)
 
-	if delSecretErr := ctl.db.WithContext(hc).Unscoped().Where("id = ?", hash(secretID)).Delete(Secret{}).Error; delSecretErr != nil {
-		ctl.logger.Error("error deleting secret after retrieval", zap.Error(delSecretErr), zap.String("secretID", secretID))
+	if secret.ReadReceipt && secret.SenderID != "" {
+		sendReadReceipt(ctl, hc, i.Team.ID, i.Enterprise.ID, secret, i.User.ID)
+	}
+}
+
+// revealedSecretMessage shows a secret only to whoever revealed it. A secret too long for one attachment
+// is shown across several, so Slack doesn't cut it off, and the last one holds the delete button.
+func revealedSecretMessage(secretText string, secretID string, locale string) slack.Message {
+	attachments := []slack.Attachment{{Color: "#6D5692"}}
+	for n, chunk := range textChunks(secretText, revealedTextChunk) {
+		if n > 0 {
+			attachments = append(attachments, slack.Attachment{Color: "#6D5692"})
+		}
+		attachments[n].Text = chunk
+	}
+	attachments[0].Title = secreti18n.T(locale, "reveal.title")
+	attachments[0].Fallback = secreti18n.T(locale, "reveal.title")
+	last := &attachments[len(attachments)-1]
+	last.CallbackID = fmt.Sprintf("%s:%v", actions.DeleteMessage, secretID)
+	last.Footer = secreti18n.T(locale, "reveal.footer")
+	last.Actions = []slack.AttachmentAction{{
+		Name:  "removeMessage",
+		Text:  secreti18n.T(locale, "reveal.delete_button"),
+		Type:  "button",
+		Style: "danger",
+		Value: "removeMessage",
+	}}
+	return slack.Message{
+		Msg: slack.Msg{
+			DeleteOriginal: true,
+			ResponseType:   slack.ResponseTypeEphemeral,
+			Attachments:    attachments,
+		},
+	}
+}
+
+// secretTeamID is the team whose audit chain records a secret's events. Secrets stored before
+// teams were recorded fall back to the team of the user interacting with them.
+func secretTeamID(secret Secret, i slack.InteractionCallback) string {
+	if secret.TeamID != "" {
+		return secret.TeamID
+	}
+	return i.Team.ID
+}
+
+// retireSecret scrubs the value of an unread secret and soft deletes it, keeping its metadata for the
+// sender's activity history. It reports false if the secret was already retired.
+func retireSecret(db *gorm.DB, id string, reason string, readBy string) (bool, error) {
+	res := db.Model(&Secret{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"value":          "",
+			"retired_reason": reason,
+			"read_by":        readBy,
+			"deleted_at":     time.Now(),
+		})
+	return res.RowsAffected > 0, res.Error
+}
+
+// RetireExpiredSecrets retires secrets that expired unread, scrubbing their values and deleting the
+// contents of files and the shares submitted for split secrets, and records their secret_expired events. Secrets are also retired when someone
+// tries to read them after they expire, but their events shouldn't wait for that. It returns how many
+// secrets were retired.
+func (ctl *PublicController) RetireExpiredSecrets(ctx context.Context) (int, error) {
+	var expired []Secret
+	err := ctl.db.WithContext(ctx).
+		Select("id", "team_id", "channel_id", "file", "threshold").
+		// Secrets without an expiry date never expire
+		Where("expires_at > ? AND expires_at < ?", time.Time{}, time.Now()).
+		Find(&expired).Error
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, secret := range expired {
+		retired, err := retireSecret(ctl.db.WithContext(ctx), secret.ID, SecretRetiredExpired, "")
+		if err != nil {
+			return count, err
+		}
+		if retired {
+			ctl.recordSecretEvent(ctx, AuditSecretExpired, secret.TeamID, secret.ID, "", secret.ChannelID)
+			if secret.File {
+				ctl.deleteFile(ctx, secret.ID)
+			}
+			if secret.Threshold > 0 {
+				ctl.deleteShares(ctx, secret.ID)
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// sendReadReceipt lets the sender know, in their DM with the app, that readerID read their secret. An
+// empty readerID is someone with the secret's web link.
+func sendReadReceipt(ctl *PublicController, ctx context.Context, teamID string, enterpriseID string, secret Secret, readerID string) {
+	team, err := ctl.findInstallation(ctx, teamID, enterpriseID)
+	if err != nil {
+		ctl.logger.Error("error getting team for read receipt", zap.Error(err), zap.String("teamID", teamID))
+		return
+	}
+	api, err := ctl.slackClientForTeam(ctx, team)
+	if err != nil {
+		ctl.logger.Error("error building slack client for read receipt", zap.Error(err), zap.String("teamID", teamID))
+		return
+	}
+	locale := ctl.userLocale(ctx, teamID, enterpriseID, secret.SenderID)
+	reader := secreti18n.T(locale, "receipt.web_link_reader")
+	if readerID != "" {
+		reader = fmt.Sprintf("<@%s>", readerID)
+	}
+	_, _, err = api.PostMessageContext(ctx, secret.SenderID,
+		slack.MsgOptionText(secreti18n.T(locale, "receipt.read", reader), false))
+	if err != nil {
+		ctl.logger.Error("error sending read receipt", zap.Error(err), zap.String("teamID", teamID))
 	}
 }
 
@@ -120,33 +255,340 @@ func CallbackDeleteSecret(ctl *PublicController, c *gin.Context, i slack.Interac
 	responseBytes, err := json.Marshal(response)
 	if err != nil {
 		ctl.logger.Error("error marshalling response for delete secret", zap.Error(err), zap.String("secretID", secretID))
+		deleteFailed := newUserError("secret_delete_failed")
+		locale := ctl.userLocale(c.Request.Context(), i.Team.ID, i.Enterprise.ID, i.User.ID)
 		res, code := ctl.slackService.NewSlackErrorResponse(
-			":x: Sorry, an error occurred",
-			"An error occurred attempting to delete secret",
+			deleteFailed.title(locale),
+			deleteFailed.text(locale),
 			false,
 			"json_marshal_error")
 		c.Data(code, gin.MIMEJSON, res)
 		return
 	}
 	c.Data(http.StatusOK, gin.MIMEJSON, responseBytes)
+
+	// The secret was already retired when it was read, its metadata says which chain the event belongs to.
+	// Messages of secrets the database doesn't know, or can't be read, leave nothing to audit.
+	hc := c.Request.Context()
+	var secret Secret
+	if err := ctl.db.WithContext(hc).Unscoped().Where("id = ?", hash(secretID)).Take(&secret).Error; err != nil {
+		ctl.logger.Warn("error finding dismissed secret", zap.Error(err), zap.String("secretID", hash(secretID)))
+		return
+	}
+	ctl.recordSecretEvent(hc, AuditSecretDismissed, secretTeamID(secret, i), hash(secretID), i.User.ID, secret.ChannelID)
+}
+
+func CallbackBlockActions(ctl *PublicController, c *gin.Context, i slack.InteractionCallback) {
+	for _, action := range i.ActionCallback.BlockActions {
+		switch action.ActionID {
+		case actions.RevokeSecret:
+			CallbackRevokeSecret(ctl, c, i, action)
+			return
+		case actions.SubmitShare:
+			CallbackSubmitShare(ctl, c, i, action)
+			return
+		case actions.ChooseTemplate:
+			CallbackChooseTemplate(ctl, c, i, action)
+			return
+		}
+	}
+	ctl.logger.Warn("unknown block action", zap.String("teamID", i.Team.ID))
+	c.Data(http.StatusOK, gin.MIMEPlain, nil)
 }
 
 func CallbackViewSubmission(ctl *PublicController, c *gin.Context, i slack.InteractionCallback) {
+	switch i.View.CallbackID {
+	case actions.TeamSettingsModal:
+		CallbackTeamSettingsSubmission(ctl, c, i)
+	case actions.SplitSecretModal:
+		CallbackSplitSecretSubmission(ctl, c, i)
+	case actions.SubmitShareModal:
+		CallbackSubmitShareSubmission(ctl, c, i)
+	default:
+		CallbackCreateSecretSubmission(ctl, c, i)
+	}
+}
 
-	secretTextVal := i.View.State.Values["secret_text_input"]["secret_text_input"].Value
-	datePickerVal := i.View.State.Values["expiry_date_input"]["expiry_date_input"].SelectedDate
+const (
+	// viewSubmissionDeadline bounds everything done before answering a submitted modal, within the 3
+	// seconds Slack waits for the answer
+	viewSubmissionDeadline = 2500 * time.Millisecond
+	// recipientLookupTimeout bounds looking up a modal's recipients, which are looked up together
+	recipientLookupTimeout = 1500 * time.Millisecond
+)
 
-	dateParsed, err := time.Parse("2006-01-02", datePickerVal)
+// createSecretSubmission is what was entered in the create secret modal
+type createSecretSubmission struct {
+	text     string
+	template string
+	// generated is set when text was generated rather than typed, so the sender is shown a copy
+	generated   bool
+	expiresAt   time.Time
+	recipients  []string
+	readReceipt bool
+}
+
+// parseCreateSecretSubmission reads the create secret modal, with an error for each block whose input
+// isn't allowed by the team's settings, in locale
+func parseCreateSecretSubmission(state *slack.ViewState, meta modalMetadata, settings TeamSettings, now time.Time, locale string) (createSecretSubmission, map[string]string) {
+	sub := createSecretSubmission{
+		text:       state.Values["secret_text_input"]["secret_text_input"].Value,
+		template:   meta.Template,
+		generated:  meta.Generate != "",
+		recipients: uniqueUserIDs(state.Values["recipients_input"]["recipients_input"].SelectedUsers),
+	}
+	for _, option := range state.Values["read_receipt_input"]["read_receipt_input"].SelectedOptions {
+		sub.readReceipt = sub.readReceipt || option.Value == readReceiptOptionValue
+	}
+
+	validationErrors := map[string]string{}
+	var ue userError
+	switch {
+	case sub.generated:
+		sub.text, validationErrors = parseGenerateFields(state, meta.Generate == generatePassphrase, settings, locale)
+	case sub.template != "":
+		sub.text, validationErrors = parseTemplateFields(state, meta.TemplateFields, settings, locale)
+	case errors.As(validateSecretText(sub.text, settings), &ue):
+		validationErrors["secret_text_input"] = ue.text(locale)
+	}
+	expiresAt, expiryErr := parseExpiryDate(state.Values["expiry_date_input"]["expiry_date_input"].SelectedDate, settings.MaxExpiryDays, now, locale)
+	if expiryErr != "" {
+		validationErrors["expiry_date_input"] = expiryErr
+	}
+	sub.expiresAt = expiresAt
+	if settings.RequireRecipients && len(sub.recipients) == 0 {
+		validationErrors["recipients_input"] = secreti18n.T(locale, "validation.recipients_required")
+	}
+	return sub, validationErrors
+}
+
+// parseExpiryDate reads a modal's expiry date, or explains why it can't be used. No date means the
+// team's default expiry. Dates are picked in the user's timezone, so a day either side of the
+// server's range is let through and the secret's expiry is capped when it is created.
+func parseExpiryDate(date string, maxExpiryDays int, now time.Time, locale string) (time.Time, string) {
+	if date == "" {
+		return time.Time{}, ""
+	}
+	expiresAt, err := time.Parse("2006-01-02", date)
 	if err != nil {
-		ctl.logger.Error("error parsing date from view submission", zap.Error(err), zap.String("datePickerVal", datePickerVal))
+		return time.Time{}, secreti18n.T(locale, "validation.date_invalid")
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if expiresAt.Before(today.AddDate(0, 0, -1)) {
+		return time.Time{}, secreti18n.T(locale, "validation.date_past")
 	}
+	if expiresAt.After(today.AddDate(0, 0, maxExpiryDays+1)) {
+		return time.Time{}, secreti18n.T(locale, "validation.date_too_far", maxExpiryDays)
+	}
+	return expiresAt, ""
+}
 
-	err = PrepareAndSendSecretEnvelope(ctl, c, secretTextVal, i.Team.ID, i.User.Name, i.View.PrivateMetadata, WithExpiryDate(dateParsed))
+// recipientError explains why one of the recipients can't read secrets, like apps and deactivated
+// users, in locale. Recipients are let through when they can't be looked up in time.
+func (ctl *PublicController) recipientError(ctx context.Context, teamID string, enterpriseID string, recipients []string, locale string) string {
+	if len(recipients) == 0 {
+		return ""
+	}
+	team, err := ctl.findInstallation(ctx, teamID, enterpriseID)
+	if err != nil {
+		ctl.logger.Warn("error getting team to check recipients", zap.Error(err), zap.String("teamID", teamID))
+		return ""
+	}
+	api, err := ctl.slackClientForTeam(ctx, team)
 	if err != nil {
-		ctl.logger.Error("error preparing and sending secret envelope", zap.Error(err), zap.String("secretTextVal", secretTextVal), zap.String("teamID", i.Team.ID), zap.String("userName", i.User.Name), zap.String("privateMetadata", i.View.PrivateMetadata))
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		ctl.logger.Warn("error building slack client to check recipients", zap.Error(err), zap.String("teamID", teamID))
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, recipientLookupTimeout)
+	defer cancel()
+	recipientErrors := make([]string, len(recipients))
+	var wg sync.WaitGroup
+	for n, id := range recipients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recipientErrors[n] = ctl.lookupRecipientError(ctx, api, teamID, id, locale)
+		}()
+	}
+	wg.Wait()
+	for _, recipientErr := range recipientErrors {
+		if recipientErr != "" {
+			return recipientErr
+		}
+	}
+	return ""
+}
 
+// lookupRecipientError explains why the recipient userID can't read secrets, or returns "" when they
+// can or can't be looked up
+func (ctl *PublicController) lookupRecipientError(ctx context.Context, api *slack.Client, teamID string, userID string, locale string) string {
+	user, err := api.GetUserInfoContext(ctx, userID)
+	if err != nil {
+		ctl.logger.Warn("error looking up recipient", zap.Error(err), zap.String("teamID", teamID), zap.String("userID", userID))
+		return ""
+	}
+	// Remember their language for the messages they're about to be sent
+	ctl.locales.set(teamID+":"+userID, secreti18n.Supported(user.Locale), time.Now())
+	name := user.Profile.DisplayName
+	if name == "" {
+		name = user.RealName
+	}
+	if name == "" {
+		name = user.Name
+	}
+	switch {
+	case user.IsBot || user.ID == "USLACKBOT":
+		return secreti18n.T(locale, "validation.recipient_app", name)
+	case user.Deleted:
+		return secreti18n.T(locale, "validation.recipient_deactivated", name)
+	}
+	return ""
+}
+
+// respondViewErrors keeps a submitted modal open, showing each error under the block with its ID
+func respondViewErrors(c *gin.Context, validationErrors map[string]string) {
+	responseBytes, _ := json.Marshal(slack.NewErrorsViewSubmissionResponse(validationErrors))
+	c.Data(http.StatusOK, gin.MIMEJSON, responseBytes)
+}
+
+// respondViewUpdate replaces a submitted modal with view
+func respondViewUpdate(c *gin.Context, view *slack.ModalViewRequest) {
+	responseBytes, _ := json.Marshal(slack.NewUpdateViewSubmissionResponse(view))
+	c.Data(http.StatusOK, gin.MIMEJSON, responseBytes)
+}
+
+// viewSubmissionFailed is shown under a modal's block when its submission failed on our side, so the
+// user can submit it again rather than seeing Slack's generic connection error
+func viewSubmissionFailed(locale string) string {
+	return secreti18n.T(locale, "validation.failed")
+}
+
+// CallbackCreateSecretSubmission stores a secret created with the modal and answers with the modal
+// confirming it was sent. Slack waits 3 seconds for the answer, so the lookups before it share one
+// deadline and the secret is delivered in the background. The modal is replaced with an error if
+// delivery fails.
+func CallbackCreateSecretSubmission(ctl *PublicController, c *gin.Context, i slack.InteractionCallback) {
+	hc, cancel := context.WithTimeout(c.Request.Context(), viewSubmissionDeadline)
+	defer cancel()
+	locale := ctl.userLocale(hc, i.Team.ID, i.Enterprise.ID, i.User.ID)
+	meta := parseModalMetadata(i.View.PrivateMetadata)
+	settings, err := ctl.teamSettings(hc, i.Team.ID)
+	if err != nil {
+		ctl.logger.Error("error getting team settings", zap.Error(err), zap.String("teamID", i.Team.ID))
+		respondViewErrors(c, map[string]string{meta.secretBlockID(): viewSubmissionFailed(locale)})
 		return
 	}
-	c.Data(http.StatusOK, gin.MIMEPlain, nil)
+	sub, validationErrors := parseCreateSecretSubmission(i.View.State, meta, settings, time.Now(), locale)
+	if _, ok := validationErrors["recipients_input"]; !ok {
+		if recipientErr := ctl.recipientError(hc, i.Team.ID, i.Enterprise.ID, sub.recipients, locale); recipientErr != "" {
+			validationErrors["recipients_input"] = recipientErr
+		}
+	}
+	if len(validationErrors) > 0 {
+		respondViewErrors(c, validationErrors)
+		return
+	}
+
+	secretID, sec, err := ctl.storeSecret(hc, sub.text, i.Team.ID, settings,
+		WithTemplate(sub.template),
+		WithExpiryDate(sub.expiresAt),
+		WithSenderID(i.User.ID),
+		WithChannelID(meta.ChannelID),
+		WithRecipients(sub.recipients),
+		WithReadReceipt(sub.readReceipt),
+	)
+	if err != nil {
+		ctl.logger.Error("error storing secret from modal", zap.Error(err), zap.String("teamID", i.Team.ID), zap.String("userID", i.User.ID))
+		respondViewErrors(c, map[string]string{meta.secretBlockID(): viewSubmissionFailed(locale)})
+		return
+	}
+	envelope := secretEnvelope(sec, secretID, "", settings.Language())
+	envelope.ResponseType = slack.ResponseTypeInChannel
+	err = ctl.jobs.Enqueue(c.Request.Context(), func(ctx context.Context) {
+		ctl.deliverModalSecret(ctx, i, meta, sec, envelope, locale)
+	})
+	if err != nil {
+		ctl.logger.Error("error queueing secret delivery", zap.Error(err), zap.String("teamID", i.Team.ID), zap.String("userID", i.User.ID))
+		ctl.revokeUndelivered(hc, i.Team.ID, i.User.ID, meta.ChannelID, sec)
+		respondViewErrors(c, map[string]string{meta.secretBlockID(): viewSubmissionFailed(locale)})
+		return
+	}
+
+	sent := secretSentModal(sec, meta.ChannelID, locale)
+	if sub.generated {
+		sent.Blocks.BlockSet = append(sent.Blocks.BlockSet, generatedCopyBlock(sub.text, meta.Generate == generatePassphrase, locale))
+	}
+	respondViewUpdate(c, sent)
+}
+
+// deliverModalSecret sends the envelope of a secret created with the modal. If it can't be, the secret
+// is revoked and the modal that confirmed it was sent is replaced with an error.
+func (ctl *PublicController) deliverModalSecret(ctx context.Context, i slack.InteractionCallback, meta modalMetadata, sec *Secret, envelope slack.Message, locale string) {
+	err := ctl.sendModalEnvelope(ctx, i.Team.ID, i.Enterprise.ID, meta, envelope)
+	if err == nil {
+		return
+	}
+	ctl.logger.Error("error sending secret envelope", zap.Error(err), zap.String("teamID", i.Team.ID), zap.String("userID", i.User.ID), zap.String("channelID", meta.ChannelID))
+	ctl.revokeUndelivered(ctx, i.Team.ID, i.User.ID, meta.ChannelID, sec)
+
+	team, err := ctl.findInstallation(ctx, i.Team.ID, i.Enterprise.ID)
+	if err != nil {
+		ctl.logger.Error("error getting team to report undelivered secret", zap.Error(err), zap.String("teamID", i.Team.ID))
+		return
+	}
+	api, err := ctl.slackClientForTeam(ctx, team)
+	if err != nil {
+		ctl.logger.Error("error building slack client to report undelivered secret", zap.Error(err), zap.String("teamID", i.Team.ID))
+		return
+	}
+	// Answering a submission with a view keeps the modal's ID
+	if _, err := api.UpdateViewContext(ctx, secretNotSentModal(locale), "", "", i.View.ID); err != nil {
+		ctl.logger.Error("error reporting undelivered secret", zap.Error(err), zap.String("teamID", i.Team.ID), zap.String("viewID", i.View.ID))
+	}
+}
+
+// revokeUndelivered revokes a secret whose envelope couldn't be sent, since nobody can read it
+func (ctl *PublicController) revokeUndelivered(ctx context.Context, teamID string, userID string, channelID string, sec *Secret) {
+	if revoked, _ := retireSecret(ctl.db.WithContext(ctx), sec.ID, SecretRetiredRevoked, ""); revoked {
+		ctl.recordSecretEvent(ctx, AuditSecretRevoked, teamID, sec.ID, userID, channelID)
+	}
+}
+
+// secretNotSentModal replaces the modal that confirmed a secret was sent when it couldn't be
+func secretNotSentModal(locale string) slack.ModalViewRequest {
+	return slack.ModalViewRequest{
+		Type:  slack.VTModal,
+		Title: slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "sent_modal.not_sent_title"), false, false),
+		Close: slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "modal.done"), false, false),
+		Blocks: slack.Blocks{
+			BlockSet: []slack.Block{
+				slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", ":x: "+secreti18n.T(locale, "validation.delivery_failed"), false, false), nil, nil),
+			},
+		},
+	}
+}
+
+// secretSentModal confirms a secret created with the modal was sent
+func secretSentModal(sec *Secret, channelID string, locale string) *slack.ModalViewRequest {
+	text := secreti18n.T(locale, "sent_modal.sent")
+	if channelID != "" {
+		text = secreti18n.T(locale, "sent_modal.sent_to", fmt.Sprintf("<#%s>", channelID))
+	}
+	if len(sec.Recipients) > 0 {
+		text += " " + secreti18n.T(locale, "sent_modal.readers", userMentions(sec.Recipients))
+	}
+	text += " " + secreti18n.T(locale, "sent_modal.expires", slackDate(sec.ExpiresAt))
+	if sec.ReadReceipt {
+		text += " " + secreti18n.T(locale, "sent_modal.read_receipt")
+	}
+	return &slack.ModalViewRequest{
+		Type:  slack.VTModal,
+		Title: slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "sent_modal.title"), false, false),
+		Close: slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "modal.done"), false, false),
+		Blocks: slack.Blocks{
+			BlockSet: []slack.Block{
+				slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", ":envelope_with_arrow: "+text, false, false), nil, nil),
+			},
+		},
+	}
 }
@@ -0,0 +1,127 @@
+package secretmessage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
+	"go.uber.org/zap"
+)
+
+const (
+	// localeCacheTTL is how long a user's Slack language is remembered, so a language change shows up
+	// within the hour without looking the user up on every interaction
+	localeCacheTTL = time.Hour
+	// localeCacheSize bounds how many users' languages are remembered
+	localeCacheSize = 10000
+	// localeLookupTimeout bounds looking a user's language up, so a slow users.info call doesn't use up
+	// the 3 seconds Slack waits for a response
+	localeLookupTimeout = time.Second
+)
+
+// localeCache remembers the translated locale of users, "" when their Slack language isn't translated
+type localeCache struct {
+	mu      sync.Mutex
+	entries map[string]localeCacheEntry
+}
+
+type localeCacheEntry struct {
+	locale  string
+	expires time.Time
+}
+
+func newLocaleCache() *localeCache {
+	return &localeCache{entries: map[string]localeCacheEntry{}}
+}
+
+func (lc *localeCache) get(key string, now time.Time) (string, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	entry, ok := lc.entries[key]
+	if !ok || now.After(entry.expires) {
+		return "", false
+	}
+	return entry.locale, true
+}
+
+func (lc *localeCache) set(key string, locale string, now time.Time) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if len(lc.entries) >= localeCacheSize {
+		for k, entry := range lc.entries {
+			if now.After(entry.expires) {
+				delete(lc.entries, k)
+			}
+		}
+	}
+	if len(lc.entries) >= localeCacheSize {
+		lc.entries = map[string]localeCacheEntry{}
+	}
+	lc.entries[key] = localeCacheEntry{locale: locale, expires: now.Add(localeCacheTTL)}
+}
+
+// userLocale is the locale to show userID messages in: their Slack language when it is translated, or
+// else the team's language. Users who can't be looked up get the team's language too.
+func (ctl *PublicController) userLocale(ctx context.Context, teamID string, enterpriseID string, userID string) string {
+	// API tokens send secrets too, they have no Slack language
+	if userID == "" || strings.Contains(userID, ":") {
+		return ctl.teamLocale(ctx, teamID)
+	}
+	key := teamID + ":" + userID
+	locale, ok := ctl.locales.get(key, time.Now())
+	if !ok {
+		var err error
+		locale, err = ctl.lookupUserLocale(ctx, teamID, enterpriseID, userID)
+		if err != nil {
+			ctl.logger.Warn("error looking up user locale", zap.Error(err), zap.String("teamID", teamID), zap.String("userID", userID))
+			return ctl.teamLocale(ctx, teamID)
+		}
+		ctl.locales.set(key, locale, time.Now())
+	}
+	if locale == "" {
+		return ctl.teamLocale(ctx, teamID)
+	}
+	return locale
+}
+
+// cachedUserLocale is userLocale without waiting on Slack, for opening modals: their trigger_id expires
+// 3 seconds after the user acted, which a users.info call could use up. Users whose language isn't
+// cached yet get the team's language, handling the modal's submission looks them up for next time.
+func (ctl *PublicController) cachedUserLocale(ctx context.Context, teamID string, userID string) string {
+	locale, _ := ctl.locales.get(teamID+":"+userID, time.Now())
+	if locale == "" {
+		return ctl.teamLocale(ctx, teamID)
+	}
+	return locale
+}
+
+// lookupUserLocale asks Slack for the user's language, returning "" if it isn't translated
+func (ctl *PublicController) lookupUserLocale(ctx context.Context, teamID string, enterpriseID string, userID string) (string, error) {
+	team, err := ctl.findInstallation(ctx, teamID, enterpriseID)
+	if err != nil {
+		return "", err
+	}
+	api, err := ctl.slackClientForTeam(ctx, team)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, localeLookupTimeout)
+	defer cancel()
+	user, err := api.GetUserInfoContext(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return secreti18n.Supported(user.Locale), nil
+}
+
+// teamLocale is the language of the team's messages, the default one if its settings can't be read
+func (ctl *PublicController) teamLocale(ctx context.Context, teamID string) string {
+	settings, err := ctl.teamSettings(ctx, teamID)
+	if err != nil {
+		ctl.logger.Warn("error getting team settings for locale", zap.Error(err), zap.String("teamID", teamID))
+		return secreti18n.DefaultLocale
+	}
+	return settings.Language()
+}
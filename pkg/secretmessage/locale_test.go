@@ -0,0 +1,66 @@
+package secretmessage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocaleCache(t *testing.T) {
+	lc := newLocaleCache()
+	now := time.Now()
+	_, ok := lc.get("T1:U1", now)
+	assert.False(t, ok)
+
+	lc.set("T1:U1", "fr", now)
+	locale, ok := lc.get("T1:U1", now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, "fr", locale)
+
+	_, ok = lc.get("T1:U1", now.Add(localeCacheTTL+time.Second))
+	assert.False(t, ok, "languages are looked up again once they expire")
+}
+
+func TestLocaleCache_Bounded(t *testing.T) {
+	lc := newLocaleCache()
+	now := time.Now()
+	for n := 0; n < localeCacheSize+10; n++ {
+		lc.set(fmt.Sprintf("T1:U%d", n), "de", now)
+	}
+	assert.LessOrEqual(t, len(lc.entries), localeCacheSize)
+}
+
+// TestCataloguesHaveEveryKeyUsed checks the catalogue keys the package's code uses exist, which the
+// catalogues' own tests can't see. Keys that aren't string literals aren't checked.
+func TestCataloguesHaveEveryKeyUsed(t *testing.T) {
+	messageKey := regexp.MustCompile(`secreti18n\.T\([^,()]+, "([a-z_.]+)"[,)]`)
+	userErrorKey := regexp.MustCompile(`newUserError\("([a-z_]+)"[,)]`)
+	files, err := filepath.Glob("*.go")
+	require.NoError(t, err)
+	var keys []string
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(file)
+		require.NoError(t, err)
+		for _, m := range messageKey.FindAllStringSubmatch(string(src), -1) {
+			keys = append(keys, m[1])
+		}
+		for _, m := range userErrorKey.FindAllStringSubmatch(string(src), -1) {
+			keys = append(keys, "error."+m[1]+".title", "error."+m[1]+".text")
+		}
+	}
+	require.NotEmpty(t, keys)
+	for _, key := range keys {
+		assert.NotEqual(t, key, secreti18n.T(secreti18n.DefaultLocale, key), "%s is missing from the catalogue", key)
+	}
+}
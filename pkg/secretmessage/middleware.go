@@ -2,12 +2,16 @@ package secretmessage
 
 import (
 	"bytes"
+	"crypto/subtle"
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/slack-go/slack"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 func (ctl *PublicController) ValidateSignature() gin.HandlerFunc {
@@ -46,3 +50,53 @@ func (ctl *PublicController) ValidateSignature() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// RequireAdminToken authenticates operator endpoints with the configured admin API token, sent as
+// a bearer token. The endpoints are disabled when no token is configured.
+func (ctl *PublicController) RequireAdminToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ctl.config.AdminAPIToken == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"status": "Not Found"})
+			return
+		}
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(ctl.config.AdminAPIToken)) != 1 {
+			ctl.logger.Warn("admin request with invalid token", zap.String("path", c.Request.URL.Path))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// apiTokenContextKey is where RequireAPIToken stores the authenticated APIToken on the gin context
+const apiTokenContextKey = "apiToken"
+
+// RequireAPIToken authenticates REST API calls with a team's API token, sent as a bearer token, and
+// rejects tokens that weren't granted scope. An empty scope accepts any token of the team.
+func (ctl *PublicController) RequireAPIToken(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "Unauthorized"})
+			return
+		}
+		apiToken, err := findAPIToken(c.Request.Context(), ctl.db, token)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctl.logger.Warn("API request with invalid token", zap.String("path", c.Request.URL.Path))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "Unauthorized"})
+			return
+		}
+		if err != nil {
+			ctl.logger.Error("error looking up API token", zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+			return
+		}
+		if scope != "" && !apiToken.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "Token is missing the " + scope + " scope"})
+			return
+		}
+		c.Set(apiTokenContextKey, apiToken)
+		c.Next()
+	}
+}
@@ -0,0 +1,50 @@
+package secretmessage
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// EncryptTeamTokens seals every plain text access and refresh token, and re-seals tokens encrypted
+// with a retired key, using the cipher's active key. It is safe to run on every boot.
+func EncryptTeamTokens(ctx context.Context, db *gorm.DB, tc *TokenCipher) (int, error) {
+	var migrated int
+	var teams []Team
+	result := db.WithContext(ctx).
+		Where("access_token <> ? OR refresh_token <> ?", "", "").
+		FindInBatches(&teams, 100, func(tx *gorm.DB, batch int) error {
+			for _, team := range teams {
+				updates := map[string]interface{}{}
+				for column, stored := range map[string]string{"access_token": team.AccessToken, "refresh_token": team.RefreshToken} {
+					if !tc.NeedsReencryption(stored) {
+						continue
+					}
+					token, err := tc.Decrypt(stored)
+					if err != nil {
+						return fmt.Errorf("decrypting %s for team %s: %w", column, team.ID, err)
+					}
+					sealed, err := tc.Encrypt(token)
+					if err != nil {
+						return fmt.Errorf("encrypting %s for team %s: %w", column, team.ID, err)
+					}
+					updates[column] = sealed
+				}
+				if len(updates) == 0 {
+					continue
+				}
+				// Guard against overwriting a token that was rotated while the migration was running
+				err := db.WithContext(ctx).
+					Model(&Team{}).
+					Where("id = ? AND access_token = ? AND refresh_token = ?", team.ID, team.AccessToken, team.RefreshToken).
+					Updates(updates).Error
+				if err != nil {
+					return fmt.Errorf("updating tokens for team %s: %w", team.ID, err)
+				}
+				migrated++
+			}
+			return nil
+		})
+	return migrated, result.Error
+}
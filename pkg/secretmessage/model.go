@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"time"
 
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
 	"gorm.io/gorm"
 )
 
@@ -12,17 +13,150 @@ type Secret struct {
 	ID        string
 	ExpiresAt time.Time
 	Value     string
+	TeamID    string `gorm:"index"`
+	SenderID  string `gorm:"index"`
+	// ChannelID is the conversation the secret was sent to, empty when unknown
+	ChannelID string
+	// Recipients restricts who may read the secret. Anyone in the channel can read it when empty.
+	Recipients  []string `gorm:"serializer:json"`
+	ReadReceipt bool
+	// RetiredReason says why the secret can no longer be read. Retired secrets are soft deleted with
+	// their value scrubbed, so senders can still see what happened to them.
+	RetiredReason string
+	ReadBy        string
+	// WebLink marks a secret shared as a one-time web link instead of a Slack message
+	WebLink bool
+	// ClientEncrypted marks a web link secret that was encrypted in the sender's browser. Its Value is
+	// ciphertext the server has no key for.
+	ClientEncrypted bool
+	// File marks a web link secret holding a file. Its Value is the encrypted file name, the encrypted
+	// contents are in the blob store under the secret's ID.
+	File bool
+	// FileSize is the size of the file in bytes
+	FileSize int64
+	// Threshold is how many of a split secret's recipients must submit their share to reveal it, zero
+	// for secrets that aren't split
+	Threshold int
+	// ShareHashes maps each recipient of a split secret to the hash of their share, so submitted shares
+	// can be checked without storing the shares themselves
+	ShareHashes map[string]string `gorm:"serializer:json"`
+	// Template is the name of the template a secret was sent with. Its Value is then the encrypted
+	// JSON of the template's labelled fields.
+	Template string
+
+	defaultExpiryDays int
+	maxExpiryDays     int
 }
 
 type SecretOption func(*Secret) *Secret
 
+const (
+	// SecretRetiredRead is a secret that was read by someone
+	SecretRetiredRead = "read"
+	// SecretRetiredRevoked is a secret that its sender revoked before it was read
+	SecretRetiredRevoked = "revoked"
+	// SecretRetiredExpired is a secret that someone tried to read after it expired
+	SecretRetiredExpired = "expired"
+	// SecretStatusUnread is a secret that can still be read
+	SecretStatusUnread = "unread"
+)
+
+const (
+	// InstallTypeTeam is an installation into a single workspace, keyed by team ID
+	InstallTypeTeam = "team"
+	// InstallTypeEnterprise is an org-wide Enterprise Grid installation, keyed by enterprise ID
+	InstallTypeEnterprise = "enterprise"
+)
+
+// Team is an installation of the app. Workspace installs are keyed by team ID, org-wide
+// Enterprise Grid installs by enterprise ID and shared by every workspace in the org.
 type Team struct {
 	gorm.Model
-	ID          string
-	AccessToken string
-	Scope       string
-	Name        string
-	Paid        sql.NullBool `gorm:"default:false"`
+	ID           string
+	AccessToken  string
+	RefreshToken string
+	// TokenExpiresAt is zero unless token rotation is enabled for the app
+	TokenExpiresAt time.Time
+	Scope          string
+	Name           string
+	Paid           sql.NullBool `gorm:"default:false"`
+
+	EnterpriseID        string `gorm:"index"`
+	EnterpriseName      string
+	InstallType         string `gorm:"default:team"`
+	IsEnterpriseInstall bool   `gorm:"default:false"`
+}
+
+const (
+	// DefaultExpiryDays is how long a secret lives when the sender doesn't pick an expiry date
+	DefaultExpiryDays = 7
+	// DefaultMaxExpiryDays is the furthest out a secret may expire unless the team configured otherwise
+	DefaultMaxExpiryDays = 30
+	// MaxExpiryDaysLimit is the furthest out a team may allow secrets to expire
+	MaxExpiryDaysLimit = 90
+	// DefaultMaxSecretLength is the longest secret, in characters, unless the team configured otherwise
+	DefaultMaxSecretLength = 10000
+	// MaxSecretLengthLimit is the longest secret, in characters, a team may allow
+	MaxSecretLengthLimit = 40000
+	// DefaultMaxFileSizeMB is the largest file secret, in megabytes, unless the team configured otherwise
+	DefaultMaxFileSizeMB = 5
+	// MaxFileSizeMBLimit is the largest file secret, in megabytes, a team may allow
+	MaxFileSizeMBLimit = 50
+)
+
+// TeamSettings are per-workspace limits and defaults, editable by workspace admins with /secret settings
+type TeamSettings struct {
+	gorm.Model
+	TeamID            string `gorm:"uniqueIndex"`
+	DefaultExpiryDays int
+	MaxExpiryDays     int
+	MaxSecretLength   int
+	// MaxFileSizeMB is the largest file secret in megabytes. Zero, for settings saved before files
+	// could be shared, means DefaultMaxFileSizeMB.
+	MaxFileSizeMB      int
+	AllowInlineSecret  bool
+	RequireRecipients  bool
+	ReadReceiptDefault bool
+	// Locale is the language of messages seen by the whole conversation, and of messages to people
+	// whose Slack language isn't translated. Empty means secreti18n.DefaultLocale.
+	Locale string
+	// Templates are the structured secrets people can send with the create modal, one input per field
+	Templates SecretTemplates `gorm:"type:text"`
+	// WebhookURL receives the team's secret lifecycle events, none are sent when empty
+	WebhookURL string
+	// WebhookSecret signs webhook deliveries. It is encrypted like the team's access token.
+	WebhookSecret string
+}
+
+// DefaultTeamSettings are the settings of a workspace whose admins never changed them
+func DefaultTeamSettings(teamID string) TeamSettings {
+	return TeamSettings{
+		TeamID:             teamID,
+		DefaultExpiryDays:  DefaultExpiryDays,
+		MaxExpiryDays:      DefaultMaxExpiryDays,
+		MaxSecretLength:    DefaultMaxSecretLength,
+		MaxFileSizeMB:      DefaultMaxFileSizeMB,
+		AllowInlineSecret:  true,
+		RequireRecipients:  false,
+		ReadReceiptDefault: false,
+	}
+}
+
+// Language is the locale of the team's messages, see Locale
+func (s TeamSettings) Language() string {
+	if locale := secreti18n.Supported(s.Locale); locale != "" {
+		return locale
+	}
+	return secreti18n.DefaultLocale
+}
+
+// MaxFileSize is the largest file secret the team allows, in bytes
+func (s TeamSettings) MaxFileSize() int64 {
+	mb := s.MaxFileSizeMB
+	if mb <= 0 {
+		mb = DefaultMaxFileSizeMB
+	}
+	return int64(mb) << 20
 }
 
 func WithExpiryDate(expiryDate time.Time) SecretOption {
@@ -32,6 +166,116 @@ func WithExpiryDate(expiryDate time.Time) SecretOption {
 	}
 }
 
+func WithTeamID(teamID string) SecretOption {
+	return func(s *Secret) *Secret {
+		s.TeamID = teamID
+		return s
+	}
+}
+
+func WithSenderID(userID string) SecretOption {
+	return func(s *Secret) *Secret {
+		s.SenderID = userID
+		return s
+	}
+}
+
+func WithChannelID(channelID string) SecretOption {
+	return func(s *Secret) *Secret {
+		s.ChannelID = channelID
+		return s
+	}
+}
+
+func WithRecipients(userIDs []string) SecretOption {
+	return func(s *Secret) *Secret {
+		s.Recipients = userIDs
+		return s
+	}
+}
+
+func WithReadReceipt(readReceipt bool) SecretOption {
+	return func(s *Secret) *Secret {
+		s.ReadReceipt = readReceipt
+		return s
+	}
+}
+
+func WithWebLink(webLink bool) SecretOption {
+	return func(s *Secret) *Secret {
+		s.WebLink = webLink
+		return s
+	}
+}
+
+func WithClientEncrypted(clientEncrypted bool) SecretOption {
+	return func(s *Secret) *Secret {
+		s.ClientEncrypted = clientEncrypted
+		return s
+	}
+}
+
+// WithFile marks a web link secret as holding a file of the given size
+func WithFile(size int64) SecretOption {
+	return func(s *Secret) *Secret {
+		s.File = true
+		s.FileSize = size
+		return s
+	}
+}
+
+// WithSplit marks a secret as split between its recipients, threshold of whom must submit their share
+func WithSplit(threshold int, shareHashes map[string]string) SecretOption {
+	return func(s *Secret) *Secret {
+		s.Threshold = threshold
+		s.ShareHashes = shareHashes
+		return s
+	}
+}
+
+// WithTemplate marks a secret as holding the fields of the named template
+func WithTemplate(name string) SecretOption {
+	return func(s *Secret) *Secret {
+		s.Template = name
+		return s
+	}
+}
+
+// WithTeamSettings applies the team's default and maximum expiry instead of the global ones
+func WithTeamSettings(settings TeamSettings) SecretOption {
+	return func(s *Secret) *Secret {
+		s.defaultExpiryDays = settings.DefaultExpiryDays
+		s.maxExpiryDays = settings.MaxExpiryDays
+		return s
+	}
+}
+
+// CanBeReadBy reports whether userID is allowed to read the secret
+func (s Secret) CanBeReadBy(userID string) bool {
+	if len(s.Recipients) == 0 {
+		return true
+	}
+	for _, recipient := range s.Recipients {
+		if recipient == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Status is why the secret was retired, SecretRetiredExpired if it expired before anyone tried to
+// read it, or SecretStatusUnread
+func (s Secret) Status() string {
+	switch {
+	case s.RetiredReason != "":
+		return s.RetiredReason
+	case !s.ExpiresAt.IsZero() && time.Now().After(s.ExpiresAt):
+		return SecretRetiredExpired
+	default:
+		return SecretStatusUnread
+	}
+}
+
 func NewSecret(id string, value string, opts ...SecretOption) *Secret {
 	secret := &Secret{
 		ID:    id,
@@ -42,13 +286,21 @@ func NewSecret(id string, value string, opts ...SecretOption) *Secret {
 		opt(secret)
 	}
 
+	defaultExpiryDays, maxExpiryDays := secret.defaultExpiryDays, secret.maxExpiryDays
+	if defaultExpiryDays <= 0 {
+		defaultExpiryDays = DefaultExpiryDays
+	}
+	if maxExpiryDays <= 0 {
+		maxExpiryDays = DefaultMaxExpiryDays
+	}
+
 	if secret.ExpiresAt.IsZero() {
-		// Default to 7 days expiry if not provided
-		secret.ExpiresAt = time.Now().AddDate(0, 0, 7)
+		// Default to the team's default expiry if not provided
+		secret.ExpiresAt = time.Now().AddDate(0, 0, defaultExpiryDays)
 	}
-	// if expiry date is more than 30 days in the future, set it to 30 days
-	if secret.ExpiresAt.After(time.Now().AddDate(0, 0, 30)) {
-		secret.ExpiresAt = time.Now().AddDate(0, 0, 30)
+	// if expiry date is further out than the team allows, cap it
+	if secret.ExpiresAt.After(time.Now().AddDate(0, 0, maxExpiryDays)) {
+		secret.ExpiresAt = time.Now().AddDate(0, 0, maxExpiryDays)
 	}
 
 	// If expiry date is in the past, set it to now
@@ -57,3 +309,50 @@ func NewSecret(id string, value string, opts ...SecretOption) *Secret {
 	}
 	return secret
 }
+
+const (
+	AuditSecretCreated   = "secret_created"
+	AuditSecretRead      = "secret_read"
+	AuditSecretRevoked   = "secret_revoked"
+	AuditSecretExpired   = "secret_expired"
+	AuditSecretDismissed = "secret_dismissed"
+	AuditShareSubmitted  = "share_submitted"
+)
+
+// AuditEvent records a step in a secret's lifecycle, never its content. Each team's events form a
+// hash chain ordered by Seq, so edited or deleted events are detected by VerifyAuditChain.
+type AuditEvent struct {
+	ID         uint      `gorm:"primaryKey"`
+	TeamID     string    `gorm:"uniqueIndex:idx_audit_events_team_seq"`
+	Seq        int64     `gorm:"uniqueIndex:idx_audit_events_team_seq"`
+	OccurredAt time.Time `gorm:"index"`
+	Action     string
+	SecretID   string `gorm:"index"`
+	ActorID    string
+	ChannelID  string
+	PrevHash   string
+	Hash       string
+}
+
+// SecretShare is a share of a split secret that one of its recipients submitted towards revealing it.
+// Shares are encrypted like access tokens and deleted once the secret is revealed.
+type SecretShare struct {
+	ID        uint   `gorm:"primaryKey"`
+	SecretID  string `gorm:"uniqueIndex:idx_secret_shares_secret_user"`
+	UserID    string `gorm:"uniqueIndex:idx_secret_shares_secret_user"`
+	TeamID    string `gorm:"index"`
+	Share     string
+	CreatedAt time.Time
+}
+
+// APIToken authenticates calls to the REST API on behalf of a team. Only a hash of the token is stored,
+// the token itself is shown once when it is created.
+type APIToken struct {
+	gorm.Model
+	TeamID       string `gorm:"index"`
+	EnterpriseID string
+	Name         string
+	TokenHash    string   `gorm:"uniqueIndex"`
+	Scopes       []string `gorm:"serializer:json"`
+	LastUsedAt   time.Time
+}
@@ -46,3 +46,27 @@ func TestNewSecret_ExpiryInPast(t *testing.T) {
 
 	assert.WithinDuration(t, time.Now(), secret.ExpiresAt, time.Second*2)
 }
+
+func TestNewSecret_WithTeamSettings(t *testing.T) {
+	settings := DefaultTeamSettings("T1")
+	settings.DefaultExpiryDays = 2
+	settings.MaxExpiryDays = 60
+
+	secret := NewSecret("abc123", "mysecret", WithTeamSettings(settings))
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 2), secret.ExpiresAt, time.Second*2)
+
+	secret = NewSecret("abc123", "mysecret", WithTeamSettings(settings), WithExpiryDate(time.Now().AddDate(0, 0, 45)))
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 45), secret.ExpiresAt, time.Second*2)
+
+	secret = NewSecret("abc123", "mysecret", WithTeamSettings(settings), WithExpiryDate(time.Now().AddDate(0, 0, 80)))
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 60), secret.ExpiresAt, time.Second*2)
+}
+
+func TestSecret_CanBeReadBy(t *testing.T) {
+	open := NewSecret("abc123", "mysecret")
+	assert.True(t, open.CanBeReadBy("U1"))
+
+	restricted := NewSecret("abc123", "mysecret", WithRecipients([]string{"U1", "U2"}))
+	assert.True(t, restricted.CanBeReadBy("U2"))
+	assert.False(t, restricted.CanBeReadBy("U3"))
+}
@@ -0,0 +1,378 @@
+package secretmessage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage/actions"
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	settingsOptionAllowInline        = "allow_inline_secret"
+	settingsOptionRequireRecipients  = "require_recipients"
+	settingsOptionReadReceiptDefault = "read_receipt_default"
+	settingsOptionRotateWebhook      = "rotate_webhook_secret"
+)
+
+var errNotWorkspaceAdmin = newUserError("not_workspace_admin")
+
+// teamSettings returns the workspace's settings, or the defaults if its admins never changed them
+func (ctl *PublicController) teamSettings(ctx context.Context, teamID string) (TeamSettings, error) {
+	var settings TeamSettings
+	err := ctl.db.WithContext(ctx).Where("team_id = ?", teamID).First(&settings).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return DefaultTeamSettings(teamID), nil
+	}
+	return settings, err
+}
+
+// isWorkspaceAdmin looks the user up with users.info, so the check can't be spoofed through the request payload
+func isWorkspaceAdmin(ctx context.Context, api *slack.Client, userID string) (bool, error) {
+	user, err := api.GetUserInfoContext(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return user.IsAdmin || user.IsOwner || user.IsPrimaryOwner, nil
+}
+
+// adminSlackClient returns a client for the workspace, or errNotWorkspaceAdmin if userID is not an admin of it
+func (ctl *PublicController) adminSlackClient(ctx context.Context, teamID string, enterpriseID string, userID string) (*slack.Client, error) {
+	team, err := ctl.findInstallation(ctx, teamID, enterpriseID)
+	if err != nil {
+		return nil, err
+	}
+	api, err := ctl.slackClientForTeam(ctx, team)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := isWorkspaceAdmin(ctx, api, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !admin {
+		return nil, errNotWorkspaceAdmin
+	}
+	return api, nil
+}
+
+func teamSettingsModal(settings TeamSettings, webhookSecret string, locale string) slack.ModalViewRequest {
+	defaultExpiry := slack.NewNumberInputBlockElement(nil, "default_expiry_days_input", false).
+		WithInitialValue(strconv.Itoa(settings.DefaultExpiryDays)).
+		WithMinValue("1").
+		WithMaxValue(strconv.Itoa(MaxExpiryDaysLimit))
+	maxExpiry := slack.NewNumberInputBlockElement(nil, "max_expiry_days_input", false).
+		WithInitialValue(strconv.Itoa(settings.MaxExpiryDays)).
+		WithMinValue("1").
+		WithMaxValue(strconv.Itoa(MaxExpiryDaysLimit))
+	maxLength := slack.NewNumberInputBlockElement(nil, "max_secret_length_input", false).
+		WithInitialValue(strconv.Itoa(settings.MaxSecretLength)).
+		WithMinValue("1").
+		WithMaxValue(strconv.Itoa(MaxSecretLengthLimit))
+	maxFileSize := slack.NewNumberInputBlockElement(nil, "max_file_size_mb_input", false).
+		WithInitialValue(strconv.Itoa(int(settings.MaxFileSize() >> 20))).
+		WithMinValue("1").
+		WithMaxValue(strconv.Itoa(MaxFileSizeMBLimit))
+
+	allowInline := slack.NewOptionBlockObject(settingsOptionAllowInline,
+		slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.allow_inline"), false, false),
+		slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.allow_inline_description"), false, false))
+	requireRecipients := slack.NewOptionBlockObject(settingsOptionRequireRecipients,
+		slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.require_recipients"), false, false),
+		slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.require_recipients_description"), false, false))
+	readReceipt := slack.NewOptionBlockObject(settingsOptionReadReceiptDefault,
+		slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.read_receipt_default"), false, false),
+		slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.read_receipt_default_description"), false, false))
+	templates := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.templates_placeholder"), false, false), "templates_input")
+	templates.Multiline = true
+	templates.InitialValue = formatTemplates(settings.Templates)
+	webhookURL := slack.NewURLTextInputBlockElement(slack.NewTextBlockObject("plain_text", "https://siem.example.com/secretmessage", false, false), "webhook_url_input")
+	webhookURL.InitialValue = settings.WebhookURL
+	webhookHint := secreti18n.T(locale, "settings_modal.webhook_url_hint")
+	if webhookSecret != "" {
+		webhookHint = secreti18n.T(locale, "settings_modal.webhook_signed_hint", maskWebhookSecret(webhookSecret))
+	}
+
+	var languages []*slack.OptionBlockObject
+	var language *slack.OptionBlockObject
+	for _, locale := range secreti18n.Locales() {
+		option := slack.NewOptionBlockObject(locale, slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "language.name"), false, false), nil)
+		languages = append(languages, option)
+		if locale == settings.Language() {
+			language = option
+		}
+	}
+	languageSelect := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, nil, "locale_input", languages...)
+	languageSelect.InitialOption = language
+
+	options := slack.NewCheckboxGroupsBlockElement("options_input", allowInline, requireRecipients, readReceipt)
+	if settings.AllowInlineSecret {
+		options.InitialOptions = append(options.InitialOptions, allowInline)
+	}
+	if settings.RequireRecipients {
+		options.InitialOptions = append(options.InitialOptions, requireRecipients)
+	}
+	if settings.ReadReceiptDefault {
+		options.InitialOptions = append(options.InitialOptions, readReceipt)
+	}
+
+	modal := slack.ModalViewRequest{
+		Type:       slack.VTModal,
+		CallbackID: actions.TeamSettingsModal,
+		Title:      slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.title"), false, false),
+		Close:      slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "modal.cancel"), false, false),
+		Submit:     slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.submit"), false, false),
+		Blocks: slack.Blocks{
+			BlockSet: []slack.Block{
+				slack.NewInputBlock(
+					"default_expiry_days_input",
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.default_expiry_label"), false, false),
+					nil,
+					defaultExpiry,
+				),
+				slack.NewInputBlock(
+					"max_expiry_days_input",
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.max_expiry_label"), false, false),
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.max_expiry_hint", MaxExpiryDaysLimit), false, false),
+					maxExpiry,
+				),
+				slack.NewInputBlock(
+					"max_secret_length_input",
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.max_length_label"), false, false),
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.max_length_hint", MaxSecretLengthLimit), false, false),
+					maxLength,
+				),
+				slack.NewInputBlock(
+					"max_file_size_mb_input",
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.max_file_size_label"), false, false),
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.max_file_size_hint", MaxFileSizeMBLimit), false, false),
+					maxFileSize,
+				),
+				slack.NewInputBlock(
+					"locale_input",
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.language_label"), false, false),
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.language_hint"), false, false),
+					languageSelect,
+				),
+				slack.NewInputBlock(
+					"options_input",
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.options_label"), false, false),
+					nil,
+					options,
+				).WithOptional(true),
+				slack.NewInputBlock(
+					"templates_input",
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.templates_label"), false, false),
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.templates_hint", MaxTemplates, MaxTemplateFields), false, false),
+					templates,
+				).WithOptional(true),
+				slack.NewInputBlock(
+					"webhook_url_input",
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.webhook_url_label"), false, false),
+					slack.NewTextBlockObject("plain_text", webhookHint, false, false),
+					webhookURL,
+				).WithOptional(true),
+			},
+		},
+	}
+	// The signing secret is only shown when it's made, so admins who lost it make a new one
+	if webhookSecret != "" {
+		rotate := slack.NewOptionBlockObject(settingsOptionRotateWebhook,
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.webhook_rotate"), false, false),
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.webhook_rotate_description"), false, false))
+		modal.Blocks.BlockSet = append(modal.Blocks.BlockSet, slack.NewInputBlock(
+			"webhook_rotate_input",
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "settings_modal.webhook_rotate_label"), false, false),
+			nil,
+			slack.NewCheckboxGroupsBlockElement("webhook_rotate_input", rotate),
+		).WithOptional(true))
+	}
+	return modal
+}
+
+// maskWebhookSecret hides all but the end of a webhook signing secret, enough to tell secrets apart
+func maskWebhookSecret(secret string) string {
+	if len(secret) < 16 {
+		return "••••"
+	}
+	return "whsec_••••" + secret[len(secret)-4:]
+}
+
+// PromptTeamSettingsModal opens the workspace settings modal for workspace admins
+func PromptTeamSettingsModal(ctl *PublicController, ctx context.Context, s slack.SlashCommand) error {
+	api, err := ctl.adminSlackClient(ctx, s.TeamID, s.EnterpriseID, s.UserID)
+	if err != nil {
+		return err
+	}
+	settings, err := ctl.teamSettings(ctx, s.TeamID)
+	if err != nil {
+		ctl.logger.Error("error getting team settings", zap.Error(err), zap.String("teamID", s.TeamID))
+		return err
+	}
+	webhookSecret, err := ctl.openAtRest(settings.WebhookSecret)
+	if err != nil {
+		ctl.logger.Error("error decrypting webhook secret", zap.Error(err), zap.String("teamID", s.TeamID))
+		return err
+	}
+	locale := ctl.cachedUserLocale(ctx, s.TeamID, s.UserID)
+	_, err = api.OpenViewContext(ctx, s.TriggerID, teamSettingsModal(settings, webhookSecret, locale))
+	if err != nil {
+		ctl.logger.Error("error opening settings modal", zap.Error(err), zap.String("teamID", s.TeamID), zap.String("triggerID", s.TriggerID))
+		return err
+	}
+	return nil
+}
+
+// parseTeamSettingsSubmission reads the settings modal state, returning per-block validation errors
+// in locale
+func parseTeamSettingsSubmission(teamID string, state *slack.ViewState, allowPrivateWebhooks bool, locale string) (TeamSettings, map[string]string) {
+	settings := DefaultTeamSettings(teamID)
+	validationErrors := map[string]string{}
+
+	numbers := []struct {
+		blockID string
+		target  *int
+		max     int
+	}{
+		{"default_expiry_days_input", &settings.DefaultExpiryDays, MaxExpiryDaysLimit},
+		{"max_expiry_days_input", &settings.MaxExpiryDays, MaxExpiryDaysLimit},
+		{"max_secret_length_input", &settings.MaxSecretLength, MaxSecretLengthLimit},
+		{"max_file_size_mb_input", &settings.MaxFileSizeMB, MaxFileSizeMBLimit},
+	}
+	for _, n := range numbers {
+		v, err := strconv.Atoi(state.Values[n.blockID][n.blockID].Value)
+		if err != nil || v < 1 || v > n.max {
+			validationErrors[n.blockID] = secreti18n.T(locale, "validation.whole_number", n.max)
+			continue
+		}
+		*n.target = v
+	}
+	if _, ok := validationErrors["default_expiry_days_input"]; !ok && settings.DefaultExpiryDays > settings.MaxExpiryDays {
+		validationErrors["default_expiry_days_input"] = secreti18n.T(locale, "validation.default_expiry_too_long")
+	}
+
+	settings.WebhookURL = strings.TrimSpace(state.Values["webhook_url_input"]["webhook_url_input"].Value)
+	if err := validateWebhookURL(settings.WebhookURL, allowPrivateWebhooks); errors.Is(err, errPrivateWebhookURL) {
+		validationErrors["webhook_url_input"] = secreti18n.T(locale, "validation.webhook_url_private")
+	} else if err != nil {
+		validationErrors["webhook_url_input"] = secreti18n.T(locale, "validation.webhook_url")
+	}
+
+	templates, templatesErr := parseTemplates(state.Values["templates_input"]["templates_input"].Value, locale)
+	if templatesErr != "" {
+		validationErrors["templates_input"] = templatesErr
+	}
+	settings.Templates = templates
+
+	settings.Locale = secreti18n.Supported(state.Values["locale_input"]["locale_input"].SelectedOption.Value)
+
+	settings.AllowInlineSecret = false
+	for _, option := range state.Values["options_input"]["options_input"].SelectedOptions {
+		switch option.Value {
+		case settingsOptionAllowInline:
+			settings.AllowInlineSecret = true
+		case settingsOptionRequireRecipients:
+			settings.RequireRecipients = true
+		case settingsOptionReadReceiptDefault:
+			settings.ReadReceiptDefault = true
+		}
+	}
+	return settings, validationErrors
+}
+
+// CallbackTeamSettingsSubmission saves the settings modal after re-checking that the submitter is an admin
+func CallbackTeamSettingsSubmission(ctl *PublicController, c *gin.Context, i slack.InteractionCallback) {
+	hc := c.Request.Context()
+	locale := ctl.userLocale(hc, i.Team.ID, i.Enterprise.ID, i.User.ID)
+	settings, validationErrors := parseTeamSettingsSubmission(i.Team.ID, i.View.State, ctl.config.AllowPrivateWebhooks, locale)
+
+	_, err := ctl.adminSlackClient(hc, i.Team.ID, i.Enterprise.ID, i.User.ID)
+	var ue userError
+	if errors.As(err, &ue) {
+		validationErrors["default_expiry_days_input"] = ue.text(locale)
+	} else if err != nil {
+		ctl.logger.Error("error checking admin for settings submission", zap.Error(err), zap.String("teamID", i.Team.ID), zap.String("userID", i.User.ID))
+		respondViewErrors(c, map[string]string{"default_expiry_days_input": viewSubmissionFailed(locale)})
+		return
+	}
+
+	if len(validationErrors) > 0 {
+		respondViewErrors(c, validationErrors)
+		return
+	}
+
+	// Keep the webhook's signing secret while it has a URL, so receivers don't need reconfiguring
+	existing, err := ctl.teamSettings(hc, i.Team.ID)
+	if err != nil {
+		ctl.logger.Error("error getting team settings", zap.Error(err), zap.String("teamID", i.Team.ID))
+		respondViewErrors(c, map[string]string{"default_expiry_days_input": viewSubmissionFailed(locale)})
+		return
+	}
+	var newWebhookSecretPlain string
+	settings.WebhookSecret = existing.WebhookSecret
+	rotate := len(i.View.State.Values["webhook_rotate_input"]["webhook_rotate_input"].SelectedOptions) > 0
+	switch {
+	case settings.WebhookURL == "":
+		settings.WebhookSecret = ""
+	case existing.WebhookSecret == "" || rotate:
+		newWebhookSecretPlain = newWebhookSecret()
+		settings.WebhookSecret, err = ctl.sealAtRest(newWebhookSecretPlain)
+		if err != nil {
+			ctl.logger.Error("error encrypting webhook secret", zap.Error(err), zap.String("teamID", i.Team.ID))
+			respondViewErrors(c, map[string]string{"default_expiry_days_input": viewSubmissionFailed(locale)})
+			return
+		}
+	}
+
+	err = ctl.db.WithContext(hc).
+		Where(TeamSettings{TeamID: i.Team.ID}).
+		Assign(map[string]interface{}{
+			"default_expiry_days":  settings.DefaultExpiryDays,
+			"max_expiry_days":      settings.MaxExpiryDays,
+			"max_secret_length":    settings.MaxSecretLength,
+			"max_file_size_mb":     settings.MaxFileSizeMB,
+			"allow_inline_secret":  settings.AllowInlineSecret,
+			"require_recipients":   settings.RequireRecipients,
+			"read_receipt_default": settings.ReadReceiptDefault,
+			"locale":               settings.Locale,
+			"templates":            settings.Templates,
+			"webhook_url":          settings.WebhookURL,
+			"webhook_secret":       settings.WebhookSecret,
+		}).
+		FirstOrCreate(&TeamSettings{}).Error
+	if err != nil {
+		ctl.logger.Error("error saving team settings", zap.Error(err), zap.String("teamID", i.Team.ID))
+		respondViewErrors(c, map[string]string{"default_expiry_days_input": viewSubmissionFailed(locale)})
+		return
+	}
+	ctl.logger.Info("team settings updated", zap.String("teamID", i.Team.ID), zap.String("userID", i.User.ID))
+
+	if newWebhookSecretPlain != "" {
+		// Show the new signing secret once, so the admin can configure their receiver
+		respondViewUpdate(c, webhookSecretModal(newWebhookSecretPlain, locale))
+		return
+	}
+	c.Data(http.StatusOK, gin.MIMEPlain, nil)
+}
+
+func webhookSecretModal(secret string, locale string) *slack.ModalViewRequest {
+	return &slack.ModalViewRequest{
+		Type:  slack.VTModal,
+		Title: slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "webhook_modal.title"), false, false),
+		Close: slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "modal.done"), false, false),
+		Blocks: slack.Blocks{
+			BlockSet: []slack.Block{
+				slack.NewSectionBlock(
+					slack.NewTextBlockObject("mrkdwn", secreti18n.T(locale, "webhook_modal.text", secret), false, false),
+					nil, nil),
+			},
+		},
+	}
+}
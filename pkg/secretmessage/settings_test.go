@@ -0,0 +1,80 @@
+package secretmessage
+
+import (
+	"testing"
+
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func settingsState(defaultExpiry, maxExpiry, maxLength string, options ...string) *slack.ViewState {
+	selected := make([]slack.OptionBlockObject, len(options))
+	for i, o := range options {
+		selected[i] = slack.OptionBlockObject{Value: o}
+	}
+	return &slack.ViewState{
+		Values: map[string]map[string]slack.BlockAction{
+			"default_expiry_days_input": {"default_expiry_days_input": {Value: defaultExpiry}},
+			"max_expiry_days_input":     {"max_expiry_days_input": {Value: maxExpiry}},
+			"max_secret_length_input":   {"max_secret_length_input": {Value: maxLength}},
+			"max_file_size_mb_input":    {"max_file_size_mb_input": {Value: "10"}},
+			"options_input":             {"options_input": {SelectedOptions: selected}},
+		},
+	}
+}
+
+func TestParseTeamSettingsSubmission(t *testing.T) {
+	settings, errs := parseTeamSettingsSubmission("T1", settingsState("3", "14", "500", settingsOptionRequireRecipients), false, secreti18n.DefaultLocale)
+	assert.Empty(t, errs)
+	assert.Equal(t, "T1", settings.TeamID)
+	assert.Equal(t, 3, settings.DefaultExpiryDays)
+	assert.Equal(t, 14, settings.MaxExpiryDays)
+	assert.Equal(t, 500, settings.MaxSecretLength)
+	assert.Equal(t, 10, settings.MaxFileSizeMB)
+	assert.False(t, settings.AllowInlineSecret)
+	assert.True(t, settings.RequireRecipients)
+	assert.False(t, settings.ReadReceiptDefault)
+}
+
+func TestParseTeamSettingsSubmission_Locale(t *testing.T) {
+	state := settingsState("3", "14", "500")
+	state.Values["locale_input"] = map[string]slack.BlockAction{"locale_input": {SelectedOption: slack.OptionBlockObject{Value: "fr"}}}
+	settings, errs := parseTeamSettingsSubmission("T1", state, false, secreti18n.DefaultLocale)
+	assert.Empty(t, errs)
+	assert.Equal(t, "fr", settings.Locale)
+
+	settings, _ = parseTeamSettingsSubmission("T1", settingsState("3", "14", "500"), false, secreti18n.DefaultLocale)
+	assert.Equal(t, "", settings.Locale)
+	assert.Equal(t, "en", settings.Language(), "no language means the default one")
+}
+
+func TestParseTeamSettingsSubmission_Validation(t *testing.T) {
+	tests := []struct {
+		name  string
+		state *slack.ViewState
+		want  []string
+	}{
+		{"not a number", settingsState("abc", "14", "500"), []string{"default_expiry_days_input"}},
+		{"expiry above limit", settingsState("7", "91", "500"), []string{"max_expiry_days_input"}},
+		{"length above limit", settingsState("7", "30", "40001"), []string{"max_secret_length_input"}},
+		{"zero", settingsState("7", "30", "0"), []string{"max_secret_length_input"}},
+		{"default after max", settingsState("20", "10", "500"), []string{"default_expiry_days_input"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := parseTeamSettingsSubmission("T1", tt.state, false, secreti18n.DefaultLocale)
+			for _, blockID := range tt.want {
+				assert.Contains(t, errs, blockID)
+			}
+			assert.Len(t, errs, len(tt.want))
+		})
+	}
+}
+
+func TestFormatThousands(t *testing.T) {
+	assert.Equal(t, "7", formatThousands(7))
+	assert.Equal(t, "999", formatThousands(999))
+	assert.Equal(t, "10,000", formatThousands(10000))
+	assert.Equal(t, "1,234,567", formatThousands(1234567))
+}
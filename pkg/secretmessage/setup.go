@@ -1,6 +1,7 @@
 package secretmessage
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -30,3 +31,16 @@ func (ctl *PublicController) StayAwake() {
 		}
 	}
 }
+
+// RetireExpiredSecretsEvery runs RetireExpiredSecrets on an interval, for as long as the process runs
+func (ctl *PublicController) RetireExpiredSecretsEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		retired, err := ctl.RetireExpiredSecrets(context.Background())
+		if err != nil {
+			ctl.logger.Error("Error retiring expired secrets", zap.Error(err))
+		} else if retired > 0 {
+			ctl.logger.Info("Retired expired secrets", zap.Int("count", retired))
+		}
+	}
+}
@@ -1,23 +1,54 @@
 package secretmessage
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"crypto/rand"
 
 	"github.com/gin-gonic/gin"
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
 	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage/actions"
 	"github.com/slack-go/slack"
 	"go.uber.org/zap"
 )
 
+// readReceiptOptionValue is the value of the create modal's read receipt checkbox
+const readReceiptOptionValue = "read_receipt"
+
 // PrepareAndSendSecretEnvelope encrypts the secret, stores in db, and sends the 'envelope' back to slack
-func PrepareAndSendSecretEnvelope(ctl *PublicController, c *gin.Context, secretText string, TeamID string, UserName string, ResponseUrl string, options ...SecretOption) error {
-	hc := c.Request.Context()
+func PrepareAndSendSecretEnvelope(ctl *PublicController, ctx context.Context, secretText string, TeamID string, settings TeamSettings, ResponseUrl string, options ...SecretOption) error {
+
+	secretID, sec, err := ctl.storeSecret(ctx, secretText, TeamID, settings, options...)
+	if err != nil {
+		return err
+	}
+
+	secretResponse := secretEnvelope(sec, secretID, "", settings.Language())
+	secretResponse.ResponseType = slack.ResponseTypeInChannel
+
+	sendMessageErr := ctl.slackService.SendResponseUrlMessage(ctx, ResponseUrl, secretResponse)
+	if sendMessageErr != nil {
+		ctl.logger.Error("error sending secret to slack", zap.Error(sendMessageErr), zap.String("secretID", secretID))
+		return sendMessageErr
+	}
+
+	return nil
+
+}
 
+// storeSecret encrypts the secret with a new key and stores it with the team's settings applied. It
+// returns the key, which only the envelope's read button carries.
+func (ctl *PublicController) storeSecret(ctx context.Context, secretText string, TeamID string, settings TeamSettings, options ...SecretOption) (string, *Secret, error) {
+	if err := validateSecretText(secretText, settings); err != nil {
+		return "", nil, err
+	}
 	secretID := rand.Text()
 
 	secretEncrypted, encryptErr := encrypt(secretText, secretID)
@@ -25,94 +56,248 @@ func PrepareAndSendSecretEnvelope(ctl *PublicController, c *gin.Context, secretT
 	if encryptErr != nil {
 
 		ctl.logger.Error("error encrypting secret", zap.Error(encryptErr), zap.String("secretID", secretID))
-		return encryptErr
+		return "", nil, encryptErr
 	}
 
-	sec := NewSecret(hash(secretID), secretEncrypted, options...)
+	sec, err := ctl.saveSecret(ctx, hash(secretID), secretEncrypted, TeamID, settings, options...)
+	if err != nil {
+		return "", nil, err
+	}
+	return secretID, sec, nil
+}
+
+// saveSecret stores an already encrypted secret with the team's settings applied and records its creation
+func (ctl *PublicController) saveSecret(ctx context.Context, id string, value string, TeamID string, settings TeamSettings, options ...SecretOption) (*Secret, error) {
+	sec := NewSecret(id, value, append([]SecretOption{WithTeamID(TeamID), WithTeamSettings(settings)}, options...)...)
 	// Store the secret
-	storeErr := ctl.db.WithContext(hc).Create(sec).Error
+	storeErr := ctl.db.WithContext(ctx).Create(sec).Error
 
 	if storeErr != nil {
 
-		ctl.logger.Error("error storing secret in database", zap.Error(storeErr), zap.String("secretID", secretID))
-		return storeErr
+		ctl.logger.Error("error storing secret in database", zap.Error(storeErr), zap.String("teamID", TeamID))
+		return nil, storeErr
 	}
+	ctl.recordSecretEvent(ctx, AuditSecretCreated, TeamID, sec.ID, sec.SenderID, sec.ChannelID)
 
-	footerMsg := fmt.Sprintf("Message expires <!date^%d^{date_pretty}|%s>", sec.ExpiresAt.Unix(), sec.ExpiresAt.Format("2006-01-02 15:04 MST"))
+	return sec, nil
+}
+
+// senderMention names a secret's sender in messages. Slack users are mentioned by ID, which Slack
+// renders with their current name and can't be spoofed by changing it. Senders that aren't users, like
+// API tokens, are named by senderName.
+func senderMention(sec *Secret, senderName string, locale string) string {
+	switch {
+	case sec.SenderID != "" && !strings.Contains(sec.SenderID, ":"):
+		return fmt.Sprintf("<@%s>", sec.SenderID)
+	case senderName != "":
+		return escapeMrkdwn(senderName)
+	default:
+		return secreti18n.T(locale, "sender.someone")
+	}
+}
+
+// escapeMrkdwn escapes the characters Slack reads as control sequences in message text
+func escapeMrkdwn(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// envelopeText is an envelope's text, attributing it to its sender above what restrictions it has
+func envelopeText(attribution string, details string) string {
+	if details == "" {
+		return "*" + attribution + "*"
+	}
+	return "*" + attribution + "*\n" + details
+}
+
+// secretEnvelope is the message holding the button that reveals a secret. It is seen by everyone in the
+// conversation, so it is in the workspace's language.
+func secretEnvelope(sec *Secret, secretID string, senderName string, locale string) slack.Message {
+	footerMsg := secreti18n.T(locale, "envelope.expires", fmt.Sprintf("<!date^%d^{date_pretty}|%s>", sec.ExpiresAt.Unix(), sec.ExpiresAt.Format("2006-01-02 15:04 MST")))
+	attribution := secreti18n.T(locale, "envelope.sent_message", senderMention(sec, senderName, locale))
 
-	secretResponse := slack.Message{
+	return slack.Message{
 		Msg: slack.Msg{
-			ResponseType: slack.ResponseTypeInChannel,
 			Attachments: []slack.Attachment{{
-				Title:      fmt.Sprintf("%v sent a secret message", UserName),
-				Fallback:   fmt.Sprintf("%v sent a secret message", UserName),
+				Fallback:   attribution,
+				Text:       envelopeText(attribution, recipientsText(sec.Recipients, locale)),
+				MarkdownIn: []string{"text"},
 				CallbackID: fmt.Sprintf("%s:%v", actions.ReadMessage, secretID),
 				Color:      "#6D5692",
 				Footer:     footerMsg,
 				Actions: []slack.AttachmentAction{{
 					Name:  "readMessage",
-					Text:  ":envelope: Read message",
+					Text:  secreti18n.T(locale, "envelope.read_button"),
 					Type:  "button",
 					Value: "readMessage",
 				}},
 			}},
 		},
 	}
+}
 
-	sendMessageErr := ctl.slackService.SendResponseUrlMessage(hc, ResponseUrl, secretResponse)
-	if sendMessageErr != nil {
-		ctl.logger.Error("error sending secret to slack", zap.Error(sendMessageErr), zap.String("secretID", secretID))
-		return sendMessageErr
+// webLinkEnvelope is the message holding a button that opens a web link secret's page
+func webLinkEnvelope(sec *Secret, link string, senderName string, locale string) slack.Message {
+	footerMsg := secreti18n.T(locale, "web_link.expires", fmt.Sprintf("<!date^%d^{date_pretty}|%s>", sec.ExpiresAt.Unix(), sec.ExpiresAt.Format("2006-01-02 15:04 MST")))
+	attribution := secreti18n.T(locale, "envelope.sent_message", senderMention(sec, senderName, locale))
+	text := secreti18n.T(locale, "web_link.message_text")
+	button := secreti18n.T(locale, "web_link.open_button")
+	if sec.File {
+		attribution = secreti18n.T(locale, "envelope.sent_file", senderMention(sec, senderName, locale))
+		text = secreti18n.T(locale, "web_link.file_text")
+		button = secreti18n.T(locale, "web_link.download_button")
 	}
 
-	return nil
+	return slack.Message{
+		Msg: slack.Msg{
+			Attachments: []slack.Attachment{{
+				Fallback:   attribution,
+				Text:       envelopeText(attribution, text),
+				MarkdownIn: []string{"text"},
+				Color:      "#6D5692",
+				Footer:     footerMsg,
+				Actions: []slack.AttachmentAction{{
+					Name: "openMessage",
+					Text: button,
+					Type: "button",
+					URL:  link,
+				}},
+			}},
+		},
+	}
+}
 
+// recipientsText names the people allowed to read a secret, if it is restricted
+func recipientsText(recipients []string, locale string) string {
+	if len(recipients) == 0 {
+		return ""
+	}
+	return secreti18n.T(locale, "envelope.recipients", userMentions(recipients))
 }
 
-// PromptCreateSecretModal encrypts the secret, stores in db, and sends the 'envelope' back to slack
-func PromptCreateSecretModal(ctl *PublicController, c *gin.Context, s slack.SlashCommand) error {
+// responseURLLifetime is how long after a command Slack accepts messages to its response URL
+const responseURLLifetime = 30 * time.Minute
 
-	datePicker := slack.NewDatePickerBlockElement("expiry_date_input")
-	datePicker.InitialDate = time.Now().AddDate(0, 0, 7).Format("2006-01-02")
+// modalMetadata is the private metadata of the create modal, saying where the command that opened it
+// was run, so the secret can be sent there
+type modalMetadata struct {
+	ResponseURL string `json:"response_url"`
+	ChannelID   string `json:"channel_id"`
+	// OpenedAt is when the modal was opened, as a unix timestamp
+	OpenedAt int64 `json:"opened_at"`
+	// Template is the name of the template chosen in the modal, and TemplateFields its fields when it
+	// was chosen, so the submission matches the inputs shown even if admins change the template
+	Template       string   `json:"template,omitempty"`
+	TemplateFields []string `json:"template_fields,omitempty"`
+	// Generate is generatePassword or generatePassphrase when the secret is generated rather than typed
+	Generate string `json:"generate,omitempty"`
+}
 
-	textInput := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject("plain_text", "Enter your secret...", false, false), "secret_text_input")
-	textInput.Multiline = true
-	modalRequest := slack.ModalViewRequest{
-		Type:            slack.VTModal,
-		Title:           slack.NewTextBlockObject("plain_text", "Send a Secret", false, false),
-		Close:           slack.NewTextBlockObject("plain_text", "Cancel", false, false),
-		Submit:          slack.NewTextBlockObject("plain_text", "Send", false, false),
-		PrivateMetadata: s.ResponseURL,
-		Blocks: slack.Blocks{
-			BlockSet: []slack.Block{
-				slack.NewInputBlock(
-					"secret_text_input",
-					slack.NewTextBlockObject("plain_text", "Secret Text", false, false),
-					slack.NewTextBlockObject("plain_text", "Max 10,000 characters", false, false),
-					textInput,
-				),
-				slack.NewInputBlock(
-					"expiry_date_input",
-					slack.NewTextBlockObject("plain_text", "Secret Expiry", false, false),
-					slack.NewTextBlockObject("plain_text", "Expiry date is limited to a maximum of 30 days from today", false, false),
-					datePicker,
-				),
-			},
-		},
+func newModalMetadata(s slack.SlashCommand) modalMetadata {
+	return modalMetadata{ResponseURL: s.ResponseURL, ChannelID: s.ChannelID, OpenedAt: time.Now().Unix()}
+}
+
+// parseModalMetadata reads a modal's private metadata. Modals opened before it was JSON only hold the
+// response URL.
+func parseModalMetadata(privateMetadata string) modalMetadata {
+	var meta modalMetadata
+	if err := json.Unmarshal([]byte(privateMetadata), &meta); err != nil {
+		return modalMetadata{ResponseURL: privateMetadata}
+	}
+	return meta
+}
+
+func (m modalMetadata) String() string {
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+// secretBlockID is the block of the modal holding the secret, where errors about it are shown
+func (m modalMetadata) secretBlockID() string {
+	switch {
+	case m.Generate != "":
+		return "generate_length_input"
+	case m.Template != "":
+		return templateFieldBlockPrefix + "0"
+	}
+	return "secret_text_input"
+}
+
+// responseURLStale reports whether the response URL is known to have expired
+func (m modalMetadata) responseURLStale(now time.Time) bool {
+	return m.OpenedAt != 0 && now.Sub(time.Unix(m.OpenedAt, 0)) >= responseURLLifetime
+}
+
+// sendModalEnvelope sends the envelope of a secret created with the modal to the conversation the modal
+// was opened from. Response URLs expire after 30 minutes and five uses, so when it is stale or fails
+// the envelope is posted with the bot token instead.
+func (ctl *PublicController) sendModalEnvelope(ctx context.Context, teamID string, enterpriseID string, meta modalMetadata, envelope slack.Message) error {
+	if meta.ResponseURL != "" && !meta.responseURLStale(time.Now()) {
+		err := ctl.slackService.SendResponseUrlMessage(ctx, meta.ResponseURL, envelope)
+		if err == nil || meta.ChannelID == "" {
+			return err
+		}
+		ctl.logger.Warn("error sending secret to response url, posting it instead", zap.Error(err), zap.String("teamID", teamID), zap.String("channelID", meta.ChannelID))
+	}
+	if meta.ChannelID == "" {
+		return errors.New("response url expired and no channel to post the secret to")
+	}
+	team, err := ctl.findInstallation(ctx, teamID, enterpriseID)
+	if err != nil {
+		return err
 	}
+	api, err := ctl.slackClientForTeam(ctx, team)
+	if err != nil {
+		return err
+	}
+	_, _, err = api.PostMessageContext(ctx, meta.ChannelID, slack.MsgOptionAttachments(envelope.Attachments...))
+	return err
+}
+
+// userError is an error whose title and text are safe to show to the user in an ephemeral message. They
+// are the catalogue's error.<key>.title and error.<key>.text messages, translated when shown.
+type userError struct {
+	key  string
+	args []interface{}
+}
+
+func newUserError(key string, args ...interface{}) userError {
+	return userError{key: key, args: args}
+}
+
+func (e userError) title(locale string) string {
+	return secreti18n.T(locale, "error."+e.key+".title")
+}
 
-	team := Team{}
+func (e userError) text(locale string) string {
+	return secreti18n.T(locale, "error."+e.key+".text", e.args...)
+}
+
+func (e userError) Error() string {
+	return e.text(secreti18n.DefaultLocale)
+}
+
+// PromptCreateSecretModal encrypts the secret, stores in db, and sends the 'envelope' back to slack
+func PromptCreateSecretModal(ctl *PublicController, ctx context.Context, s slack.SlashCommand) error {
+	settings, err := ctl.teamSettings(ctx, s.TeamID)
+	if err != nil {
+		ctl.logger.Error("error getting team settings", zap.Error(err), zap.String("teamID", s.TeamID))
+		return err
+	}
+	modalRequest := createSecretModal(settings, newModalMetadata(s), ctl.cachedUserLocale(ctx, s.TeamID, s.UserID))
 
-	getTeamErr := ctl.db.Where(Team{ID: s.TeamID}).First(&team).Error
+	team, getTeamErr := ctl.findInstallation(ctx, s.TeamID, s.EnterpriseID)
 	if getTeamErr != nil {
 		ctl.logger.Error("error getting team for slash command", zap.Error(getTeamErr), zap.String("teamID", s.TeamID))
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
 		return getTeamErr
 	}
 
-	api := ctl.slackService.GetSlackClient(team.AccessToken)
+	api, err := ctl.slackClientForTeam(ctx, team)
+	if err != nil {
+		ctl.logger.Error("error building slack client for slash command", zap.Error(err), zap.String("teamID", s.TeamID))
+		return err
+	}
 
-	_, err := api.OpenView(s.TriggerID, modalRequest)
+	_, err = api.OpenViewContext(ctx, s.TriggerID, modalRequest)
 
 	if err != nil {
 		ctl.logger.Error("error opening modal for slash command", zap.Error(err), zap.String("teamID", s.TeamID), zap.String("triggerID", s.TriggerID))
@@ -122,55 +307,238 @@ func PromptCreateSecretModal(ctl *PublicController, c *gin.Context, s slack.Slas
 	return nil
 }
 
-// SlashSecret is the main entrypoint for the slash command /secret
-func SlashSecret(ctl *PublicController, c *gin.Context, s slack.SlashCommand) {
-	var err error
+// createSecretModal is the modal secrets are created with. Choosing to generate the secret, or one of
+// the workspace's templates, replaces the secret's text with inputs for what to generate or for each of
+// the template's fields.
+func createSecretModal(settings TeamSettings, meta modalMetadata, locale string) slack.ModalViewRequest {
+	datePicker := slack.NewDatePickerBlockElement("expiry_date_input")
+	datePicker.InitialDate = time.Now().AddDate(0, 0, settings.DefaultExpiryDays).Format("2006-01-02")
+
+	recipientsSelect := slack.NewOptionsMultiSelectBlockElement(slack.MultiOptTypeUser, slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.recipients_placeholder"), false, false), "recipients_input")
+	recipientsHint := secreti18n.T(locale, "create_modal.recipients_hint")
+	if settings.RequireRecipients {
+		recipientsHint = secreti18n.T(locale, "create_modal.recipients_hint_required")
+	}
+
+	readReceiptOption := slack.NewOptionBlockObject(readReceiptOptionValue, slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.read_receipt_option"), false, false), nil)
+	readReceiptCheckbox := slack.NewCheckboxGroupsBlockElement("read_receipt_input", readReceiptOption)
+	if settings.ReadReceiptDefault {
+		readReceiptCheckbox.InitialOptions = []*slack.OptionBlockObject{readReceiptOption}
+	}
+
+	secretInputs := templateInputs(meta.TemplateFields)
 	switch {
-	case strings.TrimSpace(s.Text) == "":
-		// If user provided no text, prompt them with modal
-		err = PromptCreateSecretModal(ctl, c, s)
-	default:
-		// If user provided text inline, do the old behaviour
-		err = PrepareAndSendSecretEnvelope(ctl, c, s.Text, s.TeamID, s.UserName, s.ResponseURL)
+	case meta.Generate != "":
+		secretInputs = generateInputs(meta.Generate == generatePassphrase, locale)
+	case meta.Template == "":
+		textInput := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.secret_placeholder"), false, false), "secret_text_input")
+		textInput.Multiline = true
+		secretInputs = []slack.Block{slack.NewInputBlock(
+			"secret_text_input",
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.secret_label"), false, false),
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.secret_hint", formatThousands(settings.MaxSecretLength)), false, false),
+			textInput,
+		)}
+	}
+	blocks := append([]slack.Block{templateSelect(settings.Templates, meta, locale)}, secretInputs...)
+	blocks = append(blocks,
+		slack.NewInputBlock(
+			"expiry_date_input",
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.expiry_label"), false, false),
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.expiry_hint", settings.MaxExpiryDays), false, false),
+			datePicker,
+		),
+		slack.NewInputBlock(
+			"recipients_input",
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.recipients_label"), false, false),
+			slack.NewTextBlockObject("plain_text", recipientsHint, false, false),
+			recipientsSelect,
+		).WithOptional(!settings.RequireRecipients),
+		slack.NewInputBlock(
+			"read_receipt_input",
+			slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.read_receipt_label"), false, false),
+			nil,
+			readReceiptCheckbox,
+		).WithOptional(true),
+	)
+
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      actions.CreateSecretModal,
+		Title:           slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.title"), false, false),
+		Close:           slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "modal.cancel"), false, false),
+		Submit:          slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.submit"), false, false),
+		PrivateMetadata: meta.String(),
+		Blocks:          slack.Blocks{BlockSet: blocks},
 	}
+}
+
+// SlashSecret is the main entrypoint for the slash command /secret. Slack only waits 3 seconds for a
+// command to be acknowledged, so it is acknowledged right away and run in the background. Errors are
+// sent back to the user through the command's response URL.
+func SlashSecret(ctl *PublicController, c *gin.Context, s slack.SlashCommand) {
+	err := ctl.jobs.Enqueue(c.Request.Context(), func(ctx context.Context) {
+		runSlashSecret(ctl, ctx, s)
+	})
 	if err != nil {
-		ctl.logger.Error("error processing slash command", zap.Error(err))
+		ctl.logger.Error("error queueing slash command", zap.Error(err), zap.String("teamID", s.TeamID))
+		// Looking up the user's language could take longer than Slack waits for the command
+		busy := newUserError("busy")
+		locale := ctl.teamLocale(c.Request.Context(), s.TeamID)
 		res, code := ctl.slackService.NewSlackErrorResponse(
-			":x: Sorry, an error occurred",
-			"An error occurred",
+			busy.title(locale),
+			busy.text(locale),
 			false,
 			"create_secret_error")
 		c.Data(code, gin.MIMEJSON, res)
 		c.Abort()
 		return
 	}
-	// Send empty Ack to Slack if we got here without errors
+	// Send empty Ack to Slack, the command's result goes to its response URL
 	c.Data(http.StatusOK, gin.MIMEPlain, nil)
+}
+
+// runSlashSecret does the work of a /secret command. Text naming a subcommand runs it rather than being
+// sent as a secret, so /secret generate explains how to send such text through the form when its
+// arguments aren't understood.
+func runSlashSecret(ctl *PublicController, ctx context.Context, s slack.SlashCommand) {
+	var err error
+	switch {
+	case strings.TrimSpace(s.Text) == "":
+		// If user provided no text, prompt them with modal
+		err = PromptCreateSecretModal(ctl, ctx, s)
+	case strings.TrimSpace(s.Text) == "settings":
+		err = PromptTeamSettingsModal(ctl, ctx, s)
+	case strings.TrimSpace(s.Text) == "split":
+		err = PromptSplitSecretModal(ctl, ctx, s)
+	case strings.TrimSpace(s.Text) == "link" || strings.HasPrefix(s.Text, "link "):
+		err = sendWebLinkSecret(ctl, ctx, s, strings.TrimSpace(strings.TrimPrefix(s.Text, "link")))
+	case strings.TrimSpace(s.Text) == "generate" || strings.HasPrefix(s.Text, "generate "):
+		err = sendGeneratedSecret(ctl, ctx, s, strings.TrimPrefix(s.Text, "generate"))
+	default:
+		// If user provided text inline, do the old behaviour
+		err = sendInlineSecret(ctl, ctx, s)
+	}
+	if err != nil {
+		ue := newUserError("generic")
+		if !errors.As(err, &ue) {
+			ctl.logger.Error("error processing slash command", zap.Error(err))
+		}
+		locale := ctl.userLocale(ctx, s.TeamID, s.EnterpriseID, s.UserID)
+		sendSlashError(ctl, ctx, s, ue.title(locale), ue.text(locale))
+		return
+	}
+
+	if AppReinstallNeeded(ctl, ctx, s) {
+		SendReinstallMessage(ctl, ctx, s)
+	}
+}
+
+// sendSlashError tells the user who ran a command, and only them, why it failed
+func sendSlashError(ctl *PublicController, ctx context.Context, s slack.SlashCommand, title string, text string) {
+	msg := ctl.slackService.NewSlackErrorMessage(title, text, false, "create_secret_error")
+	if err := ctl.slackService.SendResponseUrlMessage(ctx, s.ResponseURL, msg); err != nil {
+		ctl.logger.Error("error sending slash command error to slack", zap.Error(err), zap.String("teamID", s.TeamID))
+	}
+}
+
+// sendInlineSecret sends the text of /secret <text> as a secret, if the workspace settings allow it
+func sendInlineSecret(ctl *PublicController, ctx context.Context, s slack.SlashCommand) error {
+	settings, err := ctl.teamSettings(ctx, s.TeamID)
+	if err != nil {
+		return err
+	}
+	if err := inlineSecretError(settings, s.Text); err != nil {
+		return err
+	}
+	return PrepareAndSendSecretEnvelope(ctl, ctx, s.Text, s.TeamID, settings, s.ResponseURL, WithSenderID(s.UserID), WithChannelID(s.ChannelID), WithReadReceipt(settings.ReadReceiptDefault))
+}
+
+// inlineSecretError returns the userError explaining why the workspace settings don't allow sending
+// text from the command line as a secret, or nil if they do
+func inlineSecretError(settings TeamSettings, text string) error {
+	switch {
+	case !settings.AllowInlineSecret:
+		return newUserError("inline_disabled")
+	case settings.RequireRecipients:
+		return newUserError("recipients_required")
+	}
+	return validateSecretText(text, settings)
+}
+
+// sendWebLinkSecret stores the text of /secret link <text> as a secret and replies, only to the sender,
+// with a one-time web link for someone outside the workspace. The key is in the link's fragment, which
+// browsers never send to the server.
+func sendWebLinkSecret(ctl *PublicController, ctx context.Context, s slack.SlashCommand, text string) error {
+	if ctl.config.AppURL == "" {
+		return newUserError("web_links_unavailable")
+	}
+	if text == "" {
+		return newUserError("web_link_empty")
+	}
+	settings, err := ctl.teamSettings(ctx, s.TeamID)
+	if err != nil {
+		return err
+	}
+	if err := inlineSecretError(settings, text); err != nil {
+		return err
+	}
+
+	secretID, sec, err := ctl.storeSecret(ctx, text, s.TeamID, settings, WithSenderID(s.UserID), WithChannelID(s.ChannelID), WithWebLink(true), WithReadReceipt(settings.ReadReceiptDefault))
+	if err != nil {
+		return err
+	}
+	link := ctl.webLinkURL(sec.ID) + "#" + secretID
+	locale := ctl.userLocale(ctx, s.TeamID, s.EnterpriseID, s.UserID)
+	response := slack.Message{
+		Msg: slack.Msg{
+			ResponseType: slack.ResponseTypeEphemeral,
+			Attachments: []slack.Attachment{{
+				Title:    secreti18n.T(locale, "web_link.reply_title"),
+				Fallback: secreti18n.T(locale, "web_link.reply_title"),
+				Text:     secreti18n.T(locale, "web_link.reply_text", link),
+				Color:    "#6D5692",
+				Footer:   secreti18n.T(locale, "web_link.expires", fmt.Sprintf("<!date^%d^{date_pretty}|%s>", sec.ExpiresAt.Unix(), sec.ExpiresAt.Format("2006-01-02 15:04 MST"))),
+			}},
+		},
+	}
+	if err := ctl.slackService.SendResponseUrlMessage(ctx, s.ResponseURL, response); err != nil {
+		ctl.logger.Error("error sending web link to slack", zap.Error(err), zap.String("teamID", s.TeamID))
+		return err
+	}
+	return nil
+}
 
-	if AppReinstallNeeded(ctl, c, s) {
-		SendReinstallMessage(ctl, c, s)
+// formatThousands formats n with comma thousands separators, e.g. 10,000
+func formatThousands(n int) string {
+	digits := strconv.Itoa(n)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
 	}
+	return b.String()
 }
 
-func AppReinstallNeeded(ctl *PublicController, c *gin.Context, s slack.SlashCommand) bool {
-	var team Team
-	hc := c.Request.Context()
-	err := ctl.db.WithContext(hc).Where("id = ?", s.TeamID).First(&team).Error
+func AppReinstallNeeded(ctl *PublicController, ctx context.Context, s slack.SlashCommand) bool {
+	team, err := ctl.findInstallation(ctx, s.TeamID, s.EnterpriseID)
 	if err != nil || team.AccessToken == "" {
-		ctl.logger.Warn("App reinstall needed", zap.String("teamID", s.TeamID), zap.Error(err))
+		ctl.logger.Warn("App reinstall needed", zap.String("teamID", s.TeamID), zap.String("enterpriseID", s.EnterpriseID), zap.Error(err))
 		return true
 	}
 	return false
 }
 
-func SendReinstallMessage(ctl *PublicController, c *gin.Context, s slack.SlashCommand) {
+func SendReinstallMessage(ctl *PublicController, ctx context.Context, s slack.SlashCommand) {
 	responseEphemeral := slack.Message{
 		Msg: slack.Msg{
 			ResponseType: slack.ResponseTypeEphemeral,
-			Text:         fmt.Sprintf(":wave: Hey, we're working hard updating Secret Message. In order to keep using the app, <%v/auth/slack|please click here to reinstall>", ctl.config.AppURL),
+			Text:         secreti18n.T(ctl.userLocale(ctx, s.TeamID, s.EnterpriseID, s.UserID), "reinstall.text", ctl.config.AppURL),
 		},
 	}
-	sendMessageEphemeralErr := ctl.slackService.SendResponseUrlMessage(c.Request.Context(), s.ResponseURL, responseEphemeral)
+	sendMessageEphemeralErr := ctl.slackService.SendResponseUrlMessage(ctx, s.ResponseURL, responseEphemeral)
 	if sendMessageEphemeralErr != nil {
 		ctl.logger.Error("error sending ephemeral reinstall message", zap.Error(sendMessageEphemeralErr), zap.String("teamID", s.TeamID))
 	}
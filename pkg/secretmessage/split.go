@@ -0,0 +1,476 @@
+package secretmessage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage/actions"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretshamir"
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// A split secret is encrypted like any other, then its key is split with Shamir's scheme and each
+// recipient gets one share in a DM. The server only keeps a hash of each share. Recipients submit
+// their share in a modal and whoever submits the share that reaches the threshold sees the secret.
+
+const (
+	// MaxSplitRecipients is the most people a secret can be split between
+	MaxSplitRecipients = 10
+	// splitSharePrefix starts every share, so a share is recognisable when it's pasted
+	splitSharePrefix = "smshare-"
+)
+
+var errSplitSecretGone = newUserError("split_secret_gone")
+
+// PromptSplitSecretModal opens the form for splitting a secret between several recipients
+func PromptSplitSecretModal(ctl *PublicController, ctx context.Context, s slack.SlashCommand) error {
+	settings, err := ctl.teamSettings(ctx, s.TeamID)
+	if err != nil {
+		ctl.logger.Error("error getting team settings", zap.Error(err), zap.String("teamID", s.TeamID))
+		return err
+	}
+	team, err := ctl.findInstallation(ctx, s.TeamID, s.EnterpriseID)
+	if err != nil {
+		ctl.logger.Error("error getting team for slash command", zap.Error(err), zap.String("teamID", s.TeamID))
+		return err
+	}
+	api, err := ctl.slackClientForTeam(ctx, team)
+	if err != nil {
+		ctl.logger.Error("error building slack client for slash command", zap.Error(err), zap.String("teamID", s.TeamID))
+		return err
+	}
+	locale := ctl.cachedUserLocale(ctx, s.TeamID, s.UserID)
+	if _, err := api.OpenViewContext(ctx, s.TriggerID, splitSecretModal(settings, locale)); err != nil {
+		ctl.logger.Error("error opening split secret modal", zap.Error(err), zap.String("teamID", s.TeamID), zap.String("triggerID", s.TriggerID))
+		return err
+	}
+	return nil
+}
+
+func splitSecretModal(settings TeamSettings, locale string) slack.ModalViewRequest {
+	textInput := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.secret_placeholder"), false, false), "secret_text_input")
+	textInput.Multiline = true
+	recipientsSelect := slack.NewOptionsMultiSelectBlockElement(slack.MultiOptTypeUser, slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "split_modal.recipients_placeholder"), false, false), "recipients_input").
+		WithMaxSelectedItems(MaxSplitRecipients)
+	threshold := slack.NewNumberInputBlockElement(nil, "threshold_input", false).
+		WithInitialValue("2").
+		WithMinValue("2").
+		WithMaxValue(strconv.Itoa(MaxSplitRecipients))
+	datePicker := slack.NewDatePickerBlockElement("expiry_date_input")
+	datePicker.InitialDate = time.Now().AddDate(0, 0, settings.DefaultExpiryDays).Format("2006-01-02")
+
+	return slack.ModalViewRequest{
+		Type:       slack.VTModal,
+		CallbackID: actions.SplitSecretModal,
+		Title:      slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "split_modal.title"), false, false),
+		Close:      slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "modal.cancel"), false, false),
+		Submit:     slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "split_modal.submit"), false, false),
+		Blocks: slack.Blocks{
+			BlockSet: []slack.Block{
+				slack.NewInputBlock(
+					"secret_text_input",
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.secret_label"), false, false),
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.secret_hint", formatThousands(settings.MaxSecretLength)), false, false),
+					textInput,
+				),
+				slack.NewInputBlock(
+					"recipients_input",
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "split_modal.recipients_label"), false, false),
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "split_modal.recipients_hint", MaxSplitRecipients), false, false),
+					recipientsSelect,
+				),
+				slack.NewInputBlock(
+					"threshold_input",
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "split_modal.threshold_label"), false, false),
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "split_modal.threshold_hint"), false, false),
+					threshold,
+				),
+				slack.NewInputBlock(
+					"expiry_date_input",
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.expiry_label"), false, false),
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.expiry_hint", settings.MaxExpiryDays), false, false),
+					datePicker,
+				),
+			},
+		},
+	}
+}
+
+// CallbackSplitSecretSubmission splits the secret from the split modal and DMs each recipient their share
+func CallbackSplitSecretSubmission(ctl *PublicController, c *gin.Context, i slack.InteractionCallback) {
+	hc := c.Request.Context()
+	secretTextVal := i.View.State.Values["secret_text_input"]["secret_text_input"].Value
+	datePickerVal := i.View.State.Values["expiry_date_input"]["expiry_date_input"].SelectedDate
+	recipients := uniqueUserIDs(i.View.State.Values["recipients_input"]["recipients_input"].SelectedUsers)
+	threshold, thresholdErr := strconv.Atoi(i.View.State.Values["threshold_input"]["threshold_input"].Value)
+
+	locale := ctl.userLocale(hc, i.Team.ID, i.Enterprise.ID, i.User.ID)
+	settings, err := ctl.teamSettings(hc, i.Team.ID)
+	if err != nil {
+		ctl.logger.Error("error getting team settings", zap.Error(err), zap.String("teamID", i.Team.ID))
+		respondViewErrors(c, map[string]string{"secret_text_input": viewSubmissionFailed(locale)})
+		return
+	}
+	validationErrors := map[string]string{}
+	var ue userError
+	if errors.As(validateSecretText(secretTextVal, settings), &ue) {
+		validationErrors["secret_text_input"] = ue.text(locale)
+	}
+	expiresAt, expiryErr := parseExpiryDate(datePickerVal, settings.MaxExpiryDays, time.Now(), locale)
+	if expiryErr != "" {
+		validationErrors["expiry_date_input"] = expiryErr
+	}
+	if len(recipients) < 2 || len(recipients) > MaxSplitRecipients {
+		validationErrors["recipients_input"] = secreti18n.T(locale, "validation.split_recipients", MaxSplitRecipients)
+	} else if thresholdErr != nil || threshold < 2 || threshold > len(recipients) {
+		validationErrors["threshold_input"] = secreti18n.T(locale, "validation.split_threshold", len(recipients))
+	} else if recipientErr := ctl.recipientError(hc, i.Team.ID, i.Enterprise.ID, recipients, locale); recipientErr != "" {
+		validationErrors["recipients_input"] = recipientErr
+	}
+	if len(validationErrors) > 0 {
+		respondViewErrors(c, validationErrors)
+		return
+	}
+
+	sec, err := ctl.splitSecret(hc, secretTextVal, i.Team.ID, i.Enterprise.ID, i.User.ID, recipients, threshold, WithExpiryDate(expiresAt))
+	if errors.As(err, &ue) {
+		respondViewErrors(c, map[string]string{"recipients_input": ue.text(locale)})
+		return
+	}
+	if err != nil {
+		ctl.logger.Error("error splitting secret", zap.Error(err), zap.String("teamID", i.Team.ID), zap.String("userID", i.User.ID))
+		respondViewErrors(c, map[string]string{"secret_text_input": viewSubmissionFailed(locale)})
+		return
+	}
+	respondViewUpdate(c, splitSentModal(sec, locale))
+}
+
+// splitSecret encrypts the secret, splits its key between the recipients and DMs each of them their
+// share. If a share can't be delivered the secret is revoked, since it might never be revealed.
+func (ctl *PublicController) splitSecret(ctx context.Context, secretText string, teamID string, enterpriseID string, senderID string, recipients []string, threshold int, options ...SecretOption) (*Secret, error) {
+	settings, err := ctl.teamSettings(ctx, teamID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSecretText(secretText, settings); err != nil {
+		return nil, err
+	}
+	team, err := ctl.findInstallation(ctx, teamID, enterpriseID)
+	if err != nil {
+		return nil, err
+	}
+	api, err := ctl.slackClientForTeam(ctx, team)
+	if err != nil {
+		return nil, err
+	}
+
+	key := rand.Text()
+	secretEncrypted, err := encrypt(secretText, key)
+	if err != nil {
+		return nil, err
+	}
+	keyShares, err := secretshamir.Split([]byte(key), len(recipients), threshold)
+	if err != nil {
+		return nil, err
+	}
+	shares := make(map[string]string, len(recipients))
+	shareHashes := make(map[string]string, len(recipients))
+	for n, recipient := range recipients {
+		shares[recipient] = encodeShare(keyShares[n])
+		shareHashes[recipient] = hash(shares[recipient])
+	}
+
+	// Split secrets are for break-glass credentials, their sender always hears when one is revealed
+	sec, err := ctl.saveSecret(ctx, hash(key), secretEncrypted, teamID, settings, append(options,
+		WithSenderID(senderID),
+		WithRecipients(recipients),
+		WithReadReceipt(true),
+		WithSplit(threshold, shareHashes),
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, recipient := range recipients {
+		locale := ctl.userLocale(ctx, teamID, enterpriseID, recipient)
+		_, _, err := api.PostMessageContext(ctx, recipient, shareMessage(sec, senderID, shares[recipient], locale)...)
+		if err == nil {
+			continue
+		}
+		ctl.logger.Error("error sending share of split secret", zap.Error(err), zap.String("teamID", teamID), zap.String("recipient", recipient))
+		if revoked, _ := retireSecret(ctl.db.WithContext(ctx), sec.ID, SecretRetiredRevoked, ""); revoked {
+			ctl.recordSecretEvent(ctx, AuditSecretRevoked, teamID, sec.ID, senderID, "")
+		}
+		return nil, newUserError("split_delivery_failed")
+	}
+	return sec, nil
+}
+
+// shareMessage is the DM that gives a recipient their share of a split secret, in their locale
+func shareMessage(sec *Secret, senderID string, share string, locale string) []slack.MsgOption {
+	summary := secreti18n.T(locale, "share_message.summary", fmt.Sprintf("<@%s>", senderID), len(sec.Recipients), sec.Threshold)
+	submitLabel := secreti18n.T(locale, "share_message.submit_button")
+	submit := slack.NewButtonBlockElement(actions.SubmitShare, sec.ID, slack.NewTextBlockObject("plain_text", submitLabel, false, false)).
+		WithStyle(slack.StylePrimary)
+	return []slack.MsgOption{
+		slack.MsgOptionText(summary, false),
+		slack.MsgOptionBlocks(
+			slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", summary+"\n"+secreti18n.T(locale, "share_message.instructions", submitLabel), false, false), nil, nil),
+			slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", "```"+share+"```", false, false), nil, nil),
+			slack.NewActionBlock("", submit),
+			slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", secreti18n.T(locale, "share_message.expires", slackDate(sec.ExpiresAt)), false, false)),
+		),
+	}
+}
+
+func splitSentModal(sec *Secret, locale string) *slack.ModalViewRequest {
+	return &slack.ModalViewRequest{
+		Type:  slack.VTModal,
+		Title: slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "split_sent.title"), false, false),
+		Close: slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "modal.done"), false, false),
+		Blocks: slack.Blocks{
+			BlockSet: []slack.Block{
+				slack.NewSectionBlock(
+					slack.NewTextBlockObject("mrkdwn", secreti18n.T(locale, "split_sent.text", userMentions(sec.Recipients), sec.Threshold, slackDate(sec.ExpiresAt)), false, false),
+					nil, nil),
+			},
+		},
+	}
+}
+
+// CallbackSubmitShare opens the form a recipient pastes their share of a split secret into
+func CallbackSubmitShare(ctl *PublicController, c *gin.Context, i slack.InteractionCallback, action *slack.BlockAction) {
+	hc := c.Request.Context()
+	team, err := ctl.findInstallation(hc, i.Team.ID, i.Enterprise.ID)
+	if err != nil {
+		ctl.logger.Error("error getting team for share submission", zap.Error(err), zap.String("teamID", i.Team.ID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+	api, err := ctl.slackClientForTeam(hc, team)
+	if err != nil {
+		ctl.logger.Error("error building slack client for share submission", zap.Error(err), zap.String("teamID", i.Team.ID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+
+	locale := ctl.cachedUserLocale(hc, i.Team.ID, i.User.ID)
+	shareInput := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject("plain_text", splitSharePrefix+"...", false, false), "share_input")
+	modalRequest := slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      actions.SubmitShareModal,
+		Title:           slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "share_modal.title"), false, false),
+		Close:           slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "modal.cancel"), false, false),
+		Submit:          slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "share_modal.submit"), false, false),
+		PrivateMetadata: action.Value,
+		Blocks: slack.Blocks{
+			BlockSet: []slack.Block{
+				slack.NewInputBlock(
+					"share_input",
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "share_modal.label"), false, false),
+					slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "share_modal.hint"), false, false),
+					shareInput,
+				),
+			},
+		},
+	}
+	if _, err := api.OpenViewContext(hc, i.TriggerID, modalRequest); err != nil {
+		ctl.logger.Error("error opening share submission modal", zap.Error(err), zap.String("teamID", i.Team.ID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+	c.Data(http.StatusOK, gin.MIMEPlain, nil)
+}
+
+// CallbackSubmitShareSubmission stores a recipient's share and, once enough shares were submitted,
+// reveals the secret to whoever submitted the last one
+func CallbackSubmitShareSubmission(ctl *PublicController, c *gin.Context, i slack.InteractionCallback) {
+	hc := c.Request.Context()
+	share := strings.TrimSpace(i.View.State.Values["share_input"]["share_input"].Value)
+	locale := ctl.userLocale(hc, i.Team.ID, i.Enterprise.ID, i.User.ID)
+
+	secret, shares, err := ctl.submitShare(hc, i.Team.ID, i.View.PrivateMetadata, i.User.ID, share)
+	var secretText string
+	if err == nil && len(shares) >= secret.Threshold {
+		secretText, err = ctl.revealSplitSecret(hc, secret, shares, i.User.ID)
+	}
+	var ue userError
+	if errors.As(err, &ue) {
+		respondViewErrors(c, map[string]string{"share_input": ue.text(locale)})
+		return
+	}
+	if err != nil {
+		ctl.logger.Error("error submitting share of split secret", zap.Error(err), zap.String("teamID", i.Team.ID), zap.String("userID", i.User.ID))
+		respondViewErrors(c, map[string]string{"share_input": viewSubmissionFailed(locale)})
+		return
+	}
+
+	if secretText == "" {
+		respondViewUpdate(c, shareAcceptedModal(len(shares), secret.Threshold, locale))
+		return
+	}
+	respondViewUpdate(c, splitRevealedModal(secretText, locale))
+
+	if secret.ReadReceipt && secret.SenderID != "" {
+		sendReadReceipt(ctl, hc, i.Team.ID, i.Enterprise.ID, secret, i.User.ID)
+	}
+}
+
+// submitShare checks userID's share against the split secret and stores it, returning the secret and
+// every share submitted so far
+func (ctl *PublicController) submitShare(ctx context.Context, teamID string, secretID string, userID string, share string) (Secret, []string, error) {
+	var secret Secret
+	err := ctl.db.WithContext(ctx).Where("id = ? AND team_id = ? AND threshold > 0", secretID, teamID).Take(&secret).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return secret, nil, errSplitSecretGone
+	case err != nil:
+		return secret, nil, err
+	case secret.Status() == SecretRetiredExpired:
+		if retired, _ := retireSecret(ctl.db.WithContext(ctx), secret.ID, SecretRetiredExpired, ""); retired {
+			ctl.recordSecretEvent(ctx, AuditSecretExpired, teamID, secret.ID, "", "")
+			ctl.deleteShares(ctx, secret.ID)
+		}
+		return secret, nil, errSplitSecretGone
+	case secret.ShareHashes[userID] == "":
+		return secret, nil, newUserError("share_not_for_you")
+	case subtle.ConstantTimeCompare([]byte(hash(share)), []byte(secret.ShareHashes[userID])) != 1:
+		return secret, nil, newUserError("share_wrong")
+	}
+
+	sealed, err := ctl.sealAtRest(share)
+	if err != nil {
+		return secret, nil, err
+	}
+	res := ctl.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&SecretShare{SecretID: secret.ID, UserID: userID, TeamID: teamID, Share: sealed})
+	if res.Error != nil {
+		return secret, nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return secret, nil, newUserError("share_already_submitted")
+	}
+	ctl.recordSecretEvent(ctx, AuditShareSubmitted, teamID, secret.ID, userID, "")
+
+	var stored []SecretShare
+	if err := ctl.db.WithContext(ctx).Where("secret_id = ?", secret.ID).Order("id").Find(&stored).Error; err != nil {
+		return secret, nil, err
+	}
+	shares := make([]string, len(stored))
+	for n, s := range stored {
+		if shares[n], err = ctl.openAtRest(s.Share); err != nil {
+			return secret, nil, err
+		}
+	}
+	return secret, shares, nil
+}
+
+// revealSplitSecret combines the submitted shares into the secret's key, decrypts the secret and
+// retires it. Only one of several concurrent reveals succeeds.
+func (ctl *PublicController) revealSplitSecret(ctx context.Context, secret Secret, shares []string, readBy string) (string, error) {
+	keyShares := make([][]byte, len(shares))
+	for n, share := range shares {
+		var err error
+		if keyShares[n], err = decodeShare(share); err != nil {
+			return "", err
+		}
+	}
+	key, err := secretshamir.Combine(keyShares)
+	if err != nil {
+		return "", err
+	}
+	if hash(string(key)) != secret.ID {
+		return "", errors.New("submitted shares don't reconstruct the secret's key")
+	}
+	secretText, err := decrypt(secret.Value, string(key))
+	if err != nil {
+		return "", err
+	}
+
+	retired, err := retireSecret(ctl.db.WithContext(ctx), secret.ID, SecretRetiredRead, readBy)
+	if err != nil {
+		return "", err
+	}
+	if !retired {
+		return "", errSplitSecretGone
+	}
+	ctl.deleteShares(ctx, secret.ID)
+	ctl.recordSecretEvent(ctx, AuditSecretRead, secret.TeamID, secret.ID, readBy, "")
+	return secretText, nil
+}
+
+// deleteShares deletes the shares submitted for a split secret once it is retired, since they're of no
+// use anymore. It's a no-op for other secrets.
+func (ctl *PublicController) deleteShares(ctx context.Context, secretID string) {
+	if err := ctl.db.WithContext(ctx).Where("secret_id = ?", secretID).Delete(&SecretShare{}).Error; err != nil {
+		ctl.logger.Error("error deleting shares of retired secret", zap.Error(err), zap.String("secretID", secretID))
+	}
+}
+
+func shareAcceptedModal(submitted int, threshold int, locale string) *slack.ModalViewRequest {
+	return &slack.ModalViewRequest{
+		Type:  slack.VTModal,
+		Title: slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "share_accepted.title"), false, false),
+		Close: slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "modal.done"), false, false),
+		Blocks: slack.Blocks{
+			BlockSet: []slack.Block{
+				slack.NewSectionBlock(
+					slack.NewTextBlockObject("mrkdwn", secreti18n.T(locale, "share_accepted.text", submitted, threshold), false, false),
+					nil, nil),
+			},
+		},
+	}
+}
+
+func splitRevealedModal(secretText string, locale string) *slack.ModalViewRequest {
+	var blocks []slack.Block
+	for _, chunk := range textChunks(secretText, revealedTextChunk) {
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject("plain_text", chunk, false, false), nil, nil))
+	}
+	blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", secreti18n.T(locale, "split_revealed.footer"), false, false)))
+	return &slack.ModalViewRequest{
+		Type:   slack.VTModal,
+		Title:  slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "split_revealed.title"), false, false),
+		Close:  slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "modal.done"), false, false),
+		Blocks: slack.Blocks{BlockSet: blocks},
+	}
+}
+
+func encodeShare(share []byte) string {
+	return splitSharePrefix + base64.RawURLEncoding.EncodeToString(share)
+}
+
+func decodeShare(share string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(share, splitSharePrefix)
+	if !ok {
+		return nil, errors.New("not a share of a split secret")
+	}
+	return base64.RawURLEncoding.DecodeString(encoded)
+}
+
+// uniqueUserIDs drops repeated user IDs, keeping the first of each
+func uniqueUserIDs(userIDs []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, id := range userIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
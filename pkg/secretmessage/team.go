@@ -0,0 +1,186 @@
+package secretmessage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// tokenRefreshLeeway is how long before expiry a rotating access token is refreshed
+const tokenRefreshLeeway = 5 * time.Minute
+
+// needsTokenRefresh reports whether the team uses token rotation and its access token is about to expire
+func (t Team) needsTokenRefresh(now time.Time) bool {
+	return t.RefreshToken != "" && !t.TokenExpiresAt.IsZero() && now.Add(tokenRefreshLeeway).After(t.TokenExpiresAt)
+}
+
+// findInstallation resolves the installation serving a workspace. A direct workspace install with a token
+// wins, otherwise the org-wide install of the workspace's Enterprise Grid organization is used.
+func (ctl *PublicController) findInstallation(ctx context.Context, teamID string, enterpriseID string) (Team, error) {
+	var team Team
+	err := ctl.db.WithContext(ctx).
+		Where("id = ? AND is_enterprise_install = ?", teamID, false).
+		First(&team).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return team, err
+	}
+	if (err == nil && team.AccessToken != "") || enterpriseID == "" {
+		return team, err
+	}
+
+	var org Team
+	orgErr := ctl.db.WithContext(ctx).
+		Where("enterprise_id = ? AND is_enterprise_install = ?", enterpriseID, true).
+		First(&org).Error
+	if orgErr == nil || err != nil {
+		return org, orgErr
+	}
+	// Keep the token-less workspace install if the org has no install either
+	return team, nil
+}
+
+// slackClientForTeam returns a Slack API client authenticated with the team's decrypted bot token,
+// refreshing the token first if it is about to expire
+func (ctl *PublicController) slackClientForTeam(ctx context.Context, team Team) (*slack.Client, error) {
+	if team.needsTokenRefresh(time.Now()) {
+		refreshed, err := ctl.refreshTeamToken(ctx, team.ID)
+		if err != nil {
+			return nil, fmt.Errorf("refreshing access token for team %s: %w", team.ID, err)
+		}
+		team = refreshed
+	}
+	token, err := ctl.openAtRest(team.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting access token for team %s: %w", team.ID, err)
+	}
+	return ctl.slackService.GetSlackClient(team.ID, token), nil
+}
+
+// refreshTeamToken exchanges the team's refresh token for a new access token via oauth.v2.access
+// and stores both. Each team's refreshes are serialized so concurrent requests in this process don't
+// burn the same refresh token, while other teams' requests carry on. Other instances sharing the
+// database can still refresh at the same time, so the tokens are only stored if the refresh token
+// wasn't replaced in the meantime, and the tokens another instance stored win otherwise.
+func (ctl *PublicController) refreshTeamToken(ctx context.Context, teamID string) (Team, error) {
+	defer ctl.tokenRefreshLocks.lock(teamID)()
+
+	// Re-read the team, another request may have refreshed it while we waited for the lock
+	var team Team
+	if err := ctl.db.WithContext(ctx).Where("id = ?", teamID).First(&team).Error; err != nil {
+		return team, err
+	}
+	if !team.needsTokenRefresh(time.Now()) {
+		return team, nil
+	}
+
+	refreshToken, err := ctl.openAtRest(team.RefreshToken)
+	if err != nil {
+		return team, err
+	}
+	oldAccessToken, err := ctl.openAtRest(team.AccessToken)
+	if err != nil {
+		return team, err
+	}
+
+	// An already expired token forces the token source to use the refresh token
+	refreshed, err := ctl.config.OauthConfig.TokenSource(ctx, &oauth2.Token{
+		RefreshToken: refreshToken,
+		Expiry:       time.Unix(1, 0),
+	}).Token()
+	if err != nil {
+		// Refresh tokens can only be used once, another instance may have used it first
+		if current, ok := ctl.refreshedElsewhere(ctx, team); ok {
+			return current, nil
+		}
+		return team, err
+	}
+
+	accessToken, err := ctl.sealAtRest(refreshed.AccessToken)
+	if err != nil {
+		return team, err
+	}
+	newRefreshToken, err := ctl.sealAtRest(refreshed.RefreshToken)
+	if err != nil {
+		return team, err
+	}
+	res := ctl.db.WithContext(ctx).
+		Model(&Team{}).
+		Where("id = ? AND refresh_token = ?", team.ID, team.RefreshToken).
+		Updates(map[string]interface{}{
+			"access_token":     accessToken,
+			"refresh_token":    newRefreshToken,
+			"token_expires_at": refreshed.Expiry,
+		})
+	if res.Error != nil {
+		return team, res.Error
+	}
+	ctl.slackService.Invalidate(oldAccessToken)
+	if res.RowsAffected == 0 {
+		// Another instance stored its tokens first, they are the ones Slack knows about now
+		current, ok := ctl.refreshedElsewhere(ctx, team)
+		if !ok {
+			return team, fmt.Errorf("team %s changed while its access token was refreshed", team.ID)
+		}
+		return current, nil
+	}
+	ctl.logger.Info("refreshed access token", zap.String("teamID", team.ID), zap.Time("expiresAt", refreshed.Expiry))
+
+	team.AccessToken = accessToken
+	team.RefreshToken = newRefreshToken
+	team.TokenExpiresAt = refreshed.Expiry
+	return team, nil
+}
+
+// refreshedElsewhere re-reads the team, reporting whether its refresh token was replaced since team was read
+func (ctl *PublicController) refreshedElsewhere(ctx context.Context, team Team) (Team, bool) {
+	var current Team
+	if err := ctl.db.WithContext(ctx).Where("id = ?", team.ID).First(&current).Error; err != nil {
+		return team, false
+	}
+	return current, current.RefreshToken != team.RefreshToken
+}
+
+// teamLocks are mutexes keyed by team ID. The zero value is ready to use.
+type teamLocks struct {
+	mu    sync.Mutex
+	locks map[string]*teamLock
+}
+
+type teamLock struct {
+	sync.Mutex
+	// holders is how many callers hold or wait for the lock, it is forgotten once there are none
+	holders int
+}
+
+// lock locks the team's mutex, returning the function that unlocks it
+func (tl *teamLocks) lock(teamID string) func() {
+	tl.mu.Lock()
+	if tl.locks == nil {
+		tl.locks = map[string]*teamLock{}
+	}
+	l, ok := tl.locks[teamID]
+	if !ok {
+		l = &teamLock{}
+		tl.locks[teamID] = l
+	}
+	l.holders++
+	tl.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		tl.mu.Lock()
+		defer tl.mu.Unlock()
+		l.holders--
+		if l.holders == 0 {
+			delete(tl.locks, teamID)
+		}
+	}
+}
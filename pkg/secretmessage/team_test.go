@@ -0,0 +1,248 @@
+package secretmessage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// fakeOauthAccess is a local stand-in for Slack's oauth.v2.access refresh_token grant
+type fakeOauthAccess struct {
+	calls        int32
+	refreshToken string
+	fail         bool
+	// onRequest runs before each request is answered, as another instance refreshing at the same time
+	onRequest func()
+}
+
+func (f *fakeOauthAccess) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&f.calls, 1)
+	if f.onRequest != nil {
+		f.onRequest()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if f.fail || r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != f.refreshToken {
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "invalid_refresh_token"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":            true,
+		"access_token":  "xoxe.xoxb-new",
+		"refresh_token": "xoxe-1-new",
+		"token_type":    "bot",
+		"expires_in":    43200,
+	})
+}
+
+func newTokenRefreshController(t *testing.T, tokenURL string) (*PublicController, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname="+t.Name()), &gorm.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		d, _ := db.DB()
+		d.Close()
+	})
+	require.NoError(t, db.AutoMigrate(Team{}))
+
+	tc, err := NewTokenCipher("k1", map[string][]byte{"k1": testTokenKeyOld})
+	require.NoError(t, err)
+	ctl := NewController(Config{
+		TokenCipher: tc,
+		OauthConfig: &oauth2.Config{
+			ClientID:     "myclientID",
+			ClientSecret: "myClientSecret",
+			Endpoint:     oauth2.Endpoint{TokenURL: tokenURL},
+		},
+	}, db, nil)
+	return ctl, db
+}
+
+func createRotatingTeam(t *testing.T, ctl *PublicController, db *gorm.DB, expiresAt time.Time) Team {
+	accessToken, err := ctl.sealAtRest("xoxe.xoxb-old")
+	require.NoError(t, err)
+	refreshToken, err := ctl.sealAtRest("xoxe-1-old")
+	require.NoError(t, err)
+	team := Team{ID: "T1", AccessToken: accessToken, RefreshToken: refreshToken, TokenExpiresAt: expiresAt}
+	require.NoError(t, db.Create(&team).Error)
+	return team
+}
+
+func TestSlackClientForTeam_TokenStillValid(t *testing.T) {
+	fake := &fakeOauthAccess{refreshToken: "xoxe-1-old"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	ctl, db := newTokenRefreshController(t, srv.URL)
+	team := createRotatingTeam(t, ctl, db, time.Now().Add(time.Hour))
+
+	_, err := ctl.slackClientForTeam(context.Background(), team)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, atomic.LoadInt32(&fake.calls))
+}
+
+func TestSlackClientForTeam_RefreshesExpiringToken(t *testing.T) {
+	fake := &fakeOauthAccess{refreshToken: "xoxe-1-old"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	ctl, db := newTokenRefreshController(t, srv.URL)
+	team := createRotatingTeam(t, ctl, db, time.Now().Add(time.Minute))
+
+	staleClient := ctl.slackService.GetSlackClient("T1", "xoxe.xoxb-old")
+
+	client, err := ctl.slackClientForTeam(context.Background(), team)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&fake.calls))
+	assert.Same(t, ctl.slackService.GetSlackClient("T1", "xoxe.xoxb-new"), client)
+	assert.NotSame(t, staleClient, ctl.slackService.GetSlackClient("T1", "xoxe.xoxb-old"), "stale client should have been evicted")
+
+	var stored Team
+	require.NoError(t, db.Where("id = ?", "T1").First(&stored).Error)
+	assert.True(t, IsEncryptedToken(stored.AccessToken))
+	assert.True(t, IsEncryptedToken(stored.RefreshToken))
+	accessToken, err := ctl.openAtRest(stored.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "xoxe.xoxb-new", accessToken)
+	refreshToken, err := ctl.openAtRest(stored.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, "xoxe-1-new", refreshToken)
+	assert.WithinDuration(t, time.Now().Add(12*time.Hour), stored.TokenExpiresAt, time.Minute)
+}
+
+func TestSlackClientForTeam_ConcurrentRefreshHappensOnce(t *testing.T) {
+	fake := &fakeOauthAccess{refreshToken: "xoxe-1-old"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	ctl, db := newTokenRefreshController(t, srv.URL)
+	team := createRotatingTeam(t, ctl, db, time.Now().Add(-time.Minute))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ctl.slackClientForTeam(context.Background(), team)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&fake.calls))
+}
+
+// storeOtherInstanceTokens stores tokens as another instance that refreshed the team's token would
+func storeOtherInstanceTokens(t *testing.T, ctl *PublicController, db *gorm.DB) {
+	accessToken, err := ctl.sealAtRest("xoxe.xoxb-other")
+	require.NoError(t, err)
+	refreshToken, err := ctl.sealAtRest("xoxe-1-other")
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&Team{}).Where("id = ?", "T1").Updates(map[string]interface{}{
+		"access_token":     accessToken,
+		"refresh_token":    refreshToken,
+		"token_expires_at": time.Now().Add(12 * time.Hour),
+	}).Error)
+}
+
+func TestSlackClientForTeam_RefreshedByAnotherInstance(t *testing.T) {
+	fake := &fakeOauthAccess{refreshToken: "xoxe-1-old"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	ctl, db := newTokenRefreshController(t, srv.URL)
+	team := createRotatingTeam(t, ctl, db, time.Now().Add(-time.Minute))
+	// The other instance used the refresh token first, so Slack refuses it
+	fake.onRequest = func() {
+		storeOtherInstanceTokens(t, ctl, db)
+		fake.refreshToken = "xoxe-1-other"
+	}
+
+	client, err := ctl.slackClientForTeam(context.Background(), team)
+	require.NoError(t, err)
+	assert.Same(t, ctl.slackService.GetSlackClient("T1", "xoxe.xoxb-other"), client)
+}
+
+func TestSlackClientForTeam_StoredByAnotherInstanceFirst(t *testing.T) {
+	fake := &fakeOauthAccess{refreshToken: "xoxe-1-old"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	ctl, db := newTokenRefreshController(t, srv.URL)
+	team := createRotatingTeam(t, ctl, db, time.Now().Add(-time.Minute))
+	fake.onRequest = func() { storeOtherInstanceTokens(t, ctl, db) }
+
+	client, err := ctl.slackClientForTeam(context.Background(), team)
+	require.NoError(t, err)
+	assert.Same(t, ctl.slackService.GetSlackClient("T1", "xoxe.xoxb-other"), client)
+
+	var stored Team
+	require.NoError(t, db.Where("id = ?", "T1").First(&stored).Error)
+	refreshToken, err := ctl.openAtRest(stored.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, "xoxe-1-other", refreshToken)
+}
+
+func TestSlackClientForTeam_RefreshFailure(t *testing.T) {
+	fake := &fakeOauthAccess{refreshToken: "xoxe-1-old", fail: true}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	ctl, db := newTokenRefreshController(t, srv.URL)
+	team := createRotatingTeam(t, ctl, db, time.Now().Add(-time.Minute))
+
+	_, err := ctl.slackClientForTeam(context.Background(), team)
+	assert.Error(t, err)
+
+	var stored Team
+	require.NoError(t, db.Where("id = ?", "T1").First(&stored).Error)
+	assert.Equal(t, team.AccessToken, stored.AccessToken)
+}
+
+func TestSlackClientForTeam_NonRotatingToken(t *testing.T) {
+	fake := &fakeOauthAccess{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	ctl, _ := newTokenRefreshController(t, srv.URL)
+
+	_, err := ctl.slackClientForTeam(context.Background(), Team{ID: "T1", AccessToken: "xoxb-legacy"})
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, atomic.LoadInt32(&fake.calls))
+}
+
+func TestTeamLocks(t *testing.T) {
+	var tl teamLocks
+	unlockT1 := tl.lock("T1")
+
+	// Another team isn't held up by T1
+	done := make(chan struct{})
+	go func() {
+		tl.lock("T2")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("locking T2 waited for T1")
+	}
+
+	// T1 is, until it's unlocked
+	locked := make(chan struct{})
+	go func() {
+		tl.lock("T1")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("T1 was locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockT1()
+	<-locked
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	assert.Empty(t, tl.locks, "unused locks are forgotten")
+}
@@ -0,0 +1,265 @@
+package secretmessage
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
+	"github.com/neufeldtech/secretmessage-go/pkg/secretmessage/actions"
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+)
+
+const (
+	// MaxTemplates is how many templates a workspace can define
+	MaxTemplates = 10
+	// MaxTemplateFields is how many fields a template can have
+	MaxTemplateFields = 10
+	// maxTemplateLabelLength bounds template names and field labels, which are shown as select options
+	// and input labels
+	maxTemplateLabelLength = 50
+	// templateOptionNone is the template select's option for a secret without a template
+	templateOptionNone = "none"
+	// templateOptionPrefix starts the template select's option for each template, followed by its name
+	templateOptionPrefix = "template:"
+	// templateFieldBlockPrefix starts the block ID of each of a template's inputs, followed by its index
+	templateFieldBlockPrefix = "template_field_"
+)
+
+// SecretTemplate is a structured secret workspace admins define, like a VPN user, password and OTP seed,
+// so it is sent with one input per field rather than pasted in a single text
+type SecretTemplate struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+}
+
+// SecretTemplates are stored as JSON. They implement driver.Valuer rather than using gorm's JSON
+// serializer, which settings saved with a map of columns skip.
+type SecretTemplates []SecretTemplate
+
+func (t SecretTemplates) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(t)
+	return string(b), err
+}
+
+func (t *SecretTemplates) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("can't read templates from %T", value)
+	}
+	return json.Unmarshal(b, t)
+}
+
+// templateField is one labelled value of a secret sent with a template
+type templateField struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// findTemplate returns the template called name, or nil if there is none
+func (s TeamSettings) findTemplate(name string) *SecretTemplate {
+	for n := range s.Templates {
+		if s.Templates[n].Name == name {
+			return &s.Templates[n]
+		}
+	}
+	return nil
+}
+
+// formatTemplates writes templates the way admins edit them in the settings modal, one per line as
+// Name: Field, Field
+func formatTemplates(templates SecretTemplates) string {
+	lines := make([]string, len(templates))
+	for n, t := range templates {
+		lines[n] = t.Name + ": " + strings.Join(t.Fields, ", ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseTemplates reads the templates admins entered in the settings modal, or explains why they can't
+// be used in locale. Blank lines are skipped.
+func parseTemplates(text string, locale string) (SecretTemplates, string) {
+	var templates SecretTemplates
+	names := map[string]bool{}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, fieldList, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, secreti18n.T(locale, "validation.template_format")
+		}
+		if utf8.RuneCountInString(name) > maxTemplateLabelLength {
+			return nil, secreti18n.T(locale, "validation.template_name_length", maxTemplateLabelLength)
+		}
+		if names[strings.ToLower(name)] {
+			return nil, secreti18n.T(locale, "validation.template_duplicate", name)
+		}
+		names[strings.ToLower(name)] = true
+
+		t := SecretTemplate{Name: name}
+		for _, field := range strings.Split(fieldList, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			if utf8.RuneCountInString(field) > maxTemplateLabelLength {
+				return nil, secreti18n.T(locale, "validation.template_field_length", maxTemplateLabelLength)
+			}
+			t.Fields = append(t.Fields, field)
+		}
+		if len(t.Fields) == 0 {
+			return nil, secreti18n.T(locale, "validation.template_no_fields", name)
+		}
+		if len(t.Fields) > MaxTemplateFields {
+			return nil, secreti18n.T(locale, "validation.template_fields", MaxTemplateFields)
+		}
+		templates = append(templates, t)
+	}
+	if len(templates) > MaxTemplates {
+		return nil, secreti18n.T(locale, "validation.templates", MaxTemplates)
+	}
+	return templates, ""
+}
+
+// templateSelect is the create modal's choice of typing the secret, generating it or sending it with
+// a template, which updates the modal with the inputs for the choice
+func templateSelect(templates SecretTemplates, meta modalMetadata, locale string) *slack.ActionBlock {
+	none := slack.NewOptionBlockObject(templateOptionNone, slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.template_none"), false, false), nil)
+	options := []*slack.OptionBlockObject{none}
+	initial := none
+	for _, kind := range []string{generatePassword, generatePassphrase} {
+		option := slack.NewOptionBlockObject(generateOptionPrefix+kind, slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.generate_"+kind), false, false), nil)
+		options = append(options, option)
+		if kind == meta.Generate {
+			initial = option
+		}
+	}
+	for _, t := range templates {
+		option := slack.NewOptionBlockObject(templateOptionPrefix+t.Name, slack.NewTextBlockObject("plain_text", t.Name, false, false), nil)
+		options = append(options, option)
+		if meta.Generate == "" && t.Name == meta.Template {
+			initial = option
+		}
+	}
+	choose := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, slack.NewTextBlockObject("plain_text", secreti18n.T(locale, "create_modal.template_placeholder"), false, false), actions.ChooseTemplate, options...)
+	choose.InitialOption = initial
+	return slack.NewActionBlock("template_input", choose)
+}
+
+// templateInputs are the create modal's inputs for each of a template's fields
+func templateInputs(fields []string) []slack.Block {
+	blocks := make([]slack.Block, len(fields))
+	for n, field := range fields {
+		blockID := fmt.Sprintf("%s%d", templateFieldBlockPrefix, n)
+		blocks[n] = slack.NewInputBlock(
+			blockID,
+			slack.NewTextBlockObject("plain_text", field, false, false),
+			nil,
+			slack.NewPlainTextInputBlockElement(nil, blockID),
+		)
+	}
+	return blocks
+}
+
+// parseTemplateFields reads the values of a template's inputs, with an error for each one that can't
+// be sent. It returns the secret's text, the JSON of the labelled fields.
+func parseTemplateFields(state *slack.ViewState, fields []string, settings TeamSettings, locale string) (string, map[string]string) {
+	validationErrors := map[string]string{}
+	values := make([]templateField, len(fields))
+	for n, field := range fields {
+		blockID := fmt.Sprintf("%s%d", templateFieldBlockPrefix, n)
+		values[n] = templateField{Label: field, Value: state.Values[blockID][blockID].Value}
+		var ue userError
+		if errors.As(validateSecretText(values[n].Value, settings), &ue) {
+			validationErrors[blockID] = ue.text(locale)
+		}
+	}
+	b, _ := json.Marshal(values)
+	text := string(b)
+	if len(validationErrors) == 0 && len(fields) > 0 {
+		var ue userError
+		if errors.As(validateSecretText(text, settings), &ue) {
+			validationErrors[templateFieldBlockPrefix+"0"] = ue.text(locale)
+		}
+	}
+	return text, validationErrors
+}
+
+// revealedTemplateMessage shows a secret sent with a template as a labelled field for each value. Secrets
+// whose fields can't be read are shown as text instead.
+func revealedTemplateMessage(secretText string, template string, secretID string, locale string) slack.Message {
+	var fields []templateField
+	if err := json.Unmarshal([]byte(secretText), &fields); err != nil || len(fields) == 0 {
+		return revealedSecretMessage(secretText, secretID, locale)
+	}
+	msg := revealedSecretMessage("", secretID, locale)
+	attachment := &msg.Attachments[0]
+	attachment.Title = template
+	attachment.Fallback = template
+	for _, f := range fields {
+		attachment.Fields = append(attachment.Fields, slack.AttachmentField{Title: f.Label, Value: f.Value})
+	}
+	return msg
+}
+
+// CallbackChooseTemplate updates the create modal with an input for each field of the chosen template,
+// or with the inputs for generating the secret
+func CallbackChooseTemplate(ctl *PublicController, c *gin.Context, i slack.InteractionCallback, action *slack.BlockAction) {
+	hc := c.Request.Context()
+	settings, err := ctl.teamSettings(hc, i.Team.ID)
+	if err != nil {
+		ctl.logger.Error("error getting team settings", zap.Error(err), zap.String("teamID", i.Team.ID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+	meta := parseModalMetadata(i.View.PrivateMetadata)
+	meta.Template, meta.TemplateFields, meta.Generate = "", nil, ""
+	switch value := action.SelectedOption.Value; {
+	case value == generateOptionPrefix+generatePassword || value == generateOptionPrefix+generatePassphrase:
+		meta.Generate = strings.TrimPrefix(value, generateOptionPrefix)
+	case strings.HasPrefix(value, templateOptionPrefix):
+		if t := settings.findTemplate(strings.TrimPrefix(value, templateOptionPrefix)); t != nil {
+			meta.Template, meta.TemplateFields = t.Name, t.Fields
+		}
+	}
+
+	team, err := ctl.findInstallation(hc, i.Team.ID, i.Enterprise.ID)
+	if err != nil {
+		ctl.logger.Error("error getting team to choose template", zap.Error(err), zap.String("teamID", i.Team.ID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+	api, err := ctl.slackClientForTeam(hc, team)
+	if err != nil {
+		ctl.logger.Error("error building slack client to choose template", zap.Error(err), zap.String("teamID", i.Team.ID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+	locale := ctl.userLocale(hc, i.Team.ID, i.Enterprise.ID, i.User.ID)
+	// The hash makes Slack refuse the update if the modal changed since this action, rather than lose the change
+	if _, err := api.UpdateViewContext(hc, createSecretModal(settings, meta, locale), "", i.View.Hash, i.View.ID); err != nil {
+		ctl.logger.Error("error updating modal with template", zap.Error(err), zap.String("teamID", i.Team.ID))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"status": "Error with the stuffs"})
+		return
+	}
+	c.Data(http.StatusOK, gin.MIMEPlain, nil)
+}
@@ -0,0 +1,103 @@
+package secretmessage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTemplates(t *testing.T) {
+	templates, errText := parseTemplates("VPN access: User, Password, OTP seed\n\n Database :host,user , password,\n", secreti18n.DefaultLocale)
+	assert.Empty(t, errText)
+	assert.Equal(t, SecretTemplates{
+		{Name: "VPN access", Fields: []string{"User", "Password", "OTP seed"}},
+		{Name: "Database", Fields: []string{"host", "user", "password"}},
+	}, templates)
+	assert.Equal(t, "VPN access: User, Password, OTP seed\nDatabase: host, user, password", formatTemplates(templates))
+
+	again, errText := parseTemplates(formatTemplates(templates), secreti18n.DefaultLocale)
+	assert.Empty(t, errText)
+	assert.Equal(t, templates, again)
+}
+
+func TestParseTemplates_Validation(t *testing.T) {
+	var tooMany string
+	for n := 0; n <= MaxTemplates; n++ {
+		tooMany += fmt.Sprintf("Template %d: Field\n", n)
+	}
+	cases := map[string]string{
+		"VPN access":                       "Write each template as",
+		": User":                           "Write each template as",
+		strings.Repeat("n", 51) + ": User": "Template names are limited",
+		"VPN: " + strings.Repeat("f", 51):  "Field names are limited",
+		"VPN: User\nvpn: Password":         "more than one template called vpn",
+		"VPN: , ,":                         "Give VPN at least one field",
+		"VPN: a,b,c,d,e,f,g,h,i,j,k":       "at most 10 fields",
+		tooMany:                            "at most 10 templates",
+	}
+	for text, want := range cases {
+		templates, errText := parseTemplates(text, secreti18n.DefaultLocale)
+		assert.Nil(t, templates, text)
+		assert.Contains(t, errText, want, text)
+	}
+
+	_, errText := parseTemplates("VPN: , ,", "fr")
+	assert.Equal(t, "Donnez au moins un champ à VPN, comme VPN : Utilisateur, Mot de passe", errText)
+}
+
+func TestSecretTemplates_ValueScan(t *testing.T) {
+	templates := SecretTemplates{{Name: "VPN", Fields: []string{"User", "Password"}}}
+	value, err := templates.Value()
+	assert.NoError(t, err)
+
+	var scanned SecretTemplates
+	assert.NoError(t, scanned.Scan(value))
+	assert.Equal(t, templates, scanned)
+	assert.NoError(t, scanned.Scan([]byte(value.(string))))
+	assert.Equal(t, templates, scanned)
+
+	value, err = SecretTemplates(nil).Value()
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+	assert.NoError(t, scanned.Scan(nil))
+	assert.Nil(t, scanned)
+}
+
+func templateState(values ...string) *slack.ViewState {
+	state := &slack.ViewState{Values: map[string]map[string]slack.BlockAction{}}
+	for n, v := range values {
+		blockID := fmt.Sprintf("%s%d", templateFieldBlockPrefix, n)
+		state.Values[blockID] = map[string]slack.BlockAction{blockID: {Value: v}}
+	}
+	return state
+}
+
+func TestParseTemplateFields(t *testing.T) {
+	settings := DefaultTeamSettings("T1")
+	text, errs := parseTemplateFields(templateState("alice", "hunter2"), []string{"User", "Password"}, settings, "en")
+	assert.Empty(t, errs)
+	assert.JSONEq(t, `[{"label":"User","value":"alice"},{"label":"Password","value":"hunter2"}]`, text)
+
+	_, errs = parseTemplateFields(templateState("alice", " "), []string{"User", "Password"}, settings, "en")
+	assert.Equal(t, map[string]string{"template_field_1": "Enter the secret to send"}, errs)
+
+	// The fields must fit in the workspace's secret length together, not just one by one
+	settings.MaxSecretLength = 40
+	_, errs = parseTemplateFields(templateState("alice", "hunter2"), []string{"User", "Password"}, settings, "en")
+	assert.Contains(t, errs["template_field_0"], "40 characters")
+}
+
+func TestRevealedTemplateMessage(t *testing.T) {
+	msg := revealedTemplateMessage(`[{"label":"User","value":"alice"},{"label":"Password","value":"hunter2"}]`, "VPN", "abc", "en")
+	assert.Len(t, msg.Attachments, 1)
+	assert.Equal(t, "VPN", msg.Attachments[0].Title)
+	assert.Equal(t, []slack.AttachmentField{{Title: "User", Value: "alice"}, {Title: "Password", Value: "hunter2"}}, msg.Attachments[0].Fields)
+	assert.Equal(t, revealedSecretMessage("", "abc", "en").Attachments[0].Actions, msg.Attachments[0].Actions)
+
+	msg = revealedTemplateMessage("not json", "VPN", "abc", "en")
+	assert.Equal(t, revealedSecretMessage("not json", "abc", "en"), msg)
+}
@@ -0,0 +1,170 @@
+package secretmessage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// tokenCiphertextPrefix marks a value that was sealed by a TokenCipher. Values
+// without it are legacy plain text tokens written before encryption at rest.
+const tokenCiphertextPrefix = "enc:v1:"
+
+// TokenCipher encrypts Slack access tokens before they are written to the database.
+// Every ciphertext records the ID of the key that sealed it so that keys can be rotated
+// by adding a new active key while keeping the old ones around for decryption.
+type TokenCipher struct {
+	activeKeyID string
+	keys        map[string][]byte
+}
+
+// NewTokenCipher returns a TokenCipher sealing with activeKeyID. Keys must be 32 bytes (AES-256).
+func NewTokenCipher(activeKeyID string, keys map[string][]byte) (*TokenCipher, error) {
+	if activeKeyID == "" {
+		return nil, errors.New("token encryption key ID must not be empty")
+	}
+	if _, ok := keys[activeKeyID]; !ok {
+		return nil, fmt.Errorf("token encryption key %q not found", activeKeyID)
+	}
+	for id, key := range keys {
+		if strings.ContainsAny(id, ":,") {
+			return nil, fmt.Errorf("token encryption key ID %q must not contain ':' or ','", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("token encryption key %q must be 32 bytes, got %d", id, len(key))
+		}
+	}
+	return &TokenCipher{activeKeyID: activeKeyID, keys: keys}, nil
+}
+
+// ParseTokenEncryptionKeys parses a comma separated list of id:base64key pairs,
+// e.g. "2024-06:q83vEjR...,2023-01:mZ2l..."
+func ParseTokenEncryptionKeys(s string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid token encryption key entry %q, expected id:base64key", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decoding token encryption key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no token encryption keys provided")
+	}
+	return keys, nil
+}
+
+// ActiveKeyID returns the ID of the key used for new ciphertexts
+func (tc *TokenCipher) ActiveKeyID() string {
+	return tc.activeKeyID
+}
+
+// Encrypt seals a token with the active key
+func (tc *TokenCipher) Encrypt(token string) (string, error) {
+	return tc.encryptWithReader(rand.Reader, token)
+}
+
+func (tc *TokenCipher) encryptWithReader(rr io.Reader, token string) (string, error) {
+	if token == "" {
+		return "", errors.New("cannot encrypt empty token")
+	}
+	gcm, err := newTokenGCM(tc.keys[tc.activeKeyID])
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rr, nonce); err != nil {
+		return "", err
+	}
+	header := tokenCiphertextPrefix + tc.activeKeyID
+	sealed := gcm.Seal(nonce, nonce, []byte(token), []byte(header))
+	return header + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a token sealed by Encrypt. Legacy plain text tokens are returned unchanged.
+func (tc *TokenCipher) Decrypt(stored string) (string, error) {
+	if !IsEncryptedToken(stored) {
+		return stored, nil
+	}
+	keyID, encoded, ok := strings.Cut(strings.TrimPrefix(stored, tokenCiphertextPrefix), ":")
+	if !ok {
+		return "", errors.New("malformed encrypted token")
+	}
+	key, ok := tc.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("token encrypted with unknown key %q", keyID)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newTokenGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("malformed encrypted token")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(tokenCiphertextPrefix+keyID))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReencryption reports whether a stored value is plain text or sealed with a key other than the active one
+func (tc *TokenCipher) NeedsReencryption(stored string) bool {
+	if stored == "" {
+		return false
+	}
+	return !strings.HasPrefix(stored, tokenCiphertextPrefix+tc.activeKeyID+":")
+}
+
+// IsEncryptedToken reports whether a stored value was sealed by a TokenCipher
+func IsEncryptedToken(stored string) bool {
+	return strings.HasPrefix(stored, tokenCiphertextPrefix)
+}
+
+// sealAtRest encrypts a value the database shouldn't hold in plain text: Slack tokens, webhook signing
+// secrets and submitted shares of split secrets. Without a TokenCipher, which is only allowed outside
+// of production, values are stored as is.
+func (ctl *PublicController) sealAtRest(value string) (string, error) {
+	if ctl.config.TokenCipher == nil || value == "" {
+		return value, nil
+	}
+	return ctl.config.TokenCipher.Encrypt(value)
+}
+
+// openAtRest decrypts a value sealed by sealAtRest. Values stored as is are returned unchanged, while
+// sealed values can't be opened without a TokenCipher.
+func (ctl *PublicController) openAtRest(stored string) (string, error) {
+	if !IsEncryptedToken(stored) {
+		return stored, nil
+	}
+	if ctl.config.TokenCipher == nil {
+		return "", errors.New("value is encrypted but no token cipher is configured")
+	}
+	return ctl.config.TokenCipher.Decrypt(stored)
+}
+
+func newTokenGCM(key []byte) (cipher.AEAD, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(c)
+}
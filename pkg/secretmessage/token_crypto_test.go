@@ -0,0 +1,181 @@
+package secretmessage
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var (
+	testTokenKeyOld = bytes.Repeat([]byte{1}, 32)
+	testTokenKeyNew = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestTokenCipher_EncryptDecrypt(t *testing.T) {
+	tc, err := NewTokenCipher("k1", map[string][]byte{"k1": testTokenKeyOld})
+	require.NoError(t, err)
+
+	sealed, err := tc.Encrypt("xoxb-1234")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed, "enc:v1:k1:"))
+	assert.NotContains(t, sealed, "xoxb-1234")
+
+	opened, err := tc.Decrypt(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "xoxb-1234", opened)
+}
+
+func TestTokenCipher_DecryptLegacyPlainText(t *testing.T) {
+	tc, err := NewTokenCipher("k1", map[string][]byte{"k1": testTokenKeyOld})
+	require.NoError(t, err)
+
+	opened, err := tc.Decrypt("xoxb-legacy")
+	require.NoError(t, err)
+	assert.Equal(t, "xoxb-legacy", opened)
+	assert.True(t, tc.NeedsReencryption("xoxb-legacy"))
+}
+
+func TestTokenCipher_Rotation(t *testing.T) {
+	oldCipher, err := NewTokenCipher("k1", map[string][]byte{"k1": testTokenKeyOld})
+	require.NoError(t, err)
+	sealedOld, err := oldCipher.Encrypt("xoxb-1234")
+	require.NoError(t, err)
+
+	newCipher, err := NewTokenCipher("k2", map[string][]byte{"k1": testTokenKeyOld, "k2": testTokenKeyNew})
+	require.NoError(t, err)
+	assert.True(t, newCipher.NeedsReencryption(sealedOld))
+
+	opened, err := newCipher.Decrypt(sealedOld)
+	require.NoError(t, err)
+	assert.Equal(t, "xoxb-1234", opened)
+
+	sealedNew, err := newCipher.Encrypt(opened)
+	require.NoError(t, err)
+	assert.False(t, newCipher.NeedsReencryption(sealedNew))
+
+	_, err = oldCipher.Decrypt(sealedNew)
+	assert.Error(t, err)
+}
+
+func TestTokenCipher_TamperedCiphertext(t *testing.T) {
+	tc, err := NewTokenCipher("k1", map[string][]byte{"k1": testTokenKeyOld, "k2": testTokenKeyNew})
+	require.NoError(t, err)
+	sealed, err := tc.Encrypt("xoxb-1234")
+	require.NoError(t, err)
+
+	// Claiming a different key ID must fail authentication
+	_, err = tc.Decrypt(strings.Replace(sealed, "enc:v1:k1:", "enc:v1:k2:", 1))
+	assert.Error(t, err)
+}
+
+func TestNewTokenCipher_Validation(t *testing.T) {
+	_, err := NewTokenCipher("", map[string][]byte{"k1": testTokenKeyOld})
+	assert.Error(t, err)
+	_, err = NewTokenCipher("missing", map[string][]byte{"k1": testTokenKeyOld})
+	assert.Error(t, err)
+	_, err = NewTokenCipher("k1", map[string][]byte{"k1": []byte("short")})
+	assert.Error(t, err)
+	_, err = NewTokenCipher("k:1", map[string][]byte{"k:1": testTokenKeyOld})
+	assert.Error(t, err)
+}
+
+func TestParseTokenEncryptionKeys(t *testing.T) {
+	keys, err := ParseTokenEncryptionKeys("k1:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=, k2:AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=")
+	require.NoError(t, err)
+	assert.Equal(t, testTokenKeyOld, keys["k1"])
+	assert.Equal(t, testTokenKeyNew, keys["k2"])
+
+	_, err = ParseTokenEncryptionKeys("")
+	assert.Error(t, err)
+	_, err = ParseTokenEncryptionKeys("k1")
+	assert.Error(t, err)
+	_, err = ParseTokenEncryptionKeys("k1:not base64!")
+	assert.Error(t, err)
+}
+
+func TestEncryptTeamTokens(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared&dbname=encrypt_team_access_tokens"), &gorm.Config{})
+	require.NoError(t, err)
+	defer func() {
+		d, _ := db.DB()
+		d.Close()
+	}()
+	require.NoError(t, db.AutoMigrate(Team{}))
+
+	oldCipher, err := NewTokenCipher("k1", map[string][]byte{"k1": testTokenKeyOld})
+	require.NoError(t, err)
+	sealedOld, err := oldCipher.Encrypt("xoxb-old")
+	require.NoError(t, err)
+
+	require.NoError(t, db.Create(&Team{ID: "T1", AccessToken: "xoxb-plain"}).Error)
+	require.NoError(t, db.Create(&Team{ID: "T2", AccessToken: sealedOld, RefreshToken: "xoxe-plain"}).Error)
+	require.NoError(t, db.Create(&Team{ID: "T3", AccessToken: ""}).Error)
+
+	tc, err := NewTokenCipher("k2", map[string][]byte{"k1": testTokenKeyOld, "k2": testTokenKeyNew})
+	require.NoError(t, err)
+
+	migrated, err := EncryptTeamTokens(context.Background(), db, tc)
+	require.NoError(t, err)
+	assert.Equal(t, 2, migrated)
+
+	for id, want := range map[string][2]string{"T1": {"xoxb-plain", ""}, "T2": {"xoxb-old", "xoxe-plain"}} {
+		var team Team
+		require.NoError(t, db.Where("id = ?", id).First(&team).Error)
+		assert.False(t, tc.NeedsReencryption(team.AccessToken), id)
+		assert.False(t, tc.NeedsReencryption(team.RefreshToken), id)
+		gotAccess, err := tc.Decrypt(team.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, want[0], gotAccess)
+		gotRefresh, err := tc.Decrypt(team.RefreshToken)
+		require.NoError(t, err)
+		assert.Equal(t, want[1], gotRefresh)
+	}
+
+	// Second run is a no-op
+	migrated, err = EncryptTeamTokens(context.Background(), db, tc)
+	require.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+}
+
+func TestSealAtRest(t *testing.T) {
+	tc, err := NewTokenCipher("k1", map[string][]byte{"k1": testTokenKeyOld})
+	require.NoError(t, err)
+	ctl := &PublicController{config: Config{TokenCipher: tc}}
+
+	sealed, err := ctl.sealAtRest("whsec_1234")
+	require.NoError(t, err)
+	assert.True(t, IsEncryptedToken(sealed))
+	opened, err := ctl.openAtRest(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "whsec_1234", opened)
+
+	empty, err := ctl.sealAtRest("")
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+}
+
+func TestSealAtRest_WithoutCipher(t *testing.T) {
+	ctl := &PublicController{}
+
+	// Without a cipher values are stored in plain text
+	stored, err := ctl.sealAtRest("whsec_1234")
+	require.NoError(t, err)
+	assert.Equal(t, "whsec_1234", stored)
+	opened, err := ctl.openAtRest(stored)
+	require.NoError(t, err)
+	assert.Equal(t, "whsec_1234", opened)
+
+	// but values sealed by an install that had one can't be read
+	tc, err := NewTokenCipher("k1", map[string][]byte{"k1": testTokenKeyOld})
+	require.NoError(t, err)
+	sealed, err := tc.Encrypt("whsec_1234")
+	require.NoError(t, err)
+	_, err = ctl.openAtRest(sealed)
+	assert.Error(t, err)
+}
@@ -0,0 +1,47 @@
+package secretmessage
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// revealedTextChunk is how much of a revealed secret each attachment or modal section holds. Slack
+// allows 3000 characters in a section and collapses or truncates longer attachment text.
+const revealedTextChunk = 2900
+
+// validateSecretText returns the userError explaining why text can't be sent as a secret in a
+// workspace with settings, or nil if it can. Every way of creating a secret checks its text with it,
+// so they all reject the same secrets with the same message.
+func validateSecretText(text string, settings TeamSettings) error {
+	maxLength := settings.MaxSecretLength
+	switch {
+	case maxLength <= 0:
+		maxLength = DefaultMaxSecretLength
+	case maxLength > MaxSecretLengthLimit:
+		maxLength = MaxSecretLengthLimit
+	}
+	switch {
+	case !utf8.ValidString(text):
+		return newUserError("secret_not_text")
+	case strings.TrimSpace(text) == "":
+		return newUserError("secret_empty")
+	case utf8.RuneCountInString(text) > maxLength:
+		return newUserError("secret_too_long", formatThousands(maxLength))
+	}
+	return nil
+}
+
+// textChunks splits text into pieces of at most size characters, so a long secret can be shown in
+// several blocks rather than being cut off by Slack
+func textChunks(text string, size int) []string {
+	var chunks []string
+	for text != "" {
+		chunk := text
+		if utf8.RuneCountInString(chunk) > size {
+			chunk = string([]rune(text)[:size])
+		}
+		chunks = append(chunks, chunk)
+		text = text[len(chunk):]
+	}
+	return chunks
+}
@@ -0,0 +1,71 @@
+package secretmessage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/neufeldtech/secretmessage-go/pkg/secreti18n"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSecretText(t *testing.T) {
+	settings := DefaultTeamSettings("T1")
+	settings.MaxSecretLength = 5
+	tests := []struct {
+		name  string
+		text  string
+		title string
+	}{
+		{"valid", "héllo", ""},
+		{"empty", "", ":pencil2: Secret is empty"},
+		{"whitespace only", " \n\t ", ":pencil2: Secret is empty"},
+		{"too long", "hello!", ":straight_ruler: Secret too long"},
+		{"invalid utf-8", "a\xffb", ":warning: Secret isn't text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSecretText(tt.text, settings)
+			if tt.title == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var ue userError
+			require.ErrorAs(t, err, &ue)
+			assert.Equal(t, tt.title, ue.title(secreti18n.DefaultLocale))
+		})
+	}
+}
+
+func TestValidateSecretText_LimitWithoutSettings(t *testing.T) {
+	assert.NoError(t, validateSecretText(strings.Repeat("a", DefaultMaxSecretLength), TeamSettings{}))
+	assert.Error(t, validateSecretText(strings.Repeat("a", DefaultMaxSecretLength+1), TeamSettings{}))
+	assert.Error(t, validateSecretText(strings.Repeat("a", MaxSecretLengthLimit+1), TeamSettings{MaxSecretLength: 2 * MaxSecretLengthLimit}))
+}
+
+func TestTextChunks(t *testing.T) {
+	assert.Empty(t, textChunks("", 3))
+	assert.Equal(t, []string{"abc", "def", "g"}, textChunks("abcdefg", 3))
+	assert.Equal(t, []string{"héé", "é"}, textChunks("hééé", 3), "chunks are counted in characters, not bytes")
+}
+
+func TestRevealedSecretMessage(t *testing.T) {
+	short := revealedSecretMessage("hunter2", "key", secreti18n.DefaultLocale)
+	require.Len(t, short.Attachments, 1)
+	assert.Equal(t, "Secret message", short.Attachments[0].Title)
+	assert.Equal(t, "hunter2", short.Attachments[0].Text)
+	assert.NotEmpty(t, short.Attachments[0].Actions)
+
+	long := strings.Repeat("a", 2*revealedTextChunk+10)
+	msg := revealedSecretMessage(long, "key", secreti18n.DefaultLocale)
+	require.Len(t, msg.Attachments, 3)
+	var revealed strings.Builder
+	for _, a := range msg.Attachments {
+		revealed.WriteString(a.Text)
+	}
+	assert.Equal(t, long, revealed.String())
+	assert.Equal(t, "Secret message", msg.Attachments[0].Title)
+	assert.Empty(t, msg.Attachments[0].Actions)
+	assert.Equal(t, "delete_secret:key", msg.Attachments[2].CallbackID)
+	assert.NotEmpty(t, msg.Attachments[2].Actions)
+}
@@ -0,0 +1,119 @@
+package secretmessage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/neufeldtech/secretmessage-go/pkg/secretwebhook"
+	"go.uber.org/zap"
+)
+
+// webhookWorkers is how many webhook deliveries are made concurrently
+const webhookWorkers = 4
+
+// webhookEventTypes are the webhook event types sent for audit actions. Dismissals aren't sent.
+var webhookEventTypes = map[string]string{
+	AuditSecretCreated:  "secret.created",
+	AuditSecretRead:     "secret.read",
+	AuditSecretExpired:  "secret.expired",
+	AuditSecretRevoked:  "secret.revoked",
+	AuditShareSubmitted: "secret.share_submitted",
+}
+
+// WebhookEvent is the JSON body of a webhook delivery. It only holds metadata, never a secret's content.
+type WebhookEvent struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	TeamID     string    `json:"team_id"`
+	SecretID   string    `json:"secret_id"`
+	ActorID    string    `json:"actor_id,omitempty"`
+	ChannelID  string    `json:"channel_id,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// newWebhookSecret generates the secret a team's webhook deliveries are signed with
+func newWebhookSecret() string {
+	return "whsec_" + rand.Text()
+}
+
+// errPrivateWebhookURL is returned by validateWebhookURL for a URL naming a host that isn't public
+var errPrivateWebhookURL = errors.New("webhook URL is not public")
+
+// validateWebhookURL accepts an empty URL, which turns webhooks off, or an absolute https URL. Unless
+// allowPrivate, hosts that are obviously not public are refused too. Names are only checked when
+// dialing, since they can resolve to something else by then.
+func validateWebhookURL(raw string, allowPrivate bool) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("enter an https:// URL")
+	}
+	if allowPrivate {
+		return nil
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errPrivateWebhookURL
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !secretwebhook.PublicAddress(addr) {
+		return errPrivateWebhookURL
+	}
+	return nil
+}
+
+// sendWebhook queues a lifecycle event for delivery to the team's webhook, if it configured one
+func (ctl *PublicController) sendWebhook(ctx context.Context, e AuditEvent) {
+	eventType, ok := webhookEventTypes[e.Action]
+	if !ok {
+		return
+	}
+	settings, err := ctl.teamSettings(ctx, e.TeamID)
+	if err != nil {
+		ctl.logger.Error("error getting team settings for webhook", zap.Error(err), zap.String("teamID", e.TeamID))
+		return
+	}
+	if settings.WebhookURL == "" {
+		return
+	}
+	signingSecret, err := ctl.openAtRest(settings.WebhookSecret)
+	if err != nil {
+		ctl.logger.Error("error decrypting webhook secret", zap.Error(err), zap.String("teamID", e.TeamID))
+		return
+	}
+
+	event := WebhookEvent{
+		ID:         rand.Text(),
+		Type:       eventType,
+		TeamID:     e.TeamID,
+		SecretID:   e.SecretID,
+		ActorID:    e.ActorID,
+		ChannelID:  e.ChannelID,
+		OccurredAt: e.OccurredAt,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		ctl.logger.Error("error marshalling webhook event", zap.Error(err), zap.String("teamID", e.TeamID))
+		return
+	}
+	err = ctl.webhooks.Enqueue(secretwebhook.Delivery{
+		ID:     event.ID,
+		URL:    settings.WebhookURL,
+		Secret: signingSecret,
+		Event:  event.Type,
+		Body:   body,
+	})
+	if err != nil {
+		ctl.logger.Error("error queueing webhook", zap.Error(err), zap.String("teamID", e.TeamID), zap.String("event", event.Type))
+		return
+	}
+	ctl.logger.Info("webhook queued", zap.String("teamID", e.TeamID), zap.String("event", event.Type), zap.String("deliveryID", event.ID))
+}
@@ -0,0 +1,29 @@
+package secretmessage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateWebhookURL(t *testing.T) {
+	cases := map[string]error{
+		"":                              nil,
+		"https://siem.example.com/hook": nil,
+		"https://93.184.216.34/hook":    nil,
+		"https://127.0.0.1/hook":        errPrivateWebhookURL,
+		"https://169.254.169.254/latest/meta-data/": errPrivateWebhookURL,
+		"https://10.0.0.5:8443/hook":                errPrivateWebhookURL,
+		"https://[::1]/hook":                        errPrivateWebhookURL,
+		"https://localhost/hook":                    errPrivateWebhookURL,
+		"https://LOCALHOST./hook":                   errPrivateWebhookURL,
+	}
+	for raw, want := range cases {
+		assert.Equal(t, want, validateWebhookURL(raw, false), raw)
+	}
+
+	assert.Error(t, validateWebhookURL("http://siem.example.com/hook", false))
+	assert.Error(t, validateWebhookURL("https:///hook", false))
+	assert.NoError(t, validateWebhookURL("https://10.0.0.5/hook", true))
+	assert.Error(t, validateWebhookURL("http://10.0.0.5/hook", true))
+}
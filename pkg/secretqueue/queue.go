@@ -0,0 +1,133 @@
+package secretqueue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultQueueSize  = 500
+	defaultJobTimeout = 30 * time.Second
+)
+
+// ErrQueueFull is returned by Enqueue when jobs arrive faster than the workers finish them
+var ErrQueueFull = errors.New("work queue is full")
+
+// ErrClosed is returned by Enqueue once the queue is closed
+var ErrClosed = errors.New("work queue is closed")
+
+// Job is work done in the background. Its context is cancelled if the job runs longer than the
+// queue's job timeout.
+type Job func(ctx context.Context)
+
+type queuedJob struct {
+	ctx context.Context
+	run Job
+}
+
+// Queue runs jobs on a fixed number of workers, so request handlers can respond before slow work is done
+type Queue struct {
+	jobs    chan queuedJob
+	logger  *zap.Logger
+	timeout time.Duration
+
+	mux    sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewQueue() *Queue {
+	return &Queue{
+		jobs:    make(chan queuedJob, defaultQueueSize),
+		logger:  zap.Must(zap.NewProduction()),
+		timeout: defaultJobTimeout,
+	}
+}
+
+func (q *Queue) WithLogger(logger *zap.Logger) *Queue {
+	if logger == nil {
+		logger = zap.Must(zap.NewProduction())
+		q.logger.Warn("Logger is nil, using default production logger")
+	}
+	q.logger = logger
+	return q
+}
+
+// WithSize sets how many jobs may wait for a worker before Enqueue fails. It must be called before Start.
+func (q *Queue) WithSize(size int) *Queue {
+	q.jobs = make(chan queuedJob, size)
+	return q
+}
+
+// WithJobTimeout sets how long a job may run before its context is cancelled
+func (q *Queue) WithJobTimeout(timeout time.Duration) *Queue {
+	q.timeout = timeout
+	return q
+}
+
+// Start launches the workers that run queued jobs
+func (q *Queue) Start(workers int) *Queue {
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for job := range q.jobs {
+				q.run(job)
+			}
+		}()
+	}
+	return q
+}
+
+// Enqueue queues a job without blocking. The job's context carries ctx's values, but isn't cancelled
+// with it, so a job queued by a request handler outlives the request.
+func (q *Queue) Enqueue(ctx context.Context, job Job) error {
+	q.mux.RLock()
+	defer q.mux.RUnlock()
+	if q.closed {
+		return ErrClosed
+	}
+	select {
+	case q.jobs <- queuedJob{ctx: context.WithoutCancel(ctx), run: job}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting jobs and waits for the queued ones to finish, or for ctx to be done
+func (q *Queue) Close(ctx context.Context) error {
+	q.mux.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mux.Unlock()
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run runs one job, a panicking job is logged rather than taking its worker down
+func (q *Queue) run(job queuedJob) {
+	ctx, cancel := context.WithTimeout(job.ctx, q.timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			q.logger.Error("background job panicked", zap.Any("panic", r), zap.Stack("stack"))
+		}
+	}()
+	job.run(ctx)
+}
@@ -0,0 +1,107 @@
+package secretqueue
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type ctxKey struct{}
+
+func TestQueueRunsJobs(t *testing.T) {
+	q := NewQueue().WithLogger(zap.NewNop()).Start(4)
+	var ran atomic.Int32
+	for range 100 {
+		require.NoError(t, q.Enqueue(context.Background(), func(ctx context.Context) {
+			ran.Add(1)
+		}))
+	}
+	require.NoError(t, q.Close(context.Background()))
+	assert.EqualValues(t, 100, ran.Load())
+}
+
+func TestQueueJobOutlivesRequest(t *testing.T) {
+	q := NewQueue().WithLogger(zap.NewNop()).Start(1)
+	requestCtx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "trace"))
+	var value interface{}
+	var jobErr error
+	require.NoError(t, q.Enqueue(requestCtx, func(ctx context.Context) {
+		value = ctx.Value(ctxKey{})
+		jobErr = ctx.Err()
+	}))
+	cancel()
+	require.NoError(t, q.Close(context.Background()))
+	assert.Equal(t, "trace", value)
+	assert.NoError(t, jobErr)
+}
+
+func TestQueueJobTimeout(t *testing.T) {
+	q := NewQueue().WithLogger(zap.NewNop()).WithJobTimeout(10 * time.Millisecond).Start(1)
+	var jobErr error
+	require.NoError(t, q.Enqueue(context.Background(), func(ctx context.Context) {
+		<-ctx.Done()
+		jobErr = ctx.Err()
+	}))
+	require.NoError(t, q.Close(context.Background()))
+	assert.ErrorIs(t, jobErr, context.DeadlineExceeded)
+}
+
+func TestQueueFull(t *testing.T) {
+	q := NewQueue().WithLogger(zap.NewNop()).WithSize(1).Start(1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	require.NoError(t, q.Enqueue(context.Background(), func(ctx context.Context) {
+		close(started)
+		<-release
+	}))
+	<-started
+	require.NoError(t, q.Enqueue(context.Background(), func(ctx context.Context) {}))
+	assert.ErrorIs(t, q.Enqueue(context.Background(), func(ctx context.Context) {}), ErrQueueFull)
+	close(release)
+	require.NoError(t, q.Close(context.Background()))
+}
+
+func TestQueueCloseDrainsQueuedJobs(t *testing.T) {
+	q := NewQueue().WithLogger(zap.NewNop()).Start(1)
+	var ran atomic.Int32
+	for range 5 {
+		require.NoError(t, q.Enqueue(context.Background(), func(ctx context.Context) {
+			time.Sleep(5 * time.Millisecond)
+			ran.Add(1)
+		}))
+	}
+	require.NoError(t, q.Close(context.Background()))
+	assert.EqualValues(t, 5, ran.Load())
+	assert.ErrorIs(t, q.Enqueue(context.Background(), func(ctx context.Context) {}), ErrClosed)
+	assert.NoError(t, q.Close(context.Background()), "closing twice is fine")
+}
+
+func TestQueueCloseGivesUpAtDeadline(t *testing.T) {
+	q := NewQueue().WithLogger(zap.NewNop()).Start(1)
+	release := make(chan struct{})
+	defer close(release)
+	require.NoError(t, q.Enqueue(context.Background(), func(ctx context.Context) {
+		<-release
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.Close(ctx), context.DeadlineExceeded)
+}
+
+func TestQueueSurvivesPanickingJob(t *testing.T) {
+	q := NewQueue().WithLogger(zap.NewNop()).Start(1)
+	var ran atomic.Bool
+	require.NoError(t, q.Enqueue(context.Background(), func(ctx context.Context) {
+		panic("boom")
+	}))
+	require.NoError(t, q.Enqueue(context.Background(), func(ctx context.Context) {
+		ran.Store(true)
+	}))
+	require.NoError(t, q.Close(context.Background()))
+	assert.True(t, ran.Load())
+}
@@ -0,0 +1,122 @@
+package secretshamir
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// Secrets are split byte by byte over GF(2^8). Each byte is the constant term of a random polynomial
+// of degree threshold-1, and a share holds that polynomial evaluated at the share's x coordinate for
+// every byte. Any threshold shares interpolate the polynomials back at zero, fewer reveal nothing.
+// A share is the evaluated bytes followed by the x coordinate.
+
+const (
+	// MaxShares is the most shares a secret can be split into, one per non-zero x coordinate
+	MaxShares = 255
+)
+
+var (
+	// ErrInvalidParameters is returned when asked for fewer than two shares, a threshold outside
+	// 2..shares, more than MaxShares shares or to split an empty secret
+	ErrInvalidParameters = errors.New("invalid secret sharing parameters")
+	// ErrInvalidShares is returned when shares can't be combined: fewer than two, differing lengths,
+	// too short to hold a byte or sharing an x coordinate
+	ErrInvalidShares = errors.New("invalid shares")
+)
+
+// Split splits secret into shares, any threshold of which can be combined back into it
+func Split(secret []byte, shares int, threshold int) ([][]byte, error) {
+	return splitWithReader(rand.Reader, secret, shares, threshold)
+}
+
+func splitWithReader(rr io.Reader, secret []byte, shares int, threshold int) ([][]byte, error) {
+	if len(secret) == 0 || shares < 2 || shares > MaxShares || threshold < 2 || threshold > shares {
+		return nil, ErrInvalidParameters
+	}
+	out := make([][]byte, shares)
+	for i := range out {
+		out[i] = make([]byte, len(secret)+1)
+		out[i][len(secret)] = byte(i + 1)
+	}
+	coefficients := make([]byte, threshold)
+	for b, s := range secret {
+		coefficients[0] = s
+		if _, err := io.ReadFull(rr, coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range out {
+			out[i][b] = evaluate(coefficients, byte(i+1))
+		}
+	}
+	return out, nil
+}
+
+// Combine reconstructs a secret from at least threshold of its shares. Combining too few shares, or
+// shares of different secrets, gives garbage rather than an error, so callers should check the result.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, ErrInvalidShares
+	}
+	size := len(shares[0])
+	xs := make([]byte, len(shares))
+	seen := map[byte]bool{}
+	for i, share := range shares {
+		if len(share) != size || size < 2 {
+			return nil, ErrInvalidShares
+		}
+		x := share[size-1]
+		if x == 0 || seen[x] {
+			return nil, ErrInvalidShares
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+
+	secret := make([]byte, size-1)
+	for b := range secret {
+		// Lagrange interpolation at x = 0
+		var value byte
+		for i, share := range shares {
+			basis := byte(1)
+			for j, xj := range xs {
+				if i != j {
+					basis = mul(basis, div(xj, xj^xs[i]))
+				}
+			}
+			value ^= mul(share[b], basis)
+		}
+		secret[b] = value
+	}
+	return secret, nil
+}
+
+// evaluate evaluates the polynomial with the given coefficients, lowest degree first, at x
+func evaluate(coefficients []byte, x byte) byte {
+	var result byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = mul(result, x) ^ coefficients[i]
+	}
+	return result
+}
+
+// mul multiplies in GF(2^8) with the AES polynomial, without data dependent branches or lookups
+func mul(a, b byte) byte {
+	var p byte
+	for range 8 {
+		p ^= -(b & 1) & a
+		carry := -(a >> 7)
+		a = (a << 1) ^ (carry & 0x1b)
+		b >>= 1
+	}
+	return p
+}
+
+// div divides a by the non-zero b, using b^254 as the inverse of b
+func div(a, b byte) byte {
+	inverse := b
+	for range 6 {
+		inverse = mul(mul(inverse, inverse), b)
+	}
+	return mul(a, mul(inverse, inverse))
+}
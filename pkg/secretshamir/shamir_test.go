@@ -0,0 +1,104 @@
+package secretshamir
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	shares, err := Split(secret, 5, 3)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+	for _, share := range shares {
+		assert.Len(t, share, len(secret)+1)
+	}
+
+	// Every combination of three or more shares gives the secret back
+	for mask := 0; mask < 1<<len(shares); mask++ {
+		var subset [][]byte
+		for i, share := range shares {
+			if mask&(1<<i) != 0 {
+				subset = append(subset, share)
+			}
+		}
+		if len(subset) < 3 {
+			continue
+		}
+		combined, err := Combine(subset)
+		require.NoError(t, err)
+		assert.Equal(t, secret, combined, "shares %05b", mask)
+	}
+}
+
+func TestCombineTooFewShares(t *testing.T) {
+	secret := []byte("hunter2")
+	shares, err := Split(secret, 5, 3)
+	require.NoError(t, err)
+
+	combined, err := Combine(shares[:2])
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, combined)
+}
+
+func TestSplitIsRandomized(t *testing.T) {
+	secret := []byte("hunter2")
+	first, err := Split(secret, 3, 2)
+	require.NoError(t, err)
+	second, err := Split(secret, 3, 2)
+	require.NoError(t, err)
+	assert.False(t, bytes.Equal(first[0], second[0]))
+}
+
+func TestSplitInvalidParameters(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		secret    []byte
+		shares    int
+		threshold int
+	}{
+		{"empty secret", nil, 3, 2},
+		{"one share", []byte("x"), 1, 1},
+		{"threshold of one", []byte("x"), 3, 1},
+		{"threshold above shares", []byte("x"), 3, 4},
+		{"too many shares", []byte("x"), MaxShares + 1, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Split(tc.secret, tc.shares, tc.threshold)
+			assert.ErrorIs(t, err, ErrInvalidParameters)
+		})
+	}
+}
+
+func TestCombineInvalidShares(t *testing.T) {
+	shares, err := Split([]byte("hunter2"), 3, 2)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name   string
+		shares [][]byte
+	}{
+		{"one share", shares[:1]},
+		{"duplicate share", [][]byte{shares[0], shares[0]}},
+		{"different lengths", [][]byte{shares[0], shares[1][1:]}},
+		{"zero x coordinate", [][]byte{shares[0], append(bytes.Clone(shares[1][:7]), 0)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Combine(tc.shares)
+			assert.ErrorIs(t, err, ErrInvalidShares)
+		})
+	}
+}
+
+func TestFieldArithmetic(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		assert.Equal(t, byte(1), div(byte(a), byte(a)), "a=%d", a)
+		for b := 1; b < 256; b += 17 {
+			assert.Equal(t, byte(a), div(mul(byte(a), byte(b)), byte(b)), "a=%d b=%d", a, b)
+		}
+	}
+	assert.Equal(t, byte(0xc1), mul(0x57, 0x83))
+}
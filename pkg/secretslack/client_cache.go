@@ -0,0 +1,129 @@
+package secretslack
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	// DefaultClientCacheSize is how many teams' Slack clients are kept unless configured otherwise
+	DefaultClientCacheSize = 1000
+	// DefaultClientCacheTTL is how long a Slack client is reused unless configured otherwise
+	DefaultClientCacheTTL = time.Hour
+)
+
+// ClientCacheStats counts how the Slack client cache was used since it was created
+type ClientCacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Evictions counts clients dropped for being least recently used or too old. Invalidated
+	// clients aren't counted.
+	Evictions uint64
+	Size      int
+}
+
+// clientCache keeps a Slack client per team, dropping the least recently used beyond maxSize and any
+// older than ttl. A team's client is replaced when it is asked for with a different token.
+type clientCache struct {
+	mu      sync.Mutex
+	maxSize int
+	ttl     time.Duration
+	now     func() time.Time
+	// recent holds the cached clients, most recently used first
+	recent *list.List
+	teams  map[string]*list.Element
+	stats  ClientCacheStats
+}
+
+type cachedClient struct {
+	teamID    string
+	token     string
+	client    *slack.Client
+	createdAt time.Time
+}
+
+func newClientCache(maxSize int, ttl time.Duration) *clientCache {
+	if maxSize <= 0 {
+		maxSize = DefaultClientCacheSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultClientCacheTTL
+	}
+	return &clientCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		now:     time.Now,
+		recent:  list.New(),
+		teams:   make(map[string]*list.Element),
+	}
+}
+
+// get returns the team's cached client for token, creating one with newClient if there is none
+func (cc *clientCache) get(teamID string, token string, newClient func(token string) *slack.Client) *slack.Client {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	now := cc.now()
+	if el, ok := cc.teams[teamID]; ok {
+		cached := el.Value.(*cachedClient)
+		switch {
+		case cached.token != token:
+			// The team's token was rotated or it was reinstalled
+			cc.remove(el)
+		case now.Sub(cached.createdAt) >= cc.ttl:
+			cc.remove(el)
+			cc.stats.Evictions++
+		default:
+			cc.stats.Hits++
+			cc.recent.MoveToFront(el)
+			return cached.client
+		}
+	}
+
+	cc.stats.Misses++
+	client := newClient(token)
+	cc.teams[teamID] = cc.recent.PushFront(&cachedClient{teamID: teamID, token: token, client: client, createdAt: now})
+	for cc.recent.Len() > cc.maxSize {
+		cc.remove(cc.recent.Back())
+		cc.stats.Evictions++
+	}
+	return client
+}
+
+// invalidate drops every cached client for token
+func (cc *clientCache) invalidate(token string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for el := cc.recent.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*cachedClient).token == token {
+			cc.remove(el)
+		}
+		el = next
+	}
+}
+
+// invalidateTeam drops the team's cached client
+func (cc *clientCache) invalidateTeam(teamID string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if el, ok := cc.teams[teamID]; ok {
+		cc.remove(el)
+	}
+}
+
+func (cc *clientCache) remove(el *list.Element) {
+	cc.recent.Remove(el)
+	delete(cc.teams, el.Value.(*cachedClient).teamID)
+}
+
+func (cc *clientCache) snapshot() ClientCacheStats {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	stats := cc.stats
+	stats.Size = cc.recent.Len()
+	return stats
+}
@@ -0,0 +1,115 @@
+package secretslack
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(token string) *slack.Client {
+	return slack.New(token)
+}
+
+func TestClientCacheReusesTeamClient(t *testing.T) {
+	cc := newClientCache(10, time.Hour)
+	first := cc.get("T1", "xoxb-1", newTestClient)
+	assert.Same(t, first, cc.get("T1", "xoxb-1", newTestClient))
+	assert.NotSame(t, first, cc.get("T2", "xoxb-2", newTestClient))
+	assert.Equal(t, ClientCacheStats{Hits: 1, Misses: 2, Size: 2}, cc.snapshot())
+}
+
+func TestClientCacheReplacesRotatedToken(t *testing.T) {
+	cc := newClientCache(10, time.Hour)
+	old := cc.get("T1", "xoxb-old", newTestClient)
+	rotated := cc.get("T1", "xoxb-new", newTestClient)
+	assert.NotSame(t, old, rotated)
+	assert.Same(t, rotated, cc.get("T1", "xoxb-new", newTestClient))
+	assert.Equal(t, 1, cc.snapshot().Size)
+}
+
+func TestClientCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cc := newClientCache(2, time.Hour)
+	t1 := cc.get("T1", "xoxb-1", newTestClient)
+	t2 := cc.get("T2", "xoxb-2", newTestClient)
+	cc.get("T1", "xoxb-1", newTestClient)
+	cc.get("T3", "xoxb-3", newTestClient)
+
+	assert.Same(t, t1, cc.get("T1", "xoxb-1", newTestClient))
+	assert.NotSame(t, t2, cc.get("T2", "xoxb-2", newTestClient))
+	stats := cc.snapshot()
+	assert.Equal(t, 2, stats.Size)
+	assert.EqualValues(t, 2, stats.Evictions)
+}
+
+func TestClientCacheExpiresOldClients(t *testing.T) {
+	now := time.Now()
+	cc := newClientCache(10, time.Hour)
+	cc.now = func() time.Time { return now }
+	first := cc.get("T1", "xoxb-1", newTestClient)
+
+	now = now.Add(59 * time.Minute)
+	assert.Same(t, first, cc.get("T1", "xoxb-1", newTestClient))
+	now = now.Add(time.Minute)
+	assert.NotSame(t, first, cc.get("T1", "xoxb-1", newTestClient))
+	assert.EqualValues(t, 1, cc.snapshot().Evictions)
+}
+
+func TestClientCacheInvalidate(t *testing.T) {
+	cc := newClientCache(10, time.Hour)
+	t1 := cc.get("T1", "xoxb-1", newTestClient)
+	t2 := cc.get("T2", "xoxb-2", newTestClient)
+
+	cc.invalidate("xoxb-1")
+	assert.NotSame(t, t1, cc.get("T1", "xoxb-1", newTestClient))
+	assert.Same(t, t2, cc.get("T2", "xoxb-2", newTestClient))
+
+	cc.invalidateTeam("T2")
+	assert.NotSame(t, t2, cc.get("T2", "xoxb-2", newTestClient))
+	assert.Zero(t, cc.snapshot().Evictions, "invalidations aren't evictions")
+}
+
+func TestClientCacheConcurrentUse(t *testing.T) {
+	const (
+		goroutines = 50
+		iterations = 2000
+		teams      = 40
+	)
+	cc := newClientCache(teams/2, time.Hour)
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range iterations {
+				team := (g*7 + i) % teams
+				teamID := fmt.Sprintf("T%d", team)
+				token := fmt.Sprintf("xoxb-%d", team)
+				switch i % 50 {
+				case 0:
+					cc.invalidate(token)
+				case 1:
+					cc.invalidateTeam(teamID)
+				case 2:
+					cc.snapshot()
+				default:
+					client := cc.get(teamID, token, newTestClient)
+					if client == nil {
+						t.Error("got a nil client")
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := cc.snapshot()
+	require.LessOrEqual(t, stats.Size, teams/2)
+	assert.EqualValues(t, goroutines*iterations*47/50, stats.Hits+stats.Misses)
+	assert.Equal(t, len(cc.teams), cc.recent.Len())
+}
@@ -0,0 +1,225 @@
+package secretslack
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RetryPolicy says how Slack calls that were rate limited or failed on Slack's side are retried
+type RetryPolicy struct {
+	// MaxRetries is how many times a call is retried after its first attempt
+	MaxRetries int
+	// BaseDelay is the backoff before retrying a call Slack gave no Retry-After for. It doubles with
+	// every retry up to MaxDelay, and a random part of it is skipped so retries don't line up.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxRetryAfter is the longest Retry-After that is waited out. Calls told to wait longer fail
+	// with Slack's response.
+	MaxRetryAfter time.Duration
+	// MaxRetryTime bounds how long a call is retried for, counted from its first attempt, when its
+	// context's deadline is any later. Slack only waits 3 seconds for the app to answer its requests,
+	// which have no deadline of their own, and calls made while answering them must fit in.
+	MaxRetryTime time.Duration
+}
+
+// DefaultRetryPolicy is used unless the service is configured with WithRetryPolicy
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:    3,
+	BaseDelay:     250 * time.Millisecond,
+	MaxDelay:      5 * time.Second,
+	MaxRetryAfter: 30 * time.Second,
+	MaxRetryTime:  2 * time.Second,
+}
+
+// ErrRateLimited is returned for a call that would have to wait out a rate limit longer than the
+// policy's MaxRetryAfter or its context's deadline
+var ErrRateLimited = errors.New("slack rate limit in effect")
+
+// retrier holds the rate limit buckets shared by every call a SlackService makes. Slack limits each
+// method per workspace, so a bucket is a team's calls to one URL.
+type retrier struct {
+	policy RetryPolicy
+	now    func() time.Time
+	sleep  func(ctx context.Context, d time.Duration) error
+
+	mu sync.Mutex
+	// blockedUntil is when each bucket that was rate limited may be called again
+	blockedUntil map[string]time.Time
+}
+
+func newRetrier(policy RetryPolicy) *retrier {
+	return &retrier{
+		policy:       policy,
+		now:          time.Now,
+		sleep:        sleepContext,
+		blockedUntil: make(map[string]time.Time),
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryTransport retries a team's Slack calls according to its retrier's policy
+type retryTransport struct {
+	base    http.RoundTripper
+	teamID  string
+	retries *retrier
+	logger  *zap.Logger
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx := req.Context()
+	bucket := t.teamID + " " + req.URL.Host + req.URL.Path
+	// A body that can't be replayed can only be sent once
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	deadline := t.retries.deadline(ctx)
+
+	for attempt := 0; ; attempt++ {
+		if err := t.retries.waitForBucket(ctx, deadline, bucket); err != nil {
+			return nil, err
+		}
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+		resp, err := base.RoundTrip(attemptReq)
+		if err != nil || !retryable(req.Method, resp) {
+			return resp, err
+		}
+
+		delay, fromSlack := t.retries.retryDelay(resp, attempt)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			t.retries.block(bucket, delay)
+		}
+		if attempt >= t.retries.policy.MaxRetries || !replayable ||
+			(fromSlack && delay > t.retries.policy.MaxRetryAfter) || !t.retries.fitsDeadline(deadline, delay) {
+			return resp, nil
+		}
+		t.logger.Warn("retrying slack call",
+			zap.String("teamID", t.teamID),
+			zap.String("path", req.URL.Path),
+			zap.Int("status", resp.StatusCode),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("delay", delay))
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err := t.retries.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryable reports whether Slack may accept the call resp answered if it is sent again. A POST that
+// failed on Slack's side may still have been acted on, posting a message twice if it is retried, so
+// it is only retried when Slack said it wasn't: rate limited, or unavailable with a Retry-After.
+func retryable(method string, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		return method != http.MethodPost || resp.Header.Get("Retry-After") != ""
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return method != http.MethodPost
+	}
+	return false
+}
+
+// retryDelay is how long to wait before retrying the call resp answered, and whether Slack asked for
+// it with Retry-After rather than it being backoff
+func (r *retrier) retryDelay(resp *http.Response, attempt int) (time.Duration, bool) {
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if at, err := http.ParseTime(retryAfter); err == nil {
+			return max(at.Sub(r.now()), 0), true
+		}
+	}
+	delay := r.policy.MaxDelay
+	if attempt < 32 && r.policy.BaseDelay<<attempt < delay {
+		delay = r.policy.BaseDelay << attempt
+	}
+	if delay <= 0 {
+		return 0, false
+	}
+	return delay/2 + rand.N(delay/2+1), false
+}
+
+// deadline is when a call made with ctx must stop retrying: its context's deadline or MaxRetryTime
+// from now, whichever is sooner. The zero time means it may retry for as long as the policy allows.
+func (r *retrier) deadline(ctx context.Context) time.Time {
+	deadline, _ := ctx.Deadline()
+	if r.policy.MaxRetryTime > 0 {
+		if budget := r.now().Add(r.policy.MaxRetryTime); deadline.IsZero() || budget.Before(deadline) {
+			return budget
+		}
+	}
+	return deadline
+}
+
+// fitsDeadline reports whether waiting d leaves the call time before its deadline
+func (r *retrier) fitsDeadline(deadline time.Time, d time.Duration) bool {
+	return deadline.IsZero() || r.now().Add(d).Before(deadline)
+}
+
+// block holds back calls in the bucket for d. Buckets whose rate limit is over are forgotten here too,
+// since a team or method that isn't called again would otherwise be remembered for good.
+func (r *retrier) block(bucket string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	for b, until := range r.blockedUntil {
+		if !now.Before(until) {
+			delete(r.blockedUntil, b)
+		}
+	}
+	until := now.Add(d)
+	if until.After(r.blockedUntil[bucket]) {
+		r.blockedUntil[bucket] = until
+	}
+}
+
+// waitForBucket waits until calls in the bucket are no longer held back by a rate limit
+func (r *retrier) waitForBucket(ctx context.Context, deadline time.Time, bucket string) error {
+	r.mu.Lock()
+	now := r.now()
+	until, blocked := r.blockedUntil[bucket]
+	if blocked && !now.Before(until) {
+		delete(r.blockedUntil, bucket)
+		blocked = false
+	}
+	r.mu.Unlock()
+	if !blocked {
+		return nil
+	}
+	wait := until.Sub(now)
+	if wait > r.policy.MaxRetryAfter || !r.fitsDeadline(deadline, wait) {
+		return ErrRateLimited
+	}
+	return r.sleep(ctx, wait)
+}
@@ -0,0 +1,231 @@
+package secretslack
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const responseURL = "https://hooks.slack.com/actions/T1/1/abc"
+
+// newRetryTestService returns a service whose retries don't really sleep. Sleeping advances its clock
+// and is recorded in the returned slice.
+func newRetryTestService(policy RetryPolicy) (*SlackService, *[]time.Duration) {
+	srv := NewSlackService().WithLogger(zap.NewNop()).WithRetryPolicy(policy)
+	now := time.Now()
+	var slept []time.Duration
+	srv.retries.now = func() time.Time { return now }
+	srv.retries.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		now = now.Add(d)
+		return ctx.Err()
+	}
+	return srv, &slept
+}
+
+func statusResponse(code int, headers map[string]string) *http.Response {
+	resp := httpmock.NewStringResponse(code, `{"ok": false}`)
+	for k, v := range headers {
+		resp.Header.Set(k, v)
+	}
+	return resp
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", responseURL, httpmock.ResponderFromMultipleResponses([]*http.Response{
+		statusResponse(http.StatusTooManyRequests, map[string]string{"Retry-After": "1"}),
+		httpmock.NewStringResponse(http.StatusOK, "ok"),
+	}))
+	srv, slept := newRetryTestService(DefaultRetryPolicy)
+
+	require.NoError(t, srv.SendResponseUrlMessage(context.Background(), responseURL, slack.Message{}))
+	assert.Equal(t, 2, httpmock.GetTotalCallCount())
+	assert.Equal(t, []time.Duration{time.Second}, *slept)
+}
+
+func TestRetryBacksOffOnServerErrors(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", responseURL, httpmock.ResponderFromMultipleResponses([]*http.Response{
+		statusResponse(http.StatusServiceUnavailable, nil),
+		statusResponse(http.StatusBadGateway, nil),
+		statusResponse(http.StatusInternalServerError, nil),
+		httpmock.NewStringResponse(http.StatusOK, "ok"),
+	}))
+	policy := RetryPolicy{MaxRetries: 3, BaseDelay: time.Second, MaxDelay: 3 * time.Second, MaxRetryAfter: time.Minute}
+	srv, slept := newRetryTestService(policy)
+
+	resp, err := srv.teamHTTPClient("T1", 0).Get(responseURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 4, httpmock.GetTotalCallCount())
+	require.Len(t, *slept, 3)
+	for i, ceiling := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		assert.GreaterOrEqual(t, (*slept)[i], ceiling/2, "retry %d", i+1)
+		assert.LessOrEqual(t, (*slept)[i], ceiling, "retry %d", i+1)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", responseURL, httpmock.NewStringResponder(http.StatusTooManyRequests, "rate_limited"))
+	srv, slept := newRetryTestService(DefaultRetryPolicy)
+
+	assert.Error(t, srv.SendResponseUrlMessage(context.Background(), responseURL, slack.Message{}))
+	assert.Equal(t, DefaultRetryPolicy.MaxRetries+1, httpmock.GetTotalCallCount())
+	assert.Len(t, *slept, DefaultRetryPolicy.MaxRetries)
+}
+
+func TestRetryOnlyResendsPostsSlackDidNotAct(t *testing.T) {
+	for _, resp := range []*http.Response{
+		statusResponse(http.StatusInternalServerError, nil),
+		statusResponse(http.StatusBadGateway, nil),
+		statusResponse(http.StatusServiceUnavailable, nil),
+		statusResponse(http.StatusGatewayTimeout, nil),
+	} {
+		httpmock.Activate()
+		httpmock.RegisterResponder("POST", responseURL, httpmock.ResponderFromResponse(resp))
+		srv, slept := newRetryTestService(DefaultRetryPolicy)
+
+		assert.Error(t, srv.SendResponseUrlMessage(context.Background(), responseURL, slack.Message{}))
+		assert.Equal(t, 1, httpmock.GetTotalCallCount(), resp.Status)
+		assert.Empty(t, *slept, resp.Status)
+		httpmock.DeactivateAndReset()
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", responseURL, httpmock.ResponderFromMultipleResponses([]*http.Response{
+		statusResponse(http.StatusServiceUnavailable, map[string]string{"Retry-After": "1"}),
+		httpmock.NewStringResponse(http.StatusOK, "ok"),
+	}))
+	srv, slept := newRetryTestService(DefaultRetryPolicy)
+
+	require.NoError(t, srv.SendResponseUrlMessage(context.Background(), responseURL, slack.Message{}))
+	assert.Equal(t, 2, httpmock.GetTotalCallCount())
+	assert.Equal(t, []time.Duration{time.Second}, *slept)
+}
+
+func TestRetryStopsAtMaxRetryTime(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", responseURL, httpmock.ResponderFromMultipleResponses([]*http.Response{
+		statusResponse(http.StatusTooManyRequests, map[string]string{"Retry-After": "1"}),
+		statusResponse(http.StatusTooManyRequests, map[string]string{"Retry-After": "1"}),
+		httpmock.NewStringResponse(http.StatusOK, "ok"),
+	}))
+	srv, slept := newRetryTestService(DefaultRetryPolicy)
+
+	// The context has no deadline, like a gin request's, but a second retry would end past 2s
+	assert.Error(t, srv.SendResponseUrlMessage(context.Background(), responseURL, slack.Message{}))
+	assert.Equal(t, 2, httpmock.GetTotalCallCount())
+	assert.Equal(t, []time.Duration{time.Second}, *slept)
+}
+
+func TestRetryDoesNotRetryClientErrors(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", responseURL, httpmock.NewStringResponder(http.StatusNotFound, "expired_url"))
+	srv, slept := newRetryTestService(DefaultRetryPolicy)
+
+	assert.Error(t, srv.SendResponseUrlMessage(context.Background(), responseURL, slack.Message{}))
+	assert.Equal(t, 1, httpmock.GetTotalCallCount())
+	assert.Empty(t, *slept)
+}
+
+func TestRetryRespectsContextDeadline(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", responseURL, httpmock.ResponderFromMultipleResponses([]*http.Response{
+		statusResponse(http.StatusTooManyRequests, map[string]string{"Retry-After": "10"}),
+		httpmock.NewStringResponse(http.StatusOK, "ok"),
+	}))
+	srv, slept := newRetryTestService(DefaultRetryPolicy)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.Error(t, srv.SendResponseUrlMessage(ctx, responseURL, slack.Message{}))
+	assert.Equal(t, 1, httpmock.GetTotalCallCount())
+	assert.Empty(t, *slept)
+}
+
+func TestRetryIgnoresRetryAfterBeyondLimit(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", responseURL, httpmock.ResponderFromMultipleResponses([]*http.Response{
+		statusResponse(http.StatusTooManyRequests, map[string]string{"Retry-After": "120"}),
+		httpmock.NewStringResponse(http.StatusOK, "ok"),
+	}))
+	srv, slept := newRetryTestService(DefaultRetryPolicy)
+
+	assert.Error(t, srv.SendResponseUrlMessage(context.Background(), responseURL, slack.Message{}))
+	assert.Equal(t, 1, httpmock.GetTotalCallCount())
+	assert.Empty(t, *slept)
+}
+
+func TestRetryRateLimitsPerTeam(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", "https://slack.com/api/chat.postMessage", httpmock.ResponderFromMultipleResponses([]*http.Response{
+		statusResponse(http.StatusTooManyRequests, map[string]string{"Retry-After": "3"}),
+		httpmock.NewStringResponse(http.StatusOK, `{"ok": true, "channel": "C1", "ts": "1700000000.000100"}`),
+		httpmock.NewStringResponse(http.StatusOK, `{"ok": true, "channel": "C1", "ts": "1700000000.000200"}`),
+	}))
+	srv, slept := newRetryTestService(RetryPolicy{MaxRetries: 0, BaseDelay: time.Second, MaxDelay: time.Second, MaxRetryAfter: time.Minute})
+	t1 := srv.GetSlackClient("T1", "xoxb-1")
+	t2 := srv.GetSlackClient("T2", "xoxb-2")
+
+	_, _, err := t1.PostMessage("C1", slack.MsgOptionText("hi", false))
+	var rateLimited *slack.RateLimitedError
+	require.True(t, errors.As(err, &rateLimited), "got %v", err)
+
+	// Another team's calls aren't held back
+	_, _, err = t2.PostMessage("C1", slack.MsgOptionText("hi", false))
+	require.NoError(t, err)
+	assert.Empty(t, *slept)
+
+	// The rate limited team waits out Retry-After before calling again
+	_, _, err = t1.PostMessage("C1", slack.MsgOptionText("hi", false))
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{3 * time.Second}, *slept)
+	assert.Equal(t, 3, httpmock.GetTotalCallCount())
+}
+
+func TestRetryFailsFastWhileRateLimitedPastDeadline(t *testing.T) {
+	srv, slept := newRetryTestService(DefaultRetryPolicy)
+	srv.retries.block("T1 slack.com/api/chat.postMessage", 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	deadline := srv.retries.deadline(ctx)
+	assert.ErrorIs(t, srv.retries.waitForBucket(ctx, deadline, "T1 slack.com/api/chat.postMessage"), ErrRateLimited)
+	assert.NoError(t, srv.retries.waitForBucket(ctx, deadline, "T2 slack.com/api/chat.postMessage"))
+	assert.Empty(t, *slept)
+}
+
+func TestRetryForgetsBucketsAfterTheirRateLimit(t *testing.T) {
+	r := newRetrier(DefaultRetryPolicy)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	r.block("T1 slack.com /api/chat.postMessage", time.Second)
+	r.block("T2 slack.com /api/chat.postMessage", time.Minute)
+	now = now.Add(2 * time.Second)
+	r.block("T3 slack.com /api/views.open", time.Second)
+
+	assert.NotContains(t, r.blockedUntil, "T1 slack.com /api/chat.postMessage")
+	assert.Contains(t, r.blockedUntil, "T2 slack.com /api/chat.postMessage")
+	assert.Contains(t, r.blockedUntil, "T3 slack.com /api/views.open")
+}
@@ -7,7 +7,6 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/slack-go/slack"
@@ -15,17 +14,17 @@ import (
 )
 
 type SlackService struct {
-	apiClients map[string]*slack.Client
-	mux        sync.Mutex
+	clients    *clientCache
+	retries    *retrier
 	httpClient *http.Client
 	logger     *zap.Logger
 }
 
 func NewSlackService() *SlackService {
 	return &SlackService{
-		apiClients: make(map[string]*slack.Client),
-		mux:        sync.Mutex{},
-		logger:     zap.Must(zap.NewProduction()),
+		clients: newClientCache(DefaultClientCacheSize, DefaultClientCacheTTL),
+		retries: newRetrier(DefaultRetryPolicy),
+		logger:  zap.Must(zap.NewProduction()),
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
@@ -46,17 +45,55 @@ func (srv *SlackService) WithLogger(logger *zap.Logger) *SlackService {
 	return srv
 }
 
-func (srv *SlackService) GetSlackClient(token string) *slack.Client {
-	srv.mux.Lock()
-	defer srv.mux.Unlock()
+// WithClientCache keeps Slack clients for up to maxSize teams, each for at most ttl. Zero values keep
+// the defaults.
+func (srv *SlackService) WithClientCache(maxSize int, ttl time.Duration) *SlackService {
+	srv.clients = newClientCache(maxSize, ttl)
+	return srv
+}
+
+// WithRetryPolicy changes how rate limited and failed Slack calls are retried
+func (srv *SlackService) WithRetryPolicy(policy RetryPolicy) *SlackService {
+	srv.retries = newRetrier(policy)
+	return srv
+}
 
-	if client, exists := srv.apiClients[token]; exists {
-		return client
+// teamHTTPClient sends the team's Slack calls through the service's HTTP transport, retrying them
+// according to the retry policy. Calls that aren't made for a team, like response URLs, have no team ID.
+func (srv *SlackService) teamHTTPClient(teamID string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &retryTransport{
+			base:    srv.httpClient.Transport,
+			teamID:  teamID,
+			retries: srv.retries,
+			logger:  srv.logger,
+		},
 	}
+}
+
+// GetSlackClient returns the team's Slack client for token, reusing a cached one while the team's
+// token is unchanged
+func (srv *SlackService) GetSlackClient(teamID string, token string) *slack.Client {
+	return srv.clients.get(teamID, token, func(token string) *slack.Client {
+		// Slack clients also download files, so they are bounded by their calls' contexts rather than a timeout
+		return slack.New(token, slack.OptionDebug(true), slack.OptionHTTPClient(srv.teamHTTPClient(teamID, 0)))
+	})
+}
+
+// Invalidate drops the cached client for a token that was rotated or revoked
+func (srv *SlackService) Invalidate(token string) {
+	srv.clients.invalidate(token)
+}
 
-	client := slack.New(token, slack.OptionDebug(true))
-	srv.apiClients[token] = client
-	return client
+// InvalidateTeam drops the cached client of a team that was uninstalled
+func (srv *SlackService) InvalidateTeam(teamID string) {
+	srv.clients.invalidateTeam(teamID)
+}
+
+// ClientCacheStats reports the hits, misses and evictions of the Slack client cache
+func (srv *SlackService) ClientCacheStats() ClientCacheStats {
+	return srv.clients.snapshot()
 }
 
 // SendResponseUrlMessage sends a slack message via a response_url - It does not require a token
@@ -72,7 +109,7 @@ func (srv *SlackService) SendResponseUrlMessage(ctx context.Context, uri string,
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := srv.httpClient.Do(req)
+	resp, err := srv.teamHTTPClient("", srv.httpClient.Timeout).Do(req)
 	if err != nil {
 		return err
 	}
@@ -84,10 +121,9 @@ func (srv *SlackService) SendResponseUrlMessage(ctx context.Context, uri string,
 	return err
 }
 
-// NewSlackErrorResponse Constructs a json response for an ephemeral message back to a user
-func (srv *SlackService) NewSlackErrorResponse(title string, text string, deleteOriginal bool, callbackID string) ([]byte, int) {
-	responseCode := http.StatusOK
-	response := slack.Message{
+// NewSlackErrorMessage Constructs an ephemeral message telling a user what went wrong
+func (srv *SlackService) NewSlackErrorMessage(title string, text string, deleteOriginal bool, callbackID string) slack.Message {
+	return slack.Message{
 		Msg: slack.Msg{
 			DeleteOriginal: deleteOriginal,
 			ResponseType:   slack.ResponseTypeEphemeral,
@@ -100,7 +136,12 @@ func (srv *SlackService) NewSlackErrorResponse(title string, text string, delete
 			}},
 		},
 	}
-	responseBytes, err := json.Marshal(response)
+}
+
+// NewSlackErrorResponse Constructs a json response for an ephemeral message back to a user
+func (srv *SlackService) NewSlackErrorResponse(title string, text string, deleteOriginal bool, callbackID string) ([]byte, int) {
+	responseCode := http.StatusOK
+	responseBytes, err := json.Marshal(srv.NewSlackErrorMessage(title, text, deleteOriginal, callbackID))
 	if err != nil {
 		srv.logger.Error("error marshalling json for slack error response", zap.Error(err), zap.String("callbackID", callbackID))
 		responseCode = http.StatusInternalServerError
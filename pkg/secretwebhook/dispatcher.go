@@ -0,0 +1,227 @@
+package secretwebhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	SignatureHeader = "X-Secretmessage-Signature"
+	TimestampHeader = "X-Secretmessage-Timestamp"
+	EventHeader     = "X-Secretmessage-Event"
+	DeliveryHeader  = "X-Secretmessage-Delivery"
+
+	defaultQueueSize   = 1000
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	maxBackoff         = time.Minute
+)
+
+// ErrQueueFull is returned by Enqueue when deliveries arrive faster than receivers accept them
+var ErrQueueFull = errors.New("webhook delivery queue is full")
+
+// ErrClosed is returned by Enqueue once the dispatcher is closed
+var ErrClosed = errors.New("webhook dispatcher is closed")
+
+// Delivery is one event to POST to one receiver
+type Delivery struct {
+	ID     string
+	URL    string
+	Secret string
+	Event  string
+	Body   []byte
+}
+
+// Dispatcher delivers queued webhooks in the background, retrying failed deliveries with exponential backoff
+type Dispatcher struct {
+	queue       chan Delivery
+	httpClient  *http.Client
+	logger      *zap.Logger
+	maxAttempts int
+	backoff     time.Duration
+
+	mux    sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+	// stop is closed when Close gives up waiting, so workers abandon retries instead of sleeping on
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		queue:       make(chan Delivery, defaultQueueSize),
+		stop:        make(chan struct{}),
+		logger:      zap.Must(zap.NewProduction()),
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+		httpClient: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: NewPublicTransport(nil),
+		},
+	}
+}
+
+// WithHTTPClient replaces the client deliveries are made with. The default client only connects to
+// public addresses, a replacement has to do the same unless receivers are trusted.
+func (d *Dispatcher) WithHTTPClient(hc *http.Client) *Dispatcher {
+	d.httpClient = hc
+	return d
+}
+
+func (d *Dispatcher) WithLogger(logger *zap.Logger) *Dispatcher {
+	if logger == nil {
+		logger = zap.Must(zap.NewProduction())
+		d.logger.Warn("Logger is nil, using default production logger")
+	}
+	d.logger = logger
+	return d
+}
+
+// WithRetries sets how many times a delivery is attempted and the delay before the first retry,
+// which doubles with every further retry
+func (d *Dispatcher) WithRetries(maxAttempts int, backoff time.Duration) *Dispatcher {
+	d.maxAttempts = maxAttempts
+	d.backoff = backoff
+	return d
+}
+
+// Start launches the workers that deliver queued webhooks
+func (d *Dispatcher) Start(workers int) *Dispatcher {
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for delivery := range d.queue {
+				d.deliver(delivery)
+			}
+		}()
+	}
+	return d
+}
+
+// Enqueue queues a delivery without blocking
+func (d *Dispatcher) Enqueue(delivery Delivery) error {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	if d.closed {
+		return ErrClosed
+	}
+	select {
+	case d.queue <- delivery:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting deliveries and waits for the queued ones to finish, or for ctx to be done.
+// Deliveries still queued or waiting to be retried then are abandoned.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mux.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mux.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		d.stopOnce.Do(func() { close(d.stop) })
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) deliver(delivery Delivery) {
+	logger := d.logger.With(zap.String("deliveryID", delivery.ID), zap.String("event", delivery.Event))
+	delay := d.backoff
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		if d.stopped() {
+			logger.Error("webhook delivery abandoned, the dispatcher is closed", zap.Int("attempt", attempt))
+			return
+		}
+		retry, err := d.post(delivery)
+		if err == nil {
+			logger.Info("webhook delivered", zap.Int("attempt", attempt))
+			return
+		}
+		if !retry || attempt == d.maxAttempts {
+			logger.Error("webhook delivery failed", zap.Error(err), zap.Int("attempt", attempt))
+			return
+		}
+		logger.Warn("webhook delivery failed, retrying", zap.Error(err), zap.Int("attempt", attempt), zap.Duration("retryIn", delay))
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-d.stop:
+			timer.Stop()
+		}
+		delay = min(delay*2, maxBackoff)
+	}
+}
+
+func (d *Dispatcher) stopped() bool {
+	select {
+	case <-d.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth retrying
+func (d *Dispatcher) post(delivery Delivery) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, delivery.Body))
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		// The receiver's address won't become public by trying again
+		return !errors.Is(err, ErrNonPublicAddress), err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("receiver responded with %s", resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Sign computes the signature header value for a delivery: an HMAC-SHA256 of the timestamp and body
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v1:" + timestamp + ":"))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery's signature header, as a receiver would
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
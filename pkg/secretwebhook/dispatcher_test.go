@@ -0,0 +1,151 @@
+package secretwebhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// receiver is an httptest webhook endpoint answering with the given status codes in turn
+type receiver struct {
+	statuses []int
+	calls    int32
+
+	mux    sync.Mutex
+	bodies [][]byte
+	valid  []bool
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	n := int(atomic.AddInt32(&r.calls, 1))
+	body, _ := io.ReadAll(req.Body)
+	r.mux.Lock()
+	r.bodies = append(r.bodies, body)
+	r.valid = append(r.valid, Verify("whsec_test", req.Header.Get(TimestampHeader), body, req.Header.Get(SignatureHeader)))
+	r.mux.Unlock()
+
+	status := http.StatusOK
+	if n <= len(r.statuses) {
+		status = r.statuses[n-1]
+	}
+	w.WriteHeader(status)
+}
+
+func newTestDispatcher() *Dispatcher {
+	// httptest receivers listen on loopback, which the default client refuses to connect to
+	return NewDispatcher().WithHTTPClient(&http.Client{}).WithLogger(zap.NewNop()).WithRetries(3, time.Millisecond).Start(1)
+}
+
+func delivery(url string) Delivery {
+	return Delivery{ID: "d1", URL: url, Secret: "whsec_test", Event: "secret.created", Body: []byte(`{"type":"secret.created"}`)}
+}
+
+func TestDispatcher_DeliversSignedRequest(t *testing.T) {
+	r := &receiver{}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	d := newTestDispatcher()
+	require.NoError(t, d.Enqueue(delivery(srv.URL)))
+	require.NoError(t, d.Close(context.Background()))
+
+	assert.EqualValues(t, 1, r.calls)
+	assert.Equal(t, []bool{true}, r.valid)
+	assert.JSONEq(t, `{"type":"secret.created"}`, string(r.bodies[0]))
+}
+
+func TestDispatcher_RetriesServerErrors(t *testing.T) {
+	r := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	d := newTestDispatcher()
+	require.NoError(t, d.Enqueue(delivery(srv.URL)))
+	require.NoError(t, d.Close(context.Background()))
+
+	assert.EqualValues(t, 3, r.calls)
+}
+
+func TestDispatcher_GivesUpAfterMaxAttempts(t *testing.T) {
+	r := &receiver{statuses: []int{500, 500, 500, 500, 500}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	d := newTestDispatcher()
+	require.NoError(t, d.Enqueue(delivery(srv.URL)))
+	require.NoError(t, d.Close(context.Background()))
+
+	assert.EqualValues(t, 3, r.calls)
+}
+
+func TestDispatcher_CloseInterruptsBackoff(t *testing.T) {
+	r := &receiver{statuses: []int{500, 500}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	d := NewDispatcher().WithHTTPClient(&http.Client{}).WithLogger(zap.NewNop()).WithRetries(2, time.Hour).Start(1)
+	require.NoError(t, d.Enqueue(delivery(srv.URL)))
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&r.calls) == 1 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, d.Close(ctx), context.DeadlineExceeded)
+
+	// The worker stops waiting to retry, rather than an hour later
+	stopped := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("worker is still waiting to retry")
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&r.calls))
+}
+
+func TestDispatcher_DoesNotRetryClientErrors(t *testing.T) {
+	r := &receiver{statuses: []int{http.StatusGone}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	d := newTestDispatcher()
+	require.NoError(t, d.Enqueue(delivery(srv.URL)))
+	require.NoError(t, d.Close(context.Background()))
+
+	assert.EqualValues(t, 1, r.calls)
+}
+
+func TestDispatcher_EnqueueAfterClose(t *testing.T) {
+	d := newTestDispatcher()
+	require.NoError(t, d.Close(context.Background()))
+	assert.ErrorIs(t, d.Enqueue(delivery("http://127.0.0.1:1")), ErrClosed)
+}
+
+func TestDispatcher_QueueFull(t *testing.T) {
+	// Without workers nothing drains the queue
+	d := NewDispatcher().WithLogger(zap.NewNop())
+	for i := 0; i < defaultQueueSize; i++ {
+		require.NoError(t, d.Enqueue(delivery("http://127.0.0.1:1")))
+	}
+	assert.ErrorIs(t, d.Enqueue(delivery("http://127.0.0.1:1")), ErrQueueFull)
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"a":1}`)
+	sig := Sign("whsec_test", "1700000000", body)
+	assert.True(t, Verify("whsec_test", "1700000000", body, sig))
+	assert.False(t, Verify("whsec_other", "1700000000", body, sig))
+	assert.False(t, Verify("whsec_test", "1700000001", body, sig))
+	assert.False(t, Verify("whsec_test", "1700000000", []byte(`{"a":2}`), sig))
+}
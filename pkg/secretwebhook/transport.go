@@ -0,0 +1,67 @@
+package secretwebhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrNonPublicAddress is returned when a receiver's host is, or resolves to, an address that isn't on
+// the public internet
+var ErrNonPublicAddress = errors.New("webhook receiver address is not public")
+
+// sharedAddressSpace is the carrier-grade NAT range, which net/netip doesn't count as private
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// PublicAddress reports whether addr is on the public internet, rather than loopback, a private
+// network, link-local (which includes cloud metadata endpoints) or otherwise not routable
+func PublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() &&
+		!addr.IsUnspecified() &&
+		!addr.IsLoopback() &&
+		!addr.IsPrivate() &&
+		!addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() &&
+		!addr.IsMulticast() &&
+		!sharedAddressSpace.Contains(addr)
+}
+
+// NewPublicTransport returns a transport that only connects to public addresses. The check runs on
+// the address being dialed, after resolving, so a host that resolves differently on a later lookup
+// can't reach the internal network either. Proxies aren't used, since they would be dialed instead.
+// resolver may be nil to use the default resolver.
+func NewPublicTransport(resolver *net.Resolver) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Resolver:  resolver,
+		Control:   denyNonPublic,
+	}
+	// The same settings as http.DefaultTransport, which isn't cloned since tests may have replaced it
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// denyNonPublic is a net.Dialer Control function refusing connections to non-public addresses
+func denyNonPublic(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNonPublicAddress, address)
+	}
+	if !PublicAddress(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrNonPublicAddress, addrPort.Addr())
+	}
+	return nil
+}
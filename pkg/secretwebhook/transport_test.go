@@ -0,0 +1,108 @@
+package secretwebhook
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestPublicAddress(t *testing.T) {
+	cases := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"::ffff:127.0.0.1": false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"224.0.0.1":        false,
+	}
+	for addr, want := range cases {
+		assert.Equal(t, want, PublicAddress(netip.MustParseAddr(addr)), addr)
+	}
+}
+
+func TestDispatcher_RefusesNonPublicReceivers(t *testing.T) {
+	srv := httptest.NewServer(&receiver{})
+	defer srv.Close()
+
+	d := NewDispatcher()
+	for _, url := range []string{srv.URL, "https://127.0.0.1/hook", "https://169.254.169.254/latest/meta-data/"} {
+		retry, err := d.post(delivery(url))
+		assert.ErrorIs(t, err, ErrNonPublicAddress, url)
+		assert.False(t, retry, url)
+	}
+}
+
+func TestPublicTransport_RefusesNamesResolvingToPrivateAddresses(t *testing.T) {
+	client := &http.Client{Transport: NewPublicTransport(fakeResolver(t, netip.MustParseAddr("10.0.0.5")))}
+	_, err := client.Post("https://siem.internal.example.com/hook", "application/json", nil)
+	assert.ErrorIs(t, err, ErrNonPublicAddress)
+	assert.ErrorContains(t, err, "10.0.0.5")
+}
+
+// fakeResolver returns a resolver answering every A query with addr, and AAAA queries with nothing
+func fakeResolver(t *testing.T, addr netip.Addr) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go serveDNS(t, server, addr)
+			return client, nil
+		},
+	}
+}
+
+// serveDNS answers queries on conn, which the resolver treats as a stream, so messages are prefixed with
+// their length
+func serveDNS(t *testing.T, conn net.Conn, addr netip.Addr) {
+	defer conn.Close()
+	for {
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+		query := make([]byte, length)
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		var msg dnsmessage.Message
+		if !assert.NoError(t, msg.Unpack(query)) {
+			return
+		}
+
+		msg.Header.Response = true
+		msg.Header.Authoritative = true
+		q := msg.Questions[0]
+		if q.Type == dnsmessage.TypeA {
+			msg.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: addr.As4()},
+			}}
+		}
+		answer, err := msg.Pack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		if err := binary.Write(conn, binary.BigEndian, uint16(len(answer))); err != nil {
+			return
+		}
+		if _, err := conn.Write(answer); err != nil {
+			return
+		}
+	}
+}